@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
@@ -13,7 +14,11 @@ import (
 	_ "github.com/netauth/netauth/internal/crypto/bcrypt"
 	"github.com/netauth/netauth/internal/db"
 	_ "github.com/netauth/netauth/internal/db/bitcask"
+	_ "github.com/netauth/netauth/internal/db/encrypted"
 	_ "github.com/netauth/netauth/internal/db/filesystem"
+	_ "github.com/netauth/netauth/internal/db/git"
+	_ "github.com/netauth/netauth/internal/db/raft"
+	_ "github.com/netauth/netauth/internal/db/sqlite"
 	plugin "github.com/netauth/netauth/internal/plugin/tree/manager"
 
 	"github.com/netauth/netauth/pkg/token"
@@ -83,6 +88,23 @@ func init() {
 	viper.SetDefault("tls.certificate", "keys/tls.pem")
 	viper.SetDefault("tls.key", "keys/tls.key")
 	viper.SetDefault("plugin.path", filepath.Join(viper.GetString("core.home"), "plugins"))
+	viper.SetDefault("raft.bind", "127.0.0.1:1730")
+	viper.SetDefault("raft.storage", "filesystem")
+	viper.SetDefault("raft.timeout", time.Second*10)
+	viper.SetDefault("encryption.storage", "filesystem")
+	viper.SetDefault("encryption.key", "kv")
+	viper.SetDefault("journal.retention", time.Hour*24*7)
+	viper.SetDefault("journal.max-entries", 100000)
+	viper.SetDefault("journal.compact-interval", time.Hour)
+	viper.SetDefault("db.cache.size", 1024)
+	viper.SetDefault("bitcask.merge-interval", time.Hour*24)
+	viper.SetDefault("bitcask.merge-threshold", 1024*1024)
+	viper.SetDefault("bitcask.min-free-percent", 10)
+	viper.SetDefault("bitcask.max-datafiles", 256)
+	viper.SetDefault("trash.retention", time.Hour*24*30)
+	viper.SetDefault("trash.purge-interval", time.Hour)
+	viper.SetDefault("secret.policy.min-length", 8)
+	viper.SetDefault("secret.policy.reject-identity", true)
 }
 
 // newSocket binds the listening socket to the ports specified in the
@@ -266,6 +288,16 @@ func main() {
 	}
 	appLogger.Info("Database initialized", "backend", viper.GetString("db.backend"))
 
+	// The objects in the store must be in the form that this
+	// version expects.  Older stores need to be upgraded with
+	// nsutil migrate, and stores written by a newer version can't
+	// be read safely at all.
+	if err := dbImpl.CheckSchema(context.Background()); err != nil {
+		appLogger.Error("Datastore schema cannot be used", "error", err)
+		dbImpl.Shutdown()
+		os.Exit(1)
+	}
+
 	cryptoImpl, err := crypto.New(viper.GetString("crypto.backend"))
 	if err != nil {
 		appLogger.Error("Fatal crypto error", "error", err)
@@ -276,6 +308,8 @@ func main() {
 		tree.WithStorage(dbImpl),
 		tree.WithCrypto(cryptoImpl),
 		tree.WithLogger(appLogger),
+		tree.WithEntityChains(viper.GetStringMapStringSlice("tree.entity-chains")),
+		tree.WithGroupChains(viper.GetStringMapStringSlice("tree.group-chains")),
 	}
 
 	// The Tree is the core component of the server.  Its the part
@@ -339,15 +373,14 @@ func main() {
 	// A NetAuth server may serve more than one protocol version
 	// at a time.  This section binds the different application
 	// protocol versions to the grpcServer.
-	rpb.RegisterNetAuth2Server(
-		grpcServer,
-		rpc2.New(
-			rpc2.WithLogger(appLogger),
-			rpc2.WithTokenService(tokenService),
-			rpc2.WithEntityTree(tree),
-			rpc2.WithDisabledWrites(viper.GetBool("server.readonly")),
-		),
+	srv2 := rpc2.New(
+		rpc2.WithLogger(appLogger),
+		rpc2.WithTokenService(tokenService),
+		rpc2.WithEntityTree(tree),
+		rpc2.WithDisabledWrites(viper.GetBool("server.readonly")),
 	)
+	rpb.RegisterNetAuth2Server(grpcServer, srv2)
+	grpcServer.RegisterService(&rpc2.ExtServiceDesc, srv2)
 
 	// While the server is for the most part stateless, the
 	// plugins might not be.  This block registers the shutdown
@@ -365,6 +398,7 @@ func main() {
 		appLogger.Info("Shutting down...")
 		grpcServer.GracefulStop()
 		pluginManager.Shutdown()
+		dbImpl.Shutdown()
 		close(done)
 	}()
 
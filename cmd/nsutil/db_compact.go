@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/health"
+	"github.com/netauth/netauth/internal/startup"
+)
+
+var (
+	dbCompactCmd = &cobra.Command{
+		Use:   "compact",
+		Short: "Reclaim space used by overwritten and deleted values",
+		Long:  dbCompactCmdLongDocs,
+		Run:   dbCompactCmdRun,
+		Args:  cobra.NoArgs,
+	}
+
+	dbCompactCmdLongDocs = `
+The compact command asks the datastore to reclaim the space used by
+values that have been overwritten or deleted.  Only some datastores
+need this, currently only bitcask, including when it is wrapped by
+the encrypted store, and for all others the command reports that
+compaction is not supported.
+
+A running server will compact the store on its own every
+bitcask.merge-interval, so this is mostly useful to reclaim space
+immediately, or on servers where scheduled merges are disabled.  The
+state of the store is printed once compaction is complete.
+
+!!! ACHTUNG !!!
+You must only run this command with the server stopped.
+`
+)
+
+func init() {
+	rootCmd.AddCommand(dbCompactCmd)
+}
+
+func dbCompactCmdRun(c *cobra.Command, args []string) {
+	db.SetParentLogger(hclog.NewNullLogger())
+	startup.DoCallbacks()
+
+	dbImpl, err := db.New(viper.GetString("db.backend"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal database error: %s\n", err)
+		os.Exit(1)
+	}
+	defer dbImpl.Shutdown()
+
+	if err := dbImpl.Compact(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error compacting datastore: %s\n", err)
+		dbImpl.Shutdown()
+		os.Exit(1)
+	}
+	fmt.Println("Compaction complete")
+	for _, s := range health.Check().Subsystems {
+		fmt.Println(s)
+	}
+}
@@ -9,7 +9,12 @@ import (
 
 	"github.com/netauth/netauth/internal/db"
 	_ "github.com/netauth/netauth/internal/db/bitcask"
+	_ "github.com/netauth/netauth/internal/db/encrypted"
 	_ "github.com/netauth/netauth/internal/db/filesystem"
+	_ "github.com/netauth/netauth/internal/db/git"
+	_ "github.com/netauth/netauth/internal/db/raft"
+	_ "github.com/netauth/netauth/internal/db/sqlite"
+	_ "github.com/netauth/netauth/pkg/token/keyprovider/fs"
 
 	"github.com/netauth/netauth/internal/startup"
 )
@@ -28,6 +33,26 @@ The copy command allows you to copy all data from one backend to
 another.  This is useful for when you want to migrate from a
 host-local storage option to something distributed, or migrate into or
 out of a format that can be passed to other external tools.
+
+The copy command can also be used to encrypt an existing store.  Set
+encryption.storage to the backend that should hold the encrypted data
+and copy into the encrypted backend:
+
+    nsutil copy filesystem encrypted --no-dry-run
+
+If encryption.storage names the same backend as the source then the
+data is encrypted in place; do not pass --truncate in this case.  The
+encryption key is requested from the keyprovider named by
+encryption.keyprovider using the ID in encryption.key, which for the
+fs provider is read from keys/aes-gcm-<key>.tokenkey.  The key is
+base64 encoded unless encryption.key-format is set to raw.  Keys may be
+rotated by changing encryption.key while keeping the old key
+available, and values will be re-encrypted as they are read.
+
+Both netauthd and nsutil refuse to read unencrypted values from an
+encrypted store.  To convert a store gradually instead, set
+encryption.strict to false; values are then encrypted as they are
+read, and a warning is logged for each unencrypted value.
 `
 
 	dbCopyCmdNoDryRun bool
@@ -103,5 +128,14 @@ func dbCopyCmdRun(c *cobra.Command, args []string) {
 		}
 		count++
 	}
+
+	// Some stores buffer writes or hold locks that must be
+	// released cleanly for the data to be usable by the server.
+	if err := target.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing target: %s\n", err)
+	}
+	if err := source.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing source: %s\n", err)
+	}
 	fmt.Printf("Copy complete; %d objects were copied.  If this number does not match the number of source objects you have encountered copy errors and should review them above!\n", count)
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/db/fsck"
+	"github.com/netauth/netauth/internal/startup"
+)
+
+var (
+	dbFsckCmd = &cobra.Command{
+		Use:   "fsck",
+		Short: "Check the datastore for inconsistencies",
+		Long:  dbFsckCmdLongDocs,
+		Run:   dbFsckCmdRun,
+	}
+
+	dbFsckCmdLongDocs = `
+The fsck command reads every entity and group in the datastore and
+reports any that are inconsistent.  The following are detected:
+
+  * Records that can't be loaded or unmarshaled
+  * Entities that are members of groups that don't exist
+  * Expansions that target groups that don't exist
+  * Expansions that are malformed
+  * Groups managed by groups that don't exist
+  * Numbers that are shared by more than one entity or group
+
+Dangling references most often build up after groups are destroyed.
+Passing --repair removes dangling references and malformed expansions
+and describes each change that was made.  Unreadable records and
+duplicate numbers are only reported and must be fixed by hand.
+
+The command exits with a non-zero status if any problems remain.
+
+!!! ACHTUNG !!!
+You must only run this command with the server stopped to ensure your
+data storage remains consistent.
+`
+
+	dbFsckCmdRepair bool
+)
+
+func init() {
+	dbFsckCmd.Flags().BoolVar(&dbFsckCmdRepair, "repair", false, "Repair problems that can be fixed safely")
+	rootCmd.AddCommand(dbFsckCmd)
+}
+
+func dbFsckCmdRun(c *cobra.Command, args []string) {
+	db.SetParentLogger(hclog.NewNullLogger())
+	startup.DoCallbacks()
+
+	dbImpl, err := db.New(viper.GetString("db.backend"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal database error: %s\n", err)
+		os.Exit(1)
+	}
+	defer dbImpl.Shutdown()
+
+	r, err := fsck.Check(context.Background(), dbImpl, dbFsckCmdRepair)
+	if r != nil {
+		for _, p := range r.Problems {
+			fmt.Println(p)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking datastore: %s\n", err)
+		dbImpl.Shutdown()
+		os.Exit(1)
+	}
+
+	fmt.Printf("Checked %d entities and %d groups: %d problems, %d repaired.\n",
+		r.Entities, r.Groups, len(r.Problems), len(r.Problems)-r.Unrepaired())
+	if r.Unrepaired() > 0 {
+		dbImpl.Shutdown()
+		os.Exit(1)
+	}
+}
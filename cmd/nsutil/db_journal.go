@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+var (
+	dbJournalCmd = &cobra.Command{
+		Use:   "journal",
+		Short: "Show the changes recorded in the change journal",
+		Long:  dbJournalCmdLongDocs,
+		Run:   dbJournalCmdRun,
+		Args:  cobra.NoArgs,
+	}
+
+	dbJournalCmdLongDocs = `
+The journal command prints the entries in the change journal, which is
+kept in core.home when journal.enabled is set.  Each entry records the
+sequence number, time, type, and key of a change to the datastore.
+
+Tools that need to follow changes can remember the last sequence
+number they processed and pass it with --since to see only what has
+changed since.  If the entries following that sequence number have
+already been compacted out of the journal this command exits with an
+error, and the tool must start again from a full copy of the data.
+
+Unlike most nsutil commands this one is safe to use while the server
+is running.
+`
+
+	dbJournalCmdSince uint64
+	dbJournalCmdLimit int
+)
+
+func init() {
+	dbJournalCmd.Flags().Uint64Var(&dbJournalCmdSince, "since", 0, "Show changes after this sequence number")
+	dbJournalCmd.Flags().IntVar(&dbJournalCmdLimit, "limit", 0, "Show at most this many changes")
+
+	rootCmd.AddCommand(dbJournalCmd)
+}
+
+func dbJournalCmdRun(c *cobra.Command, args []string) {
+	p := filepath.Join(viper.GetString("core.home"), "journal.log")
+	entries, next, err := db.ReadJournal(p)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading journal: %s\n", err)
+		os.Exit(1)
+	}
+
+	first := next
+	if len(entries) > 0 {
+		first = entries[0].Seq
+	}
+	if dbJournalCmdSince+1 < first {
+		fmt.Fprintf(os.Stderr, "%s (oldest retained change is %d)\n", db.ErrJournalTruncated, first)
+		os.Exit(1)
+	}
+
+	shown := 0
+	for _, e := range entries {
+		if e.Seq <= dbJournalCmdSince {
+			continue
+		}
+		if dbJournalCmdLimit > 0 && shown == dbJournalCmdLimit {
+			break
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\n", e.Seq, e.Time.Format(time.RFC3339), e.Type, e.Key)
+		shown++
+	}
+}
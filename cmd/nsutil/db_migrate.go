@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/startup"
+)
+
+var (
+	dbMigrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade the datastore to the current schema",
+		Long:  dbMigrateCmdLongDocs,
+		Run:   dbMigrateCmdRun,
+		Args:  cobra.NoArgs,
+	}
+
+	dbMigrateCmdLongDocs = `
+The migrate command upgrades the objects in the datastore to the
+schema version used by this version of NetAuth.  Each pending
+migration is run in order and the changes it makes are printed.  The
+server will not start against a datastore that has pending
+migrations, or one that was written by a newer version of NetAuth.
+
+Passing --dry-run reports the changes that each migration would make
+without writing anything.  Take a snapshot before migrating so that
+the change can be undone.
+
+!!! ACHTUNG !!!
+You must only run this command with the server stopped to ensure your
+data storage remains consistent.
+`
+
+	dbMigrateCmdDryRun bool
+)
+
+func init() {
+	dbMigrateCmd.Flags().BoolVar(&dbMigrateCmdDryRun, "dry-run", false, "Report changes without making them")
+	rootCmd.AddCommand(dbMigrateCmd)
+}
+
+func dbMigrateCmdRun(c *cobra.Command, args []string) {
+	db.SetParentLogger(hclog.NewNullLogger())
+	startup.DoCallbacks()
+	ctx := context.Background()
+
+	dbImpl, err := db.New(viper.GetString("db.backend"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal database error: %s\n", err)
+		os.Exit(1)
+	}
+	defer dbImpl.Shutdown()
+
+	have, err := dbImpl.SchemaVersion(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading schema version: %s\n", err)
+		dbImpl.Shutdown()
+		os.Exit(1)
+	}
+	fmt.Printf("Datastore is at schema version %d, latest is %d\n", have, db.LatestSchema())
+
+	res, err := dbImpl.Migrate(ctx, dbMigrateCmdDryRun)
+	for _, r := range res {
+		fmt.Printf("Migration %d: %s (%d changes)\n", r.Version, r.Description, len(r.Changes))
+		for _, ch := range r.Changes {
+			fmt.Printf("  %s\n", ch)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error migrating datastore: %s\n", err)
+		dbImpl.Shutdown()
+		os.Exit(1)
+	}
+
+	switch {
+	case len(res) == 0:
+		fmt.Println("No migrations are pending")
+	case dbMigrateCmdDryRun:
+		fmt.Printf("Dry run complete, %d migrations are pending\n", len(res))
+	default:
+		fmt.Printf("Datastore migrated to schema version %d\n", db.LatestSchema())
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/startup"
+)
+
+var (
+	dbReindexCmd = &cobra.Command{
+		Use:   "reindex",
+		Short: "Rebuild the persistent search index",
+		Long:  dbReindexCmdLongDocs,
+		Run:   dbReindexCmdRun,
+		Args:  cobra.NoArgs,
+	}
+
+	dbReindexCmdLongDocs = `
+The reindex command discards the persistent search index and builds a
+new one from every entity and group in the datastore.  The server
+normally keeps the index up to date on its own, reindexing only the
+objects that have changed since it last ran, so this is only needed if
+the index is suspected to be damaged or out of sync.
+
+The index is stored in the index directory below core.home, and is
+only used when index.persistent is set.
+
+!!! ACHTUNG !!!
+You must only run this command with the server stopped.
+`
+)
+
+func init() {
+	rootCmd.AddCommand(dbReindexCmd)
+}
+
+func dbReindexCmdRun(c *cobra.Command, args []string) {
+	db.SetParentLogger(hclog.L())
+	startup.DoCallbacks()
+
+	p := filepath.Join(viper.GetString("core.home"), "index")
+	if err := os.RemoveAll(p); err != nil {
+		fmt.Fprintf(os.Stderr, "Error removing existing index: %s\n", err)
+		os.Exit(1)
+	}
+	viper.Set("index.persistent", true)
+
+	dbImpl, err := db.New(viper.GetString("db.backend"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal database error: %s\n", err)
+		os.Exit(1)
+	}
+	if err := dbImpl.EventUpdateAll(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error rebuilding index: %s\n", err)
+		dbImpl.Shutdown()
+		os.Exit(1)
+	}
+	dbImpl.Shutdown()
+	fmt.Printf("Search index rebuilt in %s\n", p)
+}
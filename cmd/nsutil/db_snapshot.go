@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	atomic "github.com/google/renameio"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/db/snapshot"
+	"github.com/netauth/netauth/internal/startup"
+)
+
+var (
+	dbSnapshotCmd = &cobra.Command{
+		Use:   "snapshot",
+		Short: "Create and restore point-in-time snapshots",
+		Long:  dbSnapshotCmdLongDocs,
+	}
+
+	dbSnapshotCreateCmd = &cobra.Command{
+		Use:   "create <file>",
+		Short: "Write a snapshot of the datastore to a file",
+		Run:   dbSnapshotCreateCmdRun,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	dbSnapshotRestoreCmd = &cobra.Command{
+		Use:   "restore <file>",
+		Short: "Restore the datastore from a snapshot",
+		Run:   dbSnapshotRestoreCmdRun,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	dbSnapshotCmdLongDocs = `
+The snapshot commands write the contents of a datastore to a single
+archive, and restore a datastore from one.  The archive is a gzipped
+tarball that begins with a manifest listing the backend the snapshot
+was taken from, the number of entities and groups it contains, and a
+checksum for every object.
+
+Restoring verifies the entire archive against its manifest before
+anything is written, so a damaged archive is refused rather than being
+partially restored.  Restore runs in dry-run mode by default, which
+is useful on its own to check that a backup is usable.
+
+The backend defaults to db.backend and can be changed with --backend.
+Snapshots of an encrypted backend contain the decrypted data.
+
+!!! ACHTUNG !!!
+You must only run these commands with the server stopped to ensure
+the snapshot is consistent.
+`
+
+	dbSnapshotCmdBackend  string
+	dbSnapshotCmdNoDryRun bool
+	dbSnapshotCmdTruncate bool
+)
+
+func init() {
+	dbSnapshotCmd.PersistentFlags().StringVar(&dbSnapshotCmdBackend, "backend", "", "Backend to snapshot or restore (default db.backend)")
+	dbSnapshotRestoreCmd.Flags().BoolVar(&dbSnapshotCmdNoDryRun, "no-dry-run", false, "Make changes, potentially destructive.")
+	dbSnapshotRestoreCmd.Flags().BoolVar(&dbSnapshotCmdTruncate, "truncate", false, "Remove objects that are not in the snapshot.")
+
+	dbSnapshotCmd.AddCommand(dbSnapshotCreateCmd)
+	dbSnapshotCmd.AddCommand(dbSnapshotRestoreCmd)
+	rootCmd.AddCommand(dbSnapshotCmd)
+}
+
+func dbSnapshotBackend() string {
+	if dbSnapshotCmdBackend != "" {
+		return dbSnapshotCmdBackend
+	}
+	return viper.GetString("db.backend")
+}
+
+func dbSnapshotCreateCmdRun(c *cobra.Command, args []string) {
+	startup.DoCallbacks()
+	ctx := context.Background()
+
+	backend := dbSnapshotBackend()
+	kv, err := db.NewKV(backend, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing %s: %s\n", backend, err)
+		os.Exit(1)
+	}
+	kv.SetEventFunc(func(db.Event) {})
+	defer kv.Close()
+
+	f, err := atomic.TempFile("", args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating snapshot file: %s\n", err)
+		os.Exit(1)
+	}
+	defer f.Cleanup()
+
+	m, err := snapshot.Create(ctx, kv, backend, f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating snapshot: %s\n", err)
+		os.Exit(1)
+	}
+	if err := f.CloseAtomicallyReplace(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing snapshot file: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Snapshot of %s written to %s: %d entities, %d groups.\n", backend, args[0], m.Entities, m.Groups)
+}
+
+func dbSnapshotRestoreCmdRun(c *cobra.Command, args []string) {
+	startup.DoCallbacks()
+	ctx := context.Background()
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening snapshot: %s\n", err)
+		os.Exit(1)
+	}
+	s, err := snapshot.Read(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Snapshot failed verification: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Snapshot verified: taken from %s at %s, %d entities, %d groups.\n",
+		s.Manifest.Backend, s.Manifest.Created.Local(), s.Manifest.Entities, s.Manifest.Groups)
+
+	backend := dbSnapshotBackend()
+	kv, err := db.NewKV(backend, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing %s: %s\n", backend, err)
+		os.Exit(1)
+	}
+	kv.SetEventFunc(func(db.Event) {})
+	defer kv.Close()
+
+	fmt.Printf("All data in the snapshot will be restored to %s.\n", backend)
+	if dbSnapshotCmdTruncate {
+		fmt.Println("Objects not in the snapshot will be removed.")
+	}
+	if !dbSnapshotCmdNoDryRun {
+		fmt.Println("You are in dry-run mode, pass --no-dry-run to make changes described above.")
+		return
+	}
+
+	n, err := s.Restore(ctx, kv, dbSnapshotCmdTruncate)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error restoring snapshot after %d objects: %s\n", n, err)
+		kv.Close()
+		os.Exit(1)
+	}
+	fmt.Printf("Restore complete; %d objects were restored.\n", n)
+}
@@ -14,10 +14,15 @@ import (
 	_ "github.com/netauth/netauth/internal/crypto/bcrypt"
 	"github.com/netauth/netauth/internal/db"
 	_ "github.com/netauth/netauth/internal/db/bitcask"
+	_ "github.com/netauth/netauth/internal/db/encrypted"
 	_ "github.com/netauth/netauth/internal/db/filesystem"
+	_ "github.com/netauth/netauth/internal/db/git"
+	_ "github.com/netauth/netauth/internal/db/raft"
+	_ "github.com/netauth/netauth/internal/db/sqlite"
 	"github.com/netauth/netauth/internal/startup"
 	"github.com/netauth/netauth/internal/tree"
 	_ "github.com/netauth/netauth/internal/tree/hooks"
+	_ "github.com/netauth/netauth/pkg/token/keyprovider/fs"
 
 	pb "github.com/netauth/protocol"
 )
@@ -60,6 +65,11 @@ func serverBootstrapCmdRun(c *cobra.Command, args []string) {
 		fmt.Fprintf(os.Stderr, "Fatal database error: %s\n", err)
 		os.Exit(1)
 	}
+	if err := dbImpl.CheckSchema(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Datastore schema cannot be used: %s\n", err)
+		dbImpl.Shutdown()
+		os.Exit(1)
+	}
 	cryptoImpl, err := crypto.New(viper.GetString("crypto.backend"))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Fatal crypto error: %s\n", err)
@@ -110,4 +120,5 @@ func serverBootstrapCmdRun(c *cobra.Command, args []string) {
 	if err := tree.SetEntityCapability2(ctx, args[0], pb.Capability_GLOBAL_ROOT.Enum()); err != nil {
 		fmt.Fprintf(os.Stderr, "Error setting GLOBAL_ROOT: %s\n", err)
 	}
+	dbImpl.Shutdown()
 }
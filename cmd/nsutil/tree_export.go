@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+
+	"github.com/netauth/netauth/internal/crypto"
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+var (
+	treeExportCmd = &cobra.Command{
+		Use:   "export [file]",
+		Short: "Export all entities and groups as JSON or YAML",
+		Long:  treeExportCmdLongDocs,
+		Run:   treeExportCmdRun,
+		Args:  cobra.MaximumNArgs(1),
+	}
+
+	treeImportCmd = &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import entities and groups from JSON or YAML",
+		Long:  treeImportCmdLongDocs,
+		Run:   treeImportCmdRun,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	treeExportCmdLongDocs = `
+The export command writes every entity and group in the datastore to a
+single JSON or YAML document, sorted so that successive exports can be
+compared with standard tools or kept in version control.  If no file
+is given the document is written to stdout.
+
+Secrets are exported as the hashes that are stored in the datastore.
+Pass --secrets=omit to leave them out entirely.
+`
+
+	treeImportCmdLongDocs = `
+The import command reads a document written by export and writes the
+entities and groups it contains to the datastore, replacing any that
+already exist.  Objects in the datastore that are not in the document
+are left alone.
+
+Every object is checked by the same hooks that check changes made
+through the API, so a document that would create an expansion cycle,
+or that refers to groups that don't exist, is refused.  If the
+storage backend supports transactions then either the whole document
+is imported or none of it is.
+
+Entities without a secret keep the secret they already have, if any.
+
+!!! ACHTUNG !!!
+You must only run this command with the server stopped to ensure your
+data storage remains consistent.
+`
+
+	treeExportCmdFormat  string
+	treeExportCmdSecrets string
+)
+
+// treeDocumentFormat is the version of the document layout.
+const treeDocumentFormat = 1
+
+// treeDocument is the layout of an exported document.  Objects are
+// held as JSON so that they can be converted with protojson.
+type treeDocument struct {
+	Format   int               `json:"format"`
+	Entities []json.RawMessage `json:"entities"`
+	Groups   []json.RawMessage `json:"groups"`
+}
+
+func init() {
+	treeExportCmd.Flags().StringVar(&treeExportCmdFormat, "format", "", "Document format, json or yaml (default from file extension, else json)")
+	treeExportCmd.Flags().StringVar(&treeExportCmdSecrets, "secrets", "hash", "How to export secrets, hash or omit")
+	treeImportCmd.Flags().StringVar(&treeExportCmdFormat, "format", "", "Document format, json or yaml (default from file extension, else json)")
+
+	rootCmd.AddCommand(treeExportCmd)
+	rootCmd.AddCommand(treeImportCmd)
+}
+
+func treeExportCmdRun(c *cobra.Command, args []string) {
+	db.SetParentLogger(hclog.NewNullLogger())
+	startup.DoCallbacks()
+	ctx := context.Background()
+
+	if treeExportCmdSecrets != "hash" && treeExportCmdSecrets != "omit" {
+		fmt.Fprintf(os.Stderr, "--secrets must be hash or omit\n")
+		os.Exit(1)
+	}
+
+	dbImpl, err := db.New(viper.GetString("db.backend"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal database error: %s\n", err)
+		os.Exit(1)
+	}
+	defer dbImpl.Shutdown()
+
+	doc := treeDocument{
+		Format:   treeDocumentFormat,
+		Entities: []json.RawMessage{},
+		Groups:   []json.RawMessage{},
+	}
+	ids, err := dbImpl.DiscoverEntityIDs(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing entities: %s\n", err)
+		os.Exit(1)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		e, err := dbImpl.LoadEntity(ctx, filepath.Base(id))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading entity %s: %s\n", id, err)
+			os.Exit(1)
+		}
+		if treeExportCmdSecrets == "omit" {
+			e.Secret = nil
+		}
+		doc.Entities = append(doc.Entities, treeMarshalObject(e))
+	}
+
+	names, err := dbImpl.DiscoverGroupNames(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing groups: %s\n", err)
+		os.Exit(1)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g, err := dbImpl.LoadGroup(ctx, filepath.Base(name))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading group %s: %s\n", name, err)
+			os.Exit(1)
+		}
+		doc.Groups = append(doc.Groups, treeMarshalObject(g))
+	}
+
+	path := ""
+	if len(args) == 1 {
+		path = args[0]
+	}
+	out, err := treeEncodeDocument(doc, treeDocumentFormatFor(path))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding document: %s\n", err)
+		os.Exit(1)
+	}
+
+	if path == "" {
+		os.Stdout.Write(out)
+		return
+	}
+	if err := ioutil.WriteFile(path, out, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing document: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d entities and %d groups to %s\n", len(doc.Entities), len(doc.Groups), path)
+}
+
+func treeImportCmdRun(c *cobra.Command, args []string) {
+	crypto.SetParentLogger(hclog.NewNullLogger())
+	db.SetParentLogger(hclog.NewNullLogger())
+	tree.SetParentLogger(hclog.NewNullLogger())
+	startup.DoCallbacks()
+	ctx := context.Background()
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening document: %s\n", err)
+		os.Exit(1)
+	}
+	entities, groups, err := treeDecodeDocument(f, treeDocumentFormatFor(args[0]))
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading document: %s\n", err)
+		os.Exit(1)
+	}
+
+	dbImpl, err := db.New(viper.GetString("db.backend"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal database error: %s\n", err)
+		os.Exit(1)
+	}
+	defer dbImpl.Shutdown()
+	if err := dbImpl.CheckSchema(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Datastore schema cannot be used: %s\n", err)
+		dbImpl.Shutdown()
+		os.Exit(1)
+	}
+	cryptoImpl, err := crypto.New(viper.GetString("crypto.backend"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal crypto error: %s\n", err)
+		os.Exit(1)
+	}
+	tree, err := tree.New(tree.WithStorage(dbImpl), tree.WithCrypto(cryptoImpl))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal initialization error: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := tree.Import(ctx, entities, groups); err != nil {
+		fmt.Fprintf(os.Stderr, "Import refused: %s\n", err)
+		dbImpl.Shutdown()
+		os.Exit(1)
+	}
+	fmt.Printf("Imported %d entities and %d groups\n", len(entities), len(groups))
+}
+
+// treeDocumentFormatFor picks a format from the flag, or failing that
+// from the extension of the file.
+func treeDocumentFormatFor(path string) string {
+	if treeExportCmdFormat != "" {
+		return treeExportCmdFormat
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	default:
+		return "json"
+	}
+}
+
+// treeMarshalObject converts an object to JSON.  The output of
+// protojson is deliberately unstable, so it is passed through
+// encoding/json to produce something that can be diffed.
+func treeMarshalObject(m proto.Message) json.RawMessage {
+	b, _ := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+	var v interface{}
+	json.Unmarshal(b, &v)
+	b, _ = json.Marshal(v)
+	return b
+}
+
+func treeEncodeDocument(doc treeDocument, format string) ([]byte, error) {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "json":
+		var out bytes.Buffer
+		if err := json.Indent(&out, b, "", "  "); err != nil {
+			return nil, err
+		}
+		out.WriteByte('\n')
+		return out.Bytes(), nil
+	case "yaml":
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(v)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func treeDecodeDocument(r io.Reader, format string) ([]*pb.Entity, []*pb.Group, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch format {
+	case "json":
+	case "yaml":
+		var v interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, nil, err
+		}
+		if b, err = json.Marshal(v); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	var doc treeDocument
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, nil, err
+	}
+	if doc.Format != treeDocumentFormat {
+		return nil, nil, fmt.Errorf("unsupported document format %d", doc.Format)
+	}
+
+	entities := make([]*pb.Entity, len(doc.Entities))
+	for i, raw := range doc.Entities {
+		entities[i] = &pb.Entity{}
+		if err := protojson.Unmarshal(raw, entities[i]); err != nil {
+			return nil, nil, fmt.Errorf("entity %d: %w", i, err)
+		}
+	}
+	groups := make([]*pb.Group, len(doc.Groups))
+	for i, raw := range doc.Groups {
+		groups[i] = &pb.Group{}
+		if err := protojson.Unmarshal(raw, groups[i]); err != nil {
+			return nil, nil, fmt.Errorf("group %d: %w", i, err)
+		}
+	}
+	return entities, groups, nil
+}
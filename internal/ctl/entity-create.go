@@ -10,9 +10,10 @@ import (
 )
 
 var (
-	newEntityID string
-	newNumber   int
-	newSecret   string
+	newEntityID    string
+	newNumber      int
+	newSecret      string
+	newNumberRange string
 
 	entityCreateCmd = &cobra.Command{
 		Use:     "create <ID>",
@@ -36,6 +37,11 @@ number will be chosen as the next unassigned number, and the secret
 will be prompted for.  To create an entity with an unset secret,
 specify the empty string as the initial secret.
 
+If the server is configured with more than one range of numbers, such
+as one for people and one for service accounts, the range to choose
+the number from can be given with --number-range.  Numbers are unique,
+so a number that is already held by another entity will be refused.
+
 The caller must possess the CREATE_ENTITY capability or be a
 GLOBAL_ROOT operator for this command to succeed.`
 
@@ -48,6 +54,7 @@ func init() {
 	entityCmd.AddCommand(entityCreateCmd)
 	entityCreateCmd.Flags().IntVar(&newNumber, "number", -1, "Number to assign.")
 	entityCreateCmd.Flags().StringVar(&newSecret, "initial-secret", "", "Initial secret.")
+	entityCreateCmd.Flags().StringVar(&newNumberRange, "number-range", "", "Range to choose the number from.")
 }
 
 func entityCreateRun(cmd *cobra.Command, args []string) {
@@ -57,6 +64,9 @@ func entityCreateRun(cmd *cobra.Command, args []string) {
 	}
 
 	ctx = netauth.Authorize(ctx, token())
+	if newNumberRange != "" {
+		ctx = netauth.WithNumberRange(ctx, newNumberRange)
+	}
 
 	if err := rpc.EntityCreate(ctx, newEntityID, newSecret, newNumber); err != nil {
 		fmt.Println(err)
@@ -24,6 +24,9 @@ Destroy the entity with the specified ID.  The entity is deleted
 immediately and without confirmation, please ensure you have typed the
 ID correctly.
 
+If the server keeps a trash then the entity can be brought back with
+'netauth entity restore' until it is purged.
+
 It is possible to remove the entity running the command, but this is
 not recommended and may leave your system without any administrative
 users.
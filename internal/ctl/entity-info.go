@@ -5,46 +5,72 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+
+	pb "github.com/netauth/protocol"
 )
 
 var (
 	entityInfoFields string
+	entityInfoNumber int
 
 	entityInfoCmd = &cobra.Command{
-		Use:     "info <entity>",
+		Use:     "info [entity]",
 		Short:   "Fetch information on an existing entity",
 		Long:    entityInfoLongDocs,
 		Example: entityInfoExample,
-		Args:    cobra.ExactArgs(1),
+		Args:    entityInfoArgs,
 		Run:     entityInfoRun,
 	}
 
 	entityInfoLongDocs = `
 The info command can return information on any entity known to the
 server.  The output may be filtered with the --fields option which
-takes a comma separated list of field names to display.  `
+takes a comma separated list of field names to display.
+
+Instead of an ID the entity may be given by number with --number,
+which looks the number up directly rather than searching for it.`
 
 	entityInfoExample = `$ netauth entity info demo2
 ID: demo2
 Number: 9
 
 $ netauth entity info --fields ID demo2
-ID: demo2`
+ID: demo2
+
+$ netauth entity info --number 9
+ID: demo2
+Number: 9`
 )
 
 func init() {
 	entityCmd.AddCommand(entityInfoCmd)
 	entityInfoCmd.Flags().StringVar(&entityInfoFields, "fields", "", "Fields to be displayed")
+	entityInfoCmd.Flags().IntVar(&entityInfoNumber, "number", -1, "Look up the entity with this number")
+}
+
+func entityInfoArgs(cmd *cobra.Command, args []string) error {
+	if cmd.Flags().Changed("number") {
+		return cobra.NoArgs(cmd, args)
+	}
+	return cobra.ExactArgs(1)(cmd, args)
 }
 
 func entityInfoRun(cmd *cobra.Command, args []string) {
 	// Obtain entity info
-	entity, err := rpc.EntityInfo(ctx, args[0])
+	var entity *pb.Entity
+	var err error
+	if cmd.Flags().Changed("number") {
+		entity, err = rpc.EntityInfoByNumber(ctx, entityInfoNumber)
+	} else {
+		var e pb.Entity
+		e, err = rpc.EntityInfo(ctx, args[0])
+		entity = &e
+	}
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
 	// Print the fields
-	printEntity(&entity, entityInfoFields)
+	printEntity(entity, entityInfoFields)
 }
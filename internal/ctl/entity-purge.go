@@ -0,0 +1,45 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+)
+
+var (
+	entityPurgeCmd = &cobra.Command{
+		Use:     "purge <ID>",
+		Short:   "Permanently remove an entity from the trash",
+		Long:    entityPurgeLongDocs,
+		Example: entityPurgeExample,
+		Args:    cobra.ExactArgs(1),
+		Run:     entityPurgeRun,
+	}
+
+	entityPurgeLongDocs = `
+Permanently remove a destroyed entity from the trash.  Once purged
+the entity cannot be restored.
+
+The caller must possess the DESTROY_ENTITY capability or be a
+GLOBAL_ROOT operator for this command to succeed.`
+
+	entityPurgeExample = `$ netauth entity purge demo
+Entity Purged`
+)
+
+func init() {
+	entityCmd.AddCommand(entityPurgeCmd)
+}
+
+func entityPurgeRun(cmd *cobra.Command, args []string) {
+	ctx = netauth.Authorize(ctx, token())
+
+	if err := rpc.EntityPurge(ctx, args[0]); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Entity Purged")
+}
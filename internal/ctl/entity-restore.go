@@ -0,0 +1,46 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+)
+
+var (
+	entityRestoreCmd = &cobra.Command{
+		Use:     "restore <ID>",
+		Short:   "Restore a destroyed entity from the trash",
+		Long:    entityRestoreLongDocs,
+		Example: entityRestoreExample,
+		Args:    cobra.ExactArgs(1),
+		Run:     entityRestoreRun,
+	}
+
+	entityRestoreLongDocs = `
+Restore a destroyed entity from the trash.  The entity is restored
+exactly as it was when it was destroyed.  Restoring fails if another
+entity with the same ID or number has been created in the meantime.
+
+The caller must possess the CREATE_ENTITY capability or be a
+GLOBAL_ROOT operator for this command to succeed.`
+
+	entityRestoreExample = `$ netauth entity restore demo
+Entity Restored`
+)
+
+func init() {
+	entityCmd.AddCommand(entityRestoreCmd)
+}
+
+func entityRestoreRun(cmd *cobra.Command, args []string) {
+	ctx = netauth.Authorize(ctx, token())
+
+	if err := rpc.EntityRestore(ctx, args[0]); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Entity Restored")
+}
@@ -8,7 +8,11 @@ import (
 )
 
 var (
-	entitySearchFields string
+	entitySearchFields   string
+	entitySearchPage     int
+	entitySearchPageSize int
+	entitySearchSort     string
+	entitySearchLDAP     bool
 
 	entitySearchCmd = &cobra.Command{
 		Use:     "search <expression>",
@@ -29,7 +33,21 @@ only certain fields pass a comma separated list to the --fields
 argument of the field names you wish to display.
 
 Some fields on entities are part of the metadata, to address these
-fields in a search prefix them with 'meta.' as in 'meta.DisplayName'.`
+fields in a search prefix them with 'meta.' as in 'meta.DisplayName'.
+Values stored with the kv command can be searched for by prefixing
+the key with 'kv.' as in 'kv.department:infra'.  The server may be
+configured to leave sensitive keys out of searches.
+
+Large result sets can be displayed a page at a time by passing the
+page number to --page, and the number of results on each page to
+--page-size.  Results are ordered by how well they match unless a
+comma separated list of fields is passed to --sort, and fields
+prefixed with '-' are sorted in descending order.
+
+Tools that speak LDAP can pass an RFC 4515 filter instead by setting
+--ldap.  The usual posixAccount attributes such as uid, uidNumber,
+and loginShell are understood, and memberOf is answered using the
+same membership rules as the server applies everywhere else.`
 
 	entitySearchExample = `$ netauth entity search 'ID:demo*'
 ID: demo2
@@ -45,17 +63,36 @@ $ netauth entity search 'meta.Shell: /bin/bash'
 ID: demo3
 Number: 10
 shell: /bin/bash
+
+$ netauth entity search --page 2 --page-size 2 --sort ID 'ID:demo*'
+ID: demo4
+Number: 11
+--- Page 2 of 2 (3 results)
+
+$ netauth entity search --ldap '(&(objectClass=posixAccount)(memberOf=ops))'
+ID: demo3
+Number: 10
 `
 )
 
 func init() {
 	entityCmd.AddCommand(entitySearchCmd)
 	entitySearchCmd.Flags().StringVar(&entitySearchFields, "fields", "", "Fields to be displayed")
+	entitySearchCmd.Flags().IntVar(&entitySearchPage, "page", 0, "Page of results to display, starting from 1")
+	entitySearchCmd.Flags().IntVar(&entitySearchPageSize, "page-size", 50, "Number of results on each page")
+	entitySearchCmd.Flags().StringVar(&entitySearchSort, "sort", "", "Comma separated fields to sort by, prefix with '-' to reverse")
+	entitySearchCmd.Flags().BoolVar(&entitySearchLDAP, "ldap", false, "Interpret the expression as an LDAP filter")
 }
 
 func entitySearchRun(cmd *cobra.Command, args []string) {
+	if entitySearchPage > 0 && entitySearchPageSize < 1 {
+		fmt.Println("--page-size must be at least 1")
+		os.Exit(1)
+	}
+
 	// Obtain entity info
-	res, err := rpc.EntitySearch(ctx, args[0])
+	opts := searchOptions(entitySearchPage, entitySearchPageSize, entitySearchSort, entitySearchLDAP)
+	res, page, err := rpc.EntitySearchPage(ctx, args[0], opts)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -68,4 +105,5 @@ func entitySearchRun(cmd *cobra.Command, args []string) {
 			fmt.Println("---")
 		}
 	}
+	printSearchFooter(entitySearchPage, entitySearchPageSize, page)
 }
@@ -0,0 +1,51 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+)
+
+var (
+	entityTrashCmd = &cobra.Command{
+		Use:     "trash",
+		Short:   "List entities that can be restored",
+		Long:    entityTrashLongDocs,
+		Example: entityTrashExample,
+		Args:    cobra.NoArgs,
+		Run:     entityTrashRun,
+	}
+
+	entityTrashLongDocs = `
+List the entities that have been destroyed but are still held in the
+trash.  Each entity is shown with the time that it was destroyed and the
+entity that destroyed it.  Entities remain in the trash until they are
+restored, purged, or have been there for longer than the retention
+period configured on the server.  If the server does not keep a trash
+then this list is always empty.
+
+The caller must possess the DESTROY_ENTITY capability or be a
+GLOBAL_ROOT operator for this command to succeed.`
+
+	entityTrashExample = `$ netauth entity trash
+KIND    NAME  DELETED               BY
+entity  demo  2021-06-01T12:00:00Z  admin`
+)
+
+func init() {
+	entityCmd.AddCommand(entityTrashCmd)
+}
+
+func entityTrashRun(cmd *cobra.Command, args []string) {
+	ctx = netauth.Authorize(ctx, token())
+
+	res, err := rpc.EntityTrash(ctx)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	printTrash(res)
+}
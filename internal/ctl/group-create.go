@@ -14,6 +14,7 @@ var (
 	newGroupNumber      int
 	newGroupDisplayName string
 	newGroupManagedBy   string
+	newGroupNumberRange string
 
 	groupCreateCmd = &cobra.Command{
 		Use:     "create <name>",
@@ -35,6 +36,8 @@ Additional fields can be specified on the command line such as the
 display name, or a group to defer management capability to.  If
 desired a custom number can be provided, but the default behavior is
 sufficient to select a valid unallocated number for the new group.
+If the server is configured with more than one range of numbers, the
+range to choose from can be given with --number-range.
 
 The caller must possess the CREATE_GROUP capability or be a GLOBAL_ROOT
 operator for this command to succeed.`
@@ -48,12 +51,16 @@ func init() {
 	groupCreateCmd.Flags().IntVar(&newGroupNumber, "number", -1, "Number to assign.")
 	groupCreateCmd.Flags().StringVar(&newGroupDisplayName, "display-name", "", "Group display name")
 	groupCreateCmd.Flags().StringVar(&newGroupManagedBy, "managed-by", "", "Delegate management to this group")
+	groupCreateCmd.Flags().StringVar(&newGroupNumberRange, "number-range", "", "Range to choose the number from")
 }
 
 func groupCreateRun(cmd *cobra.Command, args []string) {
 	newGroupName = args[0]
 
 	ctx = netauth.Authorize(ctx, token())
+	if newGroupNumberRange != "" {
+		ctx = netauth.WithNumberRange(ctx, newGroupNumberRange)
+	}
 
 	if err := rpc.GroupCreate(ctx, newGroupName, newGroupDisplayName, newGroupManagedBy, newGroupNumber); err != nil {
 		fmt.Println(err)
@@ -24,6 +24,9 @@ Destroy the group with the specified name.  The group is deleted
 immediately and without confirmation, please ensure you have typed the
 ID correctly.
 
+If the server keeps a trash then the group can be brought back with
+'netauth group restore' until it is purged.
+
 Referential integrity is not checked before deletion.  You are
 strongly encouraged to empty groups before deleting them as well as
 remove any expansions that target the group to be deleted.
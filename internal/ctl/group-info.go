@@ -9,20 +9,24 @@ import (
 
 var (
 	groupInfoFields string
+	groupInfoNumber int
 
 	groupInfoCmd = &cobra.Command{
-		Use:     "info <group>",
+		Use:     "info [group]",
 		Short:   "Fetch information on an existing group",
 		Long:    groupInfoLongDocs,
 		Example: groupInfoExample,
-		Args:    cobra.ExactArgs(1),
+		Args:    groupInfoArgs,
 		Run:     groupInfoRun,
 	}
 
 	groupInfoLongDocs = `
 The info command retursn information on any group known to the server.
 The output may be filtered with the --fields option which takes a
-comma separated list of field names to display.`
+comma separated list of field names to display.
+
+Instead of a name the group may be given by number with --number,
+which looks the number up directly rather than searching for it.`
 
 	groupInfoExample = `$ netauth group info example-group
 Name: example-group
@@ -34,12 +38,31 @@ Expansion: INCLUDE:example-group2`
 func init() {
 	groupCmd.AddCommand(groupInfoCmd)
 	groupInfoCmd.Flags().StringVar(&groupInfoFields, "fields", "", "Fields to be displayed")
+	groupInfoCmd.Flags().IntVar(&groupInfoNumber, "number", -1, "Look up the group with this number")
+}
+
+func groupInfoArgs(cmd *cobra.Command, args []string) error {
+	if cmd.Flags().Changed("number") {
+		return cobra.NoArgs(cmd, args)
+	}
+	return cobra.ExactArgs(1)(cmd, args)
 }
 
 func groupInfoRun(cmd *cobra.Command, args []string) {
+	var name string
+	if cmd.Flags().Changed("number") {
+		g, err := rpc.GroupInfoByNumber(ctx, groupInfoNumber)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		name = g.GetName()
+	} else {
+		name = args[0]
+	}
 
 	// Obtain group info
-	result, sub, err := rpc.GroupInfo(ctx, args[0])
+	result, sub, err := rpc.GroupInfo(ctx, name)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -0,0 +1,45 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+)
+
+var (
+	groupPurgeCmd = &cobra.Command{
+		Use:     "purge <name>",
+		Short:   "Permanently remove a group from the trash",
+		Long:    groupPurgeLongDocs,
+		Example: groupPurgeExample,
+		Args:    cobra.ExactArgs(1),
+		Run:     groupPurgeRun,
+	}
+
+	groupPurgeLongDocs = `
+Permanently remove a destroyed group from the trash.  Once purged
+the group cannot be restored.
+
+The caller must possess the DESTROY_GROUP capability or be a
+GLOBAL_ROOT operator for this command to succeed.`
+
+	groupPurgeExample = `$ netauth group purge demo-group
+Group Purged`
+)
+
+func init() {
+	groupCmd.AddCommand(groupPurgeCmd)
+}
+
+func groupPurgeRun(cmd *cobra.Command, args []string) {
+	ctx = netauth.Authorize(ctx, token())
+
+	if err := rpc.GroupPurge(ctx, args[0]); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Group Purged")
+}
@@ -0,0 +1,46 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+)
+
+var (
+	groupRestoreCmd = &cobra.Command{
+		Use:     "restore <name>",
+		Short:   "Restore a destroyed group from the trash",
+		Long:    groupRestoreLongDocs,
+		Example: groupRestoreExample,
+		Args:    cobra.ExactArgs(1),
+		Run:     groupRestoreRun,
+	}
+
+	groupRestoreLongDocs = `
+Restore a destroyed group from the trash.  The group is restored
+exactly as it was when it was destroyed.  Restoring fails if another
+group with the same name or number has been created in the meantime.
+
+The caller must possess the CREATE_GROUP capability or be a
+GLOBAL_ROOT operator for this command to succeed.`
+
+	groupRestoreExample = `$ netauth group restore demo-group
+Group Restored`
+)
+
+func init() {
+	groupCmd.AddCommand(groupRestoreCmd)
+}
+
+func groupRestoreRun(cmd *cobra.Command, args []string) {
+	ctx = netauth.Authorize(ctx, token())
+
+	if err := rpc.GroupRestore(ctx, args[0]); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Group Restored")
+}
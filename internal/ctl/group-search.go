@@ -8,7 +8,11 @@ import (
 )
 
 var (
-	groupSearchFields string
+	groupSearchFields   string
+	groupSearchPage     int
+	groupSearchPageSize int
+	groupSearchSort     string
+	groupSearchLDAP     bool
 
 	groupSearchCmd = &cobra.Command{
 		Use:     "search <expression>",
@@ -26,7 +30,20 @@ sure to quote the expression if making a complex query.
 
 All set fields on returned groups will be displayed.  To display
 only certain fields pass a comma separated list to the --fields
-argument of the field names you wish to display.`
+argument of the field names you wish to display.
+
+Values stored with the kv command can be searched for by prefixing
+the key with 'kv.' as in 'kv.owner:infra'.
+
+Large result sets can be displayed a page at a time by passing the
+page number to --page, and the number of results on each page to
+--page-size.  Results are ordered by how well they match unless a
+comma separated list of fields is passed to --sort, and fields
+prefixed with '-' are sorted in descending order.
+
+Tools that speak LDAP can pass an RFC 4515 filter instead by setting
+--ldap.  The usual posixGroup attributes such as cn and gidNumber are
+understood, and memberUid finds the groups an entity is a member of.`
 
 	groupSearchExample = `$ netauth group search 'Name:example*'
 Name: example-group
@@ -43,10 +60,20 @@ Number: 11
 func init() {
 	groupCmd.AddCommand(groupSearchCmd)
 	groupSearchCmd.Flags().StringVar(&groupSearchFields, "fields", "", "Fields to be displayed")
+	groupSearchCmd.Flags().IntVar(&groupSearchPage, "page", 0, "Page of results to display, starting from 1")
+	groupSearchCmd.Flags().IntVar(&groupSearchPageSize, "page-size", 50, "Number of results on each page")
+	groupSearchCmd.Flags().StringVar(&groupSearchSort, "sort", "", "Comma separated fields to sort by, prefix with '-' to reverse")
+	groupSearchCmd.Flags().BoolVar(&groupSearchLDAP, "ldap", false, "Interpret the expression as an LDAP filter")
 }
 
 func groupSearchRun(cmd *cobra.Command, args []string) {
-	res, err := rpc.GroupSearch(ctx, args[0])
+	if groupSearchPage > 0 && groupSearchPageSize < 1 {
+		fmt.Println("--page-size must be at least 1")
+		os.Exit(1)
+	}
+
+	opts := searchOptions(groupSearchPage, groupSearchPageSize, groupSearchSort, groupSearchLDAP)
+	res, page, err := rpc.GroupSearchPage(ctx, args[0], opts)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -59,4 +86,5 @@ func groupSearchRun(cmd *cobra.Command, args []string) {
 			fmt.Println("---")
 		}
 	}
+	printSearchFooter(groupSearchPage, groupSearchPageSize, page)
 }
@@ -0,0 +1,51 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+)
+
+var (
+	groupTrashCmd = &cobra.Command{
+		Use:     "trash",
+		Short:   "List groups that can be restored",
+		Long:    groupTrashLongDocs,
+		Example: groupTrashExample,
+		Args:    cobra.NoArgs,
+		Run:     groupTrashRun,
+	}
+
+	groupTrashLongDocs = `
+List the groups that have been destroyed but are still held in the
+trash.  Each group is shown with the time that it was destroyed and the
+entity that destroyed it.  Groups remain in the trash until they are
+restored, purged, or have been there for longer than the retention
+period configured on the server.  If the server does not keep a trash
+then this list is always empty.
+
+The caller must possess the DESTROY_GROUP capability or be a
+GLOBAL_ROOT operator for this command to succeed.`
+
+	groupTrashExample = `$ netauth group trash
+KIND   NAME        DELETED               BY
+group  demo-group  2021-06-01T12:00:00Z  admin`
+)
+
+func init() {
+	groupCmd.AddCommand(groupTrashCmd)
+}
+
+func groupTrashRun(cmd *cobra.Command, args []string) {
+	ctx = netauth.Authorize(ctx, token())
+
+	res, err := rpc.GroupTrash(ctx)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	printTrash(res)
+}
@@ -4,11 +4,14 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/bgentry/speakeasy"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/netauth/netauth/pkg/netauth"
 	"github.com/netauth/netauth/pkg/token/cache"
 
 	pb "github.com/netauth/protocol"
@@ -213,3 +216,40 @@ func printGroup(group *pb.Group, fields string) {
 		}
 	}
 }
+
+// printTrash prints the contents of the trash as a table.
+func printTrash(entries []netauth.TrashEntry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tDELETED\tBY")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Kind, e.Name, e.Deleted.Format(time.RFC3339), e.Actor)
+	}
+	w.Flush()
+}
+
+// searchOptions converts a page number, counting from 1, and a page
+// size into the options for a paged search.  Page 0 requests every
+// result.  If ldap is set the expression is sent as an LDAP filter.
+func searchOptions(page, size int, sortBy string, ldap bool) netauth.SearchOptions {
+	opts := netauth.SearchOptions{}
+	if ldap {
+		opts.Syntax = "ldap"
+	}
+	if sortBy != "" {
+		opts.SortBy = strings.Split(sortBy, ",")
+	}
+	if page > 0 {
+		opts.PageSize = size
+		opts.Offset = (page - 1) * size
+	}
+	return opts
+}
+
+// printSearchFooter describes which page of results was displayed.
+func printSearchFooter(page, size int, p netauth.SearchPage) {
+	if page < 1 {
+		return
+	}
+	pages := (p.Total + size - 1) / size
+	fmt.Printf("--- Page %d of %d (%d results)\n", page, pages, p.Total)
+}
@@ -0,0 +1,89 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+)
+
+var (
+	systemChainsCmd = &cobra.Command{
+		Use:     "chains",
+		Short:   "Show the hook chains the server runs",
+		Long:    systemChainsLongDocs,
+		Example: systemChainsExample,
+		Args:    cobra.NoArgs,
+		Run:     systemChainsRun,
+	}
+
+	systemChainsLongDocs = `
+The chains command shows the hooks that the server runs to carry out
+each kind of request on entities and groups.  Hooks run in order of
+priority, from lowest to highest.
+
+The chains shown are the effective chains, and include any changes
+made in the tree.entity-chains and tree.group-chains sections of the
+server configuration as well as hooks that were inserted by plugins.
+
+A chain in the server configuration replaces the whole default chain.
+To add hooks to a chain while keeping its defaults, include "..." in
+the list of hooks, for example:
+
+    [tree.entity-chains]
+    SET-SECRET = ["...", "validate-entity-unlocked"]
+
+Otherwise every hook that the chain should run must be listed, and
+hooks added to the defaults in later versions will not be run.`
+
+	systemChainsExample = `$ netauth system chains
+Entity Chains
+CHAIN       PRIORITY  HOOK
+...
+SET-SECRET  0         load-entity
+SET-SECRET  50        set-entity-secret
+SET-SECRET  99        save-entity
+...
+`
+)
+
+func init() {
+	systemCmd.AddCommand(systemChainsCmd)
+}
+
+func systemChainsRun(cmd *cobra.Command, args []string) {
+	entity, group, err := rpc.SystemChains(ctx)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Entity Chains")
+	printChains(entity)
+	fmt.Println()
+	fmt.Println("Group Chains")
+	printChains(group)
+}
+
+// printChains prints every chain as a table, with the chains in
+// alphabetical order.
+func printChains(chains map[string][]netauth.ChainHook) {
+	names := make([]string, 0, len(chains))
+	for c := range chains {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHAIN\tPRIORITY\tHOOK")
+	for _, c := range names {
+		for _, h := range chains[c] {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", c, h.Priority, h.Name)
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,126 @@
+package ctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netauth/netauth/pkg/netauth"
+)
+
+var (
+	systemReportJSON   bool
+	systemReportFilter string
+	systemReportSize   int
+
+	systemReportCmd = &cobra.Command{
+		Use:     "report",
+		Short:   "Summarize entities and groups on the server",
+		Long:    systemReportLongDocs,
+		Example: systemReportExample,
+		Args:    cobra.NoArgs,
+		Run:     systemReportRun,
+	}
+
+	systemReportLongDocs = `
+The report command counts entities and groups on the server without
+fetching them.  Entities are counted by primary group, by shell, and
+by whether or not they are locked, and groups are counted by the
+group that manages them.
+
+Pass a search expression to --filter to only count the entities that
+match it, and --size to limit the number of values shown for each
+field.  Values beyond the limit are summed into a single row.
+
+The report is printed as a set of tables, or as JSON if --json is
+passed.`
+
+	systemReportExample = `$ netauth system report
+Entities by PrimaryGroup
+VALUE   COUNT
+users   41
+admins  3
+(none)  2
+
+Entities by Shell
+VALUE      COUNT
+/bin/bash  40
+/bin/zsh   6
+
+Entities by Locked
+VALUE  COUNT
+false  45
+true   1
+
+Groups by ManagedBy
+VALUE   COUNT
+admins  12
+(none)  4
+
+$ netauth system report --filter 'kv.department:infra' --json
+`
+)
+
+// systemReport is the form in which the report is printed as JSON.
+type systemReport struct {
+	Entities []netauth.Facet `json:"entities"`
+	Groups   []netauth.Facet `json:"groups"`
+}
+
+func init() {
+	systemCmd.AddCommand(systemReportCmd)
+	systemReportCmd.Flags().BoolVar(&systemReportJSON, "json", false, "Print the report as JSON")
+	systemReportCmd.Flags().StringVar(&systemReportFilter, "filter", "", "Only count entities matching this expression")
+	systemReportCmd.Flags().IntVar(&systemReportSize, "size", 0, "Most values to show for each field")
+}
+
+func systemReportRun(cmd *cobra.Command, args []string) {
+	entities, err := rpc.EntityAggregate(ctx, systemReportFilter, []string{"PrimaryGroup", "Shell", "Locked"}, systemReportSize)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	groups, err := rpc.GroupAggregate(ctx, "", []string{"ManagedBy"}, systemReportSize)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if systemReportJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(systemReport{Entities: entities, Groups: groups})
+		return
+	}
+
+	for _, f := range entities {
+		printFacet("Entities", f)
+		fmt.Println()
+	}
+	for i, f := range groups {
+		printFacet("Groups", f)
+		if i < len(groups)-1 {
+			fmt.Println()
+		}
+	}
+}
+
+// printFacet prints the counts for a single field as a table.
+func printFacet(kind string, f netauth.Facet) {
+	fmt.Printf("%s by %s\n", kind, f.Field)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VALUE\tCOUNT")
+	for _, t := range f.Terms {
+		fmt.Fprintf(w, "%s\t%d\n", t.Term, t.Count)
+	}
+	if f.Other > 0 {
+		fmt.Fprintf(w, "(other)\t%d\n", f.Other)
+	}
+	if f.Missing > 0 {
+		fmt.Fprintf(w, "(none)\t%d\n", f.Missing)
+	}
+	w.Flush()
+}
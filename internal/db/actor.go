@@ -0,0 +1,21 @@
+package db
+
+import (
+	"context"
+)
+
+type actorKey struct{}
+
+// WithActor returns a context that records the ID of the entity on
+// whose behalf a change is being made.  Stores that keep a history of
+// changes can use ActorFromContext to attribute them.
+func WithActor(ctx context.Context, ID string) context.Context {
+	return context.WithValue(ctx, actorKey{}, ID)
+}
+
+// ActorFromContext returns the ID of the entity making a change, or
+// the empty string if it isn't known.
+func ActorFromContext(ctx context.Context) string {
+	s, _ := ctx.Value(actorKey{}).(string)
+	return s
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
+
+	pb "github.com/netauth/protocol"
+)
+
+// Facets can only be computed over the fields listed here.  The
+// values of these fields are indexed a second time below the facet
+// prefix without being broken into words, so that a shell such as
+// /bin/bash or a group such as site-admins is counted as a single
+// term.
+var (
+	entityFacets = []string{"PrimaryGroup", "Shell", "Locked"}
+	groupFacets  = []string{"ManagedBy"}
+)
+
+// AggregateRequest asks for the number of objects that have each
+// value of the named fields.
+type AggregateRequest struct {
+	// Expression limits the objects that are counted to those
+	// that match it.  All objects are counted if it is empty.
+	Expression string
+
+	// Fields are the fields to count values of.
+	Fields []string
+
+	// Size is the most values to return for each field, values
+	// beyond which are summed into Facet.Other.  A size that is
+	// zero or larger than the most results a search will return
+	// is reduced to that limit.
+	Size int
+}
+
+// A Facet holds the counts of each value of a field.
+type Facet struct {
+	Field string
+
+	// Missing is the number of objects that have no value for
+	// the field, and Other is the number that have a value that
+	// was left out of Terms.
+	Missing int
+	Other   int
+
+	Terms []FacetTerm
+}
+
+// A FacetTerm is a single value of a field, and the number of objects
+// that have it.
+type FacetTerm struct {
+	Term  string
+	Count int
+}
+
+// facetMapping returns the mapping for the facet fields.  They are
+// left out of the composite field since they duplicate fields that
+// are already in it.
+func facetMapping(fields []string) *mapping.DocumentMapping {
+	m := bleve.NewDocumentStaticMapping()
+	for _, f := range fields {
+		fm := bleve.NewTextFieldMapping()
+		fm.Analyzer = keyword.Name
+		fm.IncludeInAll = false
+		m.AddFieldMappingsAt(f, fm)
+	}
+	return m
+}
+
+// entityFacetValues returns the values of the facet fields for an
+// entity.  Fields that aren't set are left out so that they are
+// counted as missing.
+func entityFacetValues(e *pb.Entity) map[string]string {
+	out := map[string]string{
+		"Locked": strconv.FormatBool(e.GetMeta().GetLocked()),
+	}
+	if v := e.GetMeta().GetPrimaryGroup(); v != "" {
+		out["PrimaryGroup"] = v
+	}
+	if v := e.GetMeta().GetShell(); v != "" {
+		out["Shell"] = v
+	}
+	return out
+}
+
+// groupFacetValues returns the values of the facet fields for a
+// group.
+func groupFacetValues(g *pb.Group) map[string]string {
+	out := map[string]string{}
+	if v := g.GetManagedBy(); v != "" {
+		out["ManagedBy"] = v
+	}
+	return out
+}
+
+// AggregateEntities counts the entities that have each value of the
+// requested fields.
+func (s *Index) AggregateEntities(r AggregateRequest) ([]Facet, error) {
+	return s.aggregate(s.eIndex, entityFacets, r)
+}
+
+// AggregateGroups counts the groups that have each value of the
+// requested fields.
+func (s *Index) AggregateGroups(r AggregateRequest) ([]Facet, error) {
+	return s.aggregate(s.gIndex, groupFacets, r)
+}
+
+func (s *Index) aggregate(idx bleve.Index, allowed []string, r AggregateRequest) ([]Facet, error) {
+	if len(r.Fields) == 0 {
+		return nil, ErrBadSearch
+	}
+
+	var q query.Query = bleve.NewMatchAllQuery()
+	if r.Expression != "" {
+		q = bleve.NewQueryStringQuery(r.Expression)
+	}
+	size := r.Size
+	if size <= 0 || size > maxSearchResults {
+		size = maxSearchResults
+	}
+
+	req := bleve.NewSearchRequestOptions(q, 0, 0, false)
+	for _, f := range r.Fields {
+		if !isFacetField(allowed, f) {
+			return nil, ErrBadSearch
+		}
+		req.AddFacet(f, bleve.NewFacetRequest("facet."+f, size))
+	}
+
+	result, err := idx.Search(req)
+	if err != nil {
+		s.l.Debug("Aggregation failed", "index", idx.Name(), "error", err)
+		return nil, ErrBadSearch
+	}
+
+	out := make([]Facet, 0, len(r.Fields))
+	for _, f := range r.Fields {
+		fr := result.Facets[f]
+		facet := Facet{Field: f, Terms: []FacetTerm{}}
+		if fr != nil {
+			facet.Missing = fr.Missing
+			facet.Other = fr.Other
+			for _, t := range fr.Terms {
+				facet.Terms = append(facet.Terms, FacetTerm{Term: t.Term, Count: t.Count})
+			}
+		}
+		out = append(out, facet)
+	}
+	return out, nil
+}
+
+func isFacetField(allowed []string, f string) bool {
+	for _, a := range allowed {
+		if a == f {
+			return true
+		}
+	}
+	return false
+}
+
+// AggregateEntities counts the entities that have each value of the
+// requested fields.  ErrBadSearch is returned if a field can't be
+// aggregated or the expression is invalid.
+func (db *DB) AggregateEntities(ctx context.Context, r AggregateRequest) ([]Facet, error) {
+	return db.Index.AggregateEntities(r)
+}
+
+// AggregateGroups counts the groups that have each value of the
+// requested fields in the same way as AggregateEntities.
+func (db *DB) AggregateGroups(ctx context.Context, r AggregateRequest) ([]Facet, error) {
+	return db.Index.AggregateGroups(r)
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestAggregateEntities(t *testing.T) {
+	si := NewIndex(hclog.NewNullLogger())
+
+	shells := []string{"/bin/bash", "/bin/bash", "/bin/zsh", ""}
+	for i, shell := range shells {
+		e := &pb.Entity{
+			ID: proto.String(fmt.Sprintf("entity%d", i)),
+			Meta: &pb.EntityMeta{
+				PrimaryGroup: proto.String("site-admins"),
+				Locked:       proto.Bool(i == 0),
+			},
+		}
+		if shell != "" {
+			e.Meta.Shell = proto.String(shell)
+		}
+		assert.Nil(t, si.IndexEntity(e))
+	}
+
+	res, err := si.AggregateEntities(AggregateRequest{Fields: []string{"Shell", "PrimaryGroup", "Locked"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Facet{
+		{Field: "Shell", Missing: 1, Terms: []FacetTerm{{"/bin/bash", 2}, {"/bin/zsh", 1}}},
+		{Field: "PrimaryGroup", Terms: []FacetTerm{{"site-admins", 4}}},
+		{Field: "Locked", Terms: []FacetTerm{{"false", 3}, {"true", 1}}},
+	}, res)
+
+	// Values beyond the size are summed, and the expression limits
+	// what is counted.
+	res, err = si.AggregateEntities(AggregateRequest{Expression: "-ID:entity0", Fields: []string{"Shell"}, Size: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, []Facet{
+		{Field: "Shell", Missing: 1, Other: 1, Terms: []FacetTerm{{"/bin/bash", 1}}},
+	}, res)
+
+	_, err = si.AggregateEntities(AggregateRequest{Fields: []string{"secret"}})
+	assert.Equal(t, ErrBadSearch, err)
+	_, err = si.AggregateEntities(AggregateRequest{})
+	assert.Equal(t, ErrBadSearch, err)
+}
+
+func TestAggregateGroups(t *testing.T) {
+	si := NewIndex(hclog.NewNullLogger())
+
+	assert.Nil(t, si.IndexGroup(&pb.Group{Name: proto.String("group1"), ManagedBy: proto.String("group1")}))
+	assert.Nil(t, si.IndexGroup(&pb.Group{Name: proto.String("group2"), ManagedBy: proto.String("group1")}))
+	assert.Nil(t, si.IndexGroup(&pb.Group{Name: proto.String("group3")}))
+
+	res, err := si.AggregateGroups(AggregateRequest{Fields: []string{"ManagedBy"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Facet{
+		{Field: "ManagedBy", Missing: 1, Terms: []FacetTerm{{"group1", 2}}},
+	}, res)
+}
@@ -0,0 +1,224 @@
+package db
+
+import (
+	"context"
+)
+
+// batchKey is used to locate an active batch in a context.
+type batchKey struct{}
+
+// batchOp is a single pending write within a batch.  The value is the
+// bare object; the revision header is added when the batch is
+// committed.
+type batchOp struct {
+	key   string
+	value []byte
+	del   bool
+}
+
+// A batch holds the writes made while it is active so that they can
+// be committed together.  It also remembers the revision of every key
+// that was read from the store so that the commit can fail if any of
+// them have changed in the meantime.
+type batch struct {
+	ops   []batchOp
+	reads map[string]revision
+}
+
+func newBatch() *batch {
+	return &batch{reads: make(map[string]revision)}
+}
+
+// put queues a write of v to k.
+func (b *batch) put(k string, v []byte) {
+	b.ops = append(b.ops, batchOp{key: k, value: v})
+}
+
+// del queues the removal of k.
+func (b *batch) del(k string) {
+	b.ops = append(b.ops, batchOp{key: k, del: true})
+}
+
+// get returns the most recent pending write for k.  The second value
+// is false if there is no pending write for the key.
+func (b *batch) get(k string) (batchOp, bool) {
+	for i := len(b.ops) - 1; i >= 0; i-- {
+		if b.ops[i].key == k {
+			return b.ops[i], true
+		}
+	}
+	return batchOp{}, false
+}
+
+// read records the revision that k was at when it was first read.
+func (b *batch) read(k string, r revision) {
+	if _, ok := b.reads[k]; !ok {
+		b.reads[k] = r
+	}
+}
+
+// batchFromContext returns the active batch, or nil if there isn't
+// one.
+func batchFromContext(ctx context.Context) *batch {
+	b, _ := ctx.Value(batchKey{}).(*batch)
+	return b
+}
+
+// Batch calls f with a context that carries a new batch.  Entities
+// and groups that are saved or deleted using this context are held in
+// the batch until f returns, at which point they are committed
+// together.  If f returns an error then nothing is written.  Loads
+// made with the context will see the pending writes, but discovery
+// and search will not.
+//
+// Objects that were loaded with the context are only written if they
+// are still at the revision that was loaded.  Likewise, an object
+// that was found not to exist must still not exist.  If any object
+// has changed then nothing is written and ErrConflict is returned, in
+// which case the caller may run f again to retry.
+//
+// If the KV store advertises KVTransactional then the batch is
+// committed atomically.  Other stores will have the writes applied
+// one at a time, and a failure part way through will leave the
+// earlier writes in place.
+//
+// Calling Batch with a context that already carries a batch will add
+// to the existing batch rather than starting a new one.
+func (db *DB) Batch(ctx context.Context, f func(context.Context) error) error {
+	if batchFromContext(ctx) != nil {
+		return f(ctx)
+	}
+
+	b := newBatch()
+	if err := f(context.WithValue(ctx, batchKey{}, b)); err != nil {
+		return err
+	}
+	return db.commitBatch(ctx, b)
+}
+
+// commitBatch checks the revisions of the keys that are about to be
+// written and that no number is being given to two objects, and then
+// writes out all the operations that are pending in the batch.  Commits are serialized so that no other write can land
+// between the check and the write.
+func (db *DB) commitBatch(ctx context.Context, b *batch) error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+
+	db.commitMu.Lock()
+	defer db.commitMu.Unlock()
+
+	writes := make([]batchOp, len(b.ops))
+	for i, op := range b.ops {
+		cur, err := db.currentRevision(ctx, op.key)
+		if err != nil {
+			db.log.Warn("Error reading revision", "key", op.key, "error", err)
+			return ErrInternalError
+		}
+		if want, ok := b.reads[op.key]; ok && want != cur {
+			db.log.Debug("Revision conflict", "key", op.key, "want", want.rev, "have", cur.rev)
+			return ErrConflict
+		}
+		writes[i] = op
+		if !op.del {
+			writes[i].value = encodeValue(cur.rev+1, op.value)
+		}
+	}
+
+	entities, groups := numberChanges(b.ops)
+	if err := db.entityNumbers.check(ctx, entities); err != nil {
+		return err
+	}
+	if err := db.groupNumbers.check(ctx, groups); err != nil {
+		return err
+	}
+
+	err := db.applyBatch(ctx, writes)
+	for _, op := range writes {
+		db.cache.invalidate(op.key)
+	}
+	if err != nil {
+		return err
+	}
+	db.entityNumbers.apply(entities)
+	db.groupNumbers.apply(groups)
+	return nil
+}
+
+// applyBatch writes out the operations in a batch.
+func (db *DB) applyBatch(ctx context.Context, writes []batchOp) error {
+	if tx, ok := db.kv.(TxKVStore); ok && db.hasCapability(KVTransactional) {
+		return db.commitBatchTx(ctx, tx, writes)
+	}
+
+	if len(writes) > 1 {
+		db.log.Debug("KV store is not transactional, batch will not be atomic", "ops", len(writes))
+	}
+	for i, op := range writes {
+		var err error
+		if op.del {
+			err = db.kv.Del(ctx, op.key)
+		} else {
+			err = db.kv.Put(ctx, op.key, op.value)
+		}
+		if err != nil && !(op.del && err == ErrNoValue) {
+			db.log.Error("Batch partially applied",
+				"applied", batchKeys(writes[:i]),
+				"failed", op.key,
+				"unapplied", batchKeys(writes[i+1:]),
+				"error", err)
+			return ErrInternalError
+		}
+	}
+	return nil
+}
+
+// commitBatchTx applies the writes using a transaction in the KV
+// store.
+func (db *DB) commitBatchTx(ctx context.Context, kv TxKVStore, writes []batchOp) error {
+	tx, err := kv.Begin(ctx)
+	if err != nil {
+		db.log.Warn("Error starting transaction", "error", err)
+		return ErrInternalError
+	}
+
+	for _, op := range writes {
+		if op.del {
+			err = tx.Del(ctx, op.key)
+		} else {
+			err = tx.Put(ctx, op.key, op.value)
+		}
+		if err != nil && !(op.del && err == ErrNoValue) {
+			db.log.Warn("Error in transaction, rolling back", "key", op.key, "error", err)
+			if err := tx.Rollback(); err != nil {
+				db.log.Error("Error rolling back transaction", "error", err)
+			}
+			return ErrInternalError
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		db.log.Warn("Error committing transaction", "keys", batchKeys(writes), "error", err)
+		return ErrInternalError
+	}
+	return nil
+}
+
+// hasCapability checks if the KV store advertises the given
+// capability.
+func (db *DB) hasCapability(c KVCapability) bool {
+	for _, have := range db.kv.Capabilities() {
+		if have == c {
+			return true
+		}
+	}
+	return false
+}
+
+func batchKeys(ops []batchOp) []string {
+	out := make([]string, len(ops))
+	for i := range ops {
+		out[i] = ops[i].key
+	}
+	return out
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/proto"
+
+	types "github.com/netauth/protocol"
+)
+
+// mockTxKV is a mockKV that also supports transactions, which are
+// recorded so they can be inspected.
+type mockTxKV struct {
+	mockKV
+
+	tx *mockTx
+}
+
+type mockTx struct {
+	ops        []string
+	failOn     string
+	committed  bool
+	rolledBack bool
+}
+
+func newMockTxKV(hclog.Logger) (KVStore, error) {
+	x := &mockTxKV{}
+	x.On("SetEventFunc", mock.Anything).Return()
+	x.On("Capabilities").Return([]KVCapability{KVMutable, KVTransactional})
+	x.On("Get", mock.Anything).Return([]byte{}, ErrNoValue)
+	return x, nil
+}
+
+func (mkv *mockTxKV) Begin(context.Context) (KVTx, error) {
+	if mkv.tx == nil {
+		mkv.tx = &mockTx{}
+	}
+	return mkv.tx, nil
+}
+
+func (t *mockTx) Put(_ context.Context, k string, _ []byte) error {
+	if k == t.failOn {
+		return errors.New("something internal")
+	}
+	t.ops = append(t.ops, "PUT "+k)
+	return nil
+}
+
+func (t *mockTx) Del(_ context.Context, k string) error {
+	t.ops = append(t.ops, "DEL "+k)
+	return nil
+}
+
+func (t *mockTx) Commit() error {
+	t.committed = true
+	return nil
+}
+
+func (t *mockTx) Rollback() error {
+	t.rolledBack = true
+	return nil
+}
+
+func TestBatchNotTransactional(t *testing.T) {
+	ctx := context.Background()
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	m.kv.(*mockKV).On("Capabilities").Return([]KVCapability{KVMutable})
+	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(goodEntityBytes1, nil)
+	m.kv.(*mockKV).On("Put", "/entities/entity1", mock.Anything).Return(nil)
+	m.kv.(*mockKV).On("Get", "/groups/group1").Return([]byte{}, ErrNoValue)
+	m.kv.(*mockKV).On("Put", "/groups/group1", mock.Anything).Return(nil)
+	m.kv.(*mockKV).On("Del", "/entities/entity1").Return(nil)
+	m.kv.(*mockKV).On("Get", "/secret-history/entity1").Return([]byte{}, ErrNoValue)
+
+	err = m.Batch(ctx, func(ctx context.Context) error {
+		e, err := m.LoadEntity(ctx, "entity1")
+		assert.Nil(t, err)
+		e.Number = proto.Int32(42)
+		assert.Nil(t, m.SaveEntity(ctx, e))
+
+		// Loads see the pending write.
+		e, err = m.LoadEntity(ctx, "entity1")
+		assert.Nil(t, err)
+		assert.Equal(t, int32(42), e.GetNumber())
+
+		// Nothing has been written yet.
+		m.kv.(*mockKV).AssertNotCalled(t, "Put", "/entities/entity1", mock.Anything)
+
+		assert.Nil(t, m.SaveGroup(ctx, &types.Group{Name: proto.String("group1")}))
+		return m.DeleteEntity(ctx, "entity1")
+	})
+	assert.Nil(t, err)
+	m.kv.(*mockKV).AssertCalled(t, "Put", "/entities/entity1", mock.Anything)
+	m.kv.(*mockKV).AssertCalled(t, "Put", "/groups/group1", mock.Anything)
+	m.kv.(*mockKV).AssertCalled(t, "Del", "/entities/entity1")
+}
+
+func TestBatchNotTransactionalFailure(t *testing.T) {
+	ctx := context.Background()
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	m.kv.(*mockKV).On("Capabilities").Return([]KVCapability{KVMutable})
+	m.kv.(*mockKV).On("Get", "/entities/bad").Return([]byte{}, ErrNoValue)
+	m.kv.(*mockKV).On("Put", "/entities/bad", mock.Anything).Return(errors.New("something internal"))
+
+	err = m.Batch(ctx, func(ctx context.Context) error {
+		return m.SaveEntity(ctx, &types.Entity{ID: proto.String("bad")})
+	})
+	assert.Equal(t, ErrInternalError, err)
+}
+
+func TestBatchAborted(t *testing.T) {
+	ctx := context.Background()
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	err = m.Batch(ctx, func(ctx context.Context) error {
+		assert.Nil(t, m.SaveEntity(ctx, &types.Entity{ID: proto.String("entity1")}))
+		return ErrUnknownGroup
+	})
+	assert.Equal(t, ErrUnknownGroup, err)
+	m.kv.(*mockKV).AssertNotCalled(t, "Put", mock.Anything, mock.Anything)
+}
+
+func TestBatchDeleteMissing(t *testing.T) {
+	ctx := context.Background()
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	m.kv.(*mockKV).On("Get", "/entities/missing").Return([]byte{}, ErrNoValue)
+	m.kv.(*mockKV).On("Get", "/groups/missing").Return([]byte{}, ErrNoValue)
+
+	err = m.Batch(ctx, func(ctx context.Context) error {
+		assert.Equal(t, ErrUnknownEntity, m.DeleteEntity(ctx, "missing"))
+		assert.Equal(t, ErrUnknownGroup, m.DeleteGroup(ctx, "missing"))
+
+		// A pending delete hides the key from loads.
+		assert.Nil(t, m.SaveGroup(ctx, &types.Group{Name: proto.String("group1")}))
+		assert.Nil(t, m.DeleteGroup(ctx, "group1"))
+		_, err := m.LoadGroup(ctx, "group1")
+		assert.Equal(t, ErrUnknownGroup, err)
+		return errors.New("abort")
+	})
+	assert.NotNil(t, err)
+}
+
+func TestBatchTransactional(t *testing.T) {
+	ctx := context.Background()
+	RegisterKV("mocktx", newMockTxKV)
+	m, err := New("mocktx")
+	assert.Nil(t, err)
+
+	err = m.Batch(ctx, func(ctx context.Context) error {
+		assert.Nil(t, m.SaveEntity(ctx, &types.Entity{ID: proto.String("entity1")}))
+
+		// Nested batches join the outer one.
+		return m.Batch(ctx, func(ctx context.Context) error {
+			return m.SaveGroup(ctx, &types.Group{Name: proto.String("group1")})
+		})
+	})
+	assert.Nil(t, err)
+
+	tx := m.kv.(*mockTxKV).tx
+	assert.True(t, tx.committed)
+	assert.Equal(t, []string{"PUT /entities/entity1", "PUT /groups/group1"}, tx.ops)
+}
+
+func TestBatchTransactionalFailure(t *testing.T) {
+	ctx := context.Background()
+	RegisterKV("mocktx", newMockTxKV)
+	m, err := New("mocktx")
+	assert.Nil(t, err)
+	m.kv.(*mockTxKV).tx = &mockTx{failOn: "/groups/group1"}
+
+	err = m.Batch(ctx, func(ctx context.Context) error {
+		assert.Nil(t, m.SaveEntity(ctx, &types.Entity{ID: proto.String("entity1")}))
+		return m.SaveGroup(ctx, &types.Group{Name: proto.String("group1")})
+	})
+	assert.Equal(t, ErrInternalError, err)
+
+	tx := m.kv.(*mockTxKV).tx
+	assert.False(t, tx.committed)
+	assert.True(t, tx.rolledBack)
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/health"
 	"github.com/netauth/netauth/internal/startup"
 )
 
@@ -28,8 +29,13 @@ func cb() {
 // BCStore is a store implementation based on the bitcask storage
 // engine.
 type BCStore struct {
-	s *bitcask.Bitcask
-	l hclog.Logger
+	s    *bitcask.Bitcask
+	l    hclog.Logger
+	path string
+
+	minFreePct   float64
+	maxDatafiles int
+	mergeDone    chan struct{}
 
 	eF func(db.Event)
 }
@@ -43,11 +49,19 @@ const (
 	eventDelete
 )
 
-// New creates a new instance of the bitcask store.
+// New creates a new instance of the bitcask store.  If
+// bitcask.merge-interval is set then the store is compacted on that
+// schedule, see Compact.  The health checks for the store fail when
+// less than bitcask.min-free-percent of the disk is free, or when
+// there are more than bitcask.max-datafiles datafiles.
 func New(l hclog.Logger) (db.KVStore, error) {
 	p := filepath.Join(viper.GetString("core.home"), "bc")
 
-	x := &BCStore{}
+	x := &BCStore{
+		path:         p,
+		minFreePct:   viper.GetFloat64("bitcask.min-free-percent"),
+		maxDatafiles: viper.GetInt("bitcask.max-datafiles"),
+	}
 	x.l = l.Named("bitcask")
 	opts := []bitcask.Option{
 		bitcask.WithMaxKeySize(1024),
@@ -59,6 +73,13 @@ func New(l hclog.Logger) (db.KVStore, error) {
 		return nil, err
 	}
 	x.s = b
+
+	if every := viper.GetDuration("bitcask.merge-interval"); every > 0 {
+		x.mergeDone = make(chan struct{})
+		go x.mergeLoop(every, viper.GetInt64("bitcask.merge-threshold"), x.mergeDone)
+	}
+	health.RegisterCheck("bitcask-disk", x.diskCheck)
+	health.RegisterCheck("bitcask-datafiles", x.datafileCheck)
 	return x, nil
 }
 
@@ -117,6 +138,9 @@ func (bcs *BCStore) Keys(_ context.Context, f string) ([]string, error) {
 // Close terminates the connection to the bitcask and flushes it to
 // disk.  The cask must not be used after Close() is called.
 func (bcs *BCStore) Close() error {
+	if bcs.mergeDone != nil {
+		close(bcs.mergeDone)
+	}
 	return bcs.s.Close()
 }
 
@@ -151,7 +175,7 @@ func (bcs *BCStore) fireEventForKey(k string, t eventType) {
 			Type: db.EventGroupDestroy,
 		})
 	default:
-		bcs.l.Warn("Event translation called with unknown key prefix", "type", t, "key", k)
+		bcs.l.Trace("Event translation called with unknown key prefix", "type", t, "key", k)
 	}
 }
 
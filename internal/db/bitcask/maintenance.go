@@ -0,0 +1,108 @@
+//go:build !windows
+// +build !windows
+
+package bitcask
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"time"
+
+	"git.mills.io/prologic/bitcask"
+
+	"github.com/netauth/netauth/internal/health"
+)
+
+// Bitcask never rewrites its datafiles, so every write leaves the
+// previous value of the key behind on disk.  Merging rewrites the
+// live values into new datafiles and removes the old ones.  Merges
+// can be run on demand with Compact, and are run automatically every
+// bitcask.merge-interval once at least bitcask.merge-threshold bytes
+// can be reclaimed.
+
+// Compact merges the datafiles in the store, reclaiming the space
+// used by overwritten and deleted values.  The store remains usable
+// while the merge runs.
+func (bcs *BCStore) Compact(_ context.Context) error {
+	before, _ := bcs.s.Stats()
+	start := time.Now()
+	if err := bcs.s.Merge(); err != nil {
+		if err != bitcask.ErrMergeInProgress {
+			bcs.l.Error("Error merging datafiles", "error", err)
+		}
+		return err
+	}
+	after, _ := bcs.s.Stats()
+	bcs.l.Info("Datafiles merged",
+		"took", time.Since(start),
+		"datafiles", after.Datafiles,
+		"reclaimed", before.Size-after.Size,
+	)
+	return nil
+}
+
+// mergeLoop compacts the store every interval until the done channel
+// is closed.  Merges are skipped if less than threshold bytes could
+// be reclaimed.
+func (bcs *BCStore) mergeLoop(every time.Duration, threshold int64, done chan struct{}) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			if r := bcs.s.Reclaimable(); r < threshold {
+				bcs.l.Trace("Skipping merge", "reclaimable", r, "threshold", threshold)
+				continue
+			}
+			bcs.Compact(context.Background())
+		}
+	}
+}
+
+// diskCheck reports the space used by the store and the space that
+// remains on the filesystem that holds it.  The check fails once the
+// free space drops below bitcask.min-free-percent of the filesystem.
+func (bcs *BCStore) diskCheck() health.SubsystemStatus {
+	status := health.SubsystemStatus{Name: "bitcask-disk"}
+
+	stats, err := bcs.s.Stats()
+	if err != nil {
+		status.Status = fmt.Sprintf("Unable to determine store size: %s", err)
+		return status
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(bcs.path, &fs); err != nil {
+		status.Status = fmt.Sprintf("Unable to determine free space: %s", err)
+		return status
+	}
+	free := fs.Bavail * uint64(fs.Bsize)
+	total := fs.Blocks * uint64(fs.Bsize)
+	pct := 100.0
+	if total > 0 {
+		pct = float64(free) / float64(total) * 100
+	}
+
+	status.OK = pct >= bcs.minFreePct
+	status.Status = fmt.Sprintf("%d bytes used, %d reclaimable, %d of %d bytes free (%.1f%%)",
+		stats.Size, bcs.s.Reclaimable(), free, total, pct)
+	return status
+}
+
+// datafileCheck reports the number of datafiles in the store.  A
+// large number of datafiles means that merges are not keeping up, and
+// the check fails once there are more than bitcask.max-datafiles.
+func (bcs *BCStore) datafileCheck() health.SubsystemStatus {
+	status := health.SubsystemStatus{Name: "bitcask-datafiles"}
+
+	stats, err := bcs.s.Stats()
+	if err != nil {
+		status.Status = fmt.Sprintf("Unable to determine datafile count: %s", err)
+		return status
+	}
+	status.OK = bcs.maxDatafiles <= 0 || stats.Datafiles <= bcs.maxDatafiles
+	status.Status = fmt.Sprintf("%d datafiles, %d keys", stats.Datafiles, stats.Keys)
+	return status
+}
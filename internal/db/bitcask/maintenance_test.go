@@ -0,0 +1,105 @@
+//go:build !windows
+// +build !windows
+
+package bitcask
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/encrypted"
+	"github.com/netauth/netauth/internal/startup"
+	_ "github.com/netauth/netauth/pkg/token/keyprovider/fs"
+)
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	kv.SetEventFunc(func(db.Event) {})
+	bcs := kv.(*BCStore)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, kv.Put(ctx, "/entities/entity1", make([]byte, 1024)))
+	}
+	assert.Nil(t, kv.Del(ctx, "/entities/entity1"))
+	assert.Nil(t, kv.Put(ctx, "/groups/group1", []byte("group1")))
+	before, err := bcs.s.Stats()
+	assert.Nil(t, err)
+	assert.NotZero(t, bcs.s.Reclaimable())
+
+	assert.Nil(t, kv.(db.CompactingKVStore).Compact(ctx))
+
+	after, err := bcs.s.Stats()
+	assert.Nil(t, err)
+	assert.Less(t, after.Size, before.Size)
+	assert.Zero(t, bcs.s.Reclaimable())
+
+	v, err := kv.Get(ctx, "/groups/group1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("group1"), v)
+	_, err = kv.Get(ctx, "/entities/entity1")
+	assert.Equal(t, db.ErrNoValue, err)
+	assert.Nil(t, kv.Close())
+}
+
+func TestCompactEncrypted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	viper.Set("core.home", dir)
+	viper.Set("core.conf", dir)
+	viper.Set("encryption.storage", "bitcask")
+	viper.Set("encryption.keyprovider", "fs")
+	defer func() {
+		for _, k := range []string{"core.conf", "encryption.storage", "encryption.keyprovider"} {
+			viper.Set(k, nil)
+		}
+	}()
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "keys"), 0750))
+	key := []byte("AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=")
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "keys", "aes-gcm-kv.tokenkey"), key, 0600))
+
+	startup.DoCallbacks()
+	x, err := db.New("encrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Shutdown()
+
+	// Compaction passes through the encryption to bitcask.
+	assert.Nil(t, x.Compact(ctx))
+}
+
+func TestHealthChecks(t *testing.T) {
+	viper.Set("core.home", t.TempDir())
+	viper.Set("bitcask.max-datafiles", 1)
+	viper.Set("bitcask.min-free-percent", 0)
+	defer viper.Set("bitcask.max-datafiles", 0)
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	defer kv.Close()
+	bcs := kv.(*BCStore)
+
+	s := bcs.diskCheck()
+	assert.True(t, s.OK, s.Status)
+	assert.Equal(t, "bitcask-disk", s.Name)
+
+	s = bcs.datafileCheck()
+	assert.True(t, s.OK, s.Status)
+	assert.Equal(t, "bitcask-datafiles", s.Name)
+
+	bcs.maxDatafiles = -1
+	assert.True(t, bcs.datafileCheck().OK)
+
+	bcs.minFreePct = 101
+	assert.False(t, bcs.diskCheck().OK)
+}
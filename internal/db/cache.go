@@ -0,0 +1,182 @@
+package db
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/spf13/viper"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/health"
+)
+
+// CacheStats are the counters kept by the object cache.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Size      int
+}
+
+// String returns the stats in a form suitable for a status report.
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d/%d entries, %d hits, %d misses, %d evictions",
+		s.Entries, s.Size, s.Hits, s.Misses, s.Evictions)
+}
+
+// An objectCache holds recently loaded entities and groups so that
+// they don't need to be read and unmarshaled on every load.  It is a
+// fixed size LRU, and entries are removed whenever the KV store
+// reports that they have changed.  Callers never see the cached
+// message itself, only copies of it.
+//
+// A nil cache is valid and caches nothing.
+type objectCache struct {
+	sync.Mutex
+
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+
+	// gen is incremented on every invalidation.  Values read
+	// from the store are only cached if no invalidation happened
+	// while they were being read, since otherwise a stale value
+	// could be cached after the change that replaced it.
+	gen uint64
+
+	stats CacheStats
+}
+
+type cacheEntry struct {
+	key string
+	rev uint64
+	msg proto.Message
+}
+
+// objectCacheFromConfig returns a cache that holds up to db.cache.size
+// recently loaded entities and groups, or nil if the size is not
+// greater than zero.
+func objectCacheFromConfig() *objectCache {
+	return newObjectCache(viper.GetInt("db.cache.size"))
+}
+
+func newObjectCache(size int) *objectCache {
+	if size <= 0 {
+		return nil
+	}
+	return &objectCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// get copies the cached object for k into m and returns its revision.
+// The second value is false if k isn't cached.
+func (c *objectCache) get(k string, m proto.Message) (uint64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	el, ok := c.items[k]
+	if !ok {
+		c.stats.Misses++
+		return 0, false
+	}
+	c.stats.Hits++
+	c.ll.MoveToFront(el)
+	ent := el.Value.(*cacheEntry)
+	proto.Merge(m, ent.msg)
+	return ent.rev, true
+}
+
+// generation returns the current generation, which must be passed to
+// put.
+func (c *objectCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.gen
+}
+
+// put caches a copy of m as the object for k at revision rev, unless
+// the cache has been invalidated since gen was obtained.
+func (c *objectCache) put(k string, rev uint64, m proto.Message, gen uint64) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	ent := &cacheEntry{key: k, rev: rev, msg: proto.Clone(m)}
+	if el, ok := c.items[k]; ok {
+		el.Value = ent
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[k] = c.ll.PushFront(ent)
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+// invalidate removes k from the cache.
+func (c *objectCache) invalidate(k string) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	c.gen++
+	if el, ok := c.items[k]; ok {
+		c.ll.Remove(el)
+		delete(c.items, k)
+	}
+}
+
+// Stats returns a snapshot of the counters.
+func (c *objectCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.Lock()
+	defer c.Unlock()
+	s := c.stats
+	s.Entries = c.ll.Len()
+	s.Size = c.size
+	return s
+}
+
+// healthCheck reports the cache counters.  The cache is never
+// unhealthy.
+func (c *objectCache) healthCheck() health.SubsystemStatus {
+	return health.SubsystemStatus{
+		OK:     true,
+		Name:   "db-cache",
+		Status: c.Stats().String(),
+	}
+}
+
+// cacheCallback removes objects from the cache when they change.
+func (db *DB) cacheCallback(e Event) {
+	db.cache.invalidate(eventKey(e))
+}
+
+// CacheStats returns the counters for the object cache.  All values
+// are zero if the cache is disabled.
+func (db *DB) CacheStats() CacheStats {
+	return db.cache.Stats()
+}
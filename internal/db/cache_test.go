@@ -0,0 +1,134 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+
+	types "github.com/netauth/protocol"
+)
+
+func newCachedDB(t *testing.T, size int) *db.DB {
+	viper.Set("db.cache.size", size)
+	defer viper.Set("db.cache.size", 0)
+	return newMemoryDB(t)
+}
+
+func TestCacheHitMiss(t *testing.T) {
+	ctx := context.Background()
+	m := newCachedDB(t, 10)
+
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity1", 1)))
+	before := m.CacheStats()
+	for i := 0; i < 3; i++ {
+		e, err := m.LoadEntity(ctx, "entity1")
+		assert.Nil(t, err)
+		assert.Equal(t, "entity1", e.GetID())
+	}
+	_, err := m.LoadEntity(ctx, "unknown")
+	assert.Equal(t, db.ErrUnknownEntity, err)
+
+	s := m.CacheStats()
+	assert.Equal(t, before.Hits+2, s.Hits)
+	assert.Equal(t, before.Misses+2, s.Misses)
+	assert.Equal(t, 1, s.Entries)
+	assert.Equal(t, 10, s.Size)
+}
+
+func TestCacheDefensiveCopy(t *testing.T) {
+	ctx := context.Background()
+	m := newCachedDB(t, 10)
+
+	assert.Nil(t, m.SaveGroup(ctx, &types.Group{Name: proto.String("group1"), Number: proto.Int32(1)}))
+	g, err := m.LoadGroup(ctx, "group1")
+	assert.Nil(t, err)
+	g.DisplayName = proto.String("changed")
+	g.Expansions = append(g.Expansions, "INCLUDE:group2")
+
+	g, err = m.LoadGroup(ctx, "group1")
+	assert.Nil(t, err)
+	assert.Nil(t, g.DisplayName)
+	assert.Empty(t, g.Expansions)
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	m := newCachedDB(t, 10)
+
+	e := entity("entity1", 1)
+	assert.Nil(t, m.SaveEntity(ctx, e))
+	_, err := m.LoadEntity(ctx, "entity1")
+	assert.Nil(t, err)
+
+	e.Number = proto.Int32(2)
+	assert.Nil(t, m.SaveEntity(ctx, e))
+	e, err = m.LoadEntity(ctx, "entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), e.GetNumber())
+
+	assert.Nil(t, m.DeleteEntity(ctx, "entity1"))
+	_, err = m.LoadEntity(ctx, "entity1")
+	assert.Equal(t, db.ErrUnknownEntity, err)
+}
+
+func TestCacheBatch(t *testing.T) {
+	ctx := context.Background()
+	m := newCachedDB(t, 10)
+
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity1", 1)))
+	_, err := m.LoadEntity(ctx, "entity1")
+	assert.Nil(t, err)
+
+	// Pending writes are visible within the batch, and the cache
+	// isn't updated until the batch commits.
+	assert.Nil(t, m.Batch(ctx, func(ctx context.Context) error {
+		if err := m.SaveEntity(ctx, entity("entity1", 5)); err != nil {
+			return err
+		}
+		e, err := m.LoadEntity(ctx, "entity1")
+		assert.Nil(t, err)
+		assert.Equal(t, int32(5), e.GetNumber())
+		e, err = m.LoadEntity(context.Background(), "entity1")
+		assert.Nil(t, err)
+		assert.Equal(t, int32(1), e.GetNumber())
+		return nil
+	}))
+
+	e, err := m.LoadEntity(ctx, "entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(5), e.GetNumber())
+}
+
+func TestCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	m := newCachedDB(t, 2)
+
+	for i, id := range []string{"entity1", "entity2", "entity3"} {
+		assert.Nil(t, m.SaveEntity(ctx, entity(id, int32(i+1))))
+		_, err := m.LoadEntity(ctx, id)
+		assert.Nil(t, err)
+	}
+	s := m.CacheStats()
+	assert.Equal(t, 2, s.Entries)
+	assert.Equal(t, uint64(1), s.Evictions)
+
+	// entity1 was the least recently used.
+	_, err := m.LoadEntity(ctx, "entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, s.Misses+1, m.CacheStats().Misses)
+}
+
+func TestCacheDisabled(t *testing.T) {
+	ctx := context.Background()
+	m := newMemoryDB(t)
+
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity1", 1)))
+	_, err := m.LoadEntity(ctx, "entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, db.CacheStats{}, m.CacheStats())
+}
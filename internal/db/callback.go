@@ -17,8 +17,17 @@ func (db *DB) RegisterCallback(name string, c Callback) {
 	log().Info("Database callback registered", "callback", name)
 }
 
-// FireEvent fires an event to all callbacks.
+// FireEvent records an event in the journal if there is one, and
+// then fires it to all callbacks.
 func (db *DB) FireEvent(e Event) {
+	if db.journal != nil {
+		db.journal.Append(e)
+	}
+	db.runCallbacks(e)
+}
+
+// runCallbacks calls every callback with the event.
+func (db *DB) runCallbacks(e Event) {
 	log().Debug("Processing callbacks")
 	for name, c := range db.cbs {
 		log().Trace("Calling callback", "callback", name)
@@ -29,22 +38,27 @@ func (db *DB) FireEvent(e Event) {
 // EventUpdateAll fires an event for all entities and all groups with
 // the type set to "Update".  This is used to allow the async
 // components that are event driven to pre-load on a server startup
-// and begin monitoring changes after the load completes.
+// and begin monitoring changes after the load completes.  A
+// persistent search index will also discard any objects that no
+// longer exist.  Nothing has actually changed, so these events are
+// not recorded in the journal.
 func (db *DB) EventUpdateAll() error {
 	ids, err := db.DiscoverEntityIDs(context.Background())
 	if err != nil {
 		return err
 	}
+	db.Index.prune(db.Index.eIndex, ids)
 	for _, i := range ids {
-		db.FireEvent(Event{Type: EventEntityUpdate, PK: path.Base(i)})
+		db.runCallbacks(Event{Type: EventEntityUpdate, PK: path.Base(i)})
 	}
 
 	ids, err = db.DiscoverGroupNames(context.Background())
 	if err != nil {
 		return err
 	}
+	db.Index.prune(db.Index.gIndex, ids)
 	for _, i := range ids {
-		db.FireEvent(Event{Type: EventGroupUpdate, PK: path.Base(i)})
+		db.runCallbacks(Event{Type: EventGroupUpdate, PK: path.Base(i)})
 	}
 	return nil
 }
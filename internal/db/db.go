@@ -10,6 +10,8 @@ import (
 	"github.com/hashicorp/go-hclog"
 	"google.golang.org/protobuf/proto"
 
+	"github.com/netauth/netauth/internal/health"
+
 	types "github.com/netauth/protocol"
 )
 
@@ -17,23 +19,65 @@ var (
 	lb hclog.Logger
 )
 
-// New returns a db struct.
+// New returns a db struct that stores its data in the named KV
+// backend.  The search index, number ranges, object cache, journal and
+// trash are configured from the settings described alongside each of
+// them.
 func New(backend string) (*DB, error) {
 	kv, err := NewKV(backend, log())
 	if err != nil {
 		return nil, err
 	}
 
-	idx := NewIndex(log())
+	eRanges, eDefault, err := numberRangesFromConfig("entity")
+	if err != nil {
+		log().Error("Invalid entity number range", "error", err)
+		kv.Close()
+		return nil, ErrInternalError
+	}
+	gRanges, gDefault, err := numberRangesFromConfig("group")
+	if err != nil {
+		log().Error("Invalid group number range", "error", err)
+		kv.Close()
+		return nil, ErrInternalError
+	}
+
+	idx, err := indexFromConfig(log(), backend)
+	if err != nil {
+		kv.Close()
+		return nil, ErrInternalError
+	}
+
 	x := &DB{
 		log:   log(),
 		Index: idx,
 		kv:    kv,
 		cbs:   make(map[string]Callback),
 	}
+	x.entityNumbers = newNumberIndex(x.loadEntityNumbers, eRanges, eDefault)
+	x.groupNumbers = newNumberIndex(x.loadGroupNumbers, gRanges, gDefault)
+	x.cache = objectCacheFromConfig()
+
+	if err := x.journalFromConfig(); err != nil {
+		idx.Close()
+		kv.Close()
+		return nil, ErrInternalError
+	}
+	x.trashFromConfig()
+
+	if _, ok := kv.(TxKVStore); !x.hasCapability(KVTransactional) || !ok {
+		x.log.Warn("KV store is not transactional, changes that span several keys will not be applied atomically", "backend", backend)
+	}
+
 	kv.SetEventFunc(x.FireEvent)
 	x.Index.ConfigureCallback(x.LoadEntity, x.LoadGroup)
+	x.Index.configureRevisions(x.currentRevision)
 	x.RegisterCallback("BleveSearch", x.Index.IndexCallback)
+	x.RegisterCallback("NumberIndex", x.numberCallback)
+	if x.cache != nil {
+		x.RegisterCallback("ObjectCache", x.cacheCallback)
+		health.RegisterCheck("db-cache", x.cache.healthCheck)
+	}
 
 	return x, nil
 }
@@ -46,21 +90,15 @@ func (db *DB) DiscoverEntityIDs(ctx context.Context) ([]string, error) {
 
 // LoadEntity retrieves a single entity from the kv store.
 func (db *DB) LoadEntity(ctx context.Context, ID string) (*types.Entity, error) {
-	b, err := db.kv.Get(ctx, path.Join("/entities", ID))
-	if err == ErrNoValue {
-		return nil, ErrUnknownEntity
-	}
-	if err != nil {
-		db.log.Debug("Error loading entity from KV store", "error", err, "ID", ID)
-		return nil, ErrInternalError
-	}
-
 	e := &types.Entity{}
-	if err := proto.Unmarshal(b, e); err != nil {
-		db.log.Warn("Error unmarshaling entity", "error", err)
-		return nil, ErrInternalError
+	switch err := db.load(ctx, path.Join("/entities", ID), e); err {
+	case nil:
+		return e, nil
+	case ErrNoValue:
+		return nil, ErrUnknownEntity
+	default:
+		return nil, err
 	}
-	return e, nil
 }
 
 // SaveEntity writes an entity to the kv store.
@@ -70,16 +108,32 @@ func (db *DB) SaveEntity(ctx context.Context, e *types.Entity) error {
 	// fields in the Entity proto, this cannot return an error.
 	b, _ := proto.Marshal(e)
 
-	if err := db.kv.Put(ctx, path.Join("/entities", e.GetID()), b); err != nil {
+	switch err := db.put(ctx, path.Join("/entities", e.GetID()), b); err {
+	case nil, ErrConflict, ErrDuplicateNumber:
+		return err
+	default:
 		db.log.Warn("Error storing entity", "error", err)
 		return ErrInternalError
 	}
-	return nil
 }
 
-// DeleteEntity tries to delete an entity that already exists.
+// DeleteEntity tries to delete an entity that already exists.  If
+// trash.enabled is set then the entity is moved to the trash instead,
+// and can be restored with RestoreEntity.  Otherwise the secret
+// history of the entity is removed along with it.
 func (db *DB) DeleteEntity(ctx context.Context, ID string) error {
-	err := db.kv.Del(ctx, path.Join("/entities", ID))
+	k := path.Join("/entities", ID)
+	var err error
+	if db.trash {
+		err = db.moveToTrash(ctx, TrashEntity, ID, k)
+	} else {
+		err = db.Batch(ctx, func(ctx context.Context) error {
+			if err := db.del(ctx, k); err != nil {
+				return err
+			}
+			return db.dropSecretHistory(ctx, ID)
+		})
+	}
 	if err == ErrNoValue {
 		return ErrUnknownEntity
 	}
@@ -94,21 +148,15 @@ func (db *DB) DiscoverGroupNames(ctx context.Context) ([]string, error) {
 
 // LoadGroup retrieves a single group from the kv store.
 func (db *DB) LoadGroup(ctx context.Context, ID string) (*types.Group, error) {
-	b, err := db.kv.Get(ctx, path.Join("/groups", ID))
-	if err == ErrNoValue {
-		return nil, ErrUnknownGroup
-	}
-	if err != nil {
-		db.log.Debug("Error loading group from KV store", "error", err, "ID", ID)
-		return nil, ErrInternalError
-	}
-
 	g := &types.Group{}
-	if err := proto.Unmarshal(b, g); err != nil {
-		db.log.Warn("Error unmarshaling group", "error", err)
-		return nil, ErrInternalError
+	switch err := db.load(ctx, path.Join("/groups", ID), g); err {
+	case nil:
+		return g, nil
+	case ErrNoValue:
+		return nil, ErrUnknownGroup
+	default:
+		return nil, err
 	}
-	return g, nil
 }
 
 // SaveGroup writes an group to the kv store.
@@ -118,16 +166,23 @@ func (db *DB) SaveGroup(ctx context.Context, g *types.Group) error {
 	// fields in the Group proto, this cannot return an error.
 	b, _ := proto.Marshal(g)
 
-	if err := db.kv.Put(ctx, path.Join("/groups", g.GetName()), b); err != nil {
+	err := db.put(ctx, path.Join("/groups", g.GetName()), b)
+	if err != nil && err != ErrConflict && err != ErrDuplicateNumber {
 		db.log.Warn("Error storing group", "error", err)
-		return err
 	}
-	return nil
+	return err
 }
 
-// DeleteGroup tries to delete an group that already exists.
+// DeleteGroup tries to delete an group that already exists.  As with
+// entities, the group is moved to the trash if trash.enabled is set.
 func (db *DB) DeleteGroup(ctx context.Context, ID string) error {
-	err := db.kv.Del(ctx, path.Join("/groups", ID))
+	k := path.Join("/groups", ID)
+	var err error
+	if db.trash {
+		err = db.moveToTrash(ctx, TrashGroup, ID, k)
+	} else {
+		err = db.del(ctx, k)
+	}
 	if err == ErrNoValue {
 		return ErrUnknownGroup
 	}
@@ -137,59 +192,39 @@ func (db *DB) DeleteGroup(ctx context.Context, ID string) error {
 // Shutdown is called to disconnect the KV store from any other
 // systems and flush any buffers before shutting down the server.
 func (db *DB) Shutdown() {
+	if db.trashDone != nil {
+		close(db.trashDone)
+	}
 	if err := db.kv.Close(); err != nil {
 		db.log.Error("Error shutting down KV store", "error", err)
 	}
-}
-
-// NextEntityNumber computes and returns the next unnassigned number
-// in the entity space.
-func (db *DB) NextEntityNumber(ctx context.Context) (int32, error) {
-	var largest int32
-
-	// Iterate over the entities and return the largest ID found
-	// +1.  This allows them to be in any order or have IDs
-	// missing in the middle and still work.  Though an
-	// inefficient search this is worst case O(N) and happens only
-	// on provisioning a new entry in the database.
-	el, err := db.DiscoverEntityIDs(ctx)
-	if err != nil {
-		return 0, err
+	if err := db.Index.Close(); err != nil {
+		db.log.Error("Error shutting down search index", "error", err)
 	}
-
-	for _, en := range el {
-		e, err := db.LoadEntity(ctx, path.Base(en))
-		if err != nil {
-			return 0, err
-		}
-		if e.GetNumber() > largest {
-			largest = e.GetNumber()
+	if db.journal != nil {
+		close(db.journalDone)
+		if err := db.journal.Close(); err != nil {
+			db.log.Error("Error closing change journal", "error", err)
 		}
 	}
+}
 
-	return largest + 1, nil
+// NextEntityNumber allocates a number for a new entity.  The number
+// is one more than the highest number in use in the range named by
+// WithNumberRange, or the default range if none was named.  Numbers
+// below the highest one in use are never handed out again, but if the
+// object with the highest number is removed then its number will be
+// given to the next object that is created.  The number is held back
+// from other allocations for a short while so that concurrent creates
+// receive different numbers.
+func (db *DB) NextEntityNumber(ctx context.Context) (int32, error) {
+	return db.entityNumbers.next(ctx)
 }
 
-// NextGroupNumber computes the next available group number and
-// returns it.
+// NextGroupNumber allocates a number for a new group in the same way
+// that NextEntityNumber does for entities.
 func (db *DB) NextGroupNumber(ctx context.Context) (int32, error) {
-	var largest int32
-
-	l, err := db.DiscoverGroupNames(ctx)
-	if err != nil {
-		return 0, err
-	}
-	for _, i := range l {
-		g, err := db.LoadGroup(ctx, path.Base(i))
-		if err != nil {
-			return 0, err
-		}
-		if g.GetNumber() > largest {
-			largest = g.GetNumber()
-		}
-	}
-
-	return largest + 1, nil
+	return db.groupNumbers.next(ctx)
 }
 
 // Capabilities returns a slice of capabilities the backing store
@@ -200,26 +235,53 @@ func (db *DB) Capabilities() []KVCapability {
 	return db.kv.Capabilities()
 }
 
+// Compact asks the KV store to reclaim the space used by values that
+// have been overwritten or deleted.  ErrCompactionUnsupported is
+// returned if the store doesn't keep such values around.
+func (db *DB) Compact(ctx context.Context) error {
+	c, ok := db.kv.(CompactingKVStore)
+	if !ok {
+		return ErrCompactionUnsupported
+	}
+	return c.Compact(ctx)
+}
+
 // SearchEntities performs a search of all entities using the given
 // query and then batch loads the result.
 func (db *DB) SearchEntities(ctx context.Context, r SearchRequest) ([]*types.Entity, error) {
-	ids, err := db.Index.SearchEntities(r)
+	res, _, err := db.SearchEntityPage(ctx, r)
+	return res, err
+}
+
+// SearchEntityPage is SearchEntities, but also returns the total
+// number of matches and the cursor for the next page.
+func (db *DB) SearchEntityPage(ctx context.Context, r SearchRequest) ([]*types.Entity, SearchResult, error) {
+	ids, page, err := db.Index.SearchEntityPage(r)
 	if err != nil {
-		return nil, err
+		return nil, SearchResult{}, err
 	}
 
-	return db.loadEntityBatch(ctx, ids)
+	res, err := db.loadEntityBatch(ctx, ids)
+	return res, page, err
 }
 
 // SearchGroups performs a search of all groups using the given query
 // and then batch loads the result.
 func (db *DB) SearchGroups(ctx context.Context, r SearchRequest) ([]*types.Group, error) {
-	ids, err := db.Index.SearchGroups(r)
+	res, _, err := db.SearchGroupPage(ctx, r)
+	return res, err
+}
+
+// SearchGroupPage is SearchGroups, but also returns the total number
+// of matches and the cursor for the next page.
+func (db *DB) SearchGroupPage(ctx context.Context, r SearchRequest) ([]*types.Group, SearchResult, error) {
+	ids, page, err := db.Index.SearchGroupPage(r)
 	if err != nil {
-		return nil, err
+		return nil, SearchResult{}, err
 	}
 
-	return db.loadGroupBatch(ctx, ids)
+	res, err := db.loadGroupBatch(ctx, ids)
+	return res, page, err
 }
 
 func (db *DB) loadEntityBatch(ctx context.Context, ids []string) ([]*types.Entity, error) {
@@ -248,6 +310,110 @@ func (db *DB) loadGroupBatch(ctx context.Context, ids []string) ([]*types.Group,
 	return gSlice, nil
 }
 
+// load reads the object at k into m.  Objects that are not pending in
+// an active batch are served from the cache if possible, and are
+// added to it otherwise.  ErrNoValue is returned if there is no
+// object, and ErrInternalError for all other failures.
+func (db *DB) load(ctx context.Context, k string, m proto.Message) error {
+	b := batchFromContext(ctx)
+	pending := false
+	if b != nil {
+		_, pending = b.get(k)
+	}
+
+	if !pending {
+		if rev, ok := db.cache.get(k, m); ok {
+			if b != nil {
+				b.read(k, revision{rev: rev, exists: true})
+			}
+			return nil
+		}
+	}
+
+	gen := db.cache.generation()
+	rev, v, err := db.getRevision(ctx, k)
+	if err == ErrNoValue {
+		return err
+	}
+	if err != nil {
+		db.log.Debug("Error loading object from KV store", "error", err, "key", k)
+		return ErrInternalError
+	}
+	if err := proto.Unmarshal(v, m); err != nil {
+		db.log.Warn("Error unmarshaling object", "key", k, "error", err)
+		return ErrInternalError
+	}
+	if !pending {
+		db.cache.put(k, rev, m, gen)
+	}
+	return nil
+}
+
+// get reads an object, taking into account any writes that are
+// pending in an active batch.  The revision of the object is recorded
+// in the batch so that it can be checked before writing.
+func (db *DB) get(ctx context.Context, k string) ([]byte, error) {
+	_, v, err := db.getRevision(ctx, k)
+	return v, err
+}
+
+// getRevision is get, but also returns the revision of the object.
+// The revision of a write that is pending in a batch is not known
+// and is returned as zero.
+func (db *DB) getRevision(ctx context.Context, k string) (uint64, []byte, error) {
+	b := batchFromContext(ctx)
+	if b != nil {
+		if op, ok := b.get(k); ok {
+			if op.del {
+				return 0, nil, ErrNoValue
+			}
+			return 0, op.value, nil
+		}
+	}
+
+	v, err := db.kv.Get(ctx, k)
+	if err == ErrNoValue && b != nil {
+		b.read(k, revision{})
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	rev, obj, err := decodeValue(v)
+	if err != nil {
+		return 0, nil, err
+	}
+	if b != nil {
+		b.read(k, revision{rev: rev, exists: true})
+	}
+	return rev, obj, nil
+}
+
+// put writes an object.  If there is no active batch then the write
+// is committed immediately as a batch of its own.
+func (db *DB) put(ctx context.Context, k string, v []byte) error {
+	b := batchFromContext(ctx)
+	if b == nil {
+		return db.Batch(ctx, func(ctx context.Context) error { return db.put(ctx, k, v) })
+	}
+	b.put(k, v)
+	return nil
+}
+
+// del removes an object.  As with the KV stores, ErrNoValue is
+// returned if the object does not exist.  If there is no active batch
+// then the removal is committed immediately as a batch of its own.
+func (db *DB) del(ctx context.Context, k string) error {
+	b := batchFromContext(ctx)
+	if b == nil {
+		return db.Batch(ctx, func(ctx context.Context) error { return db.del(ctx, k) })
+	}
+	if _, err := db.get(ctx, k); err != nil {
+		return err
+	}
+	b.del(k)
+	return nil
+}
+
 // SetParentLogger sets the parent logger for this instance.
 func SetParentLogger(l hclog.Logger) {
 	lb = l.Named("db")
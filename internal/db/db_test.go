@@ -71,6 +71,9 @@ func TestSaveEntity(t *testing.T) {
 	m, err := New("mock")
 	assert.Nil(t, err)
 
+	m.kv.(*mockKV).On("Capabilities").Return([]KVCapability{KVMutable})
+	m.kv.(*mockKV).On("Get", "/entities/good").Return([]byte{}, ErrNoValue)
+	m.kv.(*mockKV).On("Get", "/entities/bad").Return([]byte{}, ErrNoValue)
 	m.kv.(*mockKV).On("Put", "/entities/good", mock.Anything).Return(nil)
 	m.kv.(*mockKV).On("Put", "/entities/bad", mock.Anything).Return(errors.New("something internal"))
 
@@ -87,8 +90,11 @@ func TestDeleteEntity(t *testing.T) {
 	m, err := New("mock")
 	assert.Nil(t, err)
 
+	m.kv.(*mockKV).On("Capabilities").Return([]KVCapability{KVMutable})
+	m.kv.(*mockKV).On("Get", "/entities/good").Return(goodEntityBytes1, nil)
+	m.kv.(*mockKV).On("Get", "/entities/missing").Return([]byte{}, ErrNoValue)
 	m.kv.(*mockKV).On("Del", "/entities/good").Return(nil)
-	m.kv.(*mockKV).On("Del", "/entities/missing").Return(ErrNoValue)
+	m.kv.(*mockKV).On("Get", "/secret-history/good").Return([]byte{}, ErrNoValue)
 
 	assert.Nil(t, m.DeleteEntity(ctx, "good"))
 	assert.Equal(t, m.DeleteEntity(ctx, "missing"), ErrUnknownEntity)
@@ -141,6 +147,9 @@ func TestSaveGroup(t *testing.T) {
 	m, err := New("mock")
 	assert.Nil(t, err)
 
+	m.kv.(*mockKV).On("Capabilities").Return([]KVCapability{KVMutable})
+	m.kv.(*mockKV).On("Get", "/groups/good").Return([]byte{}, ErrNoValue)
+	m.kv.(*mockKV).On("Get", "/groups/bad").Return([]byte{}, ErrNoValue)
 	m.kv.(*mockKV).On("Put", "/groups/good", mock.Anything).Return(nil)
 	m.kv.(*mockKV).On("Put", "/groups/bad", mock.Anything).Return(errors.New("something internal"))
 
@@ -157,8 +166,10 @@ func TestDeleteGroup(t *testing.T) {
 	m, err := New("mock")
 	assert.Nil(t, err)
 
+	m.kv.(*mockKV).On("Capabilities").Return([]KVCapability{KVMutable})
+	m.kv.(*mockKV).On("Get", "/groups/good").Return(goodGroupBytes1, nil)
+	m.kv.(*mockKV).On("Get", "/groups/missing").Return([]byte{}, ErrNoValue)
 	m.kv.(*mockKV).On("Del", "/groups/good").Return(nil)
-	m.kv.(*mockKV).On("Del", "/groups/missing").Return(ErrNoValue)
 
 	assert.Nil(t, m.DeleteGroup(ctx, "good"))
 	assert.Equal(t, m.DeleteGroup(ctx, "missing"), ErrUnknownGroup)
@@ -176,65 +187,67 @@ func TestShutdown(t *testing.T) {
 func TestNextEntityNumber(t *testing.T) {
 	ctx := context.Background()
 	RegisterKV("mock", newMockKV)
-	m, err := New("mock")
-	assert.Nil(t, err)
 
-	m.kv.(*mockKV).On("Get", "/entities/load-error").Return([]byte{}, errors.New("KV Load error"))
-	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(goodEntityBytes1, nil)
-	m.kv.(*mockKV).On("Get", "/entities/entity2").Return(goodEntityBytes2, nil)
+	// The number index is loaded once, so each case needs a new
+	// db.
+	newDB := func(keys []string, err error) *DB {
+		m, nerr := New("mock")
+		assert.Nil(t, nerr)
+		m.kv.(*mockKV).On("Get", "/entities/load-error").Return([]byte{}, errors.New("KV Load error"))
+		m.kv.(*mockKV).On("Get", "/entities/entity1").Return(goodEntityBytes1, nil)
+		m.kv.(*mockKV).On("Get", "/entities/entity2").Return(goodEntityBytes2, nil)
+		m.kv.(*mockKV).On("Keys", "/entities/*").Return(keys, err)
+		return m
+	}
 
-	m.kv.(*mockKV).On("Keys", "/entities/*").Return([]string{}, nil).Once()
-	res, err := m.NextEntityNumber(ctx)
+	res, err := newDB([]string{}, nil).NextEntityNumber(ctx)
 	assert.Nil(t, err)
 	assert.Equal(t, int32(1), res)
 
-	m.kv.(*mockKV).On("Keys", "/entities/*").Return([]string{}, errors.New("retrieval error")).Once()
-	_, err = m.NextEntityNumber(ctx)
-	assert.NotNil(t, err)
-
-	m.kv.(*mockKV).On("Keys", "/entities/*").Return([]string{}, errors.New("retrieval error")).Once()
-	_, err = m.NextEntityNumber(ctx)
+	_, err = newDB([]string{}, errors.New("retrieval error")).NextEntityNumber(ctx)
 	assert.NotNil(t, err)
 
-	m.kv.(*mockKV).On("Keys", "/entities/*").Return([]string{"/entities/entity1", "/entities/load-error"}, nil).Once()
-	_, err = m.NextEntityNumber(ctx)
+	_, err = newDB([]string{"/entities/entity1", "/entities/load-error"}, nil).NextEntityNumber(ctx)
 	assert.NotNil(t, err)
 
-	m.kv.(*mockKV).On("Keys", "/entities/*").Return([]string{"/entities/entity1", "/entities/entity2"}, nil).Once()
+	m := newDB([]string{"/entities/entity1", "/entities/entity2"}, nil)
 	res, err = m.NextEntityNumber(ctx)
 	assert.Nil(t, err)
 	assert.Equal(t, int32(8), res)
+
+	// The number is reserved, so the next call gets a new one
+	// without the KV store being consulted again.
+	res, err = m.NextEntityNumber(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(9), res)
+	m.kv.(*mockKV).AssertNumberOfCalls(t, "Keys", 1)
 }
 
 func TestNextGroupNumber(t *testing.T) {
 	ctx := context.Background()
 	RegisterKV("mock", newMockKV)
-	m, err := New("mock")
-	assert.Nil(t, err)
 
-	m.kv.(*mockKV).On("Get", "/groups/load-error").Return([]byte{}, errors.New("KV Load error"))
-	m.kv.(*mockKV).On("Get", "/groups/group1").Return(goodGroupBytes1, nil)
-	m.kv.(*mockKV).On("Get", "/groups/group2").Return(goodGroupBytes2, nil)
+	newDB := func(keys []string, err error) *DB {
+		m, nerr := New("mock")
+		assert.Nil(t, nerr)
+		m.kv.(*mockKV).On("Get", "/groups/load-error").Return([]byte{}, errors.New("KV Load error"))
+		m.kv.(*mockKV).On("Get", "/groups/group1").Return(goodGroupBytes1, nil)
+		m.kv.(*mockKV).On("Get", "/groups/group2").Return(goodGroupBytes2, nil)
+		m.kv.(*mockKV).On("Keys", "/groups/*").Return(keys, err)
+		return m
+	}
 
-	m.kv.(*mockKV).On("Keys", "/groups/*").Return([]string{}, nil).Once()
-	res, err := m.NextGroupNumber(ctx)
+	res, err := newDB([]string{}, nil).NextGroupNumber(ctx)
 	assert.Nil(t, err)
 	assert.Equal(t, int32(1), res)
 
-	m.kv.(*mockKV).On("Keys", "/groups/*").Return([]string{}, errors.New("retrieval error")).Once()
-	_, err = m.NextGroupNumber(ctx)
+	_, err = newDB([]string{}, errors.New("retrieval error")).NextGroupNumber(ctx)
 	assert.NotNil(t, err)
 
-	m.kv.(*mockKV).On("Keys", "/groups/*").Return([]string{}, errors.New("retrieval error")).Once()
-	_, err = m.NextGroupNumber(ctx)
+	_, err = newDB([]string{"/groups/group1", "/groups/load-error"}, nil).NextGroupNumber(ctx)
 	assert.NotNil(t, err)
 
-	m.kv.(*mockKV).On("Keys", "/groups/*").Return([]string{"/groups/group1", "/groups/load-error"}, nil).Once()
-	_, err = m.NextGroupNumber(ctx)
-	assert.NotNil(t, err)
-
-	m.kv.(*mockKV).On("Keys", "/groups/*").Return([]string{"/groups/group1", "/groups/group2"}, nil).Once()
-	res, err = m.NextGroupNumber(ctx)
+	res, err = newDB([]string{"/groups/group1", "/groups/group2"}, nil).NextGroupNumber(ctx)
 	assert.Nil(t, err)
 	assert.Equal(t, int32(8), res)
 }
@@ -321,3 +334,26 @@ func TestSetParentLogger(t *testing.T) {
 	SetParentLogger(hclog.NewNullLogger())
 	assert.NotNil(t, lb)
 }
+
+type compactingKV struct {
+	*mockKV
+	compacted bool
+}
+
+func (c *compactingKV) Compact(context.Context) error {
+	c.compacted = true
+	return nil
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+	assert.Equal(t, ErrCompactionUnsupported, m.Compact(ctx))
+
+	c := &compactingKV{mockKV: m.kv.(*mockKV)}
+	m.kv = c
+	assert.Nil(t, m.Compact(ctx))
+	assert.True(t, c.compacted)
+}
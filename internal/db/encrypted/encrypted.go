@@ -0,0 +1,495 @@
+// Package encrypted implements a KVStore that wraps another registered
+// backend and encrypts every value with AES-GCM before it is handed
+// to the underlying store.  Keys remain in the clear so that the
+// underlying store can still list and range over them.
+//
+// Encryption keys are obtained from a keyprovider, and each value
+// records the ID of the key that was used to encrypt it.  This allows
+// keys to be rotated by changing the configured key ID: values that
+// were written with an older key remain readable for as long as the
+// keyprovider can supply that key, and are re-encrypted with the
+// current key the next time they are read.  An existing store can be
+// converted all at once using nsutil copy.  Values that are found in
+// the clear are rejected unless encryption.strict is turned off, in
+// which case they are treated the same way as values with an old key
+// so that the store can be converted gradually.
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"path"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/pkg/token/keyprovider"
+)
+
+// keyMech is the mechanism name that is passed to the keyprovider
+// when requesting keys.  The key ID is passed as the use case.
+const keyMech = "aes-gcm"
+
+// Encrypted values begin with a byte that cannot begin a valid
+// protobuf message, since it would specify the invalid wire type 7.
+// This allows plaintext values to be detected reliably.
+const (
+	valueMagic   byte = 0xff
+	valueVersion byte = 1
+)
+
+var (
+	// ErrRecursiveStorage is returned if the encrypted store is
+	// configured to wrap itself.
+	ErrRecursiveStorage = errors.New("encrypted store cannot wrap itself")
+
+	// ErrBadKey is returned if the key material supplied by the
+	// keyprovider is not a valid AES key.
+	ErrBadKey = errors.New("key must be 16, 24, or 32 bytes in the configured format")
+
+	// ErrBadKeyFormat is returned if encryption.key-format is not
+	// a known format.
+	ErrBadKeyFormat = errors.New("key format must be base64 or raw")
+
+	// ErrNotEncrypted is returned in strict mode if a value was
+	// found in the clear.
+	ErrNotEncrypted = errors.New("value is not encrypted")
+
+	// ErrBadValue is returned if an encrypted value is truncated
+	// or cannot be authenticated.
+	ErrBadValue = errors.New("encrypted value is corrupt or was tampered with")
+
+	// ErrNotTransactional is returned if a transaction is
+	// requested but the underlying store does not support them.
+	ErrNotTransactional = errors.New("underlying store does not support transactions")
+)
+
+// The formats that key material may be supplied in.
+const (
+	keyFormatBase64 = "base64"
+	keyFormatRaw    = "raw"
+)
+
+// Store encrypts values before passing them to another KVStore.
+type Store struct {
+	inner     db.KVStore
+	kp        keyprovider.KeyProvider
+	keyID     string
+	keyFormat string
+	strict    bool
+	l         hclog.Logger
+
+	// writeMu serializes writes so that lazy re-encryption cannot
+	// overwrite a value that changed after it was read.
+	writeMu sync.Mutex
+
+	// eventMu protects eF and quiet.  While a value is being
+	// re-encrypted quiet holds its key, and the update event that
+	// the underlying store fires for it is dropped, since the
+	// value that the rest of the server sees has not changed.
+	eventMu sync.Mutex
+	eF      func(db.Event)
+	quiet   string
+
+	aeadMu sync.Mutex
+	aeads  map[string]cipher.AEAD
+}
+
+func init() {
+	startup.RegisterCallback(cb)
+}
+
+func cb() {
+	db.RegisterKV("encrypted", New)
+}
+
+// options are the settings for a Store.
+type options struct {
+	keyID     string
+	keyFormat string
+	strict    bool
+}
+
+// New returns an encrypted store wrapping the backend named by
+// encryption.storage.  Keys are obtained from the keyprovider named
+// by encryption.keyprovider, or token.keyprovider if that is not set,
+// and values are encrypted with the key named by encryption.key.  Key
+// material is base64 encoded unless encryption.key-format is set to
+// raw.  Unencrypted values are only accepted if encryption.strict is
+// turned off.
+func New(l hclog.Logger) (db.KVStore, error) {
+	l = l.Named("encrypted")
+
+	backend := viper.GetString("encryption.storage")
+	if backend == "encrypted" {
+		return nil, ErrRecursiveStorage
+	}
+
+	keyFormat := viper.GetString("encryption.key-format")
+	if keyFormat == "" {
+		keyFormat = keyFormatBase64
+	}
+	if keyFormat != keyFormatBase64 && keyFormat != keyFormatRaw {
+		return nil, ErrBadKeyFormat
+	}
+
+	kpName := viper.GetString("encryption.keyprovider")
+	if kpName == "" {
+		kpName = viper.GetString("token.keyprovider")
+	}
+	if kpName == "" {
+		kpName = "fs"
+	}
+	kp, err := keyprovider.New(kpName)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := viper.GetString("encryption.key")
+	if keyID == "" {
+		keyID = "kv"
+	}
+
+	inner, err := db.NewKV(backend, l)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newStore(l, inner, kp, options{
+		keyID:     keyID,
+		keyFormat: keyFormat,
+		strict:    strictFromConfig(),
+	})
+	if err != nil {
+		inner.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// strictFromConfig reports whether unencrypted values should be
+// rejected.  This is the case unless encryption.strict has been
+// turned off explicitly, so that every program that opens the store
+// behaves the same way without having to set a default of its own.
+func strictFromConfig() bool {
+	return !viper.IsSet("encryption.strict") || viper.GetBool("encryption.strict")
+}
+
+// newStore wraps inner.  The current key is loaded immediately so
+// that a missing key is reported at startup rather than on the first
+// write.
+func newStore(l hclog.Logger, inner db.KVStore, kp keyprovider.KeyProvider, o options) (*Store, error) {
+	s := &Store{
+		inner:     inner,
+		kp:        kp,
+		keyID:     o.keyID,
+		keyFormat: o.keyFormat,
+		strict:    o.strict,
+		l:         l,
+		aeads:     make(map[string]cipher.AEAD),
+	}
+	if _, err := s.aead(s.keyID); err != nil {
+		l.Error("Unable to load encryption key", "key", s.keyID, "error", err)
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetEventFunc passes events from the underlying store through to f,
+// since events only carry keys and are unaffected by encryption.  The
+// only events that are held back are those caused by lazy
+// re-encryption.
+func (s *Store) SetEventFunc(f func(db.Event)) {
+	s.eventMu.Lock()
+	s.eF = f
+	s.eventMu.Unlock()
+	s.inner.SetEventFunc(s.fireEvent)
+}
+
+// fireEvent passes e on unless it is the update event for a value
+// that is being re-encrypted.
+func (s *Store) fireEvent(e db.Event) {
+	s.eventMu.Lock()
+	f := s.eF
+	quiet := s.quiet != "" && e.PK == path.Base(s.quiet) &&
+		(e.Type == db.EventEntityUpdate || e.Type == db.EventGroupUpdate)
+	s.eventMu.Unlock()
+
+	if f == nil || quiet {
+		return
+	}
+	f(e)
+}
+
+// setQuiet sets the key whose update events are dropped, or clears it
+// if k is empty.
+func (s *Store) setQuiet(k string) {
+	s.eventMu.Lock()
+	s.quiet = k
+	s.eventMu.Unlock()
+}
+
+// Put encrypts v with the current key and stores it at k.
+func (s *Store) Put(ctx context.Context, k string, v []byte) error {
+	ct, err := s.encrypt(k, v)
+	if err != nil {
+		return err
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.inner.Put(ctx, k, ct)
+}
+
+// Get returns the decrypted value at k.  If the value was written in
+// the clear or with a key other than the current one, it is
+// re-encrypted with the current key before being returned.
+func (s *Store) Get(ctx context.Context, k string) ([]byte, error) {
+	raw, err := s.inner.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, v, err := s.decrypt(k, raw)
+	if err != nil {
+		return nil, err
+	}
+	if keyID != s.keyID {
+		s.rewrite(ctx, k, raw, v)
+	}
+	return v, nil
+}
+
+// Del removes k from the underlying store.
+func (s *Store) Del(ctx context.Context, k string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.inner.Del(ctx, k)
+}
+
+// Keys returns the keys matching f from the underlying store.
+func (s *Store) Keys(ctx context.Context, f string) ([]string, error) {
+	return s.inner.Keys(ctx, f)
+}
+
+// Close closes the underlying store.
+func (s *Store) Close() error {
+	return s.inner.Close()
+}
+
+// Capabilities returns the capabilities of the underlying store.
+func (s *Store) Capabilities() []db.KVCapability {
+	return s.inner.Capabilities()
+}
+
+// Begin starts a transaction on the underlying store.  Values are
+// encrypted as they are added to the transaction.
+func (s *Store) Begin(ctx context.Context) (db.KVTx, error) {
+	t, ok := s.inner.(db.TxKVStore)
+	if !ok {
+		return nil, ErrNotTransactional
+	}
+	inner, err := t.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tx{s: s, inner: inner}, nil
+}
+
+// Compact compacts the underlying store if it supports compaction.
+// Otherwise db.ErrCompactionUnsupported is returned, the same as for
+// any other store that doesn't.
+func (s *Store) Compact(ctx context.Context) error {
+	c, ok := s.inner.(db.CompactingKVStore)
+	if !ok {
+		return db.ErrCompactionUnsupported
+	}
+	return c.Compact(ctx)
+}
+
+// rewrite re-encrypts a value with the current key.  This is only
+// done if the value has not changed since it was read, and failures
+// are logged but otherwise ignored since the value will be rewritten
+// on a later read.  No event is fired for the rewrite on this server,
+// but stores that replicate their writes will still pass it on to
+// their peers.
+func (s *Store) rewrite(ctx context.Context, k string, raw, v []byte) {
+	ct, err := s.encrypt(k, v)
+	if err != nil {
+		s.l.Warn("Unable to re-encrypt value", "key", k, "error", err)
+		return
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	cur, err := s.inner.Get(ctx, k)
+	if err != nil || !bytes.Equal(cur, raw) {
+		return
+	}
+	s.setQuiet(k)
+	err = s.inner.Put(ctx, k, ct)
+	s.setQuiet("")
+	if err != nil {
+		s.l.Warn("Unable to store re-encrypted value", "key", k, "error", err)
+		return
+	}
+	s.l.Debug("Re-encrypted value with current key", "key", k, "keyID", s.keyID)
+}
+
+// encrypt seals v with the current key.  The layout of the result is
+// the magic byte, the version, the length and ID of the key, the
+// nonce, and finally the sealed value.  The storage key and the
+// header are authenticated so that values cannot be moved between
+// keys.
+func (s *Store) encrypt(k string, v []byte) ([]byte, error) {
+	aead, err := s.aead(s.keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := []byte{valueMagic, valueVersion, byte(len(s.keyID))}
+	hdr = append(hdr, s.keyID...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(hdr, nonce...)
+	return aead.Seal(out, nonce, v, additionalData(k, hdr)), nil
+}
+
+// decrypt opens a value and returns it along with the ID of the key
+// that was used.  Outside of strict mode, values that are not
+// encrypted are returned as-is with an empty key ID.
+func (s *Store) decrypt(k string, raw []byte) (string, []byte, error) {
+	if len(raw) == 0 || raw[0] != valueMagic {
+		if s.strict {
+			s.l.Error("Rejected unencrypted value", "key", k)
+			return "", nil, ErrNotEncrypted
+		}
+		s.l.Warn("Read unencrypted value", "key", k)
+		return "", raw, nil
+	}
+	if len(raw) < 3 || raw[1] != valueVersion {
+		return "", nil, ErrBadValue
+	}
+
+	idLen := int(raw[2])
+	if len(raw) < 3+idLen {
+		return "", nil, ErrBadValue
+	}
+	hdr := raw[:3+idLen]
+	keyID := string(raw[3 : 3+idLen])
+
+	aead, err := s.aead(keyID)
+	if err != nil {
+		return "", nil, err
+	}
+	rest := raw[3+idLen:]
+	if len(rest) < aead.NonceSize() {
+		return "", nil, ErrBadValue
+	}
+	nonce, ct := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+
+	v, err := aead.Open(nil, nonce, ct, additionalData(k, hdr))
+	if err != nil {
+		s.l.Warn("Unable to authenticate value", "key", k, "keyID", keyID)
+		return "", nil, ErrBadValue
+	}
+	return keyID, v, nil
+}
+
+// aead returns the cipher for the given key ID, loading it from the
+// keyprovider if it has not been used before.
+func (s *Store) aead(keyID string) (cipher.AEAD, error) {
+	s.aeadMu.Lock()
+	defer s.aeadMu.Unlock()
+
+	if a, ok := s.aeads[keyID]; ok {
+		return a, nil
+	}
+
+	if len(keyID) == 0 || len(keyID) > 255 {
+		return nil, ErrBadKey
+	}
+	material, err := s.kp.Provide(keyMech, keyID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseKey(material, s.keyFormat)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	a, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s.aeads[keyID] = a
+	return a, nil
+}
+
+// parseKey decodes key material in the given format.  The format is
+// never guessed, since the base64 encoding of one valid key length is
+// itself a valid key length.
+func parseKey(b []byte, format string) ([]byte, error) {
+	if format != keyFormatRaw {
+		dec, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b)))
+		if err != nil {
+			return nil, ErrBadKey
+		}
+		b = dec
+	}
+	switch len(b) {
+	case 16, 24, 32:
+		return b, nil
+	}
+	return nil, ErrBadKey
+}
+
+func additionalData(k string, hdr []byte) []byte {
+	return append([]byte(k), hdr...)
+}
+
+// tx encrypts values on their way into a transaction on the
+// underlying store.
+type tx struct {
+	s     *Store
+	inner db.KVTx
+}
+
+func (t *tx) Put(ctx context.Context, k string, v []byte) error {
+	ct, err := t.s.encrypt(k, v)
+	if err != nil {
+		return err
+	}
+	return t.inner.Put(ctx, k, ct)
+}
+
+func (t *tx) Del(ctx context.Context, k string) error {
+	return t.inner.Del(ctx, k)
+}
+
+// Commit holds the write lock so that the transaction can't race
+// with lazy re-encryption.
+func (t *tx) Commit() error {
+	t.s.writeMu.Lock()
+	defer t.s.writeMu.Unlock()
+	return t.inner.Commit()
+}
+
+func (t *tx) Rollback() error {
+	return t.inner.Rollback()
+}
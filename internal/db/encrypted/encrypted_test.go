@@ -0,0 +1,264 @@
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/pkg/token/keyprovider"
+	"github.com/netauth/netauth/pkg/token/keyprovider/mock"
+)
+
+var (
+	key1 = []byte("AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=")
+	key2 = []byte("AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=\n")
+)
+
+func newTestStore(t *testing.T, keyID string) (*Store, db.KVStore, *mock.Provider) {
+	inner, _ := memory.NewKV(hclog.NewNullLogger())
+	inner.SetEventFunc(func(db.Event) {})
+
+	kp := &mock.Provider{}
+	kp.On("Provide", keyMech, "one").Return(key1, nil)
+	kp.On("Provide", keyMech, "two").Return(key2, nil)
+	kp.On("Provide", keyMech, "missing").Return([]byte(nil), keyprovider.ErrNoSuchKey)
+
+	s, err := newStore(hclog.NewNullLogger(), inner, kp, options{keyID: keyID, strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, inner, kp
+}
+
+func TestCB(t *testing.T) {
+	cb()
+}
+
+func TestNewRecursive(t *testing.T) {
+	viper.Set("encryption.storage", "encrypted")
+	defer viper.Set("encryption.storage", "")
+
+	_, err := New(hclog.NewNullLogger())
+	assert.Equal(t, ErrRecursiveStorage, err)
+}
+
+func TestNewMissingKey(t *testing.T) {
+	inner, _ := memory.NewKV(hclog.NewNullLogger())
+	kp := &mock.Provider{}
+	kp.On("Provide", keyMech, "missing").Return([]byte(nil), keyprovider.ErrNoSuchKey)
+
+	_, err := newStore(hclog.NewNullLogger(), inner, kp, options{keyID: "missing"})
+	assert.Equal(t, keyprovider.ErrNoSuchKey, err)
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s, inner, _ := newTestStore(t, "one")
+
+	assert.Nil(t, s.Put(ctx, "/entities/entity1", []byte("secret")))
+
+	raw, err := inner.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, valueMagic, raw[0])
+	assert.False(t, bytes.Contains(raw, []byte("secret")))
+
+	v, err := s.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret"), v)
+
+	keys, err := s.Keys(ctx, "/entities/*")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/entities/entity1"}, keys)
+
+	assert.Nil(t, s.Del(ctx, "/entities/entity1"))
+	_, err = s.Get(ctx, "/entities/entity1")
+	assert.Equal(t, db.ErrNoValue, err)
+
+	assert.Equal(t, inner.Capabilities(), s.Capabilities())
+}
+
+func TestLazyUpgrade(t *testing.T) {
+	ctx := context.Background()
+	s, inner, _ := newTestStore(t, "one")
+
+	// Values written in the clear are rejected in strict mode.
+	inner.Put(ctx, "/entities/plain", []byte("plaintext"))
+	_, err := s.Get(ctx, "/entities/plain")
+	assert.Equal(t, ErrNotEncrypted, err)
+
+	// Otherwise they are readable and are encrypted once they
+	// have been read.
+	s.strict = false
+	v, err := s.Get(ctx, "/entities/plain")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("plaintext"), v)
+	raw, _ := inner.Get(ctx, "/entities/plain")
+	assert.Equal(t, valueMagic, raw[0])
+
+	// Rotating the key causes values to be rewritten with the new
+	// key as they are read.
+	s.Put(ctx, "/entities/entity1", []byte("rotate me"))
+	s2, _ := newStore(hclog.NewNullLogger(), inner, s.kp, options{keyID: "two", strict: true})
+
+	v, err = s2.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("rotate me"), v)
+	raw, _ = inner.Get(ctx, "/entities/entity1")
+	keyID, _, err := s2.decrypt("/entities/entity1", raw)
+	assert.Nil(t, err)
+	assert.Equal(t, "two", keyID)
+}
+
+func TestLazyUpgradeNoEvent(t *testing.T) {
+	ctx := context.Background()
+	s, inner, _ := newTestStore(t, "one")
+	s.Put(ctx, "/entities/entity1", []byte("rotate me"))
+
+	s2, _ := newStore(hclog.NewNullLogger(), inner, s.kp, options{keyID: "two", strict: true})
+	events := []db.Event{}
+	s2.SetEventFunc(func(e db.Event) { events = append(events, e) })
+
+	// Reading a value with an old key rewrites it without
+	// announcing a change.
+	_, err := s2.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+	raw, _ := inner.Get(ctx, "/entities/entity1")
+	keyID, _, _ := s2.decrypt("/entities/entity1", raw)
+	assert.Equal(t, "two", keyID)
+	assert.Len(t, events, 0)
+
+	// Real changes are still announced.
+	assert.Nil(t, s2.Put(ctx, "/entities/entity1", []byte("changed")))
+	assert.Equal(t, []db.Event{{Type: db.EventEntityUpdate, PK: "entity1"}}, events)
+}
+
+func TestTampered(t *testing.T) {
+	ctx := context.Background()
+	s, inner, _ := newTestStore(t, "one")
+
+	s.Put(ctx, "/entities/entity1", []byte("secret"))
+	raw, _ := inner.Get(ctx, "/entities/entity1")
+
+	// Values can't be moved to a different key.
+	inner.Put(ctx, "/entities/entity2", raw)
+	_, err := s.Get(ctx, "/entities/entity2")
+	assert.Equal(t, ErrBadValue, err)
+
+	// Or modified.
+	bad := append([]byte{}, raw...)
+	bad[len(bad)-1] ^= 0xff
+	inner.Put(ctx, "/entities/entity2", bad)
+	_, err = s.Get(ctx, "/entities/entity2")
+	assert.Equal(t, ErrBadValue, err)
+
+	// Or truncated.
+	inner.Put(ctx, "/entities/entity2", raw[:5])
+	_, err = s.Get(ctx, "/entities/entity2")
+	assert.Equal(t, ErrBadValue, err)
+
+	// Values written with a key that is no longer available
+	// can't be read.
+	inner.Put(ctx, "/entities/entity2", []byte{valueMagic, valueVersion, 7, 'm', 'i', 's', 's', 'i', 'n', 'g'})
+	_, err = s.Get(ctx, "/entities/entity2")
+	assert.Equal(t, keyprovider.ErrNoSuchKey, err)
+}
+
+func TestTransaction(t *testing.T) {
+	ctx := context.Background()
+	s, inner, _ := newTestStore(t, "one")
+	s.Put(ctx, "/groups/group1", []byte("group"))
+
+	tx, err := s.Begin(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Put(ctx, "/entities/entity1", []byte("secret")))
+	assert.Nil(t, tx.Del(ctx, "/groups/group1"))
+	assert.Nil(t, tx.Commit())
+
+	raw, _ := inner.Get(ctx, "/entities/entity1")
+	assert.False(t, bytes.Contains(raw, []byte("secret")))
+	v, err := s.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret"), v)
+	_, err = s.Get(ctx, "/groups/group1")
+	assert.Equal(t, db.ErrNoValue, err)
+
+	tx, _ = s.Begin(ctx)
+	tx.Put(ctx, "/entities/entity2", []byte("discarded"))
+	assert.Nil(t, tx.Rollback())
+	_, err = s.Get(ctx, "/entities/entity2")
+	assert.Equal(t, db.ErrNoValue, err)
+}
+
+// compactingKV adds compaction to a memory store.
+type compactingKV struct {
+	*memory.KV
+
+	compacted bool
+}
+
+func (c *compactingKV) Compact(context.Context) error {
+	c.compacted = true
+	return nil
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	s, inner, _ := newTestStore(t, "one")
+	assert.Equal(t, db.ErrCompactionUnsupported, s.Compact(ctx))
+
+	c := &compactingKV{KV: inner.(*memory.KV)}
+	s.inner = c
+	assert.Nil(t, s.Compact(ctx))
+	assert.True(t, c.compacted)
+}
+
+func TestParseKey(t *testing.T) {
+	k, err := parseKey(key2, keyFormatBase64)
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{2}, 32), k)
+
+	// The encoding of a 24 byte key is 32 characters long, and is
+	// still decoded.
+	k, err = parseKey([]byte("AwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMD"), keyFormatBase64)
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{3}, 24), k)
+
+	k, err = parseKey(bytes.Repeat([]byte{4}, 16), keyFormatRaw)
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{4}, 16), k)
+
+	_, err = parseKey(bytes.Repeat([]byte{4}, 16), keyFormatBase64)
+	assert.Equal(t, ErrBadKey, err)
+
+	_, err = parseKey([]byte("too short"), keyFormatRaw)
+	assert.Equal(t, ErrBadKey, err)
+
+	_, err = parseKey([]byte("dG9vIHNob3J0"), keyFormatBase64)
+	assert.Equal(t, ErrBadKey, err)
+}
+
+func TestNewBadKeyFormat(t *testing.T) {
+	viper.Set("encryption.key-format", "hex")
+	defer viper.Set("encryption.key-format", "")
+
+	_, err := New(hclog.NewNullLogger())
+	assert.Equal(t, ErrBadKeyFormat, err)
+}
+
+func TestStrictFromConfig(t *testing.T) {
+	// Unset means strict.
+	assert.True(t, strictFromConfig())
+
+	viper.Set("encryption.strict", false)
+	defer viper.Set("encryption.strict", nil)
+	assert.False(t, strictFromConfig())
+
+	viper.Set("encryption.strict", true)
+	assert.True(t, strictFromConfig())
+}
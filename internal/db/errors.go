@@ -27,4 +27,51 @@ var (
 
 	// ErrNoValue is returned when no value exists for a given key.
 	ErrNoValue = errors.New("no value exists")
+
+	// ErrConflict is returned when an object has been changed by
+	// another request between being loaded and being saved.
+	ErrConflict = errors.New("the object was modified concurrently")
+
+	// ErrJournalDisabled is returned when changes are requested
+	// from a database that is not keeping a journal.
+	ErrJournalDisabled = errors.New("the change journal is not enabled")
+
+	// ErrJournalTruncated is returned when changes are requested
+	// from a point that has already been compacted out of the
+	// journal.
+	ErrJournalTruncated = errors.New("the requested changes are no longer in the journal")
+
+	// ErrDuplicateNumber is returned when an object would be
+	// given a number that is already held by another object of
+	// the same kind.
+	ErrDuplicateNumber = errors.New("this number is already allocated")
+
+	// ErrUnknownNumberRange is returned when numbers are
+	// requested from a range that is not configured.
+	ErrUnknownNumberRange = errors.New("the specified number range does not exist")
+
+	// ErrNumberRangeExhausted is returned when every number in a
+	// range has been allocated.
+	ErrNumberRangeExhausted = errors.New("no numbers remain in the number range")
+
+	// ErrSchemaTooNew is returned when the store was written by a
+	// newer version of NetAuth than this one.
+	ErrSchemaTooNew = errors.New("the datastore schema is newer than this version supports")
+
+	// ErrSchemaOutdated is returned when the store has migrations
+	// that have not yet been applied.
+	ErrSchemaOutdated = errors.New("the datastore schema must be migrated")
+
+	// ErrNotInTrash is returned when an object is to be restored
+	// or purged from the trash, but is not there.
+	ErrNotInTrash = errors.New("the requested object is not in the trash")
+
+	// ErrObjectExists is returned when an object can't be
+	// restored from the trash because another object with the
+	// same name has since been created.
+	ErrObjectExists = errors.New("an object with this name already exists")
+
+	// ErrCompactionUnsupported is returned when compaction is
+	// requested from a store that doesn't need it.
+	ErrCompactionUnsupported = errors.New("the datastore does not support compaction")
 )
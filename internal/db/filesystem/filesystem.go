@@ -1,11 +1,15 @@
 // Package filesystem implements a key/value store on top of a generic
 // filesystem.  This is the direct successor to the protodb and is
-// compatible with its storage format.  It is not compatible with some
-// of the features of the protodb, most notably noticing changes to
-// the filesystem outside of NetAuth.  It was incredibly hard to make
-// this work reliably in protodb, and if you look too closely you'll
-// realize that it doesn't satisfy a lot of integrity constraints and
-// probably could be used to corrupt data if you were really clever.
+// compatible with its storage format.  By default it does not notice
+// changes to the filesystem outside of NetAuth.  It was incredibly
+// hard to make this work reliably in protodb, and if you look too
+// closely you'll realize that it doesn't satisfy a lot of integrity
+// constraints and probably could be used to corrupt data if you were
+// really clever.  If filesystem.watch is set then inotify is used to
+// watch for changes instead, which is suitable for when the kv
+// directory is synchronized from elsewhere by external tooling.
+// Changes are debounced, and files that are still being written are
+// ignored until they settle.
 // Additionally, the filesystem key/value store does not use the .dat
 // extension on data files as it is wholely unnecessary.  This needs
 // to be done during migration.  The recommended way to migrate from
@@ -19,6 +23,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	atomic "github.com/google/renameio"
 	"github.com/hashicorp/go-hclog"
@@ -33,6 +38,10 @@ import (
 type Filesystem struct {
 	basePath string
 
+	watch    bool
+	debounce time.Duration
+	w        *watcher
+
 	l  hclog.Logger
 	eF func(db.Event)
 }
@@ -65,15 +74,27 @@ func newKV(l hclog.Logger) (db.KVStore, error) {
 		l: l.Named("filesystem"),
 
 		basePath: filepath.Join(viper.GetString("core.home"), "kv"),
+		watch:    viper.GetBool("filesystem.watch"),
+		debounce: viper.GetDuration("filesystem.debounce"),
+	}
+	if x.debounce <= 0 {
+		x.debounce = 500 * time.Millisecond
 	}
 
 	return x, nil
 }
 
 // SetEventFunc sets up a function to call to fire events to
-// subscribers.
+// subscribers.  If watching is enabled it begins once there is
+// somewhere to send the events.
 func (fs *Filesystem) SetEventFunc(ef func(db.Event)) {
 	fs.eF = ef
+
+	if fs.watch && fs.w == nil {
+		if err := fs.startWatcher(); err != nil {
+			fs.l.Error("Unable to watch for changes", "error", err)
+		}
+	}
 }
 
 // Put stores a series of bytes on the filesystem, checking to make
@@ -91,6 +112,9 @@ func (fs *Filesystem) Put(_ context.Context, k string, v []byte) error {
 	if err := atomic.WriteFile(p, v, 0640); err != nil {
 		return err
 	}
+	if fs.w != nil {
+		fs.w.record(k)
+	}
 
 	fs.fireEventForKey(k, eventUpdate)
 	return nil
@@ -104,6 +128,10 @@ func (fs *Filesystem) Get(_ context.Context, k string) ([]byte, error) {
 		return nil, err
 	}
 
+	if fs.w != nil {
+		fs.w.settle(k)
+	}
+
 	bytes, err := ioutil.ReadFile(p)
 	if os.IsNotExist(err) {
 		return nil, db.ErrNoValue
@@ -128,6 +156,9 @@ func (fs *Filesystem) Del(_ context.Context, k string) error {
 	if err := os.Remove(p); err != nil {
 		return err
 	}
+	if fs.w != nil {
+		fs.w.record(k)
+	}
 
 	fs.fireEventForKey(k, eventDelete)
 	return nil
@@ -139,7 +170,9 @@ func (fs *Filesystem) Del(_ context.Context, k string) error {
 // namespace with a single layer of keys below it.  Its technically
 // possible to do something dumb with an entity or group name that
 // includes a path seperator, but this should be filtered out at a
-// higher level.
+// higher level.  When watching for changes, hidden files are skipped
+// since they are likely to be temporary files that are still being
+// written.
 func (fs *Filesystem) Keys(_ context.Context, f string) ([]string, error) {
 	// Discard error because the hard coded pattern cannot return
 	// an os.PathError
@@ -148,6 +181,9 @@ func (fs *Filesystem) Keys(_ context.Context, f string) ([]string, error) {
 	out := make([]string, len(keys))
 	i := 0
 	for _, k := range keys {
+		if fs.watch && (isTempName(filepath.Base(k)) || isTempName(filepath.Base(filepath.Dir(k)))) {
+			continue
+		}
 		k, _ = filepath.Rel(fs.basePath, k)
 		k = "/" + k
 		if m, _ := filepath.Match(f, k); m {
@@ -159,9 +195,14 @@ func (fs *Filesystem) Keys(_ context.Context, f string) ([]string, error) {
 	return out[:i], nil
 }
 
-// Close is required by the interface, but all operations on the
-// filesystem are atomic, so no close is required.
-func (fs *Filesystem) Close() error { return nil }
+// Close stops the watcher if there is one.  All other operations on
+// the filesystem are atomic, so nothing else needs to be closed.
+func (fs *Filesystem) Close() error {
+	if fs.w == nil {
+		return nil
+	}
+	return fs.w.stop()
+}
 
 // Capabilities returns the capabilities that this implementation is
 // able to satisfy.  Capabilities checks for a .writeable flag to tell
@@ -225,6 +266,6 @@ func (fs *Filesystem) fireEventForKey(k string, t eventType) {
 			Type: db.EventGroupDestroy,
 		})
 	default:
-		fs.l.Warn("Event translation called with unknown key prefix", "type", t, "key", k)
+		fs.l.Trace("Event translation called with unknown key prefix", "type", t, "key", k)
 	}
 }
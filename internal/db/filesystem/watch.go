@@ -0,0 +1,270 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// A watcher notices changes that are made to the store by something
+// other than this process, such as a tool that synchronizes the kv
+// directory between hosts.  Changes are collected until the key has
+// been quiet for the debounce interval, at which point the file is
+// examined and an event is fired if it differs from what was last
+// seen.  This keeps a file that is written in several steps from
+// firing several events, and prevents the watcher from firing events
+// for the writes that this process makes itself.
+type watcher struct {
+	fs       *Filesystem
+	w        *fsnotify.Watcher
+	debounce time.Duration
+
+	sync.Mutex
+	known   map[string]fileState
+	pending map[string]time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// fileState is enough information about a file to tell if it has
+// been changed.
+type fileState struct {
+	size  int64
+	mtime time.Time
+}
+
+// maxSettleWait bounds how long a read will wait for a key that is
+// being written to settle.
+const maxSettleWait = 5 * time.Second
+
+// startWatcher begins watching the base path and the namespace
+// directories within it.
+func (fs *Filesystem) startWatcher() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	fw := &watcher{
+		fs:       fs,
+		w:        w,
+		debounce: fs.debounce,
+		known:    make(map[string]fileState),
+		pending:  make(map[string]time.Time),
+		done:     make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(fs.basePath, 0750); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Add(fs.basePath); err != nil {
+		w.Close()
+		return err
+	}
+	dirs, _ := filepath.Glob(filepath.Join(fs.basePath, "*"))
+	for _, d := range dirs {
+		fw.addDir(d, false)
+	}
+
+	fs.w = fw
+	fw.wg.Add(2)
+	go fw.run()
+	go fw.flushLoop()
+	fs.l.Info("Watching for changes", "path", fs.basePath, "debounce", fs.debounce)
+	return nil
+}
+
+// stop shuts down the watcher and waits for it to exit.
+func (fw *watcher) stop() error {
+	close(fw.done)
+	err := fw.w.Close()
+	fw.wg.Wait()
+	return err
+}
+
+// addDir watches a namespace directory.  The files that are already
+// present are recorded, and if the directory has just appeared they
+// are also marked as pending so that events are fired for them.
+func (fw *watcher) addDir(d string, isNew bool) {
+	if st, err := os.Stat(d); err != nil || !st.IsDir() || isTempName(filepath.Base(d)) {
+		return
+	}
+	if err := fw.w.Add(d); err != nil {
+		fw.fs.l.Warn("Unable to watch directory", "path", d, "error", err)
+		return
+	}
+
+	files, _ := filepath.Glob(filepath.Join(d, "*"))
+	now := time.Now()
+	fw.Lock()
+	defer fw.Unlock()
+	for _, f := range files {
+		k, ok := fw.key(f)
+		if !ok {
+			continue
+		}
+		if isNew {
+			fw.pending[k] = now
+			continue
+		}
+		if st, ok := stat(f); ok {
+			fw.known[k] = st
+		}
+	}
+}
+
+// run receives notifications from the kernel and marks the affected
+// keys as pending.
+func (fw *watcher) run() {
+	defer fw.wg.Done()
+	for {
+		select {
+		case ev, ok := <-fw.w.Events:
+			if !ok {
+				return
+			}
+			fw.handle(ev)
+		case err, ok := <-fw.w.Errors:
+			if !ok {
+				return
+			}
+			fw.fs.l.Warn("Error watching for changes", "error", err)
+		}
+	}
+}
+
+func (fw *watcher) handle(ev fsnotify.Event) {
+	if filepath.Dir(ev.Name) == fw.fs.basePath {
+		// A namespace directory has appeared, possibly
+		// already containing files.
+		if ev.Op&fsnotify.Create != 0 {
+			fw.addDir(ev.Name, true)
+		}
+		return
+	}
+
+	k, ok := fw.key(ev.Name)
+	if !ok {
+		return
+	}
+	fw.fs.l.Trace("Filesystem change", "key", k, "op", ev.Op)
+	fw.Lock()
+	fw.pending[k] = time.Now()
+	fw.Unlock()
+}
+
+// flushLoop periodically looks for keys that have settled.
+func (fw *watcher) flushLoop() {
+	defer fw.wg.Done()
+	t := time.NewTicker(fw.debounce / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-fw.done:
+			return
+		case <-t.C:
+			fw.flush(time.Now())
+		}
+	}
+}
+
+// flush fires events for all keys that have not changed in the last
+// debounce interval.  Keys whose files match what was last seen are
+// skipped.
+func (fw *watcher) flush(now time.Time) {
+	type change struct {
+		key string
+		t   eventType
+	}
+	changes := []change{}
+
+	fw.Lock()
+	for k, last := range fw.pending {
+		if now.Sub(last) < fw.debounce {
+			continue
+		}
+		delete(fw.pending, k)
+
+		st, exists := stat(filepath.Join(fw.fs.basePath, k))
+		old, wasKnown := fw.known[k]
+		switch {
+		case !exists && wasKnown:
+			delete(fw.known, k)
+			changes = append(changes, change{k, eventDelete})
+		case exists && (!wasKnown || old != st):
+			fw.known[k] = st
+			changes = append(changes, change{k, eventUpdate})
+		}
+	}
+	fw.Unlock()
+
+	for _, c := range changes {
+		fw.fs.l.Debug("Detected external change", "key", c.key, "type", c.t)
+		fw.fs.fireEventForKey(c.key, c.t)
+	}
+}
+
+// record notes the state of a key that this process has just written
+// or removed so that the watcher will not fire an event for it.
+func (fw *watcher) record(k string) {
+	k = filepath.Clean(k)
+	fw.Lock()
+	defer fw.Unlock()
+	if st, ok := stat(filepath.Join(fw.fs.basePath, k)); ok {
+		fw.known[k] = st
+	} else {
+		delete(fw.known, k)
+	}
+}
+
+// settle waits for a key that is in the middle of being changed to
+// become quiet so that a read does not see a partially written file.
+func (fw *watcher) settle(k string) {
+	k = filepath.Clean(k)
+	deadline := time.Now().Add(maxSettleWait)
+	for time.Now().Before(deadline) {
+		fw.Lock()
+		last, ok := fw.pending[k]
+		fw.Unlock()
+		if !ok || time.Since(last) >= fw.debounce {
+			return
+		}
+		time.Sleep(fw.debounce / 4)
+	}
+	fw.fs.l.Warn("Key did not settle, reading anyway", "key", k)
+}
+
+// key converts a path into a key, rejecting anything that isn't a
+// file in a namespace directory.
+func (fw *watcher) key(p string) (string, bool) {
+	rel, err := filepath.Rel(fw.fs.basePath, p)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	if len(parts) != 2 || isTempName(parts[0]) || isTempName(parts[1]) {
+		return "", false
+	}
+	return "/" + filepath.ToSlash(rel), true
+}
+
+func stat(p string) (fileState, bool) {
+	st, err := os.Stat(p)
+	if err != nil || !st.Mode().IsRegular() {
+		return fileState{}, false
+	}
+	return fileState{size: st.Size(), mtime: st.ModTime()}, true
+}
+
+// isTempName checks for the names that are used for files that are
+// still being written.  Both renameio and rsync write to a hidden file
+// and then rename it into place.
+func isTempName(n string) bool {
+	return strings.HasPrefix(n, ".")
+}
@@ -0,0 +1,146 @@
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+type eventRecorder struct {
+	sync.Mutex
+	events []db.Event
+}
+
+func (r *eventRecorder) fire(e db.Event) {
+	r.Lock()
+	defer r.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) take() []db.Event {
+	r.Lock()
+	defer r.Unlock()
+	out := r.events
+	r.events = nil
+	return out
+}
+
+func newWatchedFS(t *testing.T) (*Filesystem, *eventRecorder) {
+	fs := &Filesystem{
+		l:        hclog.NewNullLogger(),
+		basePath: t.TempDir(),
+		watch:    true,
+		debounce: 50 * time.Millisecond,
+	}
+	os.MkdirAll(filepath.Join(fs.basePath, "entities"), 0750)
+	os.WriteFile(filepath.Join(fs.basePath, "entities", "existing"), []byte("old"), 0640)
+
+	r := &eventRecorder{}
+	fs.SetEventFunc(r.fire)
+	assert.NotNil(t, fs.w)
+	t.Cleanup(func() { fs.Close() })
+	return fs, r
+}
+
+// settled waits long enough for pending changes to be flushed.
+func settled(fs *Filesystem) {
+	time.Sleep(fs.debounce * 4)
+}
+
+func TestWatchExternalChanges(t *testing.T) {
+	fs, r := newWatchedFS(t)
+
+	// Several writes to the same file result in one event.
+	p := filepath.Join(fs.basePath, "entities", "existing")
+	for i := 0; i < 5; i++ {
+		f, _ := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0640)
+		f.Write([]byte("more"))
+		f.Close()
+	}
+	settled(fs)
+	assert.Equal(t, []db.Event{{Type: db.EventEntityUpdate, PK: "existing"}}, r.take())
+
+	// Temporary files are ignored, and the rename that replaces
+	// the file is noticed.
+	tmp := filepath.Join(fs.basePath, "entities", ".new.XXXXXX")
+	os.WriteFile(tmp, []byte("new"), 0640)
+	settled(fs)
+	assert.Empty(t, r.take())
+	os.Rename(tmp, filepath.Join(fs.basePath, "entities", "new"))
+	settled(fs)
+	assert.Equal(t, []db.Event{{Type: db.EventEntityUpdate, PK: "new"}}, r.take())
+
+	// A namespace directory that appears with files already in it
+	// fires events for all of them.
+	staging := filepath.Join(t.TempDir(), "groups")
+	os.MkdirAll(staging, 0750)
+	os.WriteFile(filepath.Join(staging, "group1"), []byte("group"), 0640)
+	os.Rename(staging, filepath.Join(fs.basePath, "groups"))
+	settled(fs)
+	assert.Equal(t, []db.Event{{Type: db.EventGroupUpdate, PK: "group1"}}, r.take())
+
+	os.Remove(filepath.Join(fs.basePath, "groups", "group1"))
+	settled(fs)
+	assert.Equal(t, []db.Event{{Type: db.EventGroupDestroy, PK: "group1"}}, r.take())
+}
+
+func TestWatchOwnWrites(t *testing.T) {
+	ctx := context.Background()
+	fs, r := newWatchedFS(t)
+
+	// Writes made through the store fire their events directly,
+	// and the watcher does not fire them again.
+	assert.Nil(t, fs.Put(ctx, "/entities/entity1", []byte("bytes")))
+	assert.Nil(t, fs.Del(ctx, "/entities/existing"))
+	settled(fs)
+	assert.Equal(t, []db.Event{
+		{Type: db.EventEntityUpdate, PK: "entity1"},
+		{Type: db.EventEntityDestroy, PK: "existing"},
+	}, r.take())
+}
+
+func TestWatchSettle(t *testing.T) {
+	ctx := context.Background()
+	fs, _ := newWatchedFS(t)
+
+	// A read waits for a file that is being written to settle.
+	p := filepath.Join(fs.basePath, "entities", "partial")
+	os.WriteFile(p, []byte("part"), 0640)
+	go func() {
+		time.Sleep(fs.debounce / 2)
+		f, _ := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0640)
+		f.Write([]byte("ial"))
+		f.Close()
+	}()
+	time.Sleep(fs.debounce / 4)
+	v, err := fs.Get(ctx, "/entities/partial")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("partial"), v)
+}
+
+func TestKeysSkipsTemporary(t *testing.T) {
+	ctx := context.Background()
+	fs := &Filesystem{l: hclog.NewNullLogger(), basePath: t.TempDir(), watch: true}
+	os.MkdirAll(filepath.Join(fs.basePath, "entities"), 0750)
+	for _, n := range []string{"entity1", ".entity2.123", "entity3~", "entity4.tmp"} {
+		os.WriteFile(filepath.Join(fs.basePath, "entities", n), nil, 0640)
+	}
+
+	keys, err := fs.Keys(ctx, "/*/*")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/entities/entity1", "/entities/entity3~", "/entities/entity4.tmp"}, keys)
+
+	// Nothing is skipped when not watching for changes.
+	fs.watch = false
+	keys, err = fs.Keys(ctx, "/*/*")
+	assert.Nil(t, err)
+	assert.Len(t, keys, 4)
+}
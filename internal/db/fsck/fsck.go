@@ -0,0 +1,246 @@
+// Package fsck checks the entities and groups in a datastore for
+// references that can't be resolved and for other inconsistencies
+// that the tree does not prevent, such as the dangling group
+// memberships left behind when a group is destroyed.  Where it is safe
+// to do so the problems that are found can be repaired.
+package fsck
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/netauth/netauth/internal/db"
+
+	pb "github.com/netauth/protocol"
+)
+
+// Kind identifies a class of problem.
+type Kind string
+
+const (
+	// Unreadable records could not be loaded or unmarshaled.
+	Unreadable Kind = "unreadable"
+
+	// MissingGroup is a membership in a group that does not
+	// exist.
+	MissingGroup Kind = "missing-group"
+
+	// MissingExpansion is an expansion that targets a group that
+	// does not exist.
+	MissingExpansion Kind = "missing-expansion"
+
+	// MalformedExpansion is an expansion that can't be parsed.
+	MalformedExpansion Kind = "malformed-expansion"
+
+	// MissingManager is a ManagedBy that names a group that does
+	// not exist.
+	MissingManager Kind = "missing-manager"
+
+	// DuplicateNumber is a number that is shared by more than one
+	// entity, or more than one group.
+	DuplicateNumber Kind = "duplicate-number"
+)
+
+// Store is the subset of the db that is checked.  It is satisfied by
+// *db.DB.
+type Store interface {
+	DiscoverEntityIDs(context.Context) ([]string, error)
+	LoadEntity(context.Context, string) (*pb.Entity, error)
+	SaveEntity(context.Context, *pb.Entity) error
+
+	DiscoverGroupNames(context.Context) ([]string, error)
+	LoadGroup(context.Context, string) (*pb.Group, error)
+	SaveGroup(context.Context, *pb.Group) error
+}
+
+// A Problem is a single inconsistency.  Repaired is set if the problem
+// was fixed, and Detail describes either the problem or the change
+// that was made to fix it.
+type Problem struct {
+	Key      string
+	Kind     Kind
+	Detail   string
+	Repaired bool
+}
+
+func (p Problem) String() string {
+	s := fmt.Sprintf("%s: %s: %s", p.Key, p.Kind, p.Detail)
+	if p.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+// Report is the result of a check.
+type Report struct {
+	Entities int
+	Groups   int
+	Problems []Problem
+}
+
+// Unrepaired returns the number of problems that remain.
+func (r *Report) Unrepaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Report) add(key string, k Kind, repaired bool, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{
+		Key:      key,
+		Kind:     k,
+		Detail:   fmt.Sprintf(format, args...),
+		Repaired: repaired,
+	})
+}
+
+// Check scans every entity and group in s.  If repair is set then
+// references to groups that don't exist and malformed expansions are
+// removed and the affected objects are saved.  Records that can't be
+// read and duplicate numbers are only reported, since there is no way
+// to fix them without guessing which record is correct.
+func Check(ctx context.Context, s Store, repair bool) (*Report, error) {
+	r := &Report{}
+
+	gkeys, err := s.DiscoverGroupNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(gkeys)
+
+	// Groups that exist but are unreadable are still counted as
+	// existing so that references to them are left alone.
+	exists := make(map[string]bool, len(gkeys))
+	groups := make([]*pb.Group, 0, len(gkeys))
+	for _, k := range gkeys {
+		name := path.Base(k)
+		exists[name] = true
+		g, err := s.LoadGroup(ctx, name)
+		if err != nil {
+			r.add(k, Unreadable, false, "%v", err)
+			continue
+		}
+		groups = append(groups, g)
+	}
+	r.Groups = len(gkeys)
+
+	gnums := make(map[int32][]string)
+	for _, g := range groups {
+		key := path.Join("/groups", g.GetName())
+		if g.Number != nil {
+			gnums[g.GetNumber()] = append(gnums[g.GetNumber()], key)
+		}
+
+		changed := false
+		if g.ManagedBy != nil && !exists[g.GetManagedBy()] {
+			r.add(key, MissingManager, repair, "managed by %s, which does not exist", g.GetManagedBy())
+			g.ManagedBy = nil
+			changed = true
+		}
+
+		keep := g.Expansions[:0]
+		for _, exp := range g.GetExpansions() {
+			parts := strings.SplitN(exp, ":", 2)
+			switch {
+			case len(parts) != 2 || (parts[0] != "INCLUDE" && parts[0] != "EXCLUDE"):
+				r.add(key, MalformedExpansion, repair, "expansion %q is malformed", exp)
+				changed = true
+			case !exists[parts[1]]:
+				r.add(key, MissingExpansion, repair, "expansion %s targets a group that does not exist", exp)
+				changed = true
+			default:
+				keep = append(keep, exp)
+			}
+		}
+		g.Expansions = keep
+
+		if changed && repair {
+			if err := s.SaveGroup(ctx, g); err != nil {
+				return r, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+
+	ekeys, err := s.DiscoverEntityIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ekeys)
+	r.Entities = len(ekeys)
+
+	enums := make(map[int32][]string)
+	for _, k := range ekeys {
+		e, err := s.LoadEntity(ctx, path.Base(k))
+		if err != nil {
+			r.add(k, Unreadable, false, "%v", err)
+			continue
+		}
+		if e.Number != nil {
+			enums[e.GetNumber()] = append(enums[e.GetNumber()], k)
+		}
+
+		if e.GetMeta() == nil {
+			continue
+		}
+		changed := false
+		keep := e.Meta.Groups[:0]
+		for _, g := range e.Meta.GetGroups() {
+			if exists[g] {
+				keep = append(keep, g)
+				continue
+			}
+			r.add(k, MissingGroup, repair, "member of %s, which does not exist", g)
+			changed = true
+		}
+		e.Meta.Groups = keep
+
+		if changed && repair {
+			if err := s.SaveEntity(ctx, e); err != nil {
+				return r, fmt.Errorf("%s: %w", k, err)
+			}
+		}
+	}
+
+	duplicates(r, enums)
+	duplicates(r, gnums)
+	return r, nil
+}
+
+// duplicates reports every number that is held by more than one key.
+func duplicates(r *Report, nums map[int32][]string) {
+	ordered := make([]int32, 0, len(nums))
+	for n := range nums {
+		ordered = append(ordered, n)
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i] < ordered[j] })
+
+	for _, n := range ordered {
+		keys := nums[n]
+		if len(keys) < 2 {
+			continue
+		}
+		for _, k := range keys {
+			r.add(k, DuplicateNumber, false, "number %d is shared with %s", n, strings.Join(others(keys, k), ", "))
+		}
+	}
+}
+
+func others(keys []string, k string) []string {
+	out := make([]string, 0, len(keys)-1)
+	for _, o := range keys {
+		if o != k {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// Ensure the db satisfies Store.
+var _ Store = &db.DB{}
@@ -0,0 +1,116 @@
+package fsck
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+
+	pb "github.com/netauth/protocol"
+)
+
+// brokenStore reports entity2 as present but unreadable, and group2
+// as sharing a number with group1, which the db would refuse to
+// write.
+type brokenStore struct {
+	*db.DB
+}
+
+func (b brokenStore) DiscoverEntityIDs(ctx context.Context) ([]string, error) {
+	ids, err := b.DB.DiscoverEntityIDs(ctx)
+	return append(ids, "/entities/entity2"), err
+}
+
+func (b brokenStore) LoadEntity(ctx context.Context, ID string) (*pb.Entity, error) {
+	if ID == "entity2" {
+		return nil, db.ErrInternalError
+	}
+	return b.DB.LoadEntity(ctx, ID)
+}
+
+func (b brokenStore) LoadGroup(ctx context.Context, name string) (*pb.Group, error) {
+	g, err := b.DB.LoadGroup(ctx, name)
+	if name == "group2" && err == nil {
+		g.Number = proto.Int32(1)
+	}
+	return g, err
+}
+
+func newStore(t *testing.T) brokenStore {
+	startup.DoCallbacks()
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, g := range []*pb.Group{
+		{Name: proto.String("group1"), Number: proto.Int32(1), ManagedBy: proto.String("gone"),
+			Expansions: []string{"INCLUDE:group2", "EXCLUDE:gone", "group2"}},
+		{Name: proto.String("group2"), Number: proto.Int32(2)},
+	} {
+		assert.Nil(t, mdb.SaveGroup(ctx, g))
+	}
+	assert.Nil(t, mdb.SaveEntity(ctx, &pb.Entity{
+		ID:     proto.String("entity1"),
+		Number: proto.Int32(1),
+		Meta:   &pb.EntityMeta{Groups: []string{"group1", "gone"}},
+	}))
+	return brokenStore{mdb}
+}
+
+func kinds(r *Report) map[Kind]int {
+	out := make(map[Kind]int)
+	for _, p := range r.Problems {
+		out[p.Kind]++
+	}
+	return out
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t)
+
+	r, err := Check(ctx, s, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, r.Entities)
+	assert.Equal(t, 2, r.Groups)
+	assert.Equal(t, map[Kind]int{
+		Unreadable:         1,
+		MissingGroup:       1,
+		MissingExpansion:   1,
+		MalformedExpansion: 1,
+		MissingManager:     1,
+		DuplicateNumber:    2,
+	}, kinds(r))
+	assert.Equal(t, len(r.Problems), r.Unrepaired())
+
+	// Nothing is changed without repair.
+	g, _ := s.LoadGroup(ctx, "group1")
+	assert.Equal(t, 3, len(g.GetExpansions()))
+}
+
+func TestCheckRepair(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t)
+
+	r, err := Check(ctx, s, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, r.Unrepaired())
+
+	g, _ := s.LoadGroup(ctx, "group1")
+	assert.Equal(t, []string{"INCLUDE:group2"}, g.GetExpansions())
+	assert.Nil(t, g.ManagedBy)
+	e, _ := s.LoadEntity(ctx, "entity1")
+	assert.Equal(t, []string{"group1"}, e.GetMeta().GetGroups())
+
+	// A second pass finds only what can't be repaired.
+	r, err = Check(ctx, s, true)
+	assert.Nil(t, err)
+	assert.Equal(t, map[Kind]int{Unreadable: 1, DuplicateNumber: 2}, kinds(r))
+}
@@ -0,0 +1,336 @@
+// Package git implements a key/value store on top of a local git
+// repository.  Each key is a file in the working tree, laid out the
+// same way as the filesystem store, and every mutation is committed
+// on its own.  This provides a complete history of the identity data
+// which can be inspected with git log and git blame, and rolled back
+// with the usual git tooling while the server is stopped.  The
+// author of each commit is the entity that made the change when it
+// is known, and the message names the event that the change caused.
+//
+// Since every write is a commit this store is considerably slower
+// than the others, and is best suited to installations where changes
+// are infrequent and auditability matters more than throughput.
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	atomic "github.com/google/renameio"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/startup"
+)
+
+// Store anchors all the methods in the git key/value store.
+type Store struct {
+	sync.Mutex
+
+	basePath string
+	repo     *gogit.Repository
+	wt       *gogit.Worktree
+
+	name  string
+	email string
+
+	l  hclog.Logger
+	eF func(db.Event)
+}
+
+var (
+	// ErrPathEscape is returned if a key tries to climb up and
+	// out of the repository, or into the repository metadata.
+	ErrPathEscape = errors.New("attempted path escape")
+)
+
+func init() {
+	startup.RegisterCallback(cb)
+}
+
+func cb() {
+	db.RegisterKV("git", New)
+}
+
+// New opens the repository at git.path, which defaults to the git
+// directory below core.home, and creates it if it doesn't exist.
+// Commits are made with git.name and git.email as the committer, and
+// as the author when the acting entity isn't known.
+func New(l hclog.Logger) (db.KVStore, error) {
+	p := viper.GetString("git.path")
+	if p == "" {
+		p = filepath.Join(viper.GetString("core.home"), "git")
+	}
+
+	x := &Store{
+		basePath: p,
+		name:     viper.GetString("git.name"),
+		email:    viper.GetString("git.email"),
+		l:        l.Named("git"),
+	}
+	if x.name == "" {
+		x.name = "NetAuth"
+	}
+	if x.email == "" {
+		x.email = "netauth@localhost"
+	}
+
+	if err := os.MkdirAll(p, 0750); err != nil {
+		return nil, err
+	}
+	repo, err := gogit.PlainOpen(p)
+	if err == gogit.ErrRepositoryNotExists {
+		repo, err = gogit.PlainInit(p, false)
+		if err == nil {
+			x.l.Info("Initialized new repository", "path", p)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	x.repo = repo
+	x.wt = wt
+	x.l.Debug("Repository opened", "path", p)
+	return x, nil
+}
+
+// SetEventFunc sets up a function to call to fire events to
+// subscribers.
+func (s *Store) SetEventFunc(f func(db.Event)) {
+	s.eF = f
+}
+
+// Put writes v to the file for k and commits it.
+func (s *Store) Put(ctx context.Context, k string, v []byte) error {
+	rel, err := s.relPath(k)
+	if err != nil {
+		return err
+	}
+	p := filepath.Join(s.basePath, rel)
+
+	s.Lock()
+	defer s.Unlock()
+
+	old, err := ioutil.ReadFile(p)
+	existed := err == nil
+
+	if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+		return err
+	}
+	if err := atomic.WriteFile(p, v, 0640); err != nil {
+		return err
+	}
+	if _, err := s.wt.Add(filepath.ToSlash(rel)); err != nil {
+		s.restore(rel, old, existed)
+		return err
+	}
+
+	e, ok := eventForKey(k, existed, false)
+	if err := s.commit(ctx, k, e, ok); err != nil {
+		s.restore(rel, old, existed)
+		return err
+	}
+	if ok {
+		s.eF(e)
+	}
+	return nil
+}
+
+// Get returns the contents of the file for k, or db.ErrNoValue if
+// there is no such file.
+func (s *Store) Get(_ context.Context, k string) ([]byte, error) {
+	rel, err := s.relPath(k)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(s.basePath, rel))
+	if os.IsNotExist(err) {
+		return nil, db.ErrNoValue
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Del removes the file for k and commits the removal.  If there is
+// no such file then db.ErrNoValue is returned.
+func (s *Store) Del(ctx context.Context, k string) error {
+	rel, err := s.relPath(k)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	old, err := ioutil.ReadFile(filepath.Join(s.basePath, rel))
+	if os.IsNotExist(err) {
+		return db.ErrNoValue
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := s.wt.Remove(filepath.ToSlash(rel)); err != nil {
+		s.restore(rel, old, true)
+		return err
+	}
+
+	e, ok := eventForKey(k, true, true)
+	if err := s.commit(ctx, k, e, ok); err != nil {
+		s.restore(rel, old, true)
+		return err
+	}
+	if ok {
+		s.eF(e)
+	}
+	return nil
+}
+
+// Keys returns all the keys that match the globbing expression f.
+// As with the filesystem store this relies on every key being
+// exactly one level below a namespace.  Hidden files, which includes
+// the repository metadata, are never keys.
+func (s *Store) Keys(_ context.Context, f string) ([]string, error) {
+	// The pattern is hard coded, so this can't fail.
+	paths, _ := filepath.Glob(filepath.Join(s.basePath, "*", "*"))
+
+	out := []string{}
+	for _, p := range paths {
+		if strings.HasPrefix(filepath.Base(p), ".") || strings.HasPrefix(filepath.Base(filepath.Dir(p)), ".") {
+			continue
+		}
+		rel, _ := filepath.Rel(s.basePath, p)
+		k := "/" + filepath.ToSlash(rel)
+		if m, _ := path.Match(f, k); m {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+// Close does nothing, every change is committed as soon as it is
+// made.
+func (s *Store) Close() error { return nil }
+
+// Capabilities returns that this key/value store supports the mutable
+// property.  Transactions are not supported, so each write in a
+// batch is committed separately.
+func (s *Store) Capabilities() []db.KVCapability {
+	return []db.KVCapability{db.KVMutable}
+}
+
+// commit records the staged change to k.  The subject of the message
+// is the event that the change causes, or simply names the key if it
+// isn't an entity or group.
+func (s *Store) commit(ctx context.Context, k string, e db.Event, ok bool) error {
+	subject := "Update " + k
+	if ok {
+		subject = e.Type.String() + " " + e.PK
+	}
+	msg := fmt.Sprintf("%s\n\nKey: %s\n", subject, k)
+
+	now := time.Now()
+	committer := &object.Signature{Name: s.name, Email: s.email, When: now}
+	author := committer
+	if actor := db.ActorFromContext(ctx); actor != "" {
+		author = &object.Signature{Name: actor, Email: s.email, When: now}
+	}
+
+	h, err := s.wt.Commit(msg, &gogit.CommitOptions{
+		Author:    author,
+		Committer: committer,
+	})
+	if err != nil {
+		s.l.Error("Error committing change", "key", k, "error", err)
+		return err
+	}
+	s.l.Trace("Committed change", "key", k, "commit", h.String(), "author", author.Name)
+	return nil
+}
+
+// restore puts the file at rel back the way it was before a change
+// that could not be committed, and resets its index entry to match.
+// This keeps the failed change from being read back, and from being
+// swept into the commit for the next change.
+func (s *Store) restore(rel string, old []byte, existed bool) {
+	p := filepath.Join(s.basePath, rel)
+	var err error
+	if existed {
+		if err = atomic.WriteFile(p, old, 0640); err == nil {
+			_, err = s.wt.Add(filepath.ToSlash(rel))
+		}
+	} else {
+		if err = os.Remove(p); err == nil || os.IsNotExist(err) {
+			err = s.unstage(rel)
+		}
+	}
+	if err != nil {
+		s.l.Error("Unable to undo uncommitted change", "path", rel, "error", err)
+	}
+}
+
+// unstage removes the index entry for rel.
+func (s *Store) unstage(rel string) error {
+	idx, err := s.repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+	if _, err := idx.Remove(filepath.ToSlash(rel)); err != nil && err != index.ErrEntryNotFound {
+		return err
+	}
+	return s.repo.Storer.SetIndex(idx)
+}
+
+// relPath converts a key to a path relative to the root of the
+// repository, making sure that it stays inside the working tree and
+// out of the repository metadata.
+func (s *Store) relPath(k string) (string, error) {
+	p := filepath.Clean(filepath.Join("/", filepath.FromSlash(k)))
+	rel := strings.TrimPrefix(p, string(filepath.Separator))
+	if rel == "" || strings.HasPrefix(rel, ".git") || k != filepath.ToSlash(p) {
+		return "", ErrPathEscape
+	}
+	return rel, nil
+}
+
+// eventForKey maps from a key to the event that a change to it
+// causes.  The second value is false for keys that are not entities
+// or groups.
+func eventForKey(k string, existed, deleted bool) (db.Event, bool) {
+	e := db.Event{PK: path.Base(k)}
+	switch {
+	case strings.HasPrefix(k, "/entities/") && deleted:
+		e.Type = db.EventEntityDestroy
+	case strings.HasPrefix(k, "/entities/") && existed:
+		e.Type = db.EventEntityUpdate
+	case strings.HasPrefix(k, "/entities/"):
+		e.Type = db.EventEntityCreate
+	case strings.HasPrefix(k, "/groups/") && deleted:
+		e.Type = db.EventGroupDestroy
+	case strings.HasPrefix(k, "/groups/") && existed:
+		e.Type = db.EventGroupUpdate
+	case strings.HasPrefix(k, "/groups/"):
+		e.Type = db.EventGroupCreate
+	default:
+		return e, false
+	}
+	return e, true
+}
@@ -0,0 +1,197 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+func newStore(t *testing.T) (*Store, *[]db.Event) {
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []db.Event{}
+	kv.SetEventFunc(func(e db.Event) { events = append(events, e) })
+	return kv.(*Store), &events
+}
+
+// history returns the commits in the repository, newest first.
+func history(t *testing.T, s *Store) []*object.Commit {
+	iter, err := s.repo.Log(&gogit.LogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := []*object.Commit{}
+	iter.ForEach(func(c *object.Commit) error {
+		out = append(out, c)
+		return nil
+	})
+	return out
+}
+
+func TestCB(t *testing.T) {
+	cb()
+}
+
+func TestNewBadPath(t *testing.T) {
+	viper.Set("core.home", "/dev/null/nothing-here")
+	_, err := New(hclog.NewNullLogger())
+	assert.NotNil(t, err)
+}
+
+func TestPersistence(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStore(t)
+	assert.Nil(t, s.Put(ctx, "/entities/entity1", []byte("some data")))
+	assert.Nil(t, s.Close())
+
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	b, err := kv.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("some data"), b)
+	assert.Len(t, history(t, kv.(*Store)), 1)
+}
+
+func TestPutGetDel(t *testing.T) {
+	ctx := context.Background()
+	s, events := newStore(t)
+
+	assert.Nil(t, s.Put(ctx, "/entities/entity1", []byte("some data")))
+	assert.Nil(t, s.Put(ctx, "/entities/entity1", []byte("new data")))
+	assert.Nil(t, s.Put(ctx, "/groups/group1", []byte("group data")))
+
+	b, err := s.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new data"), b)
+
+	assert.Nil(t, s.Del(ctx, "/groups/group1"))
+	_, err = s.Get(ctx, "/groups/group1")
+	assert.Equal(t, db.ErrNoValue, err)
+	assert.Equal(t, db.ErrNoValue, s.Del(ctx, "/groups/group1"))
+
+	assert.Equal(t, []db.Event{
+		{Type: db.EventEntityCreate, PK: "entity1"},
+		{Type: db.EventEntityUpdate, PK: "entity1"},
+		{Type: db.EventGroupCreate, PK: "group1"},
+		{Type: db.EventGroupDestroy, PK: "group1"},
+	}, *events)
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStore(t)
+
+	assert.Nil(t, s.Put(ctx, "/meta/schema", []byte("1")))
+	assert.Nil(t, s.Put(db.WithActor(ctx, "admin"), "/entities/entity1", []byte("some data")))
+	assert.Nil(t, s.Del(db.WithActor(ctx, "admin"), "/entities/entity1"))
+
+	commits := history(t, s)
+	assert.Len(t, commits, 3)
+
+	assert.Equal(t, "EntityDestroy entity1\n\nKey: /entities/entity1\n", commits[0].Message)
+	assert.Equal(t, "admin", commits[0].Author.Name)
+	assert.Equal(t, "NetAuth", commits[0].Committer.Name)
+
+	assert.Equal(t, "EntityCreate entity1\n\nKey: /entities/entity1\n", commits[1].Message)
+	f, err := commits[1].File("entities/entity1")
+	assert.Nil(t, err)
+	c, _ := f.Contents()
+	assert.Equal(t, "some data", c)
+
+	assert.Equal(t, "Update /meta/schema\n\nKey: /meta/schema\n", commits[2].Message)
+	assert.Equal(t, "NetAuth", commits[2].Author.Name)
+}
+
+func TestCommitFailure(t *testing.T) {
+	ctx := context.Background()
+	s, events := newStore(t)
+	assert.Nil(t, s.Put(ctx, "/entities/entity1", []byte("some data")))
+
+	// Replacing the branch with a directory makes every commit
+	// fail when it tries to move the branch.
+	head, err := s.repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := filepath.Join(s.basePath, ".git", filepath.FromSlash(head.Name().String()))
+	assert.Nil(t, os.Remove(ref))
+	assert.Nil(t, os.MkdirAll(filepath.Join(ref, "blocked"), 0750))
+
+	assert.NotNil(t, s.Put(ctx, "/entities/entity1", []byte("new data")))
+	assert.NotNil(t, s.Put(ctx, "/groups/group1", []byte("group data")))
+	assert.NotNil(t, s.Del(ctx, "/entities/entity1"))
+
+	// None of the failed changes are visible or staged.
+	b, err := s.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("some data"), b)
+	_, err = s.Get(ctx, "/groups/group1")
+	assert.Equal(t, db.ErrNoValue, err)
+	assert.Len(t, *events, 1)
+
+	// Once commits work again nothing is left over, and the next
+	// change is committed on its own.
+	assert.Nil(t, os.RemoveAll(ref))
+	assert.Nil(t, ioutil.WriteFile(ref, []byte(head.Hash().String()+"\n"), 0640))
+	status, err := s.wt.Status()
+	assert.Nil(t, err)
+	assert.True(t, status.IsClean(), status.String())
+	assert.Nil(t, s.Put(ctx, "/meta/schema", []byte("1")))
+	commits := history(t, s)
+	assert.Len(t, commits, 2)
+	stats, err := commits[0].Stats()
+	assert.Nil(t, err)
+	assert.Len(t, stats, 1)
+	assert.Equal(t, "meta/schema", stats[0].Name)
+}
+
+func TestKeys(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStore(t)
+
+	s.Put(ctx, "/entities/entity1", []byte("lots of data"))
+	s.Put(ctx, "/entities/entity2", []byte("lots of data"))
+	s.Put(ctx, "/entities/purple", []byte("lots of data"))
+	s.Put(ctx, "/groups/group1", []byte("lots of data"))
+
+	res, err := s.Keys(ctx, "/entities/entity*")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/entities/entity1", "/entities/entity2"}, res)
+
+	// The repository metadata is never included.
+	res, err = s.Keys(ctx, "/*/*")
+	assert.Nil(t, err)
+	assert.Len(t, res, 4)
+}
+
+func TestPathEscape(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStore(t)
+
+	for _, k := range []string{"/../escape", "/.git/config", "/entities/../../escape", "/"} {
+		assert.Equal(t, ErrPathEscape, s.Put(ctx, k, []byte("data")), k)
+		_, err := s.Get(ctx, k)
+		assert.Equal(t, ErrPathEscape, err, k)
+	}
+	_, err := s.Get(ctx, "/HEAD")
+	assert.Equal(t, db.ErrNoValue, err)
+	assert.DirExists(t, filepath.Join(s.basePath, ".git"))
+}
+
+func TestCapabilities(t *testing.T) {
+	s, _ := newStore(t)
+	assert.Equal(t, []db.KVCapability{db.KVMutable}, s.Capabilities())
+}
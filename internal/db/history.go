@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+)
+
+// The secrets that an entity has had in the past are kept in the
+// /secret-history keyspace rather than on the entity itself, so that
+// they are never returned along with the entity or indexed for
+// search.  The history of an entity is removed when the entity is
+// removed for good, which is when it is purged from the trash if
+// trash.enabled is set.
+
+// A SecretHistoryEntry is a secured secret that an entity used to
+// have, and the time at which it was replaced.
+type SecretHistoryEntry struct {
+	Secret   string    `json:"secret"`
+	Replaced time.Time `json:"replaced"`
+}
+
+func secretHistoryKey(ID string) string {
+	return path.Join("/secret-history", ID)
+}
+
+// LoadSecretHistory returns the secrets that an entity has had in
+// the past, most recently replaced first.  An entity that has never
+// changed its secret has an empty history.
+func (db *DB) LoadSecretHistory(ctx context.Context, ID string) ([]SecretHistoryEntry, error) {
+	v, err := db.get(ctx, secretHistoryKey(ID))
+	if err == ErrNoValue {
+		return []SecretHistoryEntry{}, nil
+	}
+	if err != nil {
+		db.log.Warn("Error loading secret history", "entity", ID, "error", err)
+		return nil, ErrInternalError
+	}
+	out := []SecretHistoryEntry{}
+	if err := json.Unmarshal(v, &out); err != nil {
+		db.log.Warn("Unreadable secret history", "entity", ID, "error", err)
+		return nil, ErrInternalError
+	}
+	return out, nil
+}
+
+// SaveSecretHistory replaces the secret history of an entity.
+// Saving an empty history removes it.
+func (db *DB) SaveSecretHistory(ctx context.Context, ID string, h []SecretHistoryEntry) error {
+	if len(h) == 0 {
+		return db.dropSecretHistory(ctx, ID)
+	}
+	v, _ := json.Marshal(h)
+	return db.put(ctx, secretHistoryKey(ID), v)
+}
+
+// dropSecretHistory removes the secret history of an entity if it
+// has one.
+func (db *DB) dropSecretHistory(ctx context.Context, ID string) error {
+	if err := db.del(ctx, secretHistoryKey(ID)); err != nil && err != ErrNoValue {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+func TestSecretHistory(t *testing.T) {
+	ctx := context.Background()
+	m := newMemoryDB(t)
+
+	hist, err := m.LoadSecretHistory(ctx, "entity1")
+	assert.Nil(t, err)
+	assert.Len(t, hist, 0)
+
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity1", 1)))
+	h := []db.SecretHistoryEntry{{Secret: "old-secret", Replaced: time.Now().Round(0)}}
+	assert.Nil(t, m.SaveSecretHistory(ctx, "entity1", h))
+	hist, err = m.LoadSecretHistory(ctx, "entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, h[0].Secret, hist[0].Secret)
+	assert.True(t, h[0].Replaced.Equal(hist[0].Replaced))
+
+	// The history is never searchable.
+	res, err := m.SearchEntities(ctx, db.SearchRequest{Expression: "old-secret"})
+	assert.Nil(t, err)
+	assert.Len(t, res, 0)
+
+	assert.Nil(t, m.SaveSecretHistory(ctx, "entity1", nil))
+	assert.Nil(t, m.SaveSecretHistory(ctx, "entity1", nil))
+	hist, err = m.LoadSecretHistory(ctx, "entity1")
+	assert.Nil(t, err)
+	assert.Len(t, hist, 0)
+
+	// Removing the entity removes its history.
+	assert.Nil(t, m.SaveSecretHistory(ctx, "entity1", h))
+	assert.Nil(t, m.DeleteEntity(ctx, "entity1"))
+	hist, err = m.LoadSecretHistory(ctx, "entity1")
+	assert.Nil(t, err)
+	assert.Len(t, hist, 0)
+}
+
+func TestSecretHistoryTrash(t *testing.T) {
+	ctx := context.Background()
+	m := newTrashDB(t)
+
+	h := []db.SecretHistoryEntry{{Secret: "old-secret", Replaced: time.Now()}}
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity1", 1)))
+	assert.Nil(t, m.SaveSecretHistory(ctx, "entity1", h))
+
+	// The history is kept while the entity can be restored.
+	assert.Nil(t, m.DeleteEntity(ctx, "entity1"))
+	hist, err := m.LoadSecretHistory(ctx, "entity1")
+	assert.Nil(t, err)
+	assert.Len(t, hist, 1)
+
+	assert.Nil(t, m.PurgeTrash(ctx, db.TrashEntity, "entity1"))
+	hist, err = m.LoadSecretHistory(ctx, "entity1")
+	assert.Nil(t, err)
+	assert.Len(t, hist, 0)
+}
@@ -0,0 +1,325 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	atomic "github.com/google/renameio"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+)
+
+// A JournalEntry records a single change to the store.  Sequence
+// numbers start at 1 and increase by one for every change, and are
+// never reused, even after entries have been compacted away.
+type JournalEntry struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	Key  string    `json:"key"`
+	Type EventType `json:"type"`
+}
+
+// JournalOptions controls how long entries are kept for.  Entries are
+// discarded when they are older than MaxAge or when there are more
+// than MaxEntries of them, whichever comes first.  A zero value
+// disables the corresponding limit.
+type JournalOptions struct {
+	MaxAge     time.Duration
+	MaxEntries int
+}
+
+// A Journal is an append-only log of the changes that have been made
+// to the store.  It is fed by the same events that drive the
+// callbacks, so it also sees changes that did not originate in this
+// process, such as those replicated from another server.  Each server
+// keeps its own journal, so sequence numbers are only meaningful to
+// the server that issued them.
+//
+// If the journal has a path then entries are appended to it as JSON
+// lines and survive a restart.  Otherwise they are only kept in
+// memory.
+type Journal struct {
+	sync.Mutex
+
+	path    string
+	f       *os.File
+	opts    JournalOptions
+	entries []JournalEntry
+	next    uint64
+
+	l hclog.Logger
+}
+
+// journalHeader is the first line of a journal file, and preserves
+// the next sequence number when all the entries have been compacted
+// away.
+type journalHeader struct {
+	Next uint64 `json:"next"`
+}
+
+// OpenJournal opens the journal at p, creating it if it does not
+// exist.  If p is empty the journal is held in memory.
+func OpenJournal(l hclog.Logger, p string, opts JournalOptions) (*Journal, error) {
+	j := &Journal{
+		path: p,
+		opts: opts,
+		next: 1,
+		l:    l.Named("journal"),
+	}
+	if p == "" {
+		return j, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+		return nil, err
+	}
+	entries, next, err := ReadJournal(p)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	j.entries = entries
+	if next > j.next {
+		j.next = next
+	}
+
+	// Compacting on open also rewrites the file, which drops any
+	// partial entry left behind by a crash.
+	if err := j.Compact(time.Now()); err != nil {
+		return nil, err
+	}
+	j.l.Debug("Journal opened", "path", p, "entries", len(j.entries), "next", j.next)
+	return j, nil
+}
+
+// ReadJournal reads the entries from a journal file.  It is safe to
+// call while the journal is open elsewhere, and is intended for tools
+// that inspect the journal of a running server.  The next sequence
+// number that will be issued is returned along with the entries.  A
+// partially written final line is ignored.
+func ReadJournal(p string) ([]JournalEntry, uint64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var next uint64 = 1
+	entries := []JournalEntry{}
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		line := s.Bytes()
+		if bytes.HasPrefix(line, []byte(`{"next"`)) {
+			var h journalHeader
+			if json.Unmarshal(line, &h) == nil && h.Next > next {
+				next = h.Next
+			}
+			continue
+		}
+		var e JournalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+		if e.Seq >= next {
+			next = e.Seq + 1
+		}
+	}
+	return entries, next, s.Err()
+}
+
+// Append records an event and returns the entry that was written.
+// The entry is kept even if it can't be written to disk, since the
+// change it describes has already happened.
+func (j *Journal) Append(e Event) JournalEntry {
+	j.Lock()
+	defer j.Unlock()
+
+	entry := JournalEntry{
+		Seq:  j.next,
+		Time: time.Now(),
+		Key:  eventKey(e),
+		Type: e.Type,
+	}
+	j.next++
+	j.entries = append(j.entries, entry)
+
+	if j.f != nil {
+		b, _ := json.Marshal(entry)
+		if _, err := j.f.Write(append(b, '\n')); err != nil {
+			j.l.Error("Unable to write journal entry", "seq", entry.Seq, "error", err)
+		}
+	}
+	return entry
+}
+
+// Since returns up to limit entries with a sequence number greater
+// than seq, oldest first.  A limit of zero returns all of them.  If
+// entries after seq have already been compacted away then
+// ErrJournalTruncated is returned, and the caller must fall back to a
+// full resync.
+func (j *Journal) Since(seq uint64, limit int) ([]JournalEntry, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	first := j.next
+	if len(j.entries) > 0 {
+		first = j.entries[0].Seq
+	}
+	if seq+1 < first {
+		return nil, ErrJournalTruncated
+	}
+
+	i := sort.Search(len(j.entries), func(i int) bool { return j.entries[i].Seq > seq })
+	out := j.entries[i:]
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return append([]JournalEntry{}, out...), nil
+}
+
+// LastSequence returns the sequence number of the most recent entry,
+// or zero if nothing has ever been recorded.
+func (j *Journal) LastSequence() uint64 {
+	j.Lock()
+	defer j.Unlock()
+	return j.next - 1
+}
+
+// Compact discards the entries that are outside the retention limits
+// as of now, and rewrites the journal file to reclaim the space they
+// used.
+func (j *Journal) Compact(now time.Time) error {
+	j.Lock()
+	defer j.Unlock()
+
+	drop := 0
+	if j.opts.MaxEntries > 0 && len(j.entries) > j.opts.MaxEntries {
+		drop = len(j.entries) - j.opts.MaxEntries
+	}
+	if j.opts.MaxAge > 0 {
+		cutoff := now.Add(-j.opts.MaxAge)
+		for drop < len(j.entries) && j.entries[drop].Time.Before(cutoff) {
+			drop++
+		}
+	}
+	if drop > 0 {
+		j.l.Debug("Compacting journal", "dropped", drop, "kept", len(j.entries)-drop)
+		j.entries = append([]JournalEntry{}, j.entries[drop:]...)
+	}
+
+	if j.path == "" || (drop == 0 && j.f != nil) {
+		return nil
+	}
+	return j.rewrite()
+}
+
+// rewrite replaces the journal file with the entries that are
+// currently held, and reopens it for appending.
+func (j *Journal) rewrite() error {
+	var buf bytes.Buffer
+	h, _ := json.Marshal(journalHeader{Next: j.next})
+	buf.Write(append(h, '\n'))
+	for _, e := range j.entries {
+		b, _ := json.Marshal(e)
+		buf.Write(append(b, '\n'))
+	}
+
+	if j.f != nil {
+		j.f.Close()
+		j.f = nil
+	}
+	if err := atomic.WriteFile(j.path, buf.Bytes(), 0640); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	j.f = f
+	return nil
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.Lock()
+	defer j.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// eventKey converts an event back into the key that was changed.
+func eventKey(e Event) string {
+	switch e.Type {
+	case EventEntityCreate, EventEntityUpdate, EventEntityDestroy:
+		return path.Join("/entities", e.PK)
+	default:
+		return path.Join("/groups", e.PK)
+	}
+}
+
+// ChangesSince returns the changes that were made after the given
+// sequence number.  See Journal.Since for details.
+func (db *DB) ChangesSince(seq uint64, limit int) ([]JournalEntry, error) {
+	if db.journal == nil {
+		return nil, ErrJournalDisabled
+	}
+	return db.journal.Since(seq, limit)
+}
+
+// journalFromConfig opens the change journal if journal.enabled is
+// set.  The journal is kept in core.home, and every
+// journal.compact-interval it is compacted according to
+// journal.retention and journal.max-entries.
+func (db *DB) journalFromConfig() error {
+	if !viper.GetBool("journal.enabled") {
+		return nil
+	}
+
+	p := filepath.Join(viper.GetString("core.home"), "journal.log")
+	j, err := OpenJournal(db.log, p, JournalOptions{
+		MaxAge:     viper.GetDuration("journal.retention"),
+		MaxEntries: viper.GetInt("journal.max-entries"),
+	})
+	if err != nil {
+		db.log.Error("Unable to open change journal", "path", p, "error", err)
+		return err
+	}
+	db.journal = j
+
+	every := viper.GetDuration("journal.compact-interval")
+	if every <= 0 {
+		every = time.Hour
+	}
+	db.journalDone = make(chan struct{})
+	go db.compactJournal(every, db.journalDone)
+	return nil
+}
+
+// compactJournal periodically compacts the journal until the done
+// channel is closed.
+func (db *DB) compactJournal(every time.Duration, done chan struct{}) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-t.C:
+			if err := db.journal.Compact(now); err != nil {
+				db.log.Warn("Error compacting journal", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalMemory(t *testing.T) {
+	j, err := OpenJournal(hclog.NewNullLogger(), "", JournalOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), j.LastSequence())
+
+	e := j.Append(Event{Type: EventEntityUpdate, PK: "entity1"})
+	assert.Equal(t, uint64(1), e.Seq)
+	assert.Equal(t, "/entities/entity1", e.Key)
+	j.Append(Event{Type: EventGroupDestroy, PK: "group1"})
+	j.Append(Event{Type: EventEntityDestroy, PK: "entity1"})
+
+	out, err := j.Since(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, out, 3)
+
+	out, err = j.Since(1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []JournalEntry{{Seq: 2, Time: out[0].Time, Key: "/groups/group1", Type: EventGroupDestroy}}, out)
+
+	out, err = j.Since(3, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, out)
+	assert.Nil(t, j.Close())
+}
+
+func TestJournalCompact(t *testing.T) {
+	j, _ := OpenJournal(hclog.NewNullLogger(), "", JournalOptions{MaxAge: time.Hour, MaxEntries: 3})
+	for i := 0; i < 5; i++ {
+		j.Append(Event{Type: EventEntityUpdate, PK: "entity1"})
+	}
+
+	// Only the newest entries are kept.
+	assert.Nil(t, j.Compact(time.Now()))
+	_, err := j.Since(0, 0)
+	assert.Equal(t, ErrJournalTruncated, err)
+	out, err := j.Since(2, 0)
+	assert.Nil(t, err)
+	assert.Len(t, out, 3)
+
+	// Old entries are dropped, but sequence numbers carry on.
+	assert.Nil(t, j.Compact(time.Now().Add(2*time.Hour)))
+	out, err = j.Since(5, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, out)
+	assert.Equal(t, uint64(6), j.Append(Event{Type: EventGroupUpdate, PK: "group1"}).Seq)
+}
+
+func TestJournalPersistent(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "journal.log")
+	j, err := OpenJournal(hclog.NewNullLogger(), p, JournalOptions{MaxEntries: 2})
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		j.Append(Event{Type: EventEntityUpdate, PK: "entity1"})
+	}
+	assert.Nil(t, j.Close())
+
+	// Simulate a crash part way through writing an entry.
+	f, _ := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0640)
+	f.Write([]byte(`{"seq":4,"ti`))
+	f.Close()
+
+	entries, next, err := ReadJournal(p)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, uint64(4), next)
+
+	// Reopening compacts, and picks up where it left off.
+	j, err = OpenJournal(hclog.NewNullLogger(), p, JournalOptions{MaxEntries: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), j.LastSequence())
+	out, err := j.Since(1, 0)
+	assert.Nil(t, err)
+	assert.Len(t, out, 2)
+	assert.Equal(t, uint64(4), j.Append(Event{Type: EventGroupUpdate, PK: "group1"}).Seq)
+	assert.Nil(t, j.Close())
+
+	entries, next, err = ReadJournal(p)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, uint64(5), next)
+}
+
+func TestDBJournal(t *testing.T) {
+	viper.Set("core.home", t.TempDir())
+	viper.Set("journal.enabled", true)
+	defer viper.Set("journal.enabled", false)
+
+	RegisterKV("mock", newMockKV)
+	x, err := New("mock")
+	assert.Nil(t, err)
+	delete(x.cbs, "BleveSearch")
+
+	x.FireEvent(Event{Type: EventEntityCreate, PK: "entity1"})
+
+	// Preloading isn't a change, so it isn't journaled.
+	x.kv.(*mockKV).On("Keys", "/entities/*").Return([]string{"/entities/entity1"}, nil)
+	x.kv.(*mockKV).On("Keys", "/groups/*").Return([]string{}, nil)
+	assert.Nil(t, x.EventUpdateAll())
+
+	out, err := x.ChangesSince(0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, out, 1)
+	assert.Equal(t, EventEntityCreate, out[0].Type)
+
+	x.kv.(*mockKV).On("Close").Return(nil)
+	x.Shutdown()
+
+	x.journal = nil
+	_, err = x.ChangesSince(0, 0)
+	assert.Equal(t, ErrJournalDisabled, err)
+}
+
+func TestEventTypeString(t *testing.T) {
+	assert.Equal(t, "EntityUpdate", EventEntityUpdate.String())
+	assert.Equal(t, "GroupDestroy", EventGroupDestroy.String())
+	assert.Equal(t, "Unknown", EventType(42).String())
+}
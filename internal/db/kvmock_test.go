@@ -29,6 +29,9 @@ type mockKV struct {
 func newMockKV(hclog.Logger) (KVStore, error) {
 	x := &mockKV{}
 	x.On("SetEventFunc", mock.Anything).Return()
+	// New checks the capabilities once, tests set up their own
+	// capabilities for any later calls.
+	x.On("Capabilities").Return([]KVCapability{KVMutable, KVTransactional}).Once()
 	return x, nil
 }
 
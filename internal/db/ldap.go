@@ -0,0 +1,367 @@
+package db
+
+import (
+	"encoding/hex"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// LDAP filters as described in RFC 4515 are translated into bleve
+// queries so that tools that already speak LDAP can search without
+// learning the query string syntax.  The attributes of the common
+// posixAccount and posixGroup schemas are mapped to the fields they
+// correspond to, and any other attribute is used as a field name
+// directly, which allows filters such as (kv.department=infra).
+// Membership can't be answered by the index, so membership
+// attributes are resolved through the MembershipResolver on the
+// request and the result is matched by document ID.  Extensible
+// matches are not supported.
+
+// ldapSchema describes how the attributes in a filter map to the
+// fields of one kind of document.
+type ldapSchema struct {
+	// id is the field that holds the document ID, which is
+	// matched exactly for equality.
+	id string
+
+	// fields maps from lower case attribute names to fields, and
+	// numeric lists the fields that are indexed as numbers.
+	fields  map[string]string
+	numeric map[string]bool
+
+	// classes are the lower case object classes that every
+	// document is considered to have.
+	classes map[string]bool
+
+	// members are the lower case attributes that are resolved by
+	// membership, and resolve returns the IDs of the documents
+	// that satisfy a membership attribute with the given value.
+	members map[string]bool
+	resolve func(MembershipResolver, string) []string
+}
+
+var entityLDAPSchema = &ldapSchema{
+	id: "ID",
+	fields: map[string]string{
+		"uid":            "ID",
+		"cn":             "ID",
+		"uidnumber":      "Number",
+		"displayname":    "meta.DisplayName",
+		"gecos":          "meta.GECOS",
+		"legalname":      "meta.LegalName",
+		"homedirectory":  "meta.Home",
+		"loginshell":     "meta.Shell",
+		"employeenumber": "meta.BadgeNumber",
+	},
+	numeric: map[string]bool{"Number": true},
+	classes: map[string]bool{
+		"top":                  true,
+		"person":               true,
+		"organizationalperson": true,
+		"inetorgperson":        true,
+		"posixaccount":         true,
+	},
+	members: map[string]bool{"memberof": true},
+	resolve: func(r MembershipResolver, group string) []string { return r.MembersOfGroup(group) },
+}
+
+var groupLDAPSchema = &ldapSchema{
+	id: "Name",
+	fields: map[string]string{
+		"cn":          "Name",
+		"gidnumber":   "Number",
+		"description": "DisplayName",
+		"displayname": "DisplayName",
+	},
+	numeric: map[string]bool{"Number": true},
+	classes: map[string]bool{
+		"top":                true,
+		"posixgroup":         true,
+		"groupofnames":       true,
+		"groupofuniquenames": true,
+	},
+	members: map[string]bool{"member": true, "memberuid": true, "uniquemember": true},
+	resolve: func(r MembershipResolver, entity string) []string { return r.GroupsForEntity(entity) },
+}
+
+var ldapAttrRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9._-]*$`)
+
+// ldapParser holds the state of a filter that is being parsed.
+type ldapParser struct {
+	in  string
+	pos int
+
+	schema *ldapSchema
+	res    MembershipResolver
+}
+
+// parseLDAPFilter translates an RFC 4515 filter into a query against
+// documents described by the schema.  ErrBadSearch is returned if the
+// filter is malformed, or if it refers to membership and no resolver
+// is available.
+func parseLDAPFilter(in string, schema *ldapSchema, res MembershipResolver) (query.Query, error) {
+	p := &ldapParser{in: strings.TrimSpace(in), schema: schema, res: res}
+	q, err := p.filter()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.in) {
+		return nil, ErrBadSearch
+	}
+	return q, nil
+}
+
+// filter parses a single parenthesized filter.
+func (p *ldapParser) filter() (query.Query, error) {
+	if !p.consume('(') {
+		return nil, ErrBadSearch
+	}
+
+	var q query.Query
+	var err error
+	switch p.peek() {
+	case '&':
+		p.pos++
+		q, err = p.list(true)
+	case '|':
+		p.pos++
+		q, err = p.list(false)
+	case '!':
+		p.pos++
+		var sub query.Query
+		if sub, err = p.filter(); err == nil {
+			b := bleve.NewBooleanQuery()
+			b.AddMust(bleve.NewMatchAllQuery())
+			b.AddMustNot(sub)
+			q = b
+		}
+	default:
+		q, err = p.item()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.consume(')') {
+		return nil, ErrBadSearch
+	}
+	return q, nil
+}
+
+// list parses the filters that make up a conjunction or disjunction.
+// Following RFC 4526 an empty conjunction is always true, and an
+// empty disjunction is always false.
+func (p *ldapParser) list(and bool) (query.Query, error) {
+	qs := []query.Query{}
+	for p.peek() == '(' {
+		q, err := p.filter()
+		if err != nil {
+			return nil, err
+		}
+		qs = append(qs, q)
+	}
+
+	switch {
+	case len(qs) == 0 && and:
+		return bleve.NewMatchAllQuery(), nil
+	case len(qs) == 0:
+		return bleve.NewMatchNoneQuery(), nil
+	case and:
+		return bleve.NewConjunctionQuery(qs...), nil
+	default:
+		return bleve.NewDisjunctionQuery(qs...), nil
+	}
+}
+
+// item parses a single attribute assertion.  Values can't contain an
+// unescaped closing parenthesis, so the assertion runs up to the
+// next one.
+func (p *ldapParser) item() (query.Query, error) {
+	end := strings.IndexByte(p.in[p.pos:], ')')
+	if end < 0 {
+		return nil, ErrBadSearch
+	}
+	raw := p.in[p.pos : p.pos+end]
+	p.pos += end
+
+	i := strings.IndexAny(raw, "=~<>:")
+	if i <= 0 || !ldapAttrRegexp.MatchString(raw[:i]) {
+		return nil, ErrBadSearch
+	}
+	attr := raw[:i]
+
+	switch {
+	case strings.HasPrefix(raw[i:], "~="), strings.HasPrefix(raw[i:], ">="), strings.HasPrefix(raw[i:], "<="):
+		return p.assertion(attr, raw[i:i+2], raw[i+2:])
+	case raw[i] == '=':
+		return p.assertion(attr, "=", raw[i+1:])
+	default:
+		return nil, ErrBadSearch
+	}
+}
+
+// assertion converts a single attribute assertion to a query.
+func (p *ldapParser) assertion(attr, op, value string) (query.Query, error) {
+	a := strings.ToLower(attr)
+	parts := strings.Split(value, "*")
+	for i := range parts {
+		v, err := unescapeLDAPValue(parts[i])
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = v
+	}
+	present := op == "=" && value == "*"
+	substring := op == "=" && len(parts) > 1 && !present
+
+	if a == "objectclass" {
+		if present || (op == "=" && !substring && p.schema.classes[strings.ToLower(parts[0])]) {
+			return bleve.NewMatchAllQuery(), nil
+		}
+		return bleve.NewMatchNoneQuery(), nil
+	}
+
+	if p.schema.members[a] {
+		if op != "=" || present || substring || p.res == nil {
+			return nil, ErrBadSearch
+		}
+		ids := p.schema.resolve(p.res, rdnValue(parts[0]))
+		if len(ids) == 0 {
+			return bleve.NewMatchNoneQuery(), nil
+		}
+		return bleve.NewDocIDQuery(ids), nil
+	}
+
+	field := attr
+	if f, ok := p.schema.fields[a]; ok {
+		field = f
+	}
+
+	if p.schema.numeric[field] {
+		return numericAssertion(field, op, present, substring, parts[0])
+	}
+
+	switch {
+	case present:
+		q := bleve.NewRegexpQuery(".+")
+		q.SetField(field)
+		return q, nil
+	case substring:
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(strings.ToLower(parts[i]))
+		}
+		q := bleve.NewRegexpQuery(strings.Join(parts, ".*"))
+		q.SetField(field)
+		return q, nil
+	case op == "=" && field == p.schema.id:
+		return bleve.NewDocIDQuery([]string{parts[0]}), nil
+	case op == "=":
+		q := bleve.NewMatchPhraseQuery(parts[0])
+		q.SetField(field)
+		return q, nil
+	case op == "~=":
+		q := bleve.NewMatchQuery(parts[0])
+		q.SetField(field)
+		q.SetFuzziness(1)
+		return q, nil
+	default:
+		v := strings.ToLower(parts[0])
+		inclusive := true
+		var q *query.TermRangeQuery
+		if op == ">=" {
+			q = bleve.NewTermRangeInclusiveQuery(v, "", &inclusive, nil)
+		} else {
+			q = bleve.NewTermRangeInclusiveQuery("", v, nil, &inclusive)
+		}
+		q.SetField(field)
+		return q, nil
+	}
+}
+
+// numericAssertion converts an assertion on a numeric field to a
+// range query.  Substrings of numbers can't be matched.
+func numericAssertion(field, op string, present, substring bool, value string) (query.Query, error) {
+	if substring {
+		return nil, ErrBadSearch
+	}
+
+	inclusive := true
+	var min, max *float64
+	if present {
+		m := float64(math.MinInt32)
+		min = &m
+	} else {
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, ErrBadSearch
+		}
+		v := float64(n)
+		switch op {
+		case ">=":
+			min = &v
+		case "<=":
+			max = &v
+		default:
+			min, max = &v, &v
+		}
+	}
+	q := bleve.NewNumericRangeInclusiveQuery(min, max, &inclusive, &inclusive)
+	q.SetField(field)
+	return q, nil
+}
+
+// unescapeLDAPValue replaces the \XX escapes in a value with the
+// bytes they stand for.
+func unescapeLDAPValue(v string) (string, error) {
+	if !strings.Contains(v, `\`) {
+		return v, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' {
+			b.WriteByte(v[i])
+			continue
+		}
+		if i+3 > len(v) {
+			return "", ErrBadSearch
+		}
+		c, err := hex.DecodeString(v[i+1 : i+3])
+		if err != nil {
+			return "", ErrBadSearch
+		}
+		b.Write(c)
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// rdnValue returns the value of the first RDN if v is a distinguished
+// name such as cn=ops,ou=groups,dc=example,dc=com, or v itself if it
+// is not.
+func rdnValue(v string) string {
+	rdn := strings.SplitN(v, ",", 2)[0]
+	if i := strings.IndexByte(rdn, '='); i >= 0 {
+		return strings.TrimSpace(rdn[i+1:])
+	}
+	return v
+}
+
+func (p *ldapParser) peek() byte {
+	if p.pos >= len(p.in) {
+		return 0
+	}
+	return p.in[p.pos]
+}
+
+func (p *ldapParser) consume(c byte) bool {
+	if p.peek() != c {
+		return false
+	}
+	p.pos++
+	return true
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/netauth/protocol"
+)
+
+type fakeResolver map[string][]string
+
+func (f fakeResolver) MembersOfGroup(g string) []string { return f[g] }
+
+func (f fakeResolver) GroupsForEntity(e string) []string {
+	out := []string{}
+	for g, members := range f {
+		for _, m := range members {
+			if m == e {
+				out = append(out, g)
+			}
+		}
+	}
+	return out
+}
+
+func newLDAPIndex(t *testing.T) *Index {
+	si := NewIndex(hclog.NewNullLogger())
+	entities := []*pb.Entity{
+		{ID: proto.String("alice"), Number: proto.Int32(1000), Meta: &pb.EntityMeta{Shell: proto.String("/bin/bash"), DisplayName: proto.String("Alice Smith")}},
+		{ID: proto.String("bob"), Number: proto.Int32(1001), Meta: &pb.EntityMeta{Shell: proto.String("/bin/zsh")}},
+		{ID: proto.String("carol"), Number: proto.Int32(1002), Meta: &pb.EntityMeta{
+			KV: []*pb.KVData{{Key: proto.String("department"), Values: []*pb.KVValue{{Value: proto.String("infra")}}}},
+		}},
+	}
+	for _, e := range entities {
+		assert.Nil(t, si.IndexEntity(e))
+	}
+	assert.Nil(t, si.IndexGroup(&pb.Group{Name: proto.String("ops"), Number: proto.Int32(10)}))
+	assert.Nil(t, si.IndexGroup(&pb.Group{Name: proto.String("dev"), Number: proto.Int32(11)}))
+	return si
+}
+
+func TestSearchEntitiesLDAP(t *testing.T) {
+	si := newLDAPIndex(t)
+	res := fakeResolver{"ops": {"alice", "carol"}}
+
+	cases := []struct {
+		filter  string
+		want    []string
+		wantErr error
+	}{
+		{"(uid=alice)", []string{"alice"}, nil},
+		{"(objectClass=posixAccount)", []string{"alice", "bob", "carol"}, nil},
+		{"(objectClass=posixGroup)", []string{}, nil},
+		{"(&(objectClass=posixAccount)(memberOf=ops))", []string{"alice", "carol"}, nil},
+		{"(memberOf=cn=ops,ou=groups,dc=example,dc=com)", []string{"alice", "carol"}, nil},
+		{"(memberOf=nobody)", []string{}, nil},
+		{"(&(memberOf=ops)(!(uid=carol)))", []string{"alice"}, nil},
+		{"(|(uid=bob)(loginShell=/bin/bash))", []string{"alice", "bob"}, nil},
+		{"(loginShell=*)", []string{"alice", "bob"}, nil},
+		{"(uid=*o*)", []string{"bob", "carol"}, nil},
+		{"(uidNumber>=1001)", []string{"bob", "carol"}, nil},
+		{"(uidNumber<=1001)", []string{"alice", "bob"}, nil},
+		{"(uidNumber=1002)", []string{"carol"}, nil},
+		{"(displayName=alice smith)", []string{"alice"}, nil},
+		{"(kv.department=infra)", []string{"carol"}, nil},
+		{`(uid=\61lice)`, []string{"alice"}, nil},
+		{"(&)", []string{"alice", "bob", "carol"}, nil},
+		{"(|)", []string{}, nil},
+		{"(uid=alice", nil, ErrBadSearch},
+		{"uid=alice", nil, ErrBadSearch},
+		{"(uid=alice))", nil, ErrBadSearch},
+		{"(uidNumber=abc)", nil, ErrBadSearch},
+		{"(uid:dn:=alice)", nil, ErrBadSearch},
+		{`(uid=\zz)`, nil, ErrBadSearch},
+		{"(memberOf=o*)", nil, ErrBadSearch},
+	}
+
+	for _, c := range cases {
+		ids, err := si.SearchEntities(SearchRequest{Expression: c.filter, Syntax: SyntaxLDAP, Resolver: res})
+		assert.Equal(t, c.wantErr, err, c.filter)
+		if c.want != nil {
+			sort.Strings(ids)
+			assert.Equal(t, c.want, ids, c.filter)
+		}
+	}
+
+	// Membership can't be resolved without a resolver.
+	_, err := si.SearchEntities(SearchRequest{Expression: "(memberOf=ops)", Syntax: SyntaxLDAP})
+	assert.Equal(t, ErrBadSearch, err)
+}
+
+func TestSearchGroupsLDAP(t *testing.T) {
+	si := newLDAPIndex(t)
+	res := fakeResolver{"ops": {"alice"}, "dev": {"alice", "bob"}}
+
+	cases := []struct {
+		filter string
+		want   []string
+	}{
+		{"(objectClass=posixGroup)", []string{"dev", "ops"}},
+		{"(memberUid=bob)", []string{"dev"}},
+		{"(member=uid=alice,ou=entities,dc=example,dc=com)", []string{"dev", "ops"}},
+		{"(&(cn=ops)(gidNumber=10))", []string{"ops"}},
+	}
+
+	for _, c := range cases {
+		ids, err := si.SearchGroups(SearchRequest{Expression: c.filter, Syntax: SyntaxLDAP, Resolver: res})
+		assert.Nil(t, err, c.filter)
+		sort.Strings(ids)
+		assert.Equal(t, c.want, ids, c.filter)
+	}
+}
@@ -59,18 +59,7 @@ func (kv *KV) Put(_ context.Context, k string, v []byte) error {
 	kv.m[k] = v
 	kv.Unlock()
 
-	switch {
-	case strings.HasPrefix(k, "/entities"):
-		kv.eF(db.Event{
-			Type: db.EventEntityUpdate,
-			PK:   path.Base(k),
-		})
-	case strings.HasPrefix(k, "/groups"):
-		kv.eF(db.Event{
-			Type: db.EventGroupUpdate,
-			PK:   path.Base(k),
-		})
-	}
+	kv.fireEventForKey(k, false)
 	return nil
 }
 
@@ -94,18 +83,7 @@ func (kv *KV) Del(_ context.Context, k string) error {
 	kv.Unlock()
 	kv.l.Trace("DEL", "key", k)
 
-	switch {
-	case strings.HasPrefix(k, "/entities"):
-		kv.eF(db.Event{
-			Type: db.EventEntityDestroy,
-			PK:   path.Base(k),
-		})
-	case strings.HasPrefix(k, "/groups"):
-		kv.eF(db.Event{
-			Type: db.EventGroupDestroy,
-			PK:   path.Base(k),
-		})
-	}
+	kv.fireEventForKey(k, true)
 	return nil
 }
 
@@ -133,5 +111,90 @@ func (kv *KV) Close() error { return nil }
 
 // Capabilities is used to interrogate a KV store for capabilities.
 func (kv *KV) Capabilities() []db.KVCapability {
-	return []db.KVCapability{db.KVMutable}
+	return []db.KVCapability{db.KVMutable, db.KVTransactional}
+}
+
+// Begin starts a new transaction.  Writes are buffered in the
+// transaction and applied under a single lock on Commit.
+func (kv *KV) Begin(_ context.Context) (db.KVTx, error) {
+	return &tx{kv: kv}, nil
+}
+
+// fireEventForKey maps from a key to an entity or group and fires an
+// appropriate event.
+func (kv *KV) fireEventForKey(k string, deleted bool) {
+	switch {
+	case strings.HasPrefix(k, "/entities") && deleted:
+		kv.eF(db.Event{
+			Type: db.EventEntityDestroy,
+			PK:   path.Base(k),
+		})
+	case strings.HasPrefix(k, "/entities"):
+		kv.eF(db.Event{
+			Type: db.EventEntityUpdate,
+			PK:   path.Base(k),
+		})
+	case strings.HasPrefix(k, "/groups") && deleted:
+		kv.eF(db.Event{
+			Type: db.EventGroupDestroy,
+			PK:   path.Base(k),
+		})
+	case strings.HasPrefix(k, "/groups"):
+		kv.eF(db.Event{
+			Type: db.EventGroupUpdate,
+			PK:   path.Base(k),
+		})
+	}
+}
+
+// txOp is a single buffered write.
+type txOp struct {
+	key   string
+	value []byte
+	del   bool
+}
+
+// tx is a transaction against the in-memory store.
+type tx struct {
+	kv  *KV
+	ops []txOp
+}
+
+// Put buffers a write of v to k.
+func (t *tx) Put(_ context.Context, k string, v []byte) error {
+	t.ops = append(t.ops, txOp{key: k, value: v})
+	return nil
+}
+
+// Del buffers the removal of k.
+func (t *tx) Del(_ context.Context, k string) error {
+	t.ops = append(t.ops, txOp{key: k, del: true})
+	return nil
+}
+
+// Commit applies all the buffered writes and then fires events for
+// them.
+func (t *tx) Commit() error {
+	t.kv.Lock()
+	for _, op := range t.ops {
+		t.kv.l.Trace("TX", "key", op.key, "value", op.value, "delete", op.del)
+		if op.del {
+			delete(t.kv.m, op.key)
+			continue
+		}
+		t.kv.m[op.key] = op.value
+	}
+	t.kv.Unlock()
+
+	for _, op := range t.ops {
+		t.kv.fireEventForKey(op.key, op.del)
+	}
+	t.ops = nil
+	return nil
+}
+
+// Rollback discards the buffered writes.
+func (t *tx) Rollback() error {
+	t.ops = nil
+	return nil
 }
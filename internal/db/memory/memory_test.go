@@ -99,5 +99,40 @@ func TestCapabilities(t *testing.T) {
 	kv, _ := NewKV(hclog.NewNullLogger())
 	kv.SetEventFunc(func(db.Event) {})
 
-	assert.Equal(t, []db.KVCapability{db.KVMutable}, kv.Capabilities())
+	assert.Equal(t, []db.KVCapability{db.KVMutable, db.KVTransactional}, kv.Capabilities())
+}
+
+func TestTransaction(t *testing.T) {
+	ctx := context.Background()
+	kv, _ := NewKV(hclog.NewNullLogger())
+	events := []db.Event{}
+	kv.SetEventFunc(func(e db.Event) { events = append(events, e) })
+	kv.(*KV).m["/groups/group1"] = []byte("old data")
+
+	tx, err := kv.(db.TxKVStore).Begin(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Put(ctx, "/entities/entity1", []byte("new data")))
+	assert.Nil(t, tx.Del(ctx, "/groups/group1"))
+
+	// Nothing is visible until the commit.
+	_, err = kv.Get(ctx, "/entities/entity1")
+	assert.Equal(t, db.ErrNoValue, err)
+	assert.Empty(t, events)
+
+	assert.Nil(t, tx.Commit())
+	_, err = kv.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+	_, err = kv.Get(ctx, "/groups/group1")
+	assert.Equal(t, db.ErrNoValue, err)
+	assert.Equal(t, []db.Event{
+		{Type: db.EventEntityUpdate, PK: "entity1"},
+		{Type: db.EventGroupDestroy, PK: "group1"},
+	}, events)
+
+	// A rolled back transaction changes nothing.
+	tx, _ = kv.(db.TxKVStore).Begin(ctx)
+	tx.Del(ctx, "/entities/entity1")
+	assert.Nil(t, tx.Rollback())
+	_, err = kv.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
 }
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// The migrations below are applied in order by Migrate.  Migrations
+// must never be changed once released, since stores that have
+// already been migrated will not run them again.  New migrations are
+// added to the end with the next version number.
+func init() {
+	RegisterMigration(Migration{
+		Version:     1,
+		Description: "Add revision headers to objects written before revisions existed",
+		Run:         migrateRevisionHeaders,
+	})
+}
+
+// migrateRevisionHeaders rewrites every object that has no revision
+// header so that it has one.  These objects are read as being at
+// revision zero, and are written at revision one.
+func migrateRevisionHeaders(ctx context.Context, db *DB, dryRun bool) ([]string, error) {
+	changes := []string{}
+	for _, f := range []string{"/entities/*", "/groups/*"} {
+		keys, err := db.kv.Keys(ctx, f)
+		if err != nil {
+			return changes, err
+		}
+		for _, k := range keys {
+			v, err := db.kv.Get(ctx, k)
+			if err != nil {
+				return changes, err
+			}
+			if len(v) > 0 && v[0] == valueMagic {
+				continue
+			}
+			changes = append(changes, fmt.Sprintf("%s: add revision header", k))
+			if dryRun {
+				continue
+			}
+			if err := db.kv.Put(ctx, k, encodeValue(1, v)); err != nil {
+				return changes, err
+			}
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,436 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"google.golang.org/protobuf/proto"
+
+	types "github.com/netauth/protocol"
+)
+
+// DefaultNumberRange is the name of the range that numbers are
+// allocated from when no other range is requested.  Unless it is
+// configured it covers every positive number.
+const DefaultNumberRange = "default"
+
+// reservationTTL is how long a number handed out by
+// NextEntityNumber or NextGroupNumber is held back from other
+// allocations while waiting for the object that will use it to be
+// saved.
+const reservationTTL = time.Minute
+
+// A NumberRange is an inclusive span of numbers that can be
+// allocated from.
+type NumberRange struct {
+	Min int32
+	Max int32
+}
+
+type numberRangeKey struct{}
+
+// WithNumberRange returns a context which will cause numbers to be
+// allocated from the named range rather than the default one.
+func WithNumberRange(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, numberRangeKey{}, name)
+}
+
+func numberRangeFromContext(ctx context.Context) string {
+	if s, ok := ctx.Value(numberRangeKey{}).(string); ok && s != "" {
+		return s
+	}
+	return ""
+}
+
+// ParseNumberRange parses a range written as "min-max".
+func ParseNumberRange(s string) (NumberRange, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "-", 2)
+	if len(parts) != 2 {
+		return NumberRange{}, fmt.Errorf("range %q is not of the form min-max", s)
+	}
+	min, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 32)
+	if err != nil {
+		return NumberRange{}, fmt.Errorf("range %q: %w", s, err)
+	}
+	max, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 32)
+	if err != nil {
+		return NumberRange{}, fmt.Errorf("range %q: %w", s, err)
+	}
+	if min < 1 || max < min {
+		return NumberRange{}, fmt.Errorf("range %q is empty or not positive", s)
+	}
+	return NumberRange{Min: int32(min), Max: int32(max)}, nil
+}
+
+// numberRangesFromConfig reads the ranges for kind, which is either
+// "entity" or "group", from numbers.<kind>.ranges.  The default range
+// is the one named by numbers.<kind>.default, or DefaultNumberRange if
+// that isn't set.  If the default range isn't configured it covers
+// every positive number.
+func numberRangesFromConfig(kind string) (map[string]NumberRange, string, error) {
+	ranges := make(map[string]NumberRange)
+	for name, spec := range viper.GetStringMapString("numbers." + kind + ".ranges") {
+		r, err := ParseNumberRange(spec)
+		if err != nil {
+			return nil, "", err
+		}
+		ranges[name] = r
+	}
+
+	def := viper.GetString("numbers." + kind + ".default")
+	if def == "" {
+		def = DefaultNumberRange
+	}
+	if _, ok := ranges[def]; !ok {
+		ranges[def] = NumberRange{Min: 1, Max: math.MaxInt32}
+	}
+	return ranges, def, nil
+}
+
+// A numberIndex tracks which objects hold which numbers so that new
+// numbers can be allocated without loading every object, and so that
+// a number can't be given to more than one object.  It is loaded
+// lazily the first time it is needed, and then kept up to date as
+// batches are committed and as events arrive from the KV store.
+//
+// Stores that predate the index may already contain duplicate
+// numbers, so more than one owner can be recorded for a number.
+// These are left alone; only writes that give an object a number it
+// didn't already have are checked.
+type numberIndex struct {
+	sync.Mutex
+
+	load func(context.Context) (map[string]int32, error)
+
+	loaded   bool
+	owners   map[int32]map[string]struct{}
+	numbers  map[string]int32
+	reserved map[int32]time.Time
+
+	ranges       map[string]NumberRange
+	defaultRange string
+}
+
+func newNumberIndex(load func(context.Context) (map[string]int32, error), ranges map[string]NumberRange, def string) *numberIndex {
+	return &numberIndex{
+		load:         load,
+		ranges:       ranges,
+		defaultRange: def,
+		reserved:     make(map[int32]time.Time),
+	}
+}
+
+// ensure loads the index if it hasn't been loaded yet.  The lock
+// must be held.
+func (ix *numberIndex) ensure(ctx context.Context) error {
+	if ix.loaded {
+		return nil
+	}
+	nums, err := ix.load(withoutBatch(ctx))
+	if err != nil {
+		return err
+	}
+	ix.owners = make(map[int32]map[string]struct{}, len(nums))
+	ix.numbers = make(map[string]int32, len(nums))
+	for owner, n := range nums {
+		ix.set(owner, &n)
+	}
+	ix.loaded = true
+	return nil
+}
+
+// set records that owner holds n, or holds no number if n is nil.
+// The lock must be held.
+func (ix *numberIndex) set(owner string, n *int32) {
+	if old, ok := ix.numbers[owner]; ok {
+		delete(ix.owners[old], owner)
+		if len(ix.owners[old]) == 0 {
+			delete(ix.owners, old)
+		}
+		delete(ix.numbers, owner)
+	}
+	if n == nil {
+		return
+	}
+	if ix.owners[*n] == nil {
+		ix.owners[*n] = make(map[string]struct{})
+	}
+	ix.owners[*n][owner] = struct{}{}
+	ix.numbers[owner] = *n
+	delete(ix.reserved, *n)
+}
+
+// isLoaded returns true if the index has been loaded.
+func (ix *numberIndex) isLoaded() bool {
+	ix.Lock()
+	defer ix.Unlock()
+	return ix.loaded
+}
+
+// observe updates the index after an object has been changed outside
+// of this process.  Nothing is done if the index isn't loaded yet.
+func (ix *numberIndex) observe(owner string, n *int32) {
+	ix.Lock()
+	defer ix.Unlock()
+	if ix.loaded {
+		ix.set(owner, n)
+	}
+}
+
+// next allocates the next number in the range that is requested in
+// the context.  The number returned is one more than the highest
+// number in the range that is in use or reserved, so gaps are never
+// filled, but the highest number is reused once it has been freed.
+func (ix *numberIndex) next(ctx context.Context) (int32, error) {
+	name := numberRangeFromContext(ctx)
+	if name == "" {
+		name = ix.defaultRange
+	}
+	r, ok := ix.ranges[name]
+	if !ok {
+		return 0, ErrUnknownNumberRange
+	}
+
+	ix.Lock()
+	defer ix.Unlock()
+	if err := ix.ensure(ctx); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	highest := r.Min - 1
+	for n, until := range ix.reserved {
+		if now.After(until) {
+			delete(ix.reserved, n)
+			continue
+		}
+		if n >= r.Min && n <= r.Max && n > highest {
+			highest = n
+		}
+	}
+	for n := range ix.owners {
+		if n >= r.Min && n <= r.Max && n > highest {
+			highest = n
+		}
+	}
+	if highest >= r.Max {
+		return 0, ErrNumberRangeExhausted
+	}
+
+	n := highest + 1
+	ix.reserved[n] = now.Add(reservationTTL)
+	return n, nil
+}
+
+// owner returns an object that holds n.  If the number is shared then
+// the first owner by name is returned.
+func (ix *numberIndex) owner(ctx context.Context, n int32) (string, bool, error) {
+	ix.Lock()
+	defer ix.Unlock()
+	if err := ix.ensure(ctx); err != nil {
+		return "", false, err
+	}
+	owners := make([]string, 0, len(ix.owners[n]))
+	for o := range ix.owners[n] {
+		owners = append(owners, o)
+	}
+	if len(owners) == 0 {
+		return "", false, nil
+	}
+	sort.Strings(owners)
+	return owners[0], true, nil
+}
+
+// check returns ErrDuplicateNumber if committing changes, which maps
+// owners to the number they will hold, would give any owner a number
+// that is already held by another.
+func (ix *numberIndex) check(ctx context.Context, changes map[string]*int32) error {
+	if !anyNumbers(changes) {
+		return nil
+	}
+
+	ix.Lock()
+	defer ix.Unlock()
+	if err := ix.ensure(ctx); err != nil {
+		return err
+	}
+
+	for owner, n := range changes {
+		if n == nil {
+			continue
+		}
+		if old, ok := ix.numbers[owner]; ok && old == *n {
+			continue
+		}
+		for other := range ix.owners[*n] {
+			if other == owner {
+				continue
+			}
+			if moved, ok := changes[other]; ok && (moved == nil || *moved != *n) {
+				continue
+			}
+			return ErrDuplicateNumber
+		}
+		for other, m := range changes {
+			if other != owner && m != nil && *m == *n {
+				return ErrDuplicateNumber
+			}
+		}
+	}
+	return nil
+}
+
+// apply records changes that have been committed.
+func (ix *numberIndex) apply(changes map[string]*int32) {
+	ix.Lock()
+	defer ix.Unlock()
+	if !ix.loaded {
+		return
+	}
+	for owner, n := range changes {
+		ix.set(owner, n)
+	}
+}
+
+func anyNumbers(changes map[string]*int32) bool {
+	for _, n := range changes {
+		if n != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// numberChanges works out the number that each entity and group
+// written by ops will hold once the ops are applied.  Deleted objects
+// and objects without a number map to nil.
+func numberChanges(ops []batchOp) (map[string]*int32, map[string]*int32) {
+	entities := make(map[string]*int32)
+	groups := make(map[string]*int32)
+	for _, op := range ops {
+		dir, owner := path.Split(op.key)
+		switch dir {
+		case "/entities/":
+			e := &types.Entity{}
+			if !op.del && proto.Unmarshal(op.value, e) == nil {
+				entities[owner] = e.Number
+			} else {
+				entities[owner] = nil
+			}
+		case "/groups/":
+			g := &types.Group{}
+			if !op.del && proto.Unmarshal(op.value, g) == nil {
+				groups[owner] = g.Number
+			} else {
+				groups[owner] = nil
+			}
+		}
+	}
+	return entities, groups
+}
+
+// withoutBatch returns a context that does not carry the active
+// batch, so that the index is loaded from what has been committed.
+func withoutBatch(ctx context.Context) context.Context {
+	return context.WithValue(ctx, batchKey{}, nil)
+}
+
+// loadEntityNumbers reads the number of every entity.
+func (db *DB) loadEntityNumbers(ctx context.Context) (map[string]int32, error) {
+	ids, err := db.DiscoverEntityIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]int32, len(ids))
+	for _, id := range ids {
+		e, err := db.LoadEntity(ctx, path.Base(id))
+		if err != nil {
+			return nil, err
+		}
+		if e.Number != nil {
+			out[path.Base(id)] = e.GetNumber()
+		}
+	}
+	return out, nil
+}
+
+// loadGroupNumbers reads the number of every group.
+func (db *DB) loadGroupNumbers(ctx context.Context) (map[string]int32, error) {
+	names, err := db.DiscoverGroupNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]int32, len(names))
+	for _, name := range names {
+		g, err := db.LoadGroup(ctx, path.Base(name))
+		if err != nil {
+			return nil, err
+		}
+		if g.Number != nil {
+			out[path.Base(name)] = g.GetNumber()
+		}
+	}
+	return out, nil
+}
+
+// numberCallback keeps the number indexes up to date with changes
+// that arrive from the KV store, including those made by other
+// servers sharing the store.  Indexes that haven't been loaded yet
+// are skipped, since they will be read in full when they are.
+func (db *DB) numberCallback(e Event) {
+	ctx := context.Background()
+	switch e.Type {
+	case EventEntityCreate, EventEntityUpdate:
+		if !db.entityNumbers.isLoaded() {
+			return
+		}
+		if ent, err := db.LoadEntity(ctx, e.PK); err == nil {
+			db.entityNumbers.observe(e.PK, ent.Number)
+		}
+	case EventEntityDestroy:
+		db.entityNumbers.observe(e.PK, nil)
+	case EventGroupCreate, EventGroupUpdate:
+		if !db.groupNumbers.isLoaded() {
+			return
+		}
+		if g, err := db.LoadGroup(ctx, e.PK); err == nil {
+			db.groupNumbers.observe(e.PK, g.Number)
+		}
+	case EventGroupDestroy:
+		db.groupNumbers.observe(e.PK, nil)
+	}
+}
+
+// EntityIDForNumber returns the ID of the entity that holds the
+// number n, or ErrUnknownEntity if there isn't one.
+func (db *DB) EntityIDForNumber(ctx context.Context, n int32) (string, error) {
+	id, ok, err := db.entityNumbers.owner(ctx, n)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrUnknownEntity
+	}
+	return id, nil
+}
+
+// GroupNameForNumber returns the name of the group that holds the
+// number n, or ErrUnknownGroup if there isn't one.
+func (db *DB) GroupNameForNumber(ctx context.Context, n int32) (string, error) {
+	name, ok, err := db.groupNumbers.owner(ctx, n)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrUnknownGroup
+	}
+	return name, nil
+}
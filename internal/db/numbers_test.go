@@ -0,0 +1,112 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+
+	types "github.com/netauth/protocol"
+)
+
+func newMemoryDB(t *testing.T) *db.DB {
+	startup.DoCallbacks()
+	m, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func entity(id string, n int32) *types.Entity {
+	return &types.Entity{ID: proto.String(id), Number: proto.Int32(n)}
+}
+
+func TestNumbersUnique(t *testing.T) {
+	ctx := context.Background()
+	m := newMemoryDB(t)
+
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity1", 1)))
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity2", 2)))
+	assert.Equal(t, db.ErrDuplicateNumber, m.SaveEntity(ctx, entity("entity3", 1)))
+	assert.Equal(t, db.ErrDuplicateNumber, m.SaveEntity(ctx, entity("entity2", 1)))
+
+	// Saving an entity with the number it already has is fine.
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity1", 1)))
+
+	// Numbers can be swapped within a batch.
+	assert.Nil(t, m.Batch(ctx, func(ctx context.Context) error {
+		if err := m.SaveEntity(ctx, entity("entity1", 2)); err != nil {
+			return err
+		}
+		return m.SaveEntity(ctx, entity("entity2", 1))
+	}))
+	id, err := m.EntityIDForNumber(ctx, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "entity1", id)
+
+	// Numbers are freed when an entity is removed.
+	assert.Nil(t, m.DeleteEntity(ctx, "entity1"))
+	_, err = m.EntityIDForNumber(ctx, 2)
+	assert.Equal(t, db.ErrUnknownEntity, err)
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity3", 2)))
+
+	// Groups are numbered separately from entities.
+	assert.Nil(t, m.SaveGroup(ctx, &types.Group{Name: proto.String("group1"), Number: proto.Int32(1)}))
+	assert.Equal(t, db.ErrDuplicateNumber, m.SaveGroup(ctx, &types.Group{Name: proto.String("group2"), Number: proto.Int32(1)}))
+	name, err := m.GroupNameForNumber(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "group1", name)
+}
+
+func TestNumberRanges(t *testing.T) {
+	ctx := context.Background()
+	viper.Set("numbers.entity.ranges", map[string]string{
+		"human":   "1000-1999",
+		"service": "2000-2001",
+	})
+	viper.Set("numbers.entity.default", "human")
+	defer viper.Set("numbers.entity.ranges", nil)
+	defer viper.Set("numbers.entity.default", "")
+	m := newMemoryDB(t)
+
+	n, err := m.NextEntityNumber(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1000), n)
+	assert.Nil(t, m.SaveEntity(ctx, entity("human1", n)))
+
+	// Numbers outside the range don't affect it.
+	assert.Nil(t, m.SaveEntity(ctx, entity("legacy", 5000)))
+	n, err = m.NextEntityNumber(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1001), n)
+
+	sctx := db.WithNumberRange(ctx, "service")
+	for _, want := range []int32{2000, 2001} {
+		n, err = m.NextEntityNumber(sctx)
+		assert.Nil(t, err)
+		assert.Equal(t, want, n)
+	}
+	_, err = m.NextEntityNumber(sctx)
+	assert.Equal(t, db.ErrNumberRangeExhausted, err)
+
+	_, err = m.NextEntityNumber(db.WithNumberRange(ctx, "nope"))
+	assert.Equal(t, db.ErrUnknownNumberRange, err)
+}
+
+func TestParseNumberRange(t *testing.T) {
+	r, err := db.ParseNumberRange("100 - 200")
+	assert.Nil(t, err)
+	assert.Equal(t, db.NumberRange{Min: 100, Max: 200}, r)
+
+	for _, s := range []string{"100", "200-100", "0-10", "a-b", "1-99999999999"} {
+		_, err := db.ParseNumberRange(s)
+		assert.NotNil(t, err, s)
+	}
+}
@@ -0,0 +1,200 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+// opType is the kind of mutation that a command performs.
+type opType int
+
+const (
+	opPut opType = iota
+	opDel
+	opBatch
+)
+
+// command is the unit of data that is stored in the raft log.  A
+// batch command carries a list of put and delete commands in Ops
+// which are applied together.
+type command struct {
+	Op    opType
+	Key   string
+	Value []byte
+	Ops   []command
+}
+
+// ErrUnknownOp is returned if a log entry contains an operation this
+// version of the server does not understand.
+var ErrUnknownOp = errors.New("unknown operation in raft log")
+
+func encodeCommand(c command) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeCommand(b []byte) (command, error) {
+	var c command
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&c)
+	return c, err
+}
+
+// fsm applies committed log entries to the local store.
+type fsm struct {
+	local db.KVStore
+	l     hclog.Logger
+}
+
+// Apply is called once a log entry is committed.  The value returned
+// is either nil or an error, and is handed back to the caller of
+// Raft.Apply on the leader.
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	c, err := decodeCommand(l.Data)
+	if err != nil {
+		f.l.Error("Undecodable log entry", "index", l.Index, "error", err)
+		return err
+	}
+
+	ctx := context.Background()
+	switch c.Op {
+	case opPut:
+		return f.local.Put(ctx, c.Key, c.Value)
+	case opDel:
+		return f.local.Del(ctx, c.Key)
+	case opBatch:
+		return f.applyBatch(ctx, c.Ops)
+	default:
+		f.l.Error("Unknown operation in log", "index", l.Index, "op", c.Op)
+		return ErrUnknownOp
+	}
+}
+
+// applyBatch applies a group of commands.  If the local store is
+// transactional then the commands are applied in a single
+// transaction.  Otherwise they are applied one at a time, which is
+// still safe across a crash since the whole entry is replayed from
+// the log on restart.  Deletes of keys that don't exist are ignored
+// so that a batch is never applied partially.
+func (f *fsm) applyBatch(ctx context.Context, ops []command) error {
+	var kv interface {
+		Put(context.Context, string, []byte) error
+		Del(context.Context, string) error
+	} = f.local
+
+	var tx db.KVTx
+	if tkv, ok := f.local.(db.TxKVStore); ok && hasCapability(f.local, db.KVTransactional) {
+		var err error
+		tx, err = tkv.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		kv = tx
+	}
+
+	for _, op := range ops {
+		var err error
+		switch op.Op {
+		case opPut:
+			err = kv.Put(ctx, op.Key, op.Value)
+		case opDel:
+			err = kv.Del(ctx, op.Key)
+		default:
+			err = ErrUnknownOp
+		}
+		if err != nil && !(op.Op == opDel && err == db.ErrNoValue) {
+			if tx != nil {
+				tx.Rollback()
+			}
+			return err
+		}
+	}
+
+	if tx != nil {
+		return tx.Commit()
+	}
+	return nil
+}
+
+// Snapshot takes a point in time copy of the local store.  This is
+// not called concurrently with Apply, so copying all the values here
+// is what makes it safe for Persist to run in parallel with later
+// writes.
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	ctx := context.Background()
+	keys, err := f.local.Keys(ctx, "/*/*")
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		v, err := f.local.Get(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		data[k] = v
+	}
+	return &snapshot{data: data}, nil
+}
+
+// Restore replaces the contents of the local store with the contents
+// of a snapshot.  Keys are written through the local store so that
+// events fire for everything that changes.
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	data := make(map[string][]byte)
+	if err := gob.NewDecoder(rc).Decode(&data); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	keys, err := f.local.Keys(ctx, "/*/*")
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if _, ok := data[k]; ok {
+			continue
+		}
+		if err := f.local.Del(ctx, k); err != nil && err != db.ErrNoValue {
+			return err
+		}
+	}
+	for k, v := range data {
+		if err := f.local.Put(ctx, k, v); err != nil {
+			return err
+		}
+	}
+	f.l.Info("Restored from snapshot", "keys", len(data))
+	return nil
+}
+
+// snapshot is a frozen copy of the data held by the fsm.
+type snapshot struct {
+	data map[string][]byte
+}
+
+// Persist writes the snapshot out to the sink.
+func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+	if err := gob.NewEncoder(sink).Encode(s.data); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+// Release is required by the interface, but there is nothing to
+// release since the snapshot is a plain copy.
+func (s *snapshot) Release() {}
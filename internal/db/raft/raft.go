@@ -0,0 +1,536 @@
+// Package raft implements a replicated key/value store that allows
+// several netauthd instances to share a single consistent view of
+// the data.  Replication is performed using the Raft consensus
+// protocol, and each node keeps a full copy of the data in a
+// host-local store which is selected from the other registered
+// backends.
+//
+// All mutations are committed through the Raft leader.  A node that
+// is not the leader will transparently forward writes to the leader
+// over the same port that is used for Raft traffic, and will wait for
+// the write to be applied locally before returning so that a client
+// can read its own writes from any node.  Reads are always served
+// from the local copy, and so may briefly lag the leader on nodes
+// other than the one that performed the write.  Connections between
+// nodes are authenticated, and forwarded writes are only accepted
+// from members of the cluster.
+//
+// Events are fired when a committed entry is applied to the local
+// copy, which means that every node in the cluster fires events for
+// every change regardless of where it originated.  This keeps the
+// search index and membership resolver on followers in sync.
+package raft
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/health"
+	"github.com/netauth/netauth/internal/startup"
+)
+
+// Store is a KVStore that replicates all changes to a cluster of
+// peers before applying them to a local store.
+type Store struct {
+	raft   *raft.Raft
+	trans  *raft.NetworkTransport
+	stream *streamLayer
+	local  db.KVStore
+	closer func() error
+
+	timeout time.Duration
+	l       hclog.Logger
+
+	efMutex sync.RWMutex
+	eF      func(db.Event)
+}
+
+// options contain the parameters that are needed to bring up a node.
+// The stores are passed in directly so that tests can run a node
+// entirely in memory.
+type options struct {
+	id        string
+	ln        net.Listener
+	advertise net.Addr
+	peers     []raft.Server
+	bootstrap bool
+	timeout   time.Duration
+	tlsConf   *tls.Config
+	secret    []byte
+
+	local  db.KVStore
+	logs   raft.LogStore
+	stable raft.StableStore
+	snaps  raft.SnapshotStore
+	closer func() error
+}
+
+var (
+	// ErrNoLeader is returned if a write is attempted while the
+	// cluster has no leader, such as during an election.
+	ErrNoLeader = errors.New("no raft leader is available")
+
+	// ErrBadPeer is returned if a peer in the configuration is not
+	// of the form id=host:port.
+	ErrBadPeer = errors.New("peers must be specified as id=host:port")
+
+	// ErrRecursiveStorage is returned if the raft store is
+	// configured to use itself as its local store.
+	ErrRecursiveStorage = errors.New("raft cannot use itself as local storage")
+
+	// ErrNoClusterAuth is returned if neither TLS nor a shared
+	// secret is configured to authenticate peers.
+	ErrNoClusterAuth = errors.New("raft.secret or raft.tls must be configured")
+
+	// ErrIncompleteTLS is returned if only some of the TLS
+	// settings are configured, or the CA contains no
+	// certificates.
+	ErrIncompleteTLS = errors.New("raft.tls requires a certificate, key, and CA")
+)
+
+func init() {
+	startup.RegisterCallback(cb)
+}
+
+func cb() {
+	db.RegisterKV("raft", New)
+}
+
+// New creates a new replicated store based on the server
+// configuration.  The following keys are consulted:
+//
+//   raft.id:        Unique name of this node, defaults to the hostname
+//   raft.bind:      Address to listen for raft traffic on
+//   raft.advertise: Address peers should use to reach this node
+//   raft.peers:     List of all cluster members as id=host:port
+//   raft.bootstrap: Form a new cluster from raft.peers if no state exists
+//   raft.storage:   Name of the backend used to hold the local copy
+//   raft.timeout:   How long to wait for a write to be committed
+//   raft.secret:    Shared secret that every node must know
+//   raft.tls.certificate, raft.tls.key, raft.tls.ca:
+//                   Certificate and key of this node, and the CA
+//                   that signs the certificates of every node
+//
+// Peers are authenticated with TLS, the shared secret, or both, and
+// at least one of them must be configured.  Relative TLS paths are
+// relative to core.conf.  Raft's own log and snapshots are stored
+// below core.home/raft.
+func New(l hclog.Logger) (db.KVStore, error) {
+	l = l.Named("raft")
+
+	if viper.GetString("raft.storage") == "raft" {
+		return nil, ErrRecursiveStorage
+	}
+
+	id := viper.GetString("raft.id")
+	if id == "" {
+		hn, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		id = hn
+	}
+
+	peers, err := parsePeers(viper.GetStringSlice("raft.peers"))
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConf, err := tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	var secret []byte
+	if sec := viper.GetString("raft.secret"); sec != "" {
+		secret = []byte(sec)
+	}
+	if tlsConf == nil && secret == nil {
+		return nil, ErrNoClusterAuth
+	}
+
+	base := filepath.Join(viper.GetString("core.home"), "raft")
+	if err := os.MkdirAll(base, 0750); err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", viper.GetString("raft.bind"))
+	if err != nil {
+		return nil, err
+	}
+	advertise := ln.Addr()
+	if a := viper.GetString("raft.advertise"); a != "" {
+		advertise, err = net.ResolveTCPAddr("tcp", a)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+
+	bolt, err := raftboltdb.NewBoltStore(filepath.Join(base, "raft.db"))
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	snaps, err := raft.NewFileSnapshotStore(base, 3, l.StandardWriter(&hclog.StandardLoggerOptions{InferLevels: true}))
+	if err != nil {
+		ln.Close()
+		bolt.Close()
+		return nil, err
+	}
+
+	local, err := db.NewKV(viper.GetString("raft.storage"), l)
+	if err != nil {
+		ln.Close()
+		bolt.Close()
+		return nil, err
+	}
+
+	s, err := newStore(l, options{
+		id:        id,
+		ln:        ln,
+		advertise: advertise,
+		peers:     peers,
+		bootstrap: viper.GetBool("raft.bootstrap"),
+		timeout:   viper.GetDuration("raft.timeout"),
+		tlsConf:   tlsConf,
+		secret:    secret,
+		local:     local,
+		logs:      bolt,
+		stable:    bolt,
+		snaps:     snaps,
+		closer:    bolt.Close,
+	})
+	if err != nil {
+		local.Close()
+		bolt.Close()
+		return nil, err
+	}
+	health.RegisterCheck("raft", s.healthCheck)
+	return s, nil
+}
+
+// newStore does the work of actually bringing up a node once all its
+// storage has been allocated.
+func newStore(l hclog.Logger, o options) (*Store, error) {
+	s := &Store{
+		local:   o.local,
+		closer:  o.closer,
+		timeout: o.timeout,
+		l:       l,
+	}
+	if s.timeout == 0 {
+		s.timeout = 10 * time.Second
+	}
+	s.local.SetEventFunc(s.fireEvent)
+
+	s.stream = newStreamLayer(o.ln, o.advertise, s.handleForward, o.tlsConf, o.secret)
+	s.trans = raft.NewNetworkTransportWithLogger(s.stream, 3, s.timeout,
+		l.StandardLogger(&hclog.StandardLoggerOptions{InferLevels: true}))
+
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(o.id)
+	conf.Logger = l
+
+	r, err := raft.NewRaft(conf, &fsm{local: s.local, l: l}, o.logs, o.stable, o.snaps, s.trans)
+	if err != nil {
+		s.trans.Close()
+		return nil, err
+	}
+	s.raft = r
+
+	if o.bootstrap {
+		if err := s.bootstrap(o); err != nil {
+			s.raft.Shutdown()
+			s.trans.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// bootstrap forms a new cluster from the configured peers.  It is
+// safe for every node in the cluster to bootstrap with the same peer
+// list, and nodes that already have state are left alone.
+func (s *Store) bootstrap(o options) error {
+	exists, err := raft.HasExistingState(o.logs, o.stable, o.snaps)
+	if err != nil || exists {
+		return err
+	}
+
+	servers := o.peers
+	self := false
+	for _, p := range servers {
+		if p.ID == raft.ServerID(o.id) {
+			self = true
+		}
+	}
+	if !self {
+		servers = append(servers, raft.Server{
+			ID:      raft.ServerID(o.id),
+			Address: raft.ServerAddress(o.advertise.String()),
+		})
+	}
+
+	s.l.Info("Bootstrapping new cluster", "servers", servers)
+	err = s.raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+	if err == raft.ErrCantBootstrap {
+		return nil
+	}
+	return err
+}
+
+// SetEventFunc sets up a function to call to fire events to
+// subscribers.
+func (s *Store) SetEventFunc(f func(db.Event)) {
+	s.efMutex.Lock()
+	defer s.efMutex.Unlock()
+	s.eF = f
+}
+
+// fireEvent is handed to the local store so that events are fired as
+// committed entries are applied.
+func (s *Store) fireEvent(e db.Event) {
+	s.efMutex.RLock()
+	defer s.efMutex.RUnlock()
+	if s.eF != nil {
+		s.eF(e)
+	}
+}
+
+// Put commits a write of v to the key k across the cluster.
+func (s *Store) Put(ctx context.Context, k string, v []byte) error {
+	return s.mutate(ctx, command{Op: opPut, Key: k, Value: v})
+}
+
+// Get returns the value at k from the local copy of the data.
+func (s *Store) Get(ctx context.Context, k string) ([]byte, error) {
+	return s.local.Get(ctx, k)
+}
+
+// Del commits the removal of k across the cluster.
+func (s *Store) Del(ctx context.Context, k string) error {
+	return s.mutate(ctx, command{Op: opDel, Key: k})
+}
+
+// Keys returns the keys that match the glob f from the local copy of
+// the data.
+func (s *Store) Keys(ctx context.Context, f string) ([]string, error) {
+	return s.local.Keys(ctx, f)
+}
+
+// Close leaves the cluster and shuts down the local store.  The store
+// must not be used after Close() is called.
+func (s *Store) Close() error {
+	if err := s.raft.Shutdown().Error(); err != nil {
+		s.l.Warn("Error shutting down raft", "error", err)
+	}
+	s.trans.Close()
+	if s.closer != nil {
+		if err := s.closer(); err != nil {
+			s.l.Warn("Error closing raft log", "error", err)
+		}
+	}
+	return s.local.Close()
+}
+
+// Capabilities returns that this key/value store supports the mutable
+// property.  Any node may accept writes since they are forwarded to
+// the leader.  Transactions are always supported, since a transaction
+// is committed as a single entry in the log.
+func (s *Store) Capabilities() []db.KVCapability {
+	return []db.KVCapability{db.KVMutable, db.KVTransactional}
+}
+
+// Begin starts a new transaction.  Writes are buffered until Commit,
+// and are then submitted to the cluster as a single log entry.
+func (s *Store) Begin(ctx context.Context) (db.KVTx, error) {
+	return &tx{s: s, ctx: ctx}, nil
+}
+
+// tx buffers writes for a transaction.
+type tx struct {
+	s   *Store
+	ctx context.Context
+	ops []command
+}
+
+// Put buffers a write of v to k.
+func (t *tx) Put(_ context.Context, k string, v []byte) error {
+	t.ops = append(t.ops, command{Op: opPut, Key: k, Value: v})
+	return nil
+}
+
+// Del buffers the removal of k.  Unlike Store.Del, removing a key that
+// does not exist is not an error.
+func (t *tx) Del(_ context.Context, k string) error {
+	t.ops = append(t.ops, command{Op: opDel, Key: k})
+	return nil
+}
+
+// Commit submits the buffered writes to the cluster.
+func (t *tx) Commit() error {
+	if len(t.ops) == 0 {
+		return nil
+	}
+	return t.s.mutate(t.ctx, command{Op: opBatch, Ops: t.ops})
+}
+
+// Rollback discards the buffered writes.
+func (t *tx) Rollback() error {
+	t.ops = nil
+	return nil
+}
+
+// mutate either applies the command directly if this node is the
+// leader, or forwards it to the leader and waits for the local copy
+// to catch up.
+func (s *Store) mutate(ctx context.Context, c command) error {
+	if s.raft.State() == raft.Leader {
+		_, err := s.apply(c)
+		return err
+	}
+
+	leader := s.raft.Leader()
+	if leader == "" {
+		return ErrNoLeader
+	}
+	idx, err := s.forward(ctx, leader, c)
+	if err != nil {
+		return err
+	}
+	return s.waitForIndex(ctx, idx)
+}
+
+// apply submits a command to the log and waits for it to be committed
+// and applied on this node.  This is only valid on the leader.
+func (s *Store) apply(c command) (uint64, error) {
+	b, err := encodeCommand(c)
+	if err != nil {
+		return 0, err
+	}
+
+	f := s.raft.Apply(b, s.timeout)
+	if err := f.Error(); err != nil {
+		return 0, err
+	}
+	if err, ok := f.Response().(error); ok {
+		return f.Index(), err
+	}
+	return f.Index(), nil
+}
+
+// waitForIndex blocks until the local state machine has caught up to
+// at least the given index, which guarantees that a write which was
+// forwarded to the leader is visible to reads on this node.
+func (s *Store) waitForIndex(ctx context.Context, idx uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	for s.raft.AppliedIndex() < idx {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// healthCheck reports on whether this node is part of a functioning
+// cluster.
+func (s *Store) healthCheck() health.SubsystemStatus {
+	status := health.SubsystemStatus{
+		OK:   true,
+		Name: "raft",
+	}
+
+	leader := s.raft.Leader()
+	if leader == "" {
+		status.OK = false
+		status.Status = "No leader is available"
+		return status
+	}
+	status.Status = strings.ToLower(s.raft.State().String()) + ", leader is " + string(leader)
+	return status
+}
+
+// hasCapability checks if kv advertises the capability c.
+func hasCapability(kv db.KVStore, c db.KVCapability) bool {
+	for _, have := range kv.Capabilities() {
+		if have == c {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePeers converts a list of id=host:port strings to a list of
+// raft servers.
+func parsePeers(in []string) ([]raft.Server, error) {
+	out := []raft.Server{}
+	for _, p := range in {
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, ErrBadPeer
+		}
+		out = append(out, raft.Server{
+			ID:      raft.ServerID(parts[0]),
+			Address: raft.ServerAddress(parts[1]),
+		})
+	}
+	return out, nil
+}
+
+// tlsConfig loads the TLS configuration for the cluster.  If no
+// certificate is configured then TLS is not used and nil is returned.
+func tlsConfig() (*tls.Config, error) {
+	cert := viper.GetString("raft.tls.certificate")
+	key := viper.GetString("raft.tls.key")
+	ca := viper.GetString("raft.tls.ca")
+	if cert == "" && key == "" && ca == "" {
+		return nil, nil
+	}
+	if cert == "" || key == "" || ca == "" {
+		return nil, ErrIncompleteTLS
+	}
+	for _, p := range []*string{&cert, &key, &ca} {
+		if !filepath.IsAbs(*p) {
+			*p = filepath.Join(viper.GetString("core.conf"), *p)
+		}
+	}
+
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := ioutil.ReadFile(ca)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, ErrIncompleteTLS
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
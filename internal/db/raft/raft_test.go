@@ -0,0 +1,288 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/db/memory"
+)
+
+// eventRecorder collects the events fired by a node.
+type eventRecorder struct {
+	sync.Mutex
+	events []db.Event
+}
+
+func (r *eventRecorder) FireEvent(e db.Event) {
+	r.Lock()
+	defer r.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) Has(e db.Event) bool {
+	r.Lock()
+	defer r.Unlock()
+	for _, have := range r.events {
+		if have == e {
+			return true
+		}
+	}
+	return false
+}
+
+// newCluster brings up n nodes on the loopback interface, each with
+// an in-memory local store and log.
+func newCluster(t *testing.T, n int) ([]*Store, []*eventRecorder) {
+	listeners := make([]net.Listener, n)
+	peers := make([]raft.Server, n)
+	for i := range listeners {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i] = ln
+		peers[i] = raft.Server{
+			ID:      raft.ServerID(string(rune('a' + i))),
+			Address: raft.ServerAddress(ln.Addr().String()),
+		}
+	}
+
+	nodes := make([]*Store, n)
+	recorders := make([]*eventRecorder, n)
+	for i := range nodes {
+		local, _ := memory.NewKV(hclog.NewNullLogger())
+		logs := raft.NewInmemStore()
+		s, err := newStore(hclog.NewNullLogger(), options{
+			id:        string(peers[i].ID),
+			ln:        listeners[i],
+			advertise: listeners[i].Addr(),
+			peers:     peers,
+			bootstrap: true,
+			secret:    []byte("cluster-secret"),
+			local:     local,
+			logs:      logs,
+			stable:    logs,
+			snaps:     raft.NewInmemSnapshotStore(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorders[i] = &eventRecorder{}
+		s.SetEventFunc(recorders[i].FireEvent)
+		nodes[i] = s
+	}
+	t.Cleanup(func() {
+		for _, s := range nodes {
+			s.Close()
+		}
+	})
+	return nodes, recorders
+}
+
+func waitForLeader(t *testing.T, nodes []*Store) (*Store, []*Store) {
+	deadline := time.Now().Add(15 * time.Second)
+	for time.Now().Before(deadline) {
+		for i, s := range nodes {
+			if s.raft.State() == raft.Leader {
+				followers := []*Store{}
+				followers = append(followers, nodes[:i]...)
+				followers = append(followers, nodes[i+1:]...)
+				return s, followers
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("No leader was elected")
+	return nil, nil
+}
+
+func eventually(t *testing.T, f func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if f() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Condition was not met in time")
+}
+
+func TestCB(t *testing.T) {
+	cb()
+}
+
+func TestCluster(t *testing.T) {
+	ctx := context.Background()
+	nodes, recorders := newCluster(t, 3)
+	leader, followers := waitForLeader(t, nodes)
+
+	// A write on the leader is visible everywhere.
+	assert.Nil(t, leader.Put(ctx, "/entities/entity1", []byte("from leader")))
+	for i, s := range nodes {
+		eventually(t, func() bool {
+			v, err := s.Get(ctx, "/entities/entity1")
+			return err == nil && bytes.Equal(v, []byte("from leader"))
+		})
+		eventually(t, func() bool {
+			return recorders[i].Has(db.Event{Type: db.EventEntityUpdate, PK: "entity1"})
+		})
+	}
+
+	// A write on a follower is forwarded, and is immediately
+	// readable on that follower.
+	assert.Nil(t, followers[0].Put(ctx, "/groups/group1", []byte("from follower")))
+	v, err := followers[0].Get(ctx, "/groups/group1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("from follower"), v)
+	eventually(t, func() bool {
+		_, err := leader.Get(ctx, "/groups/group1")
+		return err == nil
+	})
+
+	// Other followers catch up eventually.
+	eventually(t, func() bool {
+		keys, err := followers[1].Keys(ctx, "/*/*")
+		return err == nil && len(keys) == 2
+	})
+
+	// Deletes are forwarded as well.
+	assert.Nil(t, followers[1].Del(ctx, "/entities/entity1"))
+	_, err = followers[1].Get(ctx, "/entities/entity1")
+	assert.Equal(t, db.ErrNoValue, err)
+	for i := range nodes {
+		eventually(t, func() bool {
+			return recorders[i].Has(db.Event{Type: db.EventEntityDestroy, PK: "entity1"})
+		})
+	}
+
+	// Transactions are committed as a single entry.
+	tx, err := followers[0].Begin(ctx)
+	assert.Nil(t, err)
+	tx.Put(ctx, "/entities/entity2", []byte("in a tx"))
+	tx.Del(ctx, "/groups/group1")
+	tx.Del(ctx, "/groups/missing")
+	assert.Nil(t, tx.Commit())
+	keys, err := followers[0].Keys(ctx, "/*/*")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/entities/entity2"}, keys)
+
+	assert.Equal(t, []db.KVCapability{db.KVMutable, db.KVTransactional}, followers[0].Capabilities())
+	assert.True(t, leader.healthCheck().OK)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	src, _ := memory.NewKV(hclog.NewNullLogger())
+	src.SetEventFunc(func(db.Event) {})
+	src.Put(ctx, "/entities/entity1", []byte("one"))
+	src.Put(ctx, "/groups/group1", []byte("two"))
+
+	snaps := raft.NewInmemSnapshotStore()
+	sink, err := snaps.Create(raft.SnapshotVersionMax, 1, 1, raft.Configuration{}, 0, nil)
+	assert.Nil(t, err)
+
+	f := &fsm{local: src, l: hclog.NewNullLogger()}
+	snap, err := f.Snapshot()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Persist(sink))
+
+	dst, _ := memory.NewKV(hclog.NewNullLogger())
+	rec := &eventRecorder{}
+	dst.SetEventFunc(rec.FireEvent)
+	dst.Put(ctx, "/entities/stale", []byte("old"))
+
+	_, rc, err := snaps.Open(sink.ID())
+	assert.Nil(t, err)
+	f = &fsm{local: dst, l: hclog.NewNullLogger()}
+	assert.Nil(t, f.Restore(rc))
+
+	keys, _ := dst.Keys(ctx, "/*/*")
+	assert.ElementsMatch(t, []string{"/entities/entity1", "/groups/group1"}, keys)
+	assert.True(t, rec.Has(db.Event{Type: db.EventEntityDestroy, PK: "stale"}))
+	assert.True(t, rec.Has(db.Event{Type: db.EventGroupUpdate, PK: "group1"}))
+}
+
+func TestFSMApplyBad(t *testing.T) {
+	local, _ := memory.NewKV(hclog.NewNullLogger())
+	f := &fsm{local: local, l: hclog.NewNullLogger()}
+
+	assert.NotNil(t, f.Apply(&raft.Log{Data: []byte("garbage")}))
+
+	b, _ := encodeCommand(command{Op: opType(42)})
+	assert.Equal(t, ErrUnknownOp, f.Apply(&raft.Log{Data: b}))
+}
+
+func TestParsePeers(t *testing.T) {
+	p, err := parsePeers([]string{"a=127.0.0.1:1730", "b=host:1730"})
+	assert.Nil(t, err)
+	assert.Equal(t, []raft.Server{
+		{ID: "a", Address: "127.0.0.1:1730"},
+		{ID: "b", Address: "host:1730"},
+	}, p)
+
+	_, err = parsePeers([]string{"127.0.0.1:1730"})
+	assert.Equal(t, ErrBadPeer, err)
+}
+
+func TestErrorFromString(t *testing.T) {
+	assert.Nil(t, errorFromString(""))
+	assert.Equal(t, db.ErrNoValue, errorFromString(db.ErrNoValue.Error()))
+	assert.Equal(t, raft.ErrNotLeader, errorFromString(raft.ErrNotLeader.Error()))
+	assert.EqualError(t, errorFromString("something"), "something")
+}
+
+func TestNewRequiresAuth(t *testing.T) {
+	viper.Set("raft.id", "a")
+	defer viper.Set("raft.id", nil)
+
+	_, err := New(hclog.NewNullLogger())
+	assert.Equal(t, ErrNoClusterAuth, err)
+
+	viper.Set("raft.tls.certificate", "node.pem")
+	defer viper.Set("raft.tls.certificate", nil)
+	_, err = New(hclog.NewNullLogger())
+	assert.Equal(t, ErrIncompleteTLS, err)
+}
+
+func TestUnauthenticatedForward(t *testing.T) {
+	ctx := context.Background()
+	nodes, _ := newCluster(t, 1)
+	leader, _ := waitForLeader(t, nodes)
+
+	// A forwarded write without the handshake is dropped.
+	conn, err := net.Dial("tcp", leader.stream.Addr().String())
+	assert.Nil(t, err)
+	conn.Write([]byte{rpcForward})
+	gob.NewEncoder(conn).Encode(command{Op: opPut, Key: "/entities/evil", Value: []byte("evil")})
+	var res forwardResponse
+	assert.NotNil(t, gob.NewDecoder(conn).Decode(&res))
+	conn.Close()
+
+	// As is one from a node with the wrong secret.
+	other := &streamLayer{secret: []byte("wrong-secret")}
+	_, err = other.dial(leader.stream.Addr().String(), time.Second)
+	assert.NotNil(t, err)
+
+	_, err = leader.Get(ctx, "/entities/evil")
+	assert.Equal(t, db.ErrNoValue, err)
+}
+
+func TestIsPeer(t *testing.T) {
+	nodes, _ := newCluster(t, 1)
+	leader, _ := waitForLeader(t, nodes)
+
+	assert.True(t, leader.isPeer(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}))
+	assert.False(t, leader.isPeer(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}))
+	assert.False(t, leader.isPeer(&net.UnixAddr{Name: "/tmp/sock"}))
+}
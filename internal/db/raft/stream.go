@@ -0,0 +1,370 @@
+package raft
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+// rpcForward is the first byte sent on a connection that carries a
+// forwarded write rather than raft traffic.  The raft transport uses
+// small integers to identify its own RPCs, so a high value is used
+// here to keep well clear of them.
+const rpcForward byte = 0xf0
+
+// nonceSize is the length of the nonces exchanged while proving
+// knowledge of the shared secret.
+const nonceSize = 32
+
+var (
+	// errStreamClosed is returned from Accept once the stream
+	// layer has been shut down.
+	errStreamClosed = errors.New("stream layer is closed")
+
+	// errBadSecret is returned if the other end of a connection
+	// could not prove that it knows the shared secret.
+	errBadSecret = errors.New("peer does not know the shared secret")
+)
+
+// streamLayer multiplexes raft traffic and forwarded writes onto a
+// single listener.  Each connection is routed based on its first
+// byte; forwarded writes are handled directly, and everything else is
+// handed to the raft transport.
+//
+// Connections are authenticated in both directions before anything
+// else is sent.  If tlsConf is set then every connection uses TLS and
+// both ends must present a certificate signed by the cluster CA.  If
+// secret is set then both ends must also prove that they know the
+// shared secret, see acceptHandshake.
+type streamLayer struct {
+	ln        net.Listener
+	advertise net.Addr
+	forward   func(net.Conn)
+
+	tlsConf *tls.Config
+	secret  []byte
+
+	conns    chan net.Conn
+	shutdown chan struct{}
+	once     sync.Once
+}
+
+func newStreamLayer(ln net.Listener, advertise net.Addr, forward func(net.Conn), tlsConf *tls.Config, secret []byte) *streamLayer {
+	if tlsConf != nil {
+		ln = tls.NewListener(ln, tlsConf)
+	}
+	s := &streamLayer{
+		ln:        ln,
+		advertise: advertise,
+		forward:   forward,
+		tlsConf:   tlsConf,
+		secret:    secret,
+		conns:     make(chan net.Conn),
+		shutdown:  make(chan struct{}),
+	}
+	go s.serve()
+	return s
+}
+
+// serve accepts connections until the listener is closed.
+func (s *streamLayer) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			select {
+			case <-s.shutdown:
+				return
+			default:
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		go s.route(c)
+	}
+}
+
+// route authenticates a connection, then reads its first byte and
+// dispatches it.
+func (s *streamLayer) route(c net.Conn) {
+	b := make([]byte, 1)
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	if s.secret != nil {
+		if err := s.acceptHandshake(c); err != nil {
+			c.Close()
+			return
+		}
+	}
+	if _, err := io.ReadFull(c, b); err != nil {
+		c.Close()
+		return
+	}
+	c.SetDeadline(time.Time{})
+
+	if b[0] == rpcForward {
+		s.forward(c)
+		return
+	}
+
+	select {
+	case s.conns <- &peekedConn{Conn: c, first: b[0]}:
+	case <-s.shutdown:
+		c.Close()
+	}
+}
+
+// Accept returns the next connection carrying raft traffic.
+func (s *streamLayer) Accept() (net.Conn, error) {
+	select {
+	case c := <-s.conns:
+		return c, nil
+	case <-s.shutdown:
+		return nil, errStreamClosed
+	}
+}
+
+// Close stops accepting new connections.
+func (s *streamLayer) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.shutdown)
+		err = s.ln.Close()
+	})
+	return err
+}
+
+// Addr returns the address that peers should use to reach this node.
+func (s *streamLayer) Addr() net.Addr {
+	return s.advertise
+}
+
+// Dial opens a connection to a peer for raft traffic.
+func (s *streamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return s.dial(string(address), timeout)
+}
+
+// dial opens an authenticated connection to a peer.
+func (s *streamLayer) dial(address string, timeout time.Duration) (net.Conn, error) {
+	d := &net.Dialer{Timeout: timeout}
+	var c net.Conn
+	var err error
+	if s.tlsConf != nil {
+		c, err = tls.DialWithDialer(d, "tcp", address, s.tlsConf)
+	} else {
+		c, err = d.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.secret != nil {
+		c.SetDeadline(time.Now().Add(timeout))
+		if err := s.dialHandshake(c); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.SetDeadline(time.Time{})
+	}
+	return c, nil
+}
+
+// acceptHandshake checks that the dialing end of a connection knows
+// the shared secret, and proves to it that this end does too.  The
+// accepting end sends a nonce, the dialing end replies with its own
+// nonce and a MAC over both, and the accepting end replies with a MAC
+// over both in the other order.  The secret itself is never sent.
+func (s *streamLayer) acceptHandshake(c net.Conn) error {
+	ns := make([]byte, nonceSize)
+	if _, err := rand.Read(ns); err != nil {
+		return err
+	}
+	if _, err := c.Write(ns); err != nil {
+		return err
+	}
+	buf := make([]byte, nonceSize+sha256.Size)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return err
+	}
+	nc, mac := buf[:nonceSize], buf[nonceSize:]
+	if !hmac.Equal(mac, handshakeMAC(s.secret, "dial", ns, nc)) {
+		return errBadSecret
+	}
+	_, err := c.Write(handshakeMAC(s.secret, "accept", nc, ns))
+	return err
+}
+
+// dialHandshake is the dialing end of acceptHandshake.
+func (s *streamLayer) dialHandshake(c net.Conn) error {
+	ns := make([]byte, nonceSize)
+	if _, err := io.ReadFull(c, ns); err != nil {
+		return err
+	}
+	nc := make([]byte, nonceSize)
+	if _, err := rand.Read(nc); err != nil {
+		return err
+	}
+	if _, err := c.Write(append(nc, handshakeMAC(s.secret, "dial", ns, nc)...)); err != nil {
+		return err
+	}
+	mac := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(c, mac); err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, handshakeMAC(s.secret, "accept", nc, ns)) {
+		return errBadSecret
+	}
+	return nil
+}
+
+func handshakeMAC(secret []byte, label string, a, b []byte) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(label))
+	m.Write(a)
+	m.Write(b)
+	return m.Sum(nil)
+}
+
+// peekedConn puts back the byte that was read during routing.
+type peekedConn struct {
+	net.Conn
+
+	first    byte
+	consumed bool
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	if c.consumed || len(p) == 0 {
+		return c.Conn.Read(p)
+	}
+	p[0] = c.first
+	c.consumed = true
+	return 1, nil
+}
+
+// forwardResponse is returned by the leader for a forwarded write.
+// The error is sent as a string since arbitrary error values can't be
+// encoded, and is mapped back to a sentinel where one is known.
+type forwardResponse struct {
+	Index uint64
+	Err   string
+}
+
+// forward sends a command to the leader and returns the index it was
+// committed at.
+func (s *Store) forward(ctx context.Context, leader raft.ServerAddress, c command) (uint64, error) {
+	conn, err := s.stream.dial(string(leader), s.timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte{rpcForward}); err != nil {
+		return 0, err
+	}
+	if err := gob.NewEncoder(conn).Encode(c); err != nil {
+		return 0, err
+	}
+
+	var res forwardResponse
+	if err := gob.NewDecoder(conn).Decode(&res); err != nil {
+		return 0, err
+	}
+	return res.Index, errorFromString(res.Err)
+}
+
+// handleForward applies a command that was forwarded from a follower.
+// Commands are only accepted from the addresses of servers in the
+// cluster configuration.  Commands are never forwarded a second time;
+// if this node is no longer the leader the follower receives an error
+// and the client can retry.
+func (s *Store) handleForward(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	if !s.isPeer(conn.RemoteAddr()) {
+		s.l.Warn("Rejected forwarded request from unknown peer", "remote", conn.RemoteAddr())
+		return
+	}
+
+	var c command
+	if err := gob.NewDecoder(conn).Decode(&c); err != nil {
+		s.l.Warn("Bad forwarded request", "remote", conn.RemoteAddr(), "error", err)
+		return
+	}
+
+	res := forwardResponse{}
+	idx, err := s.apply(c)
+	res.Index = idx
+	if err != nil {
+		res.Err = err.Error()
+	}
+	if err := gob.NewEncoder(conn).Encode(res); err != nil {
+		s.l.Warn("Error replying to forwarded request", "remote", conn.RemoteAddr(), "error", err)
+	}
+}
+
+// isPeer checks if addr belongs to one of the servers in the current
+// cluster configuration.  Server addresses are resolved each time
+// since forwarded writes are infrequent, and this allows peers to be
+// named by hosts whose addresses change.
+func (s *Store) isPeer(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	f := s.raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		s.l.Warn("Unable to read cluster configuration", "error", err)
+		return false
+	}
+	for _, srv := range f.Configuration().Servers {
+		host, _, err := net.SplitHostPort(string(srv.Address))
+		if err != nil {
+			continue
+		}
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if ip.Equal(tcp.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// errorFromString maps an error that has crossed the wire back to a
+// known sentinel value if possible.
+func errorFromString(s string) error {
+	switch s {
+	case "":
+		return nil
+	case db.ErrNoValue.Error():
+		return db.ErrNoValue
+	case raft.ErrNotLeader.Error():
+		return raft.ErrNotLeader
+	default:
+		return errors.New(s)
+	}
+}
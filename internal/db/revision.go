@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"encoding/binary"
+)
+
+// Every object is stored with a short header that carries its
+// revision.  The revision starts at 1 when an object is first written
+// and is incremented on every subsequent write, which allows writes
+// to be made conditional on the object not having changed since it
+// was loaded.
+//
+// The header begins with a zero byte.  This can never begin a valid
+// protobuf message since field number zero is reserved, so values
+// written before revisions existed are still read correctly and are
+// treated as being at revision zero.
+const (
+	valueMagic   byte = 0x00
+	valueVersion byte = 1
+)
+
+// revision is the state of a key at the time it was read.
+type revision struct {
+	rev    uint64
+	exists bool
+}
+
+// encodeValue prepends the revision header to the object in b.
+func encodeValue(rev uint64, b []byte) []byte {
+	out := make([]byte, 2+binary.MaxVarintLen64+len(b))
+	out[0] = valueMagic
+	out[1] = valueVersion
+	n := binary.PutUvarint(out[2:], rev)
+	copy(out[2+n:], b)
+	return out[:2+n+len(b)]
+}
+
+// decodeValue splits a stored value into its revision and the object
+// bytes.
+func decodeValue(v []byte) (uint64, []byte, error) {
+	if len(v) == 0 || v[0] != valueMagic {
+		return 0, v, nil
+	}
+	if len(v) < 2 || v[1] != valueVersion {
+		return 0, nil, ErrInternalError
+	}
+	rev, n := binary.Uvarint(v[2:])
+	if n <= 0 {
+		return 0, nil, ErrInternalError
+	}
+	return rev, v[2+n:], nil
+}
+
+// currentRevision reads the revision of k directly from the KV
+// store, ignoring any batch that is active.
+func (db *DB) currentRevision(ctx context.Context, k string) (revision, error) {
+	v, err := db.kv.Get(ctx, k)
+	if err == ErrNoValue {
+		return revision{}, nil
+	}
+	if err != nil {
+		return revision{}, err
+	}
+	rev, _, err := decodeValue(v)
+	if err != nil {
+		return revision{}, err
+	}
+	return revision{rev: rev, exists: true}, nil
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/proto"
+
+	types "github.com/netauth/protocol"
+)
+
+func TestEncodeDecodeValue(t *testing.T) {
+	rev, b, err := decodeValue(encodeValue(300, goodEntityBytes1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(300), rev)
+	assert.Equal(t, goodEntityBytes1, b)
+
+	// Values written before revisions existed are at revision
+	// zero.
+	rev, b, err = decodeValue(goodEntityBytes1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), rev)
+	assert.Equal(t, goodEntityBytes1, b)
+
+	_, _, err = decodeValue([]byte{valueMagic, 42})
+	assert.Equal(t, ErrInternalError, err)
+	_, _, err = decodeValue([]byte{valueMagic, valueVersion})
+	assert.Equal(t, ErrInternalError, err)
+}
+
+func TestRevisionIncrements(t *testing.T) {
+	ctx := context.Background()
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	m.kv.(*mockKV).On("Capabilities").Return([]KVCapability{KVMutable})
+	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(encodeValue(3, goodEntityBytes1), nil)
+	m.kv.(*mockKV).On("Keys", "/entities/*").Return([]string{"/entities/entity1"}, nil)
+	m.kv.(*mockKV).On("Put", "/entities/entity1", mock.MatchedBy(func(v []byte) bool {
+		rev, _, err := decodeValue(v)
+		return err == nil && rev == 4
+	})).Return(nil)
+
+	e, err := m.LoadEntity(ctx, "entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), e.GetNumber())
+	assert.Nil(t, m.SaveEntity(ctx, e))
+}
+
+func TestBatchConflict(t *testing.T) {
+	ctx := context.Background()
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	// The entity changes between being loaded and being saved.
+	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(encodeValue(1, goodEntityBytes1), nil).Once()
+	m.kv.(*mockKV).On("Get", "/entities/entity1").Return(encodeValue(2, goodEntityBytes2), nil)
+
+	err = m.Batch(ctx, func(ctx context.Context) error {
+		e, err := m.LoadEntity(ctx, "entity1")
+		assert.Nil(t, err)
+		return m.SaveEntity(ctx, e)
+	})
+	assert.Equal(t, ErrConflict, err)
+	m.kv.(*mockKV).AssertNotCalled(t, "Put", mock.Anything, mock.Anything)
+}
+
+func TestBatchCreateConflict(t *testing.T) {
+	ctx := context.Background()
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	// The group is created by someone else after it was found not
+	// to exist.
+	m.kv.(*mockKV).On("Get", "/groups/group1").Return([]byte{}, ErrNoValue).Once()
+	m.kv.(*mockKV).On("Get", "/groups/group1").Return(encodeValue(1, goodGroupBytes1), nil)
+
+	err = m.Batch(ctx, func(ctx context.Context) error {
+		if _, err := m.LoadGroup(ctx, "group1"); err != ErrUnknownGroup {
+			return err
+		}
+		return m.SaveGroup(ctx, &types.Group{Name: proto.String("group1")})
+	})
+	assert.Equal(t, ErrConflict, err)
+	m.kv.(*mockKV).AssertNotCalled(t, "Put", mock.Anything, mock.Anything)
+}
+
+func TestLoadBadRevision(t *testing.T) {
+	ctx := context.Background()
+	RegisterKV("mock", newMockKV)
+	m, err := New("mock")
+	assert.Nil(t, err)
+
+	m.kv.(*mockKV).On("Get", "/entities/bad").Return([]byte{valueMagic, 42}, nil)
+	_, err = m.LoadEntity(ctx, "bad")
+	assert.Equal(t, ErrInternalError, err)
+}
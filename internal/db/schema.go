@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The schema version describes how the objects in the store are
+// encoded.  It is kept outside of the entity and group keyspaces so
+// that it is carried along by snapshots and copies, but is never
+// mistaken for an object.  Stores that predate the version marker
+// are at version zero.
+const schemaKey = "/meta/schema"
+
+// A Migration upgrades the objects in the store from the previous
+// schema version to Version.
+type Migration struct {
+	Version     int
+	Description string
+
+	// Run performs the migration, and returns a description of
+	// each change that was made.  If dryRun is set then nothing
+	// may be written, and the changes that would have been made
+	// are returned instead.
+	Run func(ctx context.Context, db *DB, dryRun bool) ([]string, error)
+}
+
+// A MigrationResult is the outcome of running a single migration.
+type MigrationResult struct {
+	Version     int
+	Description string
+	Changes     []string
+}
+
+var (
+	migrations []Migration
+)
+
+// RegisterMigration adds a migration to the ordered list of
+// migrations.  A migration with a version that is already registered
+// is ignored.
+func RegisterMigration(m Migration) {
+	for _, have := range migrations {
+		if have.Version == m.Version {
+			log().Warn("Attempted to register duplicate migration", "version", m.Version)
+			return
+		}
+	}
+	migrations = append(migrations, m)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+}
+
+// LatestSchema returns the schema version that this build reads and
+// writes, which is the version of the last registered migration.
+func LatestSchema() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the schema version recorded in the store, or
+// zero if none has been recorded.
+func (db *DB) SchemaVersion(ctx context.Context) (int, error) {
+	v, err := db.kv.Get(ctx, schemaKey)
+	if err == ErrNoValue {
+		return 0, nil
+	}
+	if err != nil {
+		db.log.Warn("Error loading schema version", "error", err)
+		return 0, ErrInternalError
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(v)))
+	if err != nil {
+		db.log.Warn("Schema version is unreadable", "value", string(v), "error", err)
+		return 0, ErrInternalError
+	}
+	return n, nil
+}
+
+// CheckSchema returns ErrSchemaTooNew if the store was written by a
+// newer build, and ErrSchemaOutdated if it has migrations pending.
+// A store that has no version and holds no objects is new, and is
+// marked as being at the latest version.
+func (db *DB) CheckSchema(ctx context.Context) error {
+	have, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case have > LatestSchema():
+		db.log.Error("Datastore schema is newer than this server", "store", have, "server", LatestSchema())
+		return ErrSchemaTooNew
+	case have == LatestSchema():
+		return nil
+	}
+
+	empty, err := db.isEmpty(ctx)
+	if err != nil {
+		return err
+	}
+	if have == 0 && empty {
+		return db.setSchemaVersion(ctx, LatestSchema())
+	}
+	db.log.Error("Datastore schema must be migrated", "store", have, "server", LatestSchema())
+	return ErrSchemaOutdated
+}
+
+// Migrate runs every migration that has not yet been applied to the
+// store, in order, recording the new version after each one.  If
+// dryRun is set then nothing is written, and the results describe
+// what would have been done.  Each migration in a dry run sees the
+// store as it is, not as the previous migrations would have left it.
+// Results are returned for the migrations that completed even if a
+// later one fails.
+func (db *DB) Migrate(ctx context.Context, dryRun bool) ([]MigrationResult, error) {
+	db.commitMu.Lock()
+	defer db.commitMu.Unlock()
+
+	have, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if have > LatestSchema() {
+		return nil, ErrSchemaTooNew
+	}
+
+	out := []MigrationResult{}
+	for _, m := range migrations {
+		if m.Version <= have {
+			continue
+		}
+		changes, err := m.Run(ctx, db, dryRun)
+		if err != nil {
+			db.log.Error("Migration failed", "version", m.Version, "error", err)
+			return out, err
+		}
+		out = append(out, MigrationResult{
+			Version:     m.Version,
+			Description: m.Description,
+			Changes:     changes,
+		})
+		if dryRun {
+			continue
+		}
+		if err := db.setSchemaVersion(ctx, m.Version); err != nil {
+			return out, err
+		}
+		db.log.Info("Migration complete", "version", m.Version, "changes", len(changes))
+	}
+	return out, nil
+}
+
+// setSchemaVersion records the schema version in the store.
+func (db *DB) setSchemaVersion(ctx context.Context, v int) error {
+	if err := db.kv.Put(ctx, schemaKey, []byte(strconv.Itoa(v))); err != nil {
+		db.log.Warn("Error storing schema version", "error", err)
+		return ErrInternalError
+	}
+	return nil
+}
+
+// isEmpty returns true if there are no entities or groups in the
+// store.
+func (db *DB) isEmpty(ctx context.Context) (bool, error) {
+	for _, f := range []string{"/entities/*", "/groups/*"} {
+		keys, err := db.kv.Keys(ctx, f)
+		if err != nil {
+			db.log.Warn("Error listing keys", "error", err)
+			return false, ErrInternalError
+		}
+		if len(keys) > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+// mapKV is just enough of a KVStore to run migrations against.
+type mapKV struct {
+	KVStore
+
+	m map[string][]byte
+}
+
+func (kv *mapKV) Put(_ context.Context, k string, v []byte) error {
+	kv.m[k] = v
+	return nil
+}
+
+func (kv *mapKV) Get(_ context.Context, k string) ([]byte, error) {
+	v, ok := kv.m[k]
+	if !ok {
+		return nil, ErrNoValue
+	}
+	return v, nil
+}
+
+func (kv *mapKV) Keys(_ context.Context, f string) ([]string, error) {
+	out := []string{}
+	for k := range kv.m {
+		if ok, _ := path.Match(f, k); ok {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+func newSchemaDB() (*DB, *mapKV) {
+	kv := &mapKV{m: make(map[string][]byte)}
+	return &DB{log: hclog.NewNullLogger(), kv: kv}, kv
+}
+
+func TestCheckSchema(t *testing.T) {
+	ctx := context.Background()
+	db, kv := newSchemaDB()
+
+	// An empty store is marked as current.
+	assert.Nil(t, db.CheckSchema(ctx))
+	v, err := db.SchemaVersion(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, LatestSchema(), v)
+
+	kv.m[schemaKey] = []byte("1000")
+	assert.Equal(t, ErrSchemaTooNew, db.CheckSchema(ctx))
+	_, err = db.Migrate(ctx, false)
+	assert.Equal(t, ErrSchemaTooNew, err)
+
+	// A store with objects but no version hasn't been migrated.
+	delete(kv.m, schemaKey)
+	kv.m["/entities/entity1"] = goodEntityBytes1
+	assert.Equal(t, ErrSchemaOutdated, db.CheckSchema(ctx))
+
+	kv.m[schemaKey] = []byte("garbage")
+	assert.Equal(t, ErrInternalError, db.CheckSchema(ctx))
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	db, kv := newSchemaDB()
+	kv.m["/entities/entity1"] = goodEntityBytes1
+	kv.m["/entities/entity2"] = encodeValue(4, goodEntityBytes2)
+	kv.m["/groups/group1"] = goodGroupBytes1
+
+	res, err := db.Migrate(ctx, true)
+	assert.Nil(t, err)
+	assert.Equal(t, LatestSchema(), len(res))
+	assert.Equal(t, 1, res[0].Version)
+	assert.ElementsMatch(t, []string{
+		"/entities/entity1: add revision header",
+		"/groups/group1: add revision header",
+	}, res[0].Changes)
+
+	// A dry run changes nothing.
+	assert.Equal(t, goodEntityBytes1, kv.m["/entities/entity1"])
+	assert.Equal(t, ErrSchemaOutdated, db.CheckSchema(ctx))
+
+	res, err = db.Migrate(ctx, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(res[0].Changes))
+	assert.Nil(t, db.CheckSchema(ctx))
+
+	rev, b, err := decodeValue(kv.m["/entities/entity1"])
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), rev)
+	assert.Equal(t, goodEntityBytes1, b)
+	rev, _, _ = decodeValue(kv.m["/entities/entity2"])
+	assert.Equal(t, uint64(4), rev)
+
+	// Nothing is left to do.
+	res, err = db.Migrate(ctx, false)
+	assert.Nil(t, err)
+	assert.Empty(t, res)
+}
+
+func TestRegisterMigration(t *testing.T) {
+	saved := migrations
+	defer func() { migrations = saved }()
+	migrations = nil
+
+	noop := func(context.Context, *DB, bool) ([]string, error) { return nil, nil }
+	RegisterMigration(Migration{Version: 2, Description: "second", Run: noop})
+	RegisterMigration(Migration{Version: 1, Description: "first", Run: noop})
+	RegisterMigration(Migration{Version: 2, Description: "duplicate", Run: noop})
+
+	assert.Equal(t, 2, LatestSchema())
+	assert.Equal(t, "first", migrations[0].Description)
+	assert.Equal(t, "second", migrations[1].Description)
+}
@@ -2,9 +2,20 @@ package db
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
 	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
 
 	pb "github.com/netauth/protocol"
 )
@@ -16,52 +27,274 @@ type Index struct {
 	eIndex bleve.Index
 	gIndex bleve.Index
 
-	eLoader loadEntityFunc
-	gLoader loadGroupFunc
+	eLoader   loadEntityFunc
+	gLoader   loadGroupFunc
+	revLoader loadRevisionFunc
+
+	// persistent is set when the index outlives the process, in
+	// which case the revision of every object is recorded
+	// alongside it so that unchanged objects need not be
+	// indexed again.
+	persistent bool
+
+	// kvExclude holds the KV2 keys that are never indexed, so
+	// that sensitive values can't be found by searching for them.
+	kvExclude map[string]struct{}
 
 	l hclog.Logger
 }
 
+// The internal keys below are stored in a persistent index to
+// describe what it contains.  indexFormat must be changed whenever
+// the mappings change so that old indexes are rebuilt.
+const (
+	indexFormat = "3"
+
+	internalFormat    = "netauth:format"
+	internalBackend   = "netauth:backend"
+	internalKVExclude = "netauth:kv-exclude"
+	internalRevPfx    = "netauth:rev:"
+)
+
+// indexedEntity is the document that is indexed for an entity.  KV2
+// data is flattened so that each key is its own field, which allows
+// searches such as kv.department:infra.  The fields that can be
+// aggregated are copied below facet.
+type indexedEntity struct {
+	*pb.Entity `json:""`
+
+	KV     map[string][]string `json:"kv,omitempty"`
+	Facets map[string]string   `json:"facet,omitempty"`
+}
+
+// indexedGroup is the document that is indexed for a group, with the
+// KV2 data and facets prepared in the same way as for entities.
+type indexedGroup struct {
+	*pb.Group `json:""`
+
+	KV     map[string][]string `json:"kv,omitempty"`
+	Facets map[string]string   `json:"facet,omitempty"`
+}
+
 // NewIndex returns a new SearchIndex with the mappings configured and
 // ready to use.  Mappings are statically defined for simplicity, and
 // in general new mappings shouldn't be added without a very good
-// reason.
+// reason.  KV2 keys listed in index.kv-exclude are left out of the
+// index.
 func NewIndex(l hclog.Logger) *Index {
-	// Setup the mapping for entities and turn off certain sub
-	// keys that shouldn't be indexed.
-	eMapping := bleve.NewIndexMapping()
-	eDocMap := bleve.NewDocumentMapping()
-	eDocMap.AddSubDocumentMapping("secret", bleve.NewDocumentDisabledMapping())
-	eDocMap.AddSubDocumentMapping("meta.Keys", bleve.NewDocumentDisabledMapping())
-	eDocMap.AddSubDocumentMapping("meta.UntypedMeta", bleve.NewDocumentDisabledMapping())
-	eMapping.AddDocumentMapping("_default", eDocMap)
-
 	// The only real way to throw an error in here is if a mapping
 	// is invalid, or if this were on disk if the backing boltdb
 	// couldn't be allocated.  Since this is fully in memory and
 	// uses a hard-coded mapping, there is no concievable way for
 	// an error to be returned here.  The same is true of the
 	// group mapping below.
-	eIndex, _ := bleve.NewMemOnly(eMapping)
+	eIndex, _ := bleve.NewMemOnly(entityMapping())
 	eIndex.SetName("EntityIndex")
 
-	// Setup the mapping for groups and turn off certain sub keys
-	// that shouldn't be indexed.
+	gIndex, _ := bleve.NewMemOnly(groupMapping())
+	gIndex.SetName("GroupIndex")
+
+	// Return the prepared struct
+	return &Index{
+		eIndex:    eIndex,
+		gIndex:    gIndex,
+		kvExclude: kvExcludeFromConfig(),
+		l:         l.Named("blevesearch"),
+	}
+}
+
+// indexFromConfig returns the index to use with the named KV backend.
+// If index.persistent is set then the index is kept on disk below
+// core.home, and only objects that have changed since it was last
+// updated are reindexed at startup.  Otherwise the index is held in
+// memory and is rebuilt from scratch every time.
+func indexFromConfig(l hclog.Logger, backend string) (*Index, error) {
+	if !viper.GetBool("index.persistent") {
+		return NewIndex(l), nil
+	}
+	p := filepath.Join(viper.GetString("core.home"), "index")
+	idx, err := NewPersistentIndex(l, p, backend)
+	if err != nil {
+		l.Error("Unable to open search index", "path", p, "error", err)
+		return nil, err
+	}
+	return idx, nil
+}
+
+// NewPersistentIndex returns an index that is stored on disk in the
+// given directory.  If an index already exists there it is reused,
+// unless it was built by a different version of the mappings or
+// against a different KV backend or with different KV2 keys
+// excluded, in which case it is discarded and will be rebuilt from
+// scratch.
+func NewPersistentIndex(l hclog.Logger, dir, backend string) (*Index, error) {
+	l = l.Named("blevesearch")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	exclude := kvExcludeFromConfig()
+	eIndex, err := openPersistent(l, filepath.Join(dir, "entities"), backend, exclude, entityMapping)
+	if err != nil {
+		return nil, err
+	}
+	eIndex.SetName("EntityIndex")
+
+	gIndex, err := openPersistent(l, filepath.Join(dir, "groups"), backend, exclude, groupMapping)
+	if err != nil {
+		eIndex.Close()
+		return nil, err
+	}
+	gIndex.SetName("GroupIndex")
+
+	return &Index{
+		eIndex:     eIndex,
+		gIndex:     gIndex,
+		persistent: true,
+		kvExclude:  exclude,
+		l:          l,
+	}, nil
+}
+
+// openPersistent opens the index at p, creating it if it does not
+// exist or if what is there can't be used.
+func openPersistent(l hclog.Logger, p, backend string, exclude map[string]struct{}, m func() *mapping.IndexMappingImpl) (bleve.Index, error) {
+	excluded := kvExcludeString(exclude)
+	idx, err := bleve.Open(p)
+	if err == nil {
+		format, _ := idx.GetInternal([]byte(internalFormat))
+		built, _ := idx.GetInternal([]byte(internalBackend))
+		kvx, _ := idx.GetInternal([]byte(internalKVExclude))
+		if string(format) == indexFormat && string(built) == backend && string(kvx) == excluded {
+			n, _ := idx.DocCount()
+			l.Info("Opened existing search index", "path", p, "documents", n)
+			return idx, nil
+		}
+		l.Info("Search index is out of date and will be rebuilt", "path", p, "format", string(format), "backend", string(built))
+		idx.Close()
+	} else if err != bleve.ErrorIndexPathDoesNotExist {
+		l.Warn("Search index is unreadable and will be rebuilt", "path", p, "error", err)
+	}
+
+	if err := os.RemoveAll(p); err != nil {
+		return nil, err
+	}
+	idx, err = bleve.New(p, m())
+	if err != nil {
+		return nil, err
+	}
+	if err := idx.SetInternal([]byte(internalFormat), []byte(indexFormat)); err != nil {
+		idx.Close()
+		return nil, err
+	}
+	if err := idx.SetInternal([]byte(internalBackend), []byte(backend)); err != nil {
+		idx.Close()
+		return nil, err
+	}
+	if err := idx.SetInternal([]byte(internalKVExclude), []byte(excluded)); err != nil {
+		idx.Close()
+		return nil, err
+	}
+	return idx, nil
+}
+
+// entityMapping turns off certain sub keys of entities that shouldn't
+// be indexed.
+func entityMapping() *mapping.IndexMappingImpl {
+	eMapping := bleve.NewIndexMapping()
+	eDocMap := bleve.NewDocumentMapping()
+	eDocMap.AddSubDocumentMapping("secret", bleve.NewDocumentDisabledMapping())
+	eDocMap.AddSubDocumentMapping("meta.Keys", bleve.NewDocumentDisabledMapping())
+	eDocMap.AddSubDocumentMapping("meta.UntypedMeta", bleve.NewDocumentDisabledMapping())
+
+	// The raw KV2 data is indexed in its flattened form instead.
+	eMetaMap := bleve.NewDocumentMapping()
+	eMetaMap.AddSubDocumentMapping("KV", bleve.NewDocumentDisabledMapping())
+	eDocMap.AddSubDocumentMapping("meta", eMetaMap)
+	eDocMap.AddSubDocumentMapping("facet", facetMapping(entityFacets))
+	eMapping.AddDocumentMapping("_default", eDocMap)
+	return eMapping
+}
+
+// groupMapping turns off certain sub keys of groups that shouldn't be
+// indexed.
+func groupMapping() *mapping.IndexMappingImpl {
 	gMapping := bleve.NewIndexMapping()
 	gDocMap := bleve.NewDocumentMapping()
 	gDocMap.AddSubDocumentMapping("untypedmeta", bleve.NewDocumentDisabledMapping())
+	gDocMap.AddSubDocumentMapping("KV", bleve.NewDocumentDisabledMapping())
+	gDocMap.AddSubDocumentMapping("facet", facetMapping(groupFacets))
 	gMapping.AddDocumentMapping("_default", gDocMap)
-	gIndex, _ := bleve.NewMemOnly(gMapping)
-	gIndex.SetName("GroupIndex")
+	return gMapping
+}
 
-	// Return the prepared struct
-	return &Index{
-		eIndex: eIndex,
-		gIndex: gIndex,
-		l:      l.Named("blevesearch"),
+// kvExcludeFromConfig returns the set of KV2 keys that should not be
+// indexed.
+func kvExcludeFromConfig() map[string]struct{} {
+	out := make(map[string]struct{})
+	for _, k := range viper.GetStringSlice("index.kv-exclude") {
+		out[k] = struct{}{}
+	}
+	return out
+}
+
+// kvExcludeString returns a stable representation of a set of
+// excluded keys, so that an index can record which keys were left
+// out when it was built.
+func kvExcludeString(exclude map[string]struct{}) string {
+	keys := make([]string, 0, len(exclude))
+	for k := range exclude {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\n")
+}
+
+// entityDocument prepares an entity to be indexed.
+func (s *Index) entityDocument(e *pb.Entity) indexedEntity {
+	return indexedEntity{
+		Entity: e,
+		KV:     s.flattenKV(e.GetMeta().GetKV()),
+		Facets: entityFacetValues(e),
 	}
 }
 
+// groupDocument prepares a group to be indexed.
+func (s *Index) groupDocument(g *pb.Group) indexedGroup {
+	return indexedGroup{
+		Group:  g,
+		KV:     s.flattenKV(g.GetKV()),
+		Facets: groupFacetValues(g),
+	}
+}
+
+// flattenKV converts KV2 data to a map of key to values, leaving out
+// any keys that are excluded from the index.
+func (s *Index) flattenKV(kv []*pb.KVData) map[string][]string {
+	if len(kv) == 0 {
+		return nil
+	}
+	out := make(map[string][]string, len(kv))
+	for _, d := range kv {
+		if _, skip := s.kvExclude[d.GetKey()]; skip {
+			continue
+		}
+		for _, v := range d.GetValues() {
+			out[d.GetKey()] = append(out[d.GetKey()], v.GetValue())
+		}
+	}
+	return out
+}
+
+// Close flushes and closes the indexes.
+func (s *Index) Close() error {
+	eErr := s.eIndex.Close()
+	if err := s.gIndex.Close(); err != nil {
+		return err
+	}
+	return eErr
+}
+
 // ConfigureCallback is used to set the references to the loaders
 // which are later used by the callback to fetch entities and groups
 // for indexing.
@@ -71,6 +304,12 @@ func (s *Index) ConfigureCallback(el loadEntityFunc, gl loadGroupFunc) {
 	s.l.Trace("IndexCallback is now configured")
 }
 
+// configureRevisions provides the function used to find the current
+// revision of an object.  This is only used by persistent indexes.
+func (s *Index) configureRevisions(rl loadRevisionFunc) {
+	s.revLoader = rl
+}
+
 // IndexCallback is meant to be plugged into the event system and is
 // subsequently capable of maintaining the index based on events being
 // fired during save and as files change on disk.
@@ -84,66 +323,185 @@ func (s *Index) IndexCallback(e Event) {
 	case EventEntityCreate:
 		fallthrough
 	case EventEntityUpdate:
+		rev, fresh := s.isFresh(s.eIndex, path.Join("/entities", e.PK), e.PK)
+		if fresh {
+			return
+		}
 		ent, err := s.eLoader(context.Background(), e.PK)
 		if err != nil {
 			s.l.Warn("Could not reindex entity", "entity", e.PK, "error", err)
 			return
 		}
-		s.IndexEntity(ent)
+		s.indexWithRevision(s.eIndex, ent.GetID(), s.entityDocument(ent), rev)
 	case EventEntityDestroy:
-		s.eIndex.Delete(e.PK)
+		s.deleteWithRevision(s.eIndex, e.PK)
 	case EventGroupCreate:
 		fallthrough
 	case EventGroupUpdate:
+		rev, fresh := s.isFresh(s.gIndex, path.Join("/groups", e.PK), e.PK)
+		if fresh {
+			return
+		}
 		grp, err := s.gLoader(context.Background(), e.PK)
 		if err != nil {
 			s.l.Warn("Could not reindex group", "group", e.PK, "error", err)
 			return
 		}
-		s.IndexGroup(grp)
+		s.indexWithRevision(s.gIndex, grp.GetName(), s.groupDocument(grp), rev)
 	case EventGroupDestroy:
-		s.gIndex.Delete(e.PK)
+		s.deleteWithRevision(s.gIndex, e.PK)
+	}
+}
+
+// isFresh checks if a persistent index already holds the current
+// revision of an object.  The revision is returned so that it can be
+// recorded once the object has been indexed.  It is read before the
+// object is loaded so that a concurrent write can only cause the
+// recorded revision to be older than what was indexed, never newer.
+// Objects at revision zero predate revisions and are always indexed.
+func (s *Index) isFresh(idx bleve.Index, k, id string) (uint64, bool) {
+	if !s.persistent || s.revLoader == nil {
+		return 0, false
+	}
+	cur, err := s.revLoader(context.Background(), k)
+	if err != nil || !cur.exists || cur.rev == 0 {
+		return 0, false
+	}
+	b, err := idx.GetInternal([]byte(internalRevPfx + id))
+	if err != nil || b == nil {
+		return cur.rev, false
+	}
+	have, n := binary.Uvarint(b)
+	if n <= 0 || have != cur.rev {
+		return cur.rev, false
+	}
+	s.l.Trace("Index is up to date", "key", k, "revision", cur.rev)
+	return cur.rev, true
+}
+
+// indexWithRevision indexes a document and records the revision it
+// was indexed at in a single batch.
+func (s *Index) indexWithRevision(idx bleve.Index, id string, doc interface{}, rev uint64) {
+	s.l.Trace("Indexing document", "index", idx.Name(), "id", id, "revision", rev)
+	b := idx.NewBatch()
+	if err := b.Index(id, doc); err != nil {
+		s.l.Warn("Could not index document", "index", idx.Name(), "id", id, "error", err)
+		return
+	}
+	if s.persistent {
+		if rev > 0 {
+			buf := make([]byte, binary.MaxVarintLen64)
+			b.SetInternal([]byte(internalRevPfx+id), buf[:binary.PutUvarint(buf, rev)])
+		} else {
+			b.DeleteInternal([]byte(internalRevPfx + id))
+		}
+	}
+	if err := idx.Batch(b); err != nil {
+		s.l.Warn("Could not index document", "index", idx.Name(), "id", id, "error", err)
+	}
+}
+
+// deleteWithRevision removes a document along with its recorded
+// revision.
+func (s *Index) deleteWithRevision(idx bleve.Index, id string) {
+	b := idx.NewBatch()
+	b.Delete(id)
+	if s.persistent {
+		b.DeleteInternal([]byte(internalRevPfx + id))
+	}
+	if err := idx.Batch(b); err != nil {
+		s.l.Warn("Could not remove document", "index", idx.Name(), "id", id, "error", err)
+	}
+}
+
+// prune removes documents from a persistent index that are not in the
+// list of live IDs.  This catches objects that were removed while the
+// server was not running.
+func (s *Index) prune(idx bleve.Index, live []string) {
+	if !s.persistent {
+		return
+	}
+	n, err := idx.DocCount()
+	if err != nil || n == 0 {
+		return
+	}
+
+	keep := make(map[string]struct{}, len(live))
+	for _, id := range live {
+		keep[path.Base(id)] = struct{}{}
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), int(n), 0, false)
+	result, err := idx.Search(req)
+	if err != nil {
+		s.l.Warn("Could not list indexed documents", "index", idx.Name(), "error", err)
+		return
+	}
+	for _, id := range extractDocIDs(result) {
+		if _, ok := keep[id]; !ok {
+			s.l.Debug("Removing stale document", "index", idx.Name(), "id", id)
+			s.deleteWithRevision(idx, id)
+		}
 	}
 }
 
 // SearchEntities searches the index for entities matching the
 // qualities specified in the request.
 func (s *Index) SearchEntities(r SearchRequest) ([]string, error) {
-	if r.Expression == "" {
-		return nil, ErrBadSearch
-	}
-
-	req := createSearchRequest(r)
+	ids, _, err := s.SearchEntityPage(r)
+	return ids, err
+}
 
-	// This can only fail if the query is malformed, since the
-	// worst that can happen is the query is empty, this can't
-	// return an error.
-	result, _ := s.eIndex.Search(req)
-	slice := extractDocIDs(result)
-	return slice, nil
+// SearchEntityPage is SearchEntities, but also describes where the
+// results sit in the complete set of matches so that the next page
+// can be requested.
+func (s *Index) SearchEntityPage(r SearchRequest) ([]string, SearchResult, error) {
+	return s.searchPage(s.eIndex, entityLDAPSchema, r)
 }
 
 // SearchGroups searches the index for groups matching the qualities
 // specified in the request.
 func (s *Index) SearchGroups(r SearchRequest) ([]string, error) {
+	ids, _, err := s.SearchGroupPage(r)
+	return ids, err
+}
+
+// SearchGroupPage is SearchGroups, but also describes where the
+// results sit in the complete set of matches.
+func (s *Index) SearchGroupPage(r SearchRequest) ([]string, SearchResult, error) {
+	return s.searchPage(s.gIndex, groupLDAPSchema, r)
+}
+
+func (s *Index) searchPage(idx bleve.Index, schema *ldapSchema, r SearchRequest) ([]string, SearchResult, error) {
 	if r.Expression == "" {
-		return nil, ErrBadSearch
+		return nil, SearchResult{}, ErrBadSearch
 	}
 
-	req := createSearchRequest(r)
+	req, err := createSearchRequest(r, schema)
+	if err != nil {
+		return nil, SearchResult{}, err
+	}
 
 	// This can only fail if the query is malformed, since the
 	// worst that can happen is the query is empty, this can't
 	// return an error.
-	result, _ := s.gIndex.Search(req)
+	result, _ := idx.Search(req)
 	slice := extractDocIDs(result)
-	return slice, nil
+
+	res := SearchResult{}
+	if result != nil {
+		res.Total = int(result.Total)
+	}
+	if next := req.From + len(slice); r.PageSize > 0 && next < res.Total {
+		res.Next = encodeCursor(next)
+	}
+	return slice, res, nil
 }
 
 // IndexEntity adds or updates an entity in the index.
 func (s *Index) IndexEntity(e *pb.Entity) error {
 	s.l.Trace("Indexing Entity", "entity", e.GetID())
-	return s.eIndex.Index(e.GetID(), e)
+	return s.eIndex.Index(e.GetID(), s.entityDocument(e))
 }
 
 // DeleteEntity removes an entity from the index
@@ -155,7 +513,7 @@ func (s *Index) DeleteEntity(e *pb.Entity) error {
 // IndexGroup adds or updates a group in the index.
 func (s *Index) IndexGroup(g *pb.Group) error {
 	s.l.Trace("Indexing Group", "group", g.GetName())
-	return s.gIndex.Index(g.GetName(), g)
+	return s.gIndex.Index(g.GetName(), s.groupDocument(g))
 }
 
 // DeleteGroup removes a group from the index.
@@ -164,16 +522,74 @@ func (s *Index) DeleteGroup(g *pb.Group) error {
 	return s.gIndex.Delete(g.GetName())
 }
 
+// maxSearchResults is the most results that a single search will
+// return, and so the largest page that can be requested.
+const maxSearchResults = 16000
+
 // createSearchRequest is a helper function which converts between a
-// db.SearchRequest and a bleve.SearchRequest.
-func createSearchRequest(r SearchRequest) *bleve.SearchRequest {
-	q := bleve.NewQueryStringQuery(r.Expression)
-
-	// This will bite someone someday, by creating a near
-	// impossible to reason about bug where the entities returned
-	// in a search keep changing, but today is not that day.
-	sr := bleve.NewSearchRequestOptions(q, 16000, 0, false)
-	return sr
+// db.SearchRequest and a bleve.SearchRequest.  LDAP filters are
+// interpreted according to the schema.
+func createSearchRequest(r SearchRequest, schema *ldapSchema) (*bleve.SearchRequest, error) {
+	var q query.Query
+	switch r.Syntax {
+	case SyntaxQueryString:
+		q = bleve.NewQueryStringQuery(r.Expression)
+	case SyntaxLDAP:
+		var err error
+		if q, err = parseLDAPFilter(r.Expression, schema, r.Resolver); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrBadSearch
+	}
+
+	size := r.PageSize
+	if size <= 0 || size > maxSearchResults {
+		size = maxSearchResults
+	}
+	from := r.Offset
+	if r.Cursor != "" {
+		var err error
+		if from, err = decodeCursor(r.Cursor); err != nil {
+			return nil, err
+		}
+	}
+	if from < 0 {
+		return nil, ErrBadSearch
+	}
+
+	sr := bleve.NewSearchRequestOptions(q, size, from, false)
+
+	// Documents with the same score come back in an arbitrary
+	// order, so the ID is always the final sort key.  This keeps
+	// pages stable as long as the index doesn't change between
+	// requests.
+	order := []string{"-_score"}
+	if len(r.SortBy) > 0 {
+		order = append([]string{}, r.SortBy...)
+	}
+	sr.SortBy(append(order, "_id"))
+	return sr, nil
+}
+
+// encodeCursor returns an opaque cursor that continues a search from
+// the given offset.  Clients must not interpret cursors, so that the
+// way results are located can change without breaking them.
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("o:" + strconv.Itoa(offset)))
+}
+
+// decodeCursor returns the offset held in a cursor.
+func decodeCursor(c string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil || !strings.HasPrefix(string(b), "o:") {
+		return 0, ErrBadSearch
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(string(b), "o:"))
+	if err != nil {
+		return 0, ErrBadSearch
+	}
+	return n, nil
 }
 
 // extractDocIDs converts between a bleve.SearchResult and a []string
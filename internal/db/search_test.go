@@ -2,9 +2,13 @@ package db
 
 import (
 	"context"
+	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
 	"google.golang.org/protobuf/proto"
 
 	pb "github.com/netauth/protocol"
@@ -282,8 +286,166 @@ func TestSearchGroupsBadRequest(t *testing.T) {
 	}
 }
 
+func TestPersistentIndex(t *testing.T) {
+	dir := t.TempDir()
+	revs := map[string]revision{
+		"/entities/entity1": {rev: 3, exists: true},
+		"/groups/group1":    {rev: 0, exists: true},
+	}
+	revLoader := func(_ context.Context, k string) (revision, error) {
+		return revs[k], nil
+	}
+	loads := 0
+	countingLoader := func(ctx context.Context, e string) (*pb.Entity, error) {
+		loads++
+		return dummyEntityLoader(ctx, e)
+	}
+
+	si, err := NewPersistentIndex(hclog.NewNullLogger(), dir, "memory")
+	assert.Nil(t, err)
+	si.ConfigureCallback(countingLoader, dummyGroupLoader)
+	si.configureRevisions(revLoader)
+	si.IndexCallback(Event{Type: EventEntityUpdate, PK: "entity1"})
+	si.IndexCallback(Event{Type: EventGroupUpdate, PK: "group1"})
+	si.IndexEntity(&pb.Entity{ID: proto.String("stale")})
+	assert.Equal(t, 1, loads)
+	assert.Nil(t, si.Close())
+
+	// The index survives being reopened, and objects that have not
+	// changed are not loaded again.
+	si, err = NewPersistentIndex(hclog.NewNullLogger(), dir, "memory")
+	assert.Nil(t, err)
+	si.ConfigureCallback(countingLoader, dummyGroupLoader)
+	si.configureRevisions(revLoader)
+	r, _ := si.SearchEntities(SearchRequest{Expression: "ID:entity1"})
+	assert.Equal(t, []string{"entity1"}, r)
+	r, _ = si.SearchGroups(SearchRequest{Expression: "Name:group1"})
+	assert.Equal(t, []string{"group1"}, r)
+
+	si.IndexCallback(Event{Type: EventEntityUpdate, PK: "entity1"})
+	assert.Equal(t, 1, loads)
+	revs["/entities/entity1"] = revision{rev: 4, exists: true}
+	si.IndexCallback(Event{Type: EventEntityUpdate, PK: "entity1"})
+	assert.Equal(t, 2, loads)
+
+	// Objects that no longer exist are pruned.
+	si.prune(si.eIndex, []string{"/entities/entity1"})
+	r, _ = si.SearchEntities(SearchRequest{Expression: "ID:stale"})
+	assert.Empty(t, r)
+	assert.Nil(t, si.Close())
+
+	// An index built against a different backend is discarded.
+	si, err = NewPersistentIndex(hclog.NewNullLogger(), dir, "filesystem")
+	assert.Nil(t, err)
+	r, _ = si.SearchEntities(SearchRequest{Expression: "ID:entity1"})
+	assert.Empty(t, r)
+	assert.Nil(t, si.Close())
+}
+
 func TestExtractDocIDsNullResult(t *testing.T) {
 	if res := extractDocIDs(nil); res != nil {
 		t.Error("Got a non-nil response from a nil result")
 	}
 }
+
+func TestSearchEntityPage(t *testing.T) {
+	si := NewIndex(hclog.NewNullLogger())
+
+	for i := 1; i <= 5; i++ {
+		e := &pb.Entity{
+			ID:     proto.String(fmt.Sprintf("entity%d", i)),
+			Number: proto.Int32(int32(10 - i)),
+			Meta:   &pb.EntityMeta{Shell: proto.String("/bin/korn")},
+		}
+		if err := si.IndexEntity(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Walk the results two at a time using the cursor.
+	req := SearchRequest{Expression: "meta.Shell:korn", PageSize: 2, SortBy: []string{"ID"}}
+	got := []string{}
+	for pages := 1; ; pages++ {
+		ids, res, err := si.SearchEntityPage(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Total != 5 {
+			t.Errorf("Got total %d; Want 5", res.Total)
+		}
+		got = append(got, ids...)
+		if res.Next == "" {
+			if pages != 3 {
+				t.Errorf("Got %d pages; Want 3", pages)
+			}
+			break
+		}
+		req.Cursor = res.Next
+	}
+	if !reflect.DeepEqual(got, []string{"entity1", "entity2", "entity3", "entity4", "entity5"}) {
+		t.Errorf("Got %v", got)
+	}
+
+	// Offsets work without a cursor, and sorting can be reversed
+	// or done on other fields.
+	ids, res, err := si.SearchEntityPage(SearchRequest{Expression: "meta.Shell:korn", PageSize: 2, Offset: 1, SortBy: []string{"-ID"}})
+	if err != nil || !reflect.DeepEqual(ids, []string{"entity4", "entity3"}) || res.Next == "" {
+		t.Errorf("Got %v %v %v", ids, res, err)
+	}
+	ids, _, err = si.SearchEntityPage(SearchRequest{Expression: "meta.Shell:korn", PageSize: 1, SortBy: []string{"Number"}})
+	if err != nil || !reflect.DeepEqual(ids, []string{"entity5"}) {
+		t.Errorf("Got %v %v", ids, err)
+	}
+
+	// Unpaged searches return everything and have no cursor.
+	ids, res, err = si.SearchEntityPage(SearchRequest{Expression: "meta.Shell:korn"})
+	if err != nil || len(ids) != 5 || res.Next != "" {
+		t.Errorf("Got %v %v %v", ids, res, err)
+	}
+
+	for _, c := range []string{"not a cursor", encodeCursor(-1), "bzp4"} {
+		if _, _, err := si.SearchEntityPage(SearchRequest{Expression: "*", Cursor: c}); err != ErrBadSearch {
+			t.Errorf("%q: Got %v; Want %v", c, err, ErrBadSearch)
+		}
+	}
+}
+
+func TestSearchKV(t *testing.T) {
+	viper.Set("index.kv-exclude", []string{"ssn"})
+	defer viper.Set("index.kv-exclude", nil)
+	si := NewIndex(hclog.NewNullLogger())
+
+	e := &pb.Entity{
+		ID: proto.String("entity1"),
+		Meta: &pb.EntityMeta{
+			KV: []*pb.KVData{
+				{Key: proto.String("department"), Values: []*pb.KVValue{{Value: proto.String("infra")}}},
+				{Key: proto.String("ssn"), Values: []*pb.KVValue{{Value: proto.String("123456789")}}},
+			},
+		},
+	}
+	assert.Nil(t, si.IndexEntity(e))
+	g := &pb.Group{
+		Name: proto.String("group1"),
+		KV: []*pb.KVData{
+			{Key: proto.String("owner"), Values: []*pb.KVValue{{Value: proto.String("alice")}, {Value: proto.String("bob")}}},
+		},
+	}
+	assert.Nil(t, si.IndexGroup(g))
+
+	r, err := si.SearchEntities(SearchRequest{Expression: "kv.department:infra"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"entity1"}, r)
+
+	// Excluded keys can't be searched for, and neither can the raw
+	// KV2 data.
+	for _, expr := range []string{"kv.ssn:123456789", "123456789", "meta.KV.Values.Value:infra"} {
+		r, err = si.SearchEntities(SearchRequest{Expression: expr})
+		assert.Nil(t, err)
+		assert.Empty(t, r, expr)
+	}
+
+	r, err = si.SearchGroups(SearchRequest{Expression: "kv.owner:bob"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"group1"}, r)
+}
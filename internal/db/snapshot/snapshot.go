@@ -0,0 +1,260 @@
+// Package snapshot reads and writes point-in-time backups of a
+// KVStore.  A snapshot is a gzipped tar archive which begins with a
+// manifest describing its contents, followed by one file per key in
+// the store.  The manifest records where the snapshot came from, how
+// many entities and groups it holds, and a checksum for every key, so
+// that an archive can be fully verified before anything is restored
+// from it.
+package snapshot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+// FormatVersion is the version of the archive layout written by this
+// package.  Archives with a newer version are refused.
+const FormatVersion = 1
+
+const (
+	manifestName = "manifest.json"
+	dataDir      = "data"
+)
+
+var (
+	// ErrNoManifest is returned if the archive does not begin
+	// with a manifest.
+	ErrNoManifest = errors.New("archive does not begin with a manifest")
+
+	// ErrUnsupportedFormat is returned if the archive was written
+	// with a format this version does not understand.
+	ErrUnsupportedFormat = errors.New("archive format is not supported")
+
+	// ErrCorrupt is returned if the contents of the archive do
+	// not match the manifest.
+	ErrCorrupt = errors.New("archive contents do not match the manifest")
+)
+
+// Manifest describes the contents of a snapshot.
+type Manifest struct {
+	Format   int               `json:"format"`
+	Created  time.Time         `json:"created"`
+	Backend  string            `json:"backend"`
+	Entities int               `json:"entities"`
+	Groups   int               `json:"groups"`
+	Keys     map[string]string `json:"keys"`
+}
+
+// A Snapshot is a verified archive that has been read into memory.
+type Snapshot struct {
+	Manifest Manifest
+	Data     map[string][]byte
+}
+
+// Create writes a snapshot of every entity and group in kv to w.  The
+// backend name is recorded in the manifest for reference.
+func Create(ctx context.Context, kv db.KVStore, backend string, w io.Writer) (*Manifest, error) {
+	keys, err := kv.Keys(ctx, "/*/*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	m := &Manifest{
+		Format:  FormatVersion,
+		Created: time.Now().UTC(),
+		Backend: backend,
+		Keys:    make(map[string]string, len(keys)),
+	}
+	data := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		v, err := kv.Get(ctx, k)
+		if err == db.ErrNoValue {
+			// Removed since the keys were listed.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		data[k] = v
+		m.Keys[k] = checksum(v)
+		m.count(k)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	mb, _ := json.MarshalIndent(m, "", "  ")
+	if err := writeFile(tw, manifestName, mb, m.Created); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		v, ok := data[k]
+		if !ok {
+			continue
+		}
+		if err := writeFile(tw, path.Join(dataDir, k), v, m.Created); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Read reads and verifies a snapshot.  Every key that is listed in
+// the manifest must be present with the recorded checksum, no other
+// keys may be present, and the entity and group counts must agree
+// with the keys.  Nothing is returned unless all of this holds.
+func Read(r io.Reader) (*Snapshot, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	if err == io.EOF || (err == nil && hdr.Name != manifestName) {
+		return nil, ErrNoManifest
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{Data: make(map[string][]byte)}
+	if err := json.NewDecoder(tr).Decode(&s.Manifest); err != nil {
+		return nil, ErrNoManifest
+	}
+	if s.Manifest.Format < 1 || s.Manifest.Format > FormatVersion {
+		return nil, ErrUnsupportedFormat
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(hdr.Name, dataDir+"/") {
+			return nil, fmt.Errorf("%w: unexpected file %s", ErrCorrupt, hdr.Name)
+		}
+		k := strings.TrimPrefix(hdr.Name, dataDir)
+		if _, dup := s.Data[k]; dup {
+			return nil, fmt.Errorf("%w: duplicate key %s", ErrCorrupt, k)
+		}
+		v, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		s.Data[k] = v
+	}
+
+	if err := s.verify(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// verify checks the data against the manifest.
+func (s *Snapshot) verify() error {
+	if len(s.Data) != len(s.Manifest.Keys) {
+		return fmt.Errorf("%w: manifest lists %d keys, archive holds %d", ErrCorrupt, len(s.Manifest.Keys), len(s.Data))
+	}
+
+	counts := Manifest{}
+	for k, sum := range s.Manifest.Keys {
+		v, ok := s.Data[k]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing", ErrCorrupt, k)
+		}
+		if checksum(v) != sum {
+			return fmt.Errorf("%w: checksum mismatch for %s", ErrCorrupt, k)
+		}
+		counts.count(k)
+	}
+	if counts.Entities != s.Manifest.Entities || counts.Groups != s.Manifest.Groups {
+		return fmt.Errorf("%w: object counts do not match", ErrCorrupt)
+	}
+	return nil
+}
+
+// Restore writes the contents of a snapshot to kv.  If truncate is
+// set then any keys in kv that are not in the snapshot are removed
+// first.  The number of keys written is returned.
+func (s *Snapshot) Restore(ctx context.Context, kv db.KVStore, truncate bool) (int, error) {
+	if truncate {
+		existing, err := kv.Keys(ctx, "/*/*")
+		if err != nil {
+			return 0, err
+		}
+		for _, k := range existing {
+			if _, ok := s.Data[k]; ok {
+				continue
+			}
+			if err := kv.Del(ctx, k); err != nil && err != db.ErrNoValue {
+				return 0, fmt.Errorf("%s: %w", k, err)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(s.Data))
+	for k := range s.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for i, k := range keys {
+		if err := kv.Put(ctx, k, s.Data[k]); err != nil {
+			return i, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return len(keys), nil
+}
+
+func (m *Manifest) count(k string) {
+	switch {
+	case strings.HasPrefix(k, "/entities/"):
+		m.Entities++
+	case strings.HasPrefix(k, "/groups/"):
+		m.Groups++
+	}
+}
+
+func writeFile(tw *tar.Writer, name string, b []byte, t time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0640,
+		Size:    int64(len(b)),
+		ModTime: t,
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(b)
+	return err
+}
+
+func checksum(b []byte) string {
+	s := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(s[:])
+}
@@ -0,0 +1,140 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/db/memory"
+)
+
+func newStore(t *testing.T, data map[string]string) db.KVStore {
+	kv, err := memory.NewKV(hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.SetEventFunc(func(db.Event) {})
+	for k, v := range data {
+		kv.Put(context.Background(), k, []byte(v))
+	}
+	return kv
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := newStore(t, map[string]string{
+		"/entities/entity1": "one",
+		"/entities/entity2": "two",
+		"/groups/group1":    "three",
+	})
+
+	var buf bytes.Buffer
+	m, err := Create(ctx, src, "memory", &buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, m.Entities)
+	assert.Equal(t, 1, m.Groups)
+	assert.Equal(t, "memory", m.Backend)
+
+	s, err := Read(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, m.Keys, s.Manifest.Keys)
+
+	dst := newStore(t, map[string]string{
+		"/entities/entity1": "stale",
+		"/groups/extra":     "extra",
+	})
+	n, err := s.Restore(ctx, dst, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	keys, _ := dst.Keys(ctx, "/*/*")
+	assert.ElementsMatch(t, []string{"/entities/entity1", "/entities/entity2", "/groups/group1"}, keys)
+	v, _ := dst.Get(ctx, "/entities/entity1")
+	assert.Equal(t, []byte("one"), v)
+}
+
+// rewrite copies an archive, passing each file through f, which may
+// change the contents or return false to drop the file.
+func rewrite(t *testing.T, in []byte, f func(*tar.Header, []byte) ([]byte, bool)) *bytes.Buffer {
+	gr, _ := gzip.NewReader(bytes.NewReader(in))
+	tr := tar.NewReader(gr)
+
+	var out bytes.Buffer
+	gw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gw)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		var b bytes.Buffer
+		b.ReadFrom(tr)
+		v, keep := f(hdr, b.Bytes())
+		if !keep {
+			continue
+		}
+		hdr.Size = int64(len(v))
+		tw.WriteHeader(hdr)
+		tw.Write(v)
+	}
+	tw.Close()
+	gw.Close()
+	return &out
+}
+
+func TestReadVerifies(t *testing.T) {
+	src := newStore(t, map[string]string{
+		"/entities/entity1": "one",
+		"/groups/group1":    "two",
+	})
+	var buf bytes.Buffer
+	_, err := Create(context.Background(), src, "memory", &buf)
+	assert.Nil(t, err)
+	good := buf.Bytes()
+
+	cases := []struct {
+		name string
+		f    func(*tar.Header, []byte) ([]byte, bool)
+		err  error
+	}{
+		{"tampered", func(h *tar.Header, b []byte) ([]byte, bool) {
+			if h.Name == "data/entities/entity1" {
+				return []byte("evil"), true
+			}
+			return b, true
+		}, ErrCorrupt},
+		{"missing", func(h *tar.Header, b []byte) ([]byte, bool) {
+			return b, h.Name != "data/groups/group1"
+		}, ErrCorrupt},
+		{"no manifest", func(h *tar.Header, b []byte) ([]byte, bool) {
+			return b, h.Name != manifestName
+		}, ErrNoManifest},
+		{"future format", func(h *tar.Header, b []byte) ([]byte, bool) {
+			if h.Name == manifestName {
+				return bytes.Replace(b, []byte(`"format": 1`), []byte(`"format": 99`), 1), true
+			}
+			return b, true
+		}, ErrUnsupportedFormat},
+		{"bad counts", func(h *tar.Header, b []byte) ([]byte, bool) {
+			if h.Name == manifestName {
+				return bytes.Replace(b, []byte(`"groups": 1`), []byte(`"groups": 2`), 1), true
+			}
+			return b, true
+		}, ErrCorrupt},
+	}
+
+	for _, c := range cases {
+		_, err := Read(rewrite(t, good, c.f))
+		assert.True(t, errors.Is(err, c.err), "%s: %v", c.name, err)
+	}
+
+	_, err = Read(bytes.NewReader([]byte("not an archive")))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,317 @@
+//go:build (linux && (386 || amd64 || arm || arm64 || s390x)) || (darwin && (amd64 || arm64)) || ((freebsd || windows) && (386 || amd64)) || ((netbsd || openbsd) && amd64)
+
+// Package sqlite implements a key/value store on top of an embedded
+// SQLite database.  All keys are stored in a single table within a
+// single file, which makes the store easy to inspect with the
+// standard sqlite3 tooling and easy to back up without needing to
+// walk thousands of small files as is the case with the filesystem
+// store.  The SQLite engine used is a pure Go translation, so no C
+// toolchain is required to build this backend.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+
+	// This is the pure Go SQLite driver, it registers itself as
+	// "sqlite" with database/sql.
+	_ "modernc.org/sqlite"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/startup"
+)
+
+// SQLite anchors all the methods in the sqlite key/value store.
+type SQLite struct {
+	db *sql.DB
+	l  hclog.Logger
+
+	eF func(db.Event)
+}
+
+// event is an enum for what type of event to fire and subsequently
+// map to a DB event.
+type eventType int
+
+const (
+	eventUpdate eventType = iota
+	eventDelete
+)
+
+const (
+	// The table is created WITHOUT ROWID since the key is the
+	// only thing that is ever used to locate a row, which makes
+	// the primary key index the table itself.
+	schema = `CREATE TABLE IF NOT EXISTS kv (
+	key   TEXT PRIMARY KEY NOT NULL,
+	value BLOB NOT NULL
+) WITHOUT ROWID`
+
+	stmtPut  = `INSERT INTO kv(key, value) VALUES(?, ?) ON CONFLICT(key) DO UPDATE SET value=excluded.value`
+	stmtGet  = `SELECT value FROM kv WHERE key = ?`
+	stmtDel  = `DELETE FROM kv WHERE key = ?`
+	stmtScan = `SELECT key FROM kv WHERE key >= ? ORDER BY key`
+	stmtPfx  = `SELECT key FROM kv WHERE key >= ? AND key < ? ORDER BY key`
+)
+
+func init() {
+	startup.RegisterCallback(cb)
+}
+
+func cb() {
+	db.RegisterKV("sqlite", New)
+}
+
+// New creates a new instance of the sqlite store.  The database file
+// is created in the NetAuth home directory if it does not already
+// exist.
+func New(l hclog.Logger) (db.KVStore, error) {
+	p := filepath.Join(viper.GetString("core.home"), "netauth.sqlite")
+	if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+		return nil, err
+	}
+
+	// WAL mode allows readers to proceed while a write is in
+	// progress, and the busy timeout smooths over the short
+	// windows where the write lock is contended.  Synchronous
+	// FULL makes sure a write has reached the disk before Put
+	// returns.
+	dsn := "file:" + p +
+		"?_pragma=journal_mode(WAL)" +
+		"&_pragma=synchronous(FULL)" +
+		"&_pragma=busy_timeout(5000)"
+
+	d, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.Exec(schema); err != nil {
+		d.Close()
+		return nil, err
+	}
+
+	x := &SQLite{
+		db: d,
+		l:  l.Named("sqlite"),
+	}
+	x.l.Debug("Database opened", "path", p)
+	return x, nil
+}
+
+// SetEventFunc sets up a function to call to fire events to
+// subscribers.
+func (s *SQLite) SetEventFunc(f func(db.Event)) {
+	s.eF = f
+}
+
+// Put stores the bytes of v at a location identitified by the key k.
+// The write is a single statement and is therefore atomic; either the
+// new value is fully visible or the old one remains.
+func (s *SQLite) Put(ctx context.Context, k string, v []byte) error {
+	if _, err := s.db.ExecContext(ctx, stmtPut, k, v); err != nil {
+		return err
+	}
+	s.fireEventForKey(k, eventUpdate)
+	return nil
+}
+
+// Get returns the value stored at k or db.ErrNoValue if there is no
+// such key.
+func (s *SQLite) Get(ctx context.Context, k string) ([]byte, error) {
+	var v []byte
+	err := s.db.QueryRowContext(ctx, stmtGet, k).Scan(&v)
+	switch err {
+	case nil:
+		return v, nil
+	case sql.ErrNoRows:
+		return nil, db.ErrNoValue
+	default:
+		return nil, err
+	}
+}
+
+// Del removes the value stored at k.  If no value exists then
+// db.ErrNoValue is returned.
+func (s *SQLite) Del(ctx context.Context, k string) error {
+	res, err := s.db.ExecContext(ctx, stmtDel, k)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return db.ErrNoValue
+	}
+	s.fireEventForKey(k, eventDelete)
+	return nil
+}
+
+// Keys returns all keys matching the globbing expression f.  Rather
+// than iterating the entire table, the literal prefix of the
+// expression is used to perform a range scan of the primary key, and
+// only the keys within that range are matched against the full
+// expression.
+func (s *SQLite) Keys(ctx context.Context, f string) ([]string, error) {
+	var rows *sql.Rows
+	var err error
+
+	pfx := globPrefix(f)
+	if end, ok := prefixEnd(pfx); ok {
+		rows, err = s.db.QueryContext(ctx, stmtPfx, pfx, end)
+	} else {
+		rows, err = s.db.QueryContext(ctx, stmtScan, pfx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		if m, _ := path.Match(f, k); m {
+			out = append(out, k)
+		}
+	}
+	return out, rows.Err()
+}
+
+// Close checkpoints the write ahead log and closes the database.  The
+// store must not be used after Close() is called.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// Capabilities returns that this key/value store supports the mutable
+// property, allowing it to be writeable to the higher level systems.
+// Writes may also be grouped into transactions.
+func (s *SQLite) Capabilities() []db.KVCapability {
+	return []db.KVCapability{db.KVMutable, db.KVTransactional}
+}
+
+// Begin starts a new transaction.  The transaction is bound to ctx
+// and will be rolled back if the context is cancelled before it is
+// committed.
+func (s *SQLite) Begin(ctx context.Context) (db.KVTx, error) {
+	t, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &tx{s: s, tx: t}, nil
+}
+
+// pendingEvent is an event that will be fired once a transaction is
+// committed.
+type pendingEvent struct {
+	key string
+	t   eventType
+}
+
+// tx wraps a database transaction and holds back events until it
+// commits.
+type tx struct {
+	s      *SQLite
+	tx     *sql.Tx
+	events []pendingEvent
+}
+
+// Put stores the bytes of v at k within the transaction.
+func (t *tx) Put(ctx context.Context, k string, v []byte) error {
+	if _, err := t.tx.ExecContext(ctx, stmtPut, k, v); err != nil {
+		return err
+	}
+	t.events = append(t.events, pendingEvent{k, eventUpdate})
+	return nil
+}
+
+// Del removes k within the transaction.  If no value exists then
+// db.ErrNoValue is returned.
+func (t *tx) Del(ctx context.Context, k string) error {
+	res, err := t.tx.ExecContext(ctx, stmtDel, k)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return db.ErrNoValue
+	}
+	t.events = append(t.events, pendingEvent{k, eventDelete})
+	return nil
+}
+
+// Commit makes the changes in the transaction durable and then fires
+// events for them.
+func (t *tx) Commit() error {
+	if err := t.tx.Commit(); err != nil {
+		return err
+	}
+	for _, e := range t.events {
+		t.s.fireEventForKey(e.key, e.t)
+	}
+	return nil
+}
+
+// Rollback discards the changes in the transaction.
+func (t *tx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// globPrefix returns the literal portion of a globbing expression
+// that precedes the first special character.
+func globPrefix(f string) string {
+	if i := strings.IndexAny(f, `*?[\`); i >= 0 {
+		return f[:i]
+	}
+	return f
+}
+
+// prefixEnd returns the smallest string that is greater than every
+// string beginning with pfx.  If no such string exists, such as for
+// an empty prefix, then false is returned and the scan must be
+// unbounded.
+func prefixEnd(pfx string) (string, bool) {
+	b := []byte(pfx)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+// fireEventForKey maps from a key to an entity or group and fires an
+// appropriate event for the given key.
+func (s *SQLite) fireEventForKey(k string, t eventType) {
+	switch {
+	case t == eventUpdate && strings.HasPrefix(k, "/entities/"):
+		s.eF(db.Event{
+			PK:   path.Base(k),
+			Type: db.EventEntityUpdate,
+		})
+	case t == eventDelete && strings.HasPrefix(k, "/entities/"):
+		s.eF(db.Event{
+			PK:   path.Base(k),
+			Type: db.EventEntityDestroy,
+		})
+	case t == eventUpdate && strings.HasPrefix(k, "/groups/"):
+		s.eF(db.Event{
+			PK:   path.Base(k),
+			Type: db.EventGroupUpdate,
+		})
+	case t == eventDelete && strings.HasPrefix(k, "/groups/"):
+		s.eF(db.Event{
+			PK:   path.Base(k),
+			Type: db.EventGroupDestroy,
+		})
+	default:
+		s.l.Trace("Event translation called with unknown key prefix", "type", t, "key", k)
+	}
+}
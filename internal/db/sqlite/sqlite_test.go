@@ -0,0 +1,265 @@
+//go:build (linux && (386 || amd64 || arm || arm64 || s390x)) || (darwin && (amd64 || arm64)) || ((freebsd || windows) && (386 || amd64)) || ((netbsd || openbsd) && amd64)
+
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+func TestCB(t *testing.T) {
+	cb()
+}
+
+func TestNewBadPath(t *testing.T) {
+	viper.Set("core.home", "/dev/null/nothing-here")
+	_, err := New(hclog.NewNullLogger())
+	assert.NotNil(t, err)
+}
+
+func TestSetEventFunc(t *testing.T) {
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+
+	f := func(db.Event) {}
+
+	if kv.(*SQLite).eF != nil {
+		t.Log("EventFunc somehow already set!")
+	}
+
+	kv.SetEventFunc(f)
+
+	if kv.(*SQLite).eF == nil {
+		t.Error("EventFunc not set correctly!")
+	}
+}
+
+func TestPut(t *testing.T) {
+	ctx := context.Background()
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	kv.SetEventFunc(func(db.Event) {})
+
+	assert.Nil(t, kv.Put(ctx, "/entities/entity1", []byte("some data")))
+	assert.Nil(t, kv.Put(ctx, "/groups/group1", []byte("some more data")))
+
+	b, err := kv.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("some data"), b, "Data stored but incorrect")
+
+	// Overwrite the existing value
+	assert.Nil(t, kv.Put(ctx, "/entities/entity1", []byte("new data")))
+	b, err = kv.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new data"), b, "Data overwritten but incorrect")
+}
+
+func TestPersistence(t *testing.T) {
+	ctx := context.Background()
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	kv.SetEventFunc(func(db.Event) {})
+
+	assert.Nil(t, kv.Put(ctx, "/entities/entity1", []byte("some data")))
+	assert.Nil(t, kv.Close())
+
+	kv, err = New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	b, err := kv.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("some data"), b, "Data did not survive reopen")
+}
+
+func TestGet(t *testing.T) {
+	ctx := context.Background()
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	kv.SetEventFunc(func(db.Event) {})
+
+	assert.Nil(t, kv.Put(ctx, "/entities/entity1", []byte("lots of data")))
+
+	v, err := kv.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, v, []byte("lots of data"), "Data read but incorrect")
+
+	v, err = kv.Get(ctx, "/does/not/exist")
+	assert.Nil(t, v, "Data returned for key that does not exist")
+	assert.Equal(t, err, db.ErrNoValue, "KV made up some data")
+}
+
+func TestDel(t *testing.T) {
+	ctx := context.Background()
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	kv.SetEventFunc(func(db.Event) {})
+
+	assert.Nil(t, kv.Put(ctx, "/entities/entity1", []byte("lots of data")))
+	assert.Nil(t, kv.Put(ctx, "/groups/group1", []byte("lots of data")))
+
+	assert.Nil(t, kv.Del(ctx, "/entities/entity1"))
+	assert.Nil(t, kv.Del(ctx, "/groups/group1"))
+
+	_, err = kv.Get(ctx, "/entities/entity1")
+	assert.Equal(t, err, db.ErrNoValue)
+	_, err = kv.Get(ctx, "/groups/group1")
+	assert.Equal(t, err, db.ErrNoValue)
+
+	assert.Equal(t, db.ErrNoValue, kv.Del(ctx, "/entities/entity1"))
+}
+
+func TestKeys(t *testing.T) {
+	ctx := context.Background()
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	kv.SetEventFunc(func(db.Event) {})
+
+	kv.Put(ctx, "/entities/entity1", []byte("lots of data"))
+	kv.Put(ctx, "/entities/entity2", []byte("lots of data"))
+	kv.Put(ctx, "/entities/purple", []byte("lots of data"))
+	kv.Put(ctx, "/groups/group1", []byte("lots of data"))
+
+	res, err := kv.Keys(ctx, "/entities/entity*")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/entities/entity1", "/entities/entity2"}, res)
+
+	res, err = kv.Keys(ctx, "/*/*")
+	assert.Nil(t, err)
+	assert.Len(t, res, 4)
+
+	res, err = kv.Keys(ctx, "/groups/group1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/groups/group1"}, res)
+}
+
+func TestGlobPrefix(t *testing.T) {
+	cases := []struct {
+		glob    string
+		wantPfx string
+		wantEnd string
+		wantOK  bool
+	}{
+		{"/entities/*", "/entities/", "/entities0", true},
+		{"/*/*", "/", "0", true},
+		{"*", "", "", false},
+		{"/groups/g?", "/groups/g", "/groups/h", true},
+		{"/a\xff", "/a\xff", "/b", true},
+	}
+
+	for i, c := range cases {
+		pfx := globPrefix(c.glob)
+		end, ok := prefixEnd(pfx)
+		if pfx != c.wantPfx || end != c.wantEnd || ok != c.wantOK {
+			t.Errorf("%d: Got %q %q %v; Want %q %q %v", i, pfx, end, ok, c.wantPfx, c.wantEnd, c.wantOK)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	kv.SetEventFunc(func(db.Event) {})
+
+	assert.Nil(t, kv.Close())
+}
+
+func TestCapabilities(t *testing.T) {
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	kv.SetEventFunc(func(db.Event) {})
+
+	assert.Equal(t, []db.KVCapability{db.KVMutable, db.KVTransactional}, kv.Capabilities())
+}
+
+func TestTransaction(t *testing.T) {
+	ctx := context.Background()
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+	defer kv.Close()
+
+	events := []db.Event{}
+	kv.SetEventFunc(func(e db.Event) { events = append(events, e) })
+	assert.Nil(t, kv.Put(ctx, "/groups/group1", []byte("old data")))
+	events = nil
+
+	tx, err := kv.(db.TxKVStore).Begin(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Put(ctx, "/entities/entity1", []byte("new data")))
+	assert.Nil(t, tx.Del(ctx, "/groups/group1"))
+	assert.Equal(t, db.ErrNoValue, tx.Del(ctx, "/groups/missing"))
+	assert.Empty(t, events)
+	assert.Nil(t, tx.Commit())
+
+	_, err = kv.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+	_, err = kv.Get(ctx, "/groups/group1")
+	assert.Equal(t, db.ErrNoValue, err)
+	assert.Equal(t, []db.Event{
+		{Type: db.EventEntityUpdate, PK: "entity1"},
+		{Type: db.EventGroupDestroy, PK: "group1"},
+	}, events)
+
+	// A rolled back transaction changes nothing.
+	tx, err = kv.(db.TxKVStore).Begin(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Del(ctx, "/entities/entity1"))
+	assert.Nil(t, tx.Rollback())
+	_, err = kv.Get(ctx, "/entities/entity1")
+	assert.Nil(t, err)
+}
+
+type eventHandler struct{ mock.Mock }
+
+func (eh *eventHandler) FireEvent(e db.Event) {
+	eh.Called(e)
+}
+
+func TestFireEventForKey(t *testing.T) {
+	ctx := context.Background()
+	ef := eventHandler{}
+
+	viper.Set("core.home", t.TempDir())
+	kv, err := New(hclog.NewNullLogger())
+	assert.Nil(t, err)
+
+	kv.SetEventFunc(ef.FireEvent)
+
+	ef.On("FireEvent", db.Event{PK: "entity1", Type: db.EventEntityUpdate})
+	ef.On("FireEvent", db.Event{PK: "entity1", Type: db.EventEntityDestroy})
+	ef.On("FireEvent", db.Event{PK: "group1", Type: db.EventGroupUpdate})
+	ef.On("FireEvent", db.Event{PK: "group1", Type: db.EventGroupDestroy})
+
+	assert.Nil(t, kv.Put(ctx, "/entities/entity1", []byte("data")))
+	ef.AssertCalled(t, "FireEvent", db.Event{PK: "entity1", Type: db.EventEntityUpdate})
+
+	assert.Nil(t, kv.Del(ctx, "/entities/entity1"))
+	ef.AssertCalled(t, "FireEvent", db.Event{PK: "entity1", Type: db.EventEntityDestroy})
+
+	assert.Nil(t, kv.Put(ctx, "/groups/group1", []byte("data")))
+	ef.AssertCalled(t, "FireEvent", db.Event{PK: "group1", Type: db.EventGroupUpdate})
+
+	assert.Nil(t, kv.Del(ctx, "/groups/group1"))
+	ef.AssertCalled(t, "FireEvent", db.Event{PK: "group1", Type: db.EventGroupDestroy})
+
+	kv.(*SQLite).fireEventForKey("/not/an/event/key", eventUpdate)
+
+	// This assertion validates that the call for a key outside
+	// the fixed keyspace went to the default case and didn't
+	// trigger an event.
+	ef.AssertNumberOfCalls(t, "FireEvent", 4)
+}
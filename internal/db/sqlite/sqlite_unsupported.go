@@ -0,0 +1,17 @@
+//go:build !((linux && (386 || amd64 || arm || arm64 || s390x)) || (darwin && (amd64 || arm64)) || ((freebsd || windows) && (386 || amd64)) || ((netbsd || openbsd) && amd64))
+
+package sqlite
+
+import (
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/netauth/netauth/internal/startup"
+)
+
+func init() {
+	startup.RegisterCallback(cb)
+}
+
+func cb() {
+	hclog.L().Info("SQLite is not supported in this environment")
+}
@@ -0,0 +1,269 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// When trash.enabled is set, entities and groups that are deleted are
+// moved into the /trash keyspace rather than being removed outright.
+// Since they are no longer in their own keyspaces they are removed
+// from the search index and from membership resolution in the same
+// way as objects that have really been deleted, but they can be
+// restored until they are purged.  Objects are purged on request, or
+// automatically once they have been in the trash for longer than
+// trash.retention.
+const (
+	TrashEntity = "entity"
+	TrashGroup  = "group"
+)
+
+// A TrashEntry describes an object that is in the trash.
+type TrashEntry struct {
+	Kind    string
+	Name    string
+	Deleted time.Time
+	Actor   string
+}
+
+// trashRecord is the form in which trashed objects are stored.  The
+// object is kept exactly as it was stored, so that restoring it
+// doesn't depend on being able to interpret it.
+type trashRecord struct {
+	Kind    string    `json:"kind"`
+	Name    string    `json:"name"`
+	Deleted time.Time `json:"deleted"`
+	Actor   string    `json:"actor,omitempty"`
+	Object  []byte    `json:"object"`
+}
+
+// trashKey returns the key that an object of the given kind is kept
+// at while it is in the trash.  Keys are only a single level deep in
+// every store, so the kind is folded into the name.
+func trashKey(kind, name string) string {
+	return path.Join("/trash", kind+":"+name)
+}
+
+// objectKey returns the key that an object of the given kind is kept
+// at when it is not in the trash.
+func objectKey(kind, name string) (string, error) {
+	switch kind {
+	case TrashEntity:
+		return path.Join("/entities", name), nil
+	case TrashGroup:
+		return path.Join("/groups", name), nil
+	default:
+		return "", ErrNotInTrash
+	}
+}
+
+// moveToTrash removes the object at k and records it in the trash
+// along with the time and the entity that removed it.  An object of
+// the same kind and name that is already in the trash is replaced.
+func (db *DB) moveToTrash(ctx context.Context, kind, name, k string) error {
+	return db.Batch(ctx, func(ctx context.Context) error {
+		v, err := db.get(ctx, k)
+		if err != nil {
+			return err
+		}
+		rec, _ := json.Marshal(trashRecord{
+			Kind:    kind,
+			Name:    name,
+			Deleted: time.Now(),
+			Actor:   ActorFromContext(ctx),
+			Object:  v,
+		})
+		if err := db.put(ctx, trashKey(kind, name), rec); err != nil {
+			return err
+		}
+		return db.del(ctx, k)
+	})
+}
+
+// Trash returns the objects of the given kind that are in the trash,
+// oldest first.  If kind is empty then all objects are returned.
+func (db *DB) Trash(ctx context.Context, kind string) ([]TrashEntry, error) {
+	recs, err := db.trashRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := []TrashEntry{}
+	for _, r := range recs {
+		if kind != "" && r.Kind != kind {
+			continue
+		}
+		out = append(out, TrashEntry{Kind: r.Kind, Name: r.Name, Deleted: r.Deleted, Actor: r.Actor})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Deleted.Before(out[j].Deleted) })
+	return out, nil
+}
+
+// RestoreEntity moves an entity out of the trash.  ErrNotInTrash is
+// returned if there is no such entity in the trash, and
+// ErrObjectExists if an entity with the same ID has since been
+// created.  The entity's number must also not have been allocated in
+// the meantime.
+func (db *DB) RestoreEntity(ctx context.Context, ID string) error {
+	return db.restore(ctx, TrashEntity, ID)
+}
+
+// RestoreGroup moves a group out of the trash in the same way as
+// RestoreEntity.
+func (db *DB) RestoreGroup(ctx context.Context, name string) error {
+	return db.restore(ctx, TrashGroup, name)
+}
+
+func (db *DB) restore(ctx context.Context, kind, name string) error {
+	k, err := objectKey(kind, name)
+	if err != nil {
+		return err
+	}
+	return db.Batch(ctx, func(ctx context.Context) error {
+		rec, err := db.loadTrashRecord(ctx, kind, name)
+		if err != nil {
+			return err
+		}
+		switch _, err := db.get(ctx, k); err {
+		case nil:
+			return ErrObjectExists
+		case ErrNoValue:
+		default:
+			db.log.Warn("Error checking for existing object", "key", k, "error", err)
+			return ErrInternalError
+		}
+		if err := db.put(ctx, k, rec.Object); err != nil {
+			return err
+		}
+		return db.del(ctx, trashKey(kind, name))
+	})
+}
+
+// PurgeTrash permanently removes an object from the trash, along
+// with the secret history of an entity.
+func (db *DB) PurgeTrash(ctx context.Context, kind, name string) error {
+	if _, err := objectKey(kind, name); err != nil {
+		return err
+	}
+	err := db.Batch(ctx, func(ctx context.Context) error {
+		if err := db.del(ctx, trashKey(kind, name)); err != nil {
+			return err
+		}
+		if kind == TrashEntity {
+			return db.dropSecretHistory(ctx, name)
+		}
+		return nil
+	})
+	if err == ErrNoValue {
+		return ErrNotInTrash
+	}
+	return err
+}
+
+// PurgeExpiredTrash permanently removes every object that was moved
+// to the trash before the cutoff, and returns how many were removed.
+func (db *DB) PurgeExpiredTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	recs, err := db.trashRecords(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, r := range recs {
+		if !r.Deleted.Before(cutoff) {
+			continue
+		}
+		if err := db.PurgeTrash(ctx, r.Kind, r.Name); err != nil && err != ErrNotInTrash {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// loadTrashRecord reads a single object from the trash.
+func (db *DB) loadTrashRecord(ctx context.Context, kind, name string) (*trashRecord, error) {
+	v, err := db.get(ctx, trashKey(kind, name))
+	if err == ErrNoValue {
+		return nil, ErrNotInTrash
+	}
+	if err != nil {
+		db.log.Warn("Error loading object from trash", "kind", kind, "name", name, "error", err)
+		return nil, ErrInternalError
+	}
+	rec := &trashRecord{}
+	if err := json.Unmarshal(v, rec); err != nil {
+		db.log.Warn("Unreadable object in trash", "kind", kind, "name", name, "error", err)
+		return nil, ErrInternalError
+	}
+	return rec, nil
+}
+
+// trashRecords reads every object in the trash.
+func (db *DB) trashRecords(ctx context.Context) ([]*trashRecord, error) {
+	keys, err := db.kv.Keys(ctx, "/trash/*")
+	if err != nil {
+		db.log.Warn("Error listing trash", "error", err)
+		return nil, ErrInternalError
+	}
+	out := []*trashRecord{}
+	for _, k := range keys {
+		parts := strings.SplitN(path.Base(k), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		rec, err := db.loadTrashRecord(ctx, parts[0], parts[1])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+// trashFromConfig enables the trash if trash.enabled is set.  Unless
+// trash.retention is zero, objects that have been in the trash for
+// longer than it are purged, which is checked every
+// trash.purge-interval.
+func (db *DB) trashFromConfig() {
+	if !viper.GetBool("trash.enabled") {
+		return
+	}
+	db.trash = true
+
+	retention := viper.GetDuration("trash.retention")
+	if retention <= 0 {
+		return
+	}
+	every := viper.GetDuration("trash.purge-interval")
+	if every <= 0 {
+		every = time.Hour
+	}
+	db.trashDone = make(chan struct{})
+	go db.purgeTrash(every, retention, db.trashDone)
+}
+
+// purgeTrash periodically purges objects that have been in the trash
+// for longer than retention until the done channel is closed.
+func (db *DB) purgeTrash(every, retention time.Duration, done chan struct{}) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-t.C:
+			n, err := db.PurgeExpiredTrash(context.Background(), now.Add(-retention))
+			if err != nil {
+				db.log.Warn("Error purging trash", "error", err)
+			}
+			if n > 0 {
+				db.log.Info("Purged expired objects from trash", "count", n)
+			}
+		}
+	}
+}
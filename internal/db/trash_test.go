@@ -0,0 +1,127 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+
+	types "github.com/netauth/protocol"
+)
+
+func newTrashDB(t *testing.T) *db.DB {
+	viper.Set("trash.enabled", true)
+	viper.Set("trash.retention", 0)
+	defer viper.Set("trash.enabled", false)
+	return newMemoryDB(t)
+}
+
+func TestTrashEntity(t *testing.T) {
+	ctx := db.WithActor(context.Background(), "admin")
+	m := newTrashDB(t)
+
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity1", 1)))
+	assert.Nil(t, m.DeleteEntity(ctx, "entity1"))
+	assert.Equal(t, db.ErrUnknownEntity, m.DeleteEntity(ctx, "entity1"))
+
+	_, err := m.LoadEntity(ctx, "entity1")
+	assert.Equal(t, db.ErrUnknownEntity, err)
+	res, err := m.SearchEntities(ctx, db.SearchRequest{Expression: "ID:entity1"})
+	assert.Nil(t, err)
+	assert.Len(t, res, 0)
+
+	trash, err := m.Trash(ctx, db.TrashEntity)
+	assert.Nil(t, err)
+	if assert.Len(t, trash, 1) {
+		assert.Equal(t, "entity1", trash[0].Name)
+		assert.Equal(t, "admin", trash[0].Actor)
+		assert.WithinDuration(t, time.Now(), trash[0].Deleted, time.Minute)
+	}
+	trash, err = m.Trash(ctx, db.TrashGroup)
+	assert.Nil(t, err)
+	assert.Len(t, trash, 0)
+
+	assert.Nil(t, m.RestoreEntity(ctx, "entity1"))
+	e, err := m.LoadEntity(ctx, "entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), e.GetNumber())
+	assert.Equal(t, db.ErrNotInTrash, m.RestoreEntity(ctx, "entity1"))
+
+	// An entity can't be restored over one that has since been
+	// created, nor can it take a number that has been reused.
+	assert.Nil(t, m.DeleteEntity(ctx, "entity1"))
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity1", 2)))
+	assert.Equal(t, db.ErrObjectExists, m.RestoreEntity(ctx, "entity1"))
+
+	// Deleting the new entity replaces the old one in the trash.
+	assert.Nil(t, m.DeleteEntity(ctx, "entity1"))
+	assert.Nil(t, m.RestoreEntity(ctx, "entity1"))
+	e, err = m.LoadEntity(ctx, "entity1")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), e.GetNumber())
+
+	assert.Nil(t, m.DeleteEntity(ctx, "entity1"))
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity2", 2)))
+	assert.Equal(t, db.ErrDuplicateNumber, m.RestoreEntity(ctx, "entity1"))
+
+	assert.Nil(t, m.PurgeTrash(ctx, db.TrashEntity, "entity1"))
+	assert.Equal(t, db.ErrNotInTrash, m.PurgeTrash(ctx, db.TrashEntity, "entity1"))
+}
+
+func TestTrashGroup(t *testing.T) {
+	ctx := context.Background()
+	m := newTrashDB(t)
+
+	assert.Nil(t, m.SaveGroup(ctx, &types.Group{Name: proto.String("group1"), Number: proto.Int32(1)}))
+	assert.Nil(t, m.DeleteGroup(ctx, "group1"))
+	assert.Equal(t, db.ErrUnknownGroup, m.DeleteGroup(ctx, "group1"))
+
+	names, err := m.DiscoverGroupNames(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, names, 0)
+
+	assert.Nil(t, m.RestoreGroup(ctx, "group1"))
+	_, err = m.LoadGroup(ctx, "group1")
+	assert.Nil(t, err)
+	assert.Equal(t, db.ErrNotInTrash, m.RestoreGroup(ctx, "group1"))
+	assert.Equal(t, db.ErrNotInTrash, m.PurgeTrash(ctx, "widget", "group1"))
+}
+
+func TestPurgeExpiredTrash(t *testing.T) {
+	ctx := context.Background()
+	m := newTrashDB(t)
+
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity1", 1)))
+	assert.Nil(t, m.DeleteEntity(ctx, "entity1"))
+	cutoff := time.Now()
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity2", 2)))
+	assert.Nil(t, m.DeleteEntity(ctx, "entity2"))
+
+	n, err := m.PurgeExpiredTrash(ctx, cutoff)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	trash, err := m.Trash(ctx, "")
+	assert.Nil(t, err)
+	if assert.Len(t, trash, 1) {
+		assert.Equal(t, "entity2", trash[0].Name)
+	}
+}
+
+func TestDeleteWithoutTrash(t *testing.T) {
+	ctx := context.Background()
+	m := newMemoryDB(t)
+
+	assert.Nil(t, m.SaveEntity(ctx, entity("entity1", 1)))
+	assert.Nil(t, m.DeleteEntity(ctx, "entity1"))
+	assert.Equal(t, db.ErrNotInTrash, m.RestoreEntity(ctx, "entity1"))
+	trash, err := m.Trash(ctx, "")
+	assert.Nil(t, err)
+	assert.Len(t, trash, 0)
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"sync"
 
 	"github.com/hashicorp/go-hclog"
 
@@ -27,6 +28,39 @@ type KVStore interface {
 	SetEventFunc(func(Event))
 }
 
+// A TxKVStore is a KVStore that is able to group several mutations
+// together and apply them atomically.  Stores that implement this
+// interface should also advertise the KVTransactional capability.
+type TxKVStore interface {
+	KVStore
+
+	Begin(context.Context) (KVTx, error)
+}
+
+// A KVTx is a set of mutations that are either all applied when
+// Commit is called, or none of which are applied at all.  Events for
+// the mutations in a transaction must not be fired until the
+// transaction has been committed.  Put and Del otherwise behave the
+// same as the corresponding methods on the store that created the
+// transaction.  A transaction must not be used after Commit or
+// Rollback has been called.
+type KVTx interface {
+	Put(context.Context, string, []byte) error
+	Del(context.Context, string) error
+
+	Commit() error
+	Rollback() error
+}
+
+// A CompactingKVStore is a KVStore that keeps superseded values on
+// disk until it is compacted.  Compact reclaims the space that they
+// use, and must be safe to call while the store is in use.
+type CompactingKVStore interface {
+	KVStore
+
+	Compact(context.Context) error
+}
+
 // A DB is a collection of methods satisfying tree.DB, and which read
 // and write data to a KVStore
 type DB struct {
@@ -34,6 +68,22 @@ type DB struct {
 	kv  KVStore
 	cbs map[string]Callback
 
+	// commitMu serializes commits so that the revisions of the
+	// objects in a batch can't change between being checked and
+	// being written.
+	commitMu sync.Mutex
+
+	journal     *Journal
+	journalDone chan struct{}
+
+	entityNumbers *numberIndex
+	groupNumbers  *numberIndex
+
+	cache *objectCache
+
+	trash     bool
+	trashDone chan struct{}
+
 	*Index
 }
 
@@ -48,6 +98,11 @@ const (
 	// necessarily mean that the KV isn't mutable, only that it
 	// would prefer you not.
 	KVMutable KVCapability = iota
+
+	// KVTransactional signifies that the key/value store
+	// implements TxKVStore, and that batches of writes may be
+	// committed atomically.
+	KVTransactional
 )
 
 // Callback is a function type registered by an external customer that
@@ -83,11 +138,85 @@ const (
 	EventGroupDestroy
 )
 
+// String returns a short name for the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventEntityCreate:
+		return "EntityCreate"
+	case EventEntityUpdate:
+		return "EntityUpdate"
+	case EventEntityDestroy:
+		return "EntityDestroy"
+	case EventGroupCreate:
+		return "GroupCreate"
+	case EventGroupUpdate:
+		return "GroupUpdate"
+	case EventGroupDestroy:
+		return "GroupDestroy"
+	default:
+		return "Unknown"
+	}
+}
+
 // SearchRequest is an expression that can be interpreted by the
 // default util search system, or translated by a storage layer to
 // provide a more optimized searching experience.
 type SearchRequest struct {
 	Expression string
+
+	// Syntax selects how Expression is interpreted.  Filters in
+	// the LDAP syntax that refer to group membership are
+	// answered by Resolver.
+	Syntax   SearchSyntax
+	Resolver MembershipResolver
+
+	// PageSize is the most results that will be returned.  If it
+	// is zero then every result is returned, up to a fixed limit.
+	PageSize int
+
+	// Offset is the number of results to skip.  Cursor may be
+	// used instead to continue from where a previous page ended,
+	// in which case Offset is ignored.
+	Offset int
+	Cursor string
+
+	// SortBy lists the fields that results are ordered by.  A
+	// field prefixed with "-" is sorted in descending order.
+	// Results are ordered by relevance if no fields are given.
+	SortBy []string
+}
+
+// SearchSyntax is the language that a search expression is written
+// in.
+type SearchSyntax int
+
+const (
+	// SyntaxQueryString is the bleve query string syntax, which
+	// is used unless another syntax is requested.
+	SyntaxQueryString SearchSyntax = iota
+
+	// SyntaxLDAP is the filter syntax from RFC 4515.
+	SyntaxLDAP
+)
+
+// A MembershipResolver answers questions about group membership on
+// behalf of searches.  The resolver maintained by the tree satisfies
+// this interface.
+type MembershipResolver interface {
+	MembersOfGroup(string) []string
+	GroupsForEntity(string) []string
+}
+
+// SearchResult describes the page of results returned by a search.
+type SearchResult struct {
+	// Total is the number of results that matched, regardless
+	// of how many were returned.
+	Total int
+
+	// Next is the cursor to pass in the next request to get the
+	// next page of results.  It is empty once there are no more
+	// results.
+	Next string
 }
 
 // These allow the index to get limited access to the db itself.  You
@@ -96,3 +225,4 @@ type SearchRequest struct {
 // is not allowed.
 type loadEntityFunc func(context.Context, string) (*types.Entity, error)
 type loadGroupFunc func(context.Context, string) (*types.Group, error)
+type loadRevisionFunc func(context.Context, string) (revision, error)
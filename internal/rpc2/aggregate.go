@@ -0,0 +1,84 @@
+package rpc2
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+// EntityAggregate counts the entities that have each value of the
+// requested fields.  The request holds the fields to count as a list
+// under "fields", and optionally an "expression" that limits which
+// entities are counted and the most values to return for each field
+// as "size".  Like searching, this requires no authorization.
+func (s *Server) EntityAggregate(ctx context.Context, r *structpb.Struct) (*structpb.Struct, error) {
+	return s.aggregate(ctx, "EntityAggregate", r, s.Manager.AggregateEntities)
+}
+
+// GroupAggregate counts the groups that have each value of the
+// requested fields, and takes the same request as EntityAggregate.
+func (s *Server) GroupAggregate(ctx context.Context, r *structpb.Struct) (*structpb.Struct, error) {
+	return s.aggregate(ctx, "GroupAggregate", r, s.Manager.AggregateGroups)
+}
+
+func (s *Server) aggregate(ctx context.Context, method string, r *structpb.Struct, f func(context.Context, db.AggregateRequest) ([]db.Facet, error)) (*structpb.Struct, error) {
+	req := db.AggregateRequest{
+		Expression: r.GetFields()["expression"].GetStringValue(),
+		Size:       int(r.GetFields()["size"].GetNumberValue()),
+	}
+	for _, v := range r.GetFields()["fields"].GetListValue().GetValues() {
+		req.Fields = append(req.Fields, v.GetStringValue())
+	}
+
+	facets, err := f(ctx, req)
+	switch err {
+	case nil:
+		return facetsToStruct(facets), nil
+	case db.ErrBadSearch:
+		s.log.Warn("Bad aggregation request",
+			"method", method,
+			"expr", req.Expression,
+			"fields", req.Fields,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &structpb.Struct{}, ErrMalformedRequest
+	default:
+		s.log.Warn("Aggregation Error",
+			"method", method,
+			"expr", req.Expression,
+			"fields", req.Fields,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return &structpb.Struct{}, ErrInternal
+	}
+}
+
+// facetsToStruct converts the result of an aggregation to the message
+// that is returned to clients.
+func facetsToStruct(facets []db.Facet) *structpb.Struct {
+	l := make([]interface{}, len(facets))
+	for i, f := range facets {
+		terms := make([]interface{}, len(f.Terms))
+		for j, t := range f.Terms {
+			terms[j] = map[string]interface{}{
+				"term":  t.Term,
+				"count": t.Count,
+			}
+		}
+		l[i] = map[string]interface{}{
+			"field":   f.Field,
+			"missing": f.Missing,
+			"other":   f.Other,
+			"terms":   terms,
+		}
+	}
+	// All of the values are strings and numbers, so this can't
+	// fail.
+	st, _ := structpb.NewStruct(map[string]interface{}{"facets": l})
+	return st
+}
@@ -0,0 +1,62 @@
+package rpc2
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func aggregateRequest(t *testing.T, expr string, fields ...interface{}) *structpb.Struct {
+	st, err := structpb.NewStruct(map[string]interface{}{
+		"expression": expr,
+		"fields":     fields,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestEntityAggregate(t *testing.T) {
+	s, _, m := newServerWithRefs(t)
+	initTree(t, m)
+	m.LockEntity(context.Background(), "entity1")
+
+	res, err := s.EntityAggregate(context.Background(), aggregateRequest(t, "", "Locked"))
+	assert.Nil(t, err)
+	facets := res.GetFields()["facets"].GetListValue().GetValues()
+	if assert.Len(t, facets, 1) {
+		f := facets[0].GetStructValue().GetFields()
+		assert.Equal(t, "Locked", f["field"].GetStringValue())
+		terms := f["terms"].GetListValue().GetValues()
+		if assert.Len(t, terms, 2) {
+			assert.Equal(t, "false", terms[0].GetStructValue().GetFields()["term"].GetStringValue())
+			assert.Equal(t, 2.0, terms[0].GetStructValue().GetFields()["count"].GetNumberValue())
+		}
+	}
+
+	_, err = s.EntityAggregate(context.Background(), aggregateRequest(t, "", "secret"))
+	assert.Equal(t, ErrMalformedRequest, err)
+}
+
+func TestGroupAggregate(t *testing.T) {
+	s, _, m := newServerWithRefs(t)
+	initTree(t, m)
+
+	res, err := s.GroupAggregate(context.Background(), aggregateRequest(t, "", "ManagedBy"))
+	assert.Nil(t, err)
+	facets := res.GetFields()["facets"].GetListValue().GetValues()
+	if assert.Len(t, facets, 1) {
+		f := facets[0].GetStructValue().GetFields()
+		assert.Equal(t, 1.0, f["missing"].GetNumberValue())
+		terms := f["terms"].GetListValue().GetValues()
+		if assert.Len(t, terms, 1) {
+			assert.Equal(t, "group1", terms[0].GetStructValue().GetFields()["term"].GetStringValue())
+		}
+	}
+
+	_, err = s.GroupAggregate(context.Background(), aggregateRequest(t, ""))
+	assert.Equal(t, ErrMalformedRequest, err)
+}
@@ -2,7 +2,10 @@ package rpc2
 
 import (
 	"context"
+	"errors"
 
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/tree"
 	"github.com/netauth/netauth/pkg/token"
 
 	types "github.com/netauth/protocol"
@@ -138,13 +141,28 @@ func (s *Server) AuthChangeSecret(ctx context.Context, r *pb.AuthRequest) (*pb.E
 	}
 
 	// Set the secret
-	if err := s.SetSecret(ctx, e.GetID(), r.GetSecret()); err != nil {
+	err = s.SetSecret(ctx, e.GetID(), r.GetSecret())
+	if errors.Is(err, tree.ErrSecretPolicy) {
+		s.log.Info("Secret rejected by policy",
+			"method", "AuthChangeSecret",
+			"entity", e.GetID(),
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return &pb.Empty{}, secretPolicyError(err)
+	}
+	if err != nil {
 		s.log.Warn("Secret Manipulation Error",
 			"entity", e.GetID(),
 			"service", getServiceName(ctx),
 			"client", getClientName(ctx),
 			"error", err,
 		)
+		if err == db.ErrConflict {
+			return &pb.Empty{}, ErrConflict
+		}
 		return &pb.Empty{}, ErrInternal
 	}
 	s.log.Info("Secret Changed",
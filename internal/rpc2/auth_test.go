@@ -2,8 +2,12 @@ package rpc2
 
 import (
 	"context"
+	"strings"
 	"testing"
 
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/netauth/netauth/pkg/token/null"
@@ -223,3 +227,29 @@ func TestAuthChangeSecret(t *testing.T) {
 		}
 	}
 }
+
+func TestSecretPolicy(t *testing.T) {
+	viper.Set("secret.policy.reject-identity", true)
+	defer viper.Set("secret.policy.reject-identity", nil)
+
+	s := newServer(t)
+	initTree(t, s.Manager)
+
+	_, err := s.EntityCreate(PrivilegedContext, &pb.EntityRequest{
+		Entity: &types.Entity{
+			ID:     proto.String("test1"),
+			Secret: proto.String("test1-secret"),
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "must not contain the entity ID") {
+		t.Errorf("EntityCreate: Got %v; Want a policy violation", err)
+	}
+
+	_, err = s.AuthChangeSecret(PrivilegedContext, &pb.AuthRequest{
+		Entity: &types.Entity{ID: proto.String("entity1")},
+		Secret: proto.String("my-entity1"),
+	})
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "must not contain the entity ID") {
+		t.Errorf("AuthChangeSecret: Got %v; Want a policy violation", err)
+	}
+}
@@ -13,4 +13,5 @@ var (
 	UnprivilegedContext    = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", null.ValidEmptyToken))
 	UnauthenticatedContext = metadata.NewIncomingContext(context.Background(), nil)
 	InvalidAuthContext     = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", null.InvalidToken))
+	UnknownRangeContext    = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", null.ValidToken, "number-range", "does-not-exist"))
 )
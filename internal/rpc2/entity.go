@@ -2,6 +2,7 @@ package rpc2
 
 import (
 	"context"
+	"errors"
 
 	"github.com/netauth/netauth/internal/db"
 	"github.com/netauth/netauth/internal/tree"
@@ -14,12 +15,46 @@ import (
 // correct token is held, which must contain either CREATE_ENTITY or
 // GLOBAL_ROOT permissions.
 func (s *Server) EntityCreate(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_CREATE_ENTITY); err != nil {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_CREATE_ENTITY)
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
 	e := r.GetEntity()
-	switch err := s.CreateEntity(ctx, e.GetID(), e.GetNumber(), e.GetSecret()); err {
+	err = s.CreateEntity(withNumberRange(ctx), e.GetID(), e.GetNumber(), e.GetSecret())
+	if errors.Is(err, tree.ErrSecretPolicy) {
+		s.log.Info("Secret rejected by policy",
+			"method", "EntityCreate",
+			"entity", e.GetID(),
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return &pb.Empty{}, secretPolicyError(err)
+	}
+	if s.conflicted(ctx, "EntityCreate", err) {
+		return &pb.Empty{}, ErrConflict
+	}
+	switch err {
+	case db.ErrUnknownNumberRange:
+		s.log.Warn("Unknown number range requested",
+			"entity", e.GetID(),
+			"range", getSingleStringFromMetadata(ctx, "number-range"),
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
+	case db.ErrNumberRangeExhausted:
+		s.log.Warn("Number range exhausted",
+			"entity", e.GetID(),
+			"range", getSingleStringFromMetadata(ctx, "number-range"),
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrNumbersExhausted
 	case tree.ErrDuplicateEntityID, tree.ErrDuplicateNumber:
 		s.log.Warn("Attempt to create duplicate entity",
 			"entity", e.GetID(),
@@ -56,12 +91,17 @@ func (s *Server) EntityCreate(ctx context.Context, r *pb.EntityRequest) (*pb.Emp
 // must be in possession of a token with MODIFY_ENTITY_META
 // capabilities.
 func (s *Server) EntityUpdate(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META); err != nil {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META)
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
 	de := r.GetData()
-	switch err := s.UpdateEntityMeta(ctx, de.GetID(), de.GetMeta()); err {
+	err = s.UpdateEntityMeta(ctx, de.GetID(), de.GetMeta())
+	if s.conflicted(ctx, "EntityUpdate", err) {
+		return &pb.Empty{}, ErrConflict
+	}
+	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
 			"method", "EntityUpdate",
@@ -100,7 +140,7 @@ func (s *Server) EntityInfo(ctx context.Context, r *pb.EntityRequest) (*pb.ListO
 	switch ent, err := s.FetchEntity(ctx, e.GetID()); err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
-			"method", "EntityUpdate",
+			"method", "EntityInfo",
 			"entity", e.GetID(),
 			"service", getServiceName(ctx),
 			"client", getClientName(ctx),
@@ -124,13 +164,74 @@ func (s *Server) EntityInfo(ctx context.Context, r *pb.EntityRequest) (*pb.ListO
 	}
 }
 
+// EntityByNumber provides information on the entity that holds the
+// number in the request.  This is a direct lookup that does not go
+// through the search index, so it is suitable for resolving UIDs.
+// The list returned is guaranteed to be of length 1.
+func (s *Server) EntityByNumber(ctx context.Context, r *pb.EntityRequest) (*pb.ListOfEntities, error) {
+	e := r.GetEntity()
+	if e == nil || e.Number == nil {
+		return &pb.ListOfEntities{}, ErrMalformedRequest
+	}
+
+	switch ent, err := s.FetchEntityByNumber(ctx, e.GetNumber()); err {
+	case db.ErrUnknownEntity:
+		s.log.Warn("Entity does not exist!",
+			"method", "EntityByNumber",
+			"number", e.GetNumber(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.ListOfEntities{}, ErrDoesNotExist
+	case nil:
+		s.log.Info("Dumped Entity Info",
+			"entity", ent.GetID(),
+			"number", e.GetNumber(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.ListOfEntities{Entities: []*types.Entity{ent}}, nil
+	default:
+		s.log.Warn("Error fetching entity",
+			"number", e.GetNumber(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return &pb.ListOfEntities{}, ErrInternal
+	}
+}
+
 // EntitySearch searches all entities and returns the entities that
-// had been found.
+// had been found.  Results may be paged and sorted with the request
+// metadata described by searchRequest, and the total number of
+// matches is returned in the response header.
 func (s *Server) EntitySearch(ctx context.Context, r *pb.SearchRequest) (*pb.ListOfEntities, error) {
 	expr := r.GetExpression()
 
-	res, err := s.SearchEntities(ctx, db.SearchRequest{Expression: expr})
+	req, err := searchRequest(ctx, expr)
 	if err != nil {
+		s.log.Warn("Malformed search paging",
+			"expr", expr,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.ListOfEntities{}, err
+	}
+
+	res, page, err := s.SearchEntityPage(ctx, req)
+	switch err {
+	case nil:
+		setSearchHeader(ctx, page)
+		return &pb.ListOfEntities{Entities: res}, nil
+	case db.ErrBadSearch:
+		s.log.Warn("Bad search request",
+			"expr", expr,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.ListOfEntities{}, ErrMalformedRequest
+	default:
 		s.log.Warn("Search Error",
 			"expr", expr,
 			"service", getServiceName(ctx),
@@ -139,8 +240,6 @@ func (s *Server) EntitySearch(ctx context.Context, r *pb.SearchRequest) (*pb.Lis
 		)
 		return &pb.ListOfEntities{}, ErrInternal
 	}
-
-	return &pb.ListOfEntities{Entities: res}, nil
 }
 
 // EntityUM handles both updates, and reads to the untyped metadata
@@ -177,6 +276,9 @@ func (s *Server) EntityUM(ctx context.Context, r *pb.KVRequest) (*pb.ListOfStrin
 	// At this point, we're either in a read-only query, or in a
 	// write one that has been authorized.
 	meta, err := s.ManageUntypedEntityMeta(ctx, r.GetTarget(), r.GetAction().String(), r.GetKey(), r.GetValue())
+	if s.conflicted(ctx, "EntityUM", err) {
+		return &pb.ListOfStrings{}, ErrConflict
+	}
 	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
@@ -248,11 +350,15 @@ func (s *Server) EntityKVGet(ctx context.Context, r *pb.KV2Request) (*pb.ListOfK
 // EntityKVAdd takes the input KV2 data and adds it to an entity if an
 // only if it does not conflict with an existing key.
 func (s *Server) EntityKVAdd(ctx context.Context, r *pb.KV2Request) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META); err != nil {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META)
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
-	err := s.Manager.EntityKVAdd(ctx, r.GetTarget(), []*types.KVData{r.GetData()})
+	err = s.Manager.EntityKVAdd(ctx, r.GetTarget(), []*types.KVData{r.GetData()})
+	if s.conflicted(ctx, "EntityKVAdd", err) {
+		return &pb.Empty{}, ErrConflict
+	}
 	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
@@ -294,11 +400,15 @@ func (s *Server) EntityKVAdd(ctx context.Context, r *pb.KV2Request) (*pb.Empty,
 // EntityKVDel removes an existing key from an entity.  If the key is
 // not present an error will be returned.
 func (s *Server) EntityKVDel(ctx context.Context, r *pb.KV2Request) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META); err != nil {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META)
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
-	err := s.Manager.EntityKVDel(ctx, r.GetTarget(), []*types.KVData{r.GetData()})
+	err = s.Manager.EntityKVDel(ctx, r.GetTarget(), []*types.KVData{r.GetData()})
+	if s.conflicted(ctx, "EntityKVDel", err) {
+		return &pb.Empty{}, ErrConflict
+	}
 	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
@@ -340,11 +450,15 @@ func (s *Server) EntityKVDel(ctx context.Context, r *pb.KV2Request) (*pb.Empty,
 // The key must already exist on the entity or an error will be
 // returned.
 func (s *Server) EntityKVReplace(ctx context.Context, r *pb.KV2Request) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META); err != nil {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_ENTITY_META)
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
-	err := s.Manager.EntityKVReplace(ctx, r.GetTarget(), []*types.KVData{r.GetData()})
+	err = s.Manager.EntityKVReplace(ctx, r.GetTarget(), []*types.KVData{r.GetData()})
+	if s.conflicted(ctx, "EntityKVReplace", err) {
+		return &pb.Empty{}, ErrConflict
+	}
 	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
@@ -415,6 +529,9 @@ func (s *Server) EntityKeys(ctx context.Context, r *pb.KVRequest) (*pb.ListOfStr
 	// At this point, we're either in a read-only query, or in a
 	// write one that has been authorized.
 	keys, err := s.UpdateEntityKeys(ctx, r.GetTarget(), r.GetAction().String(), r.GetKey(), r.GetValue())
+	if s.conflicted(ctx, "EntityKeys", err) {
+		return &pb.ListOfStrings{}, ErrConflict
+	}
 	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
@@ -448,12 +565,17 @@ func (s *Server) EntityKeys(ctx context.Context, r *pb.KVRequest) (*pb.ListOfStr
 // generally discouraged, but if you must then this function will do
 // it.
 func (s *Server) EntityDestroy(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_DESTROY_ENTITY); err != nil {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_DESTROY_ENTITY)
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
 	e := r.GetEntity()
-	switch err := s.DestroyEntity(ctx, e.GetID()); err {
+	err = s.DestroyEntity(ctx, e.GetID())
+	if s.conflicted(ctx, "EntityDestroy", err) {
+		return &pb.Empty{}, ErrConflict
+	}
+	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
 			"method", "EntityDestroy",
@@ -485,12 +607,17 @@ func (s *Server) EntityDestroy(ctx context.Context, r *pb.EntityRequest) (*pb.Em
 
 // EntityLock sets the lock flag on an entity.
 func (s *Server) EntityLock(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_LOCK_ENTITY); err != nil {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_LOCK_ENTITY)
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
 	e := r.GetEntity()
-	switch err := s.LockEntity(ctx, e.GetID()); err {
+	err = s.LockEntity(ctx, e.GetID())
+	if s.conflicted(ctx, "EntityLock", err) {
+		return &pb.Empty{}, ErrConflict
+	}
+	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
 			"method", "EntityLock",
@@ -522,12 +649,17 @@ func (s *Server) EntityLock(ctx context.Context, r *pb.EntityRequest) (*pb.Empty
 
 // EntityUnlock clears the lock flag on an entity.
 func (s *Server) EntityUnlock(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_UNLOCK_ENTITY); err != nil {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_UNLOCK_ENTITY)
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
 	e := r.GetEntity()
-	switch err := s.UnlockEntity(ctx, e.GetID()); err {
+	err = s.UnlockEntity(ctx, e.GetID())
+	if s.conflicted(ctx, "EntityUnlock", err) {
+		return &pb.Empty{}, ErrConflict
+	}
+	switch err {
 	case db.ErrUnknownEntity:
 		s.log.Warn("Entity does not exist!",
 			"method", "EntityUnlock",
@@ -2,9 +2,11 @@ package rpc2
 
 import (
 	"context"
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/netauth/netauth/internal/db"
 	"google.golang.org/protobuf/proto"
@@ -89,6 +91,30 @@ func TestEntityCreate(t *testing.T) {
 			wantErr:  ErrInternal,
 			readonly: false,
 		},
+		{
+			// Fails, number is held by admin
+			ctx: PrivilegedContext,
+			req: pb.EntityRequest{
+				Entity: &types.Entity{
+					ID:     proto.String("test1"),
+					Number: proto.Int32(1),
+				},
+			},
+			wantErr:  ErrExists,
+			readonly: false,
+		},
+		{
+			// Fails, number range does not exist
+			ctx: UnknownRangeContext,
+			req: pb.EntityRequest{
+				Entity: &types.Entity{
+					ID:     proto.String("test1"),
+					Number: proto.Int32(-1),
+				},
+			},
+			wantErr:  ErrMalformedRequest,
+			readonly: false,
+		},
 	}
 
 	for i, c := range cases {
@@ -251,6 +277,53 @@ func TestEntityInfo(t *testing.T) {
 	}
 }
 
+func TestEntityByNumber(t *testing.T) {
+	cases := []struct {
+		req     *pb.EntityRequest
+		wantErr error
+		wantLen int
+	}{
+		{
+			// Works
+			req: &pb.EntityRequest{
+				Entity: &types.Entity{
+					Number: proto.Int32(3),
+				},
+			},
+			wantErr: nil,
+			wantLen: 1,
+		},
+		{
+			// Fails, no entity has the number
+			req: &pb.EntityRequest{
+				Entity: &types.Entity{
+					Number: proto.Int32(99),
+				},
+			},
+			wantErr: ErrDoesNotExist,
+			wantLen: 0,
+		},
+		{
+			// Fails, no number
+			req: &pb.EntityRequest{
+				Entity: &types.Entity{
+					ID: proto.String("entity1"),
+				},
+			},
+			wantErr: ErrMalformedRequest,
+			wantLen: 0,
+		},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		if res, err := s.EntityByNumber(context.Background(), c.req); err != c.wantErr || len(res.Entities) != c.wantLen {
+			t.Errorf("%d: Got %d, %v; Want %d, %v", i, len(res.Entities), err, c.wantLen, c.wantErr)
+		}
+	}
+}
+
 func TestEntitySearch(t *testing.T) {
 	cases := []struct {
 		req     pb.SearchRequest
@@ -282,6 +355,41 @@ func TestEntitySearch(t *testing.T) {
 	}
 }
 
+func TestEntitySearchPaged(t *testing.T) {
+	cases := []struct {
+		md      metadata.MD
+		expr    string
+		wantErr error
+		wantIDs []string
+	}{
+		{metadata.Pairs("page-size", "2", "sort-by", "ID"), "*", nil, []string{"admin", "entity1"}},
+		{metadata.Pairs("page-size", "1", "page-offset", "1", "sort-by", "-ID"), "*", nil, []string{"entity1"}},
+		{metadata.Pairs("page-size", "many"), "*", ErrMalformedRequest, nil},
+		{metadata.Pairs("page-cursor", "bogus"), "*", ErrMalformedRequest, nil},
+		{metadata.Pairs(), "", ErrMalformedRequest, nil},
+		{metadata.Pairs("search-syntax", "ldap"), "(&(objectClass=posixAccount)(memberOf=group1))", nil, []string{"entity1"}},
+		{metadata.Pairs("search-syntax", "ldap"), "(memberOf=group1", ErrMalformedRequest, nil},
+		{metadata.Pairs("search-syntax", "sql"), "*", ErrMalformedRequest, nil},
+	}
+
+	for i, c := range cases {
+		s, _, _ := newServerWithRefs(t)
+		initTree(t, s.Manager)
+		ctx := metadata.NewIncomingContext(context.Background(), c.md)
+		res, err := s.EntitySearch(ctx, &pb.SearchRequest{Expression: &c.expr})
+		if err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		ids := []string{}
+		for _, e := range res.GetEntities() {
+			ids = append(ids, e.GetID())
+		}
+		if c.wantIDs != nil && !reflect.DeepEqual(ids, c.wantIDs) {
+			t.Errorf("%d: Got %v; Want %v", i, ids, c.wantIDs)
+		}
+	}
+}
+
 func TestEntityUM(t *testing.T) {
 	cases := []struct {
 		ctx      context.Context
@@ -1,8 +1,15 @@
 package rpc2
 
 import (
+	"context"
+	"errors"
+	"strings"
+
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/tree"
 )
 
 var (
@@ -49,4 +56,42 @@ var (
 	// entity or group that does not exist, or when an expansion
 	// that doesn't exist is modified.
 	ErrDoesNotExist = status.Errorf(codes.NotFound, "The requested resource does not exist")
+
+	// ErrConflict is returned if the object being changed was
+	// modified by another request at the same time, and the
+	// change could not be applied after several attempts.  The
+	// request may be retried as-is.
+	ErrConflict = status.Errorf(codes.Aborted, "The resource was modified concurrently, please retry")
+
+	// ErrNumbersExhausted is returned if a number was to be
+	// allocated automatically, but every number in the range has
+	// already been allocated.
+	ErrNumbersExhausted = status.Errorf(codes.ResourceExhausted, "No numbers remain in the requested range")
 )
+
+// conflicted reports whether err is a conflicting modification, and
+// logs it against method if it is.  Handlers that modify the tree
+// check this before handling any other errors, and return
+// ErrConflict if it is true.
+func (s *Server) conflicted(ctx context.Context, method string, err error) bool {
+	if err != db.ErrConflict {
+		return false
+	}
+	s.log.Warn("Conflicting modification",
+		"method", method,
+		"authority", getTokenClaims(ctx).EntityID,
+		"service", getServiceName(ctx),
+		"client", getClientName(ctx),
+	)
+	return true
+}
+
+// secretPolicyError converts an error from the secret policy into one
+// that tells the client every reason that the secret was rejected.
+func secretPolicyError(err error) error {
+	var pe *tree.SecretPolicyError
+	if !errors.As(err, &pe) {
+		return status.Errorf(codes.InvalidArgument, "The secret does not satisfy the secret policy")
+	}
+	return status.Errorf(codes.InvalidArgument, "The secret does not satisfy the secret policy: %s", strings.Join(pe.Reasons, "; "))
+}
@@ -0,0 +1,124 @@
+package rpc2
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pb "github.com/netauth/protocol/v2"
+)
+
+// The protocol is versioned separately from the server, so features
+// that it has no messages for yet are served from a supplementary
+// service that is registered alongside NetAuth2 on the same
+// listener.  The service uses the existing protocol messages where
+// they fit and the well known types where they don't, so that any
+// client built against the protocol is able to call it without
+// generated stubs.
+
+// ExtServer is the set of methods that are served by ExtServiceDesc.
+type ExtServer interface {
+	EntityTrash(context.Context, *pb.Empty) (*structpb.Struct, error)
+	EntityRestore(context.Context, *pb.EntityRequest) (*pb.Empty, error)
+	EntityPurge(context.Context, *pb.EntityRequest) (*pb.Empty, error)
+	EntityByNumber(context.Context, *pb.EntityRequest) (*pb.ListOfEntities, error)
+
+	GroupTrash(context.Context, *pb.Empty) (*structpb.Struct, error)
+	GroupRestore(context.Context, *pb.GroupRequest) (*pb.Empty, error)
+	GroupPurge(context.Context, *pb.GroupRequest) (*pb.Empty, error)
+	GroupByNumber(context.Context, *pb.GroupRequest) (*pb.ListOfGroups, error)
+
+	EntityAggregate(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	GroupAggregate(context.Context, *structpb.Struct) (*structpb.Struct, error)
+
+	SystemChains(context.Context, *pb.Empty) (*structpb.Struct, error)
+}
+
+var _ ExtServer = (*Server)(nil)
+
+// ExtServiceName is the name under which the supplementary service is
+// registered.  Methods are called as /<ExtServiceName>/<Method>.
+const ExtServiceName = "netauth.v2.NetAuth2Ext"
+
+// ExtServiceDesc describes the supplementary service so that it can
+// be registered with grpc.Server.RegisterService.
+var ExtServiceDesc = grpc.ServiceDesc{
+	ServiceName: ExtServiceName,
+	HandlerType: (*ExtServer)(nil),
+	Methods: []grpc.MethodDesc{
+		extMethod("EntityTrash", func() interface{} { return new(pb.Empty) },
+			func(s ExtServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.EntityTrash(ctx, r.(*pb.Empty))
+			}),
+		extMethod("EntityRestore", func() interface{} { return new(pb.EntityRequest) },
+			func(s ExtServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.EntityRestore(ctx, r.(*pb.EntityRequest))
+			}),
+		extMethod("EntityPurge", func() interface{} { return new(pb.EntityRequest) },
+			func(s ExtServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.EntityPurge(ctx, r.(*pb.EntityRequest))
+			}),
+		extMethod("EntityByNumber", func() interface{} { return new(pb.EntityRequest) },
+			func(s ExtServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.EntityByNumber(ctx, r.(*pb.EntityRequest))
+			}),
+		extMethod("GroupTrash", func() interface{} { return new(pb.Empty) },
+			func(s ExtServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.GroupTrash(ctx, r.(*pb.Empty))
+			}),
+		extMethod("GroupRestore", func() interface{} { return new(pb.GroupRequest) },
+			func(s ExtServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.GroupRestore(ctx, r.(*pb.GroupRequest))
+			}),
+		extMethod("GroupPurge", func() interface{} { return new(pb.GroupRequest) },
+			func(s ExtServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.GroupPurge(ctx, r.(*pb.GroupRequest))
+			}),
+		extMethod("GroupByNumber", func() interface{} { return new(pb.GroupRequest) },
+			func(s ExtServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.GroupByNumber(ctx, r.(*pb.GroupRequest))
+			}),
+		extMethod("EntityAggregate", func() interface{} { return new(structpb.Struct) },
+			func(s ExtServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.EntityAggregate(ctx, r.(*structpb.Struct))
+			}),
+		extMethod("GroupAggregate", func() interface{} { return new(structpb.Struct) },
+			func(s ExtServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.GroupAggregate(ctx, r.(*structpb.Struct))
+			}),
+		extMethod("SystemChains", func() interface{} { return new(pb.Empty) },
+			func(s ExtServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.SystemChains(ctx, r.(*pb.Empty))
+			}),
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "internal/rpc2/ext.go",
+}
+
+// extMethod builds the description of a unary method in the same way
+// that protoc-gen-go-grpc does.  newReq returns an empty request
+// message to be decoded into, and call passes the decoded request to
+// the server.
+func extMethod(name string, newReq func() interface{}, call func(ExtServer, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := newReq()
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(ExtServer), ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + ExtServiceName + "/" + name,
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(ExtServer), ctx, req)
+			}
+			return interceptor(ctx, in, info, handler)
+		},
+	}
+}
@@ -16,11 +16,34 @@ import (
 func (s *Server) GroupCreate(ctx context.Context, r *pb.GroupRequest) (*pb.Empty, error) {
 	g := r.GetGroup()
 
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_CREATE_GROUP); err != nil {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_CREATE_GROUP)
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
-	switch err := s.CreateGroup(ctx, g.GetName(), g.GetDisplayName(), g.GetManagedBy(), g.GetNumber()); err {
+	err = s.CreateGroup(withNumberRange(ctx), g.GetName(), g.GetDisplayName(), g.GetManagedBy(), g.GetNumber())
+	if s.conflicted(ctx, "GroupCreate", err) {
+		return &pb.Empty{}, ErrConflict
+	}
+	switch err {
+	case db.ErrUnknownNumberRange:
+		s.log.Warn("Unknown number range requested",
+			"group", g.GetName(),
+			"range", getSingleStringFromMetadata(ctx, "number-range"),
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrMalformedRequest
+	case db.ErrNumberRangeExhausted:
+		s.log.Warn("Number range exhausted",
+			"group", g.GetName(),
+			"range", getSingleStringFromMetadata(ctx, "number-range"),
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrNumbersExhausted
 	case tree.ErrDuplicateGroupName, tree.ErrDuplicateNumber:
 		s.log.Warn("Attempt to create duplicate group",
 			"group", g.GetName(),
@@ -55,12 +78,16 @@ func (s *Server) GroupCreate(ctx context.Context, r *pb.GroupRequest) (*pb.Empty
 // untyped metadata.
 func (s *Server) GroupUpdate(ctx context.Context, r *pb.GroupRequest) (*pb.Empty, error) {
 	g := r.GetGroup()
-	err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META)
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META)
 	if err != nil && !s.manageByMembership(ctx, getTokenClaims(ctx).EntityID, g) {
 		return &pb.Empty{}, err
 	}
 
-	switch err := s.UpdateGroupMeta(ctx, g.GetName(), g); err {
+	err = s.UpdateGroupMeta(ctx, g.GetName(), g)
+	if s.conflicted(ctx, "GroupUpdate", err) {
+		return &pb.Empty{}, ErrConflict
+	}
+	switch err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Unable to load group",
 			"group", g.GetName(),
@@ -124,6 +151,43 @@ func (s *Server) GroupInfo(ctx context.Context, r *pb.GroupRequest) (*pb.ListOfG
 	}
 }
 
+// GroupByNumber returns the group that holds the number in the
+// request.  Like GroupInfo it does not return key/value data, and
+// like EntityByNumber it does not go through the search index.
+func (s *Server) GroupByNumber(ctx context.Context, r *pb.GroupRequest) (*pb.ListOfGroups, error) {
+	g := r.GetGroup()
+	if g == nil || g.Number == nil {
+		return &pb.ListOfGroups{}, ErrMalformedRequest
+	}
+
+	switch grp, err := s.FetchGroupByNumber(ctx, g.GetNumber()); err {
+	case db.ErrUnknownGroup:
+		s.log.Warn("Unknown Group",
+			"number", g.GetNumber(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return &pb.ListOfGroups{}, ErrDoesNotExist
+	case nil:
+		s.log.Info("Group Info",
+			"group", grp.GetName(),
+			"number", g.GetNumber(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.ListOfGroups{Groups: []*types.Group{grp}}, nil
+	default:
+		s.log.Warn("Error Loading Group",
+			"number", g.GetNumber(),
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return &pb.ListOfGroups{}, ErrInternal
+	}
+}
+
 // GroupUM handles updates to untyped metadata for groups.
 func (s *Server) GroupUM(ctx context.Context, r *pb.KVRequest) (*pb.ListOfStrings, error) {
 	if r.GetAction() != pb.Action_READ &&
@@ -134,7 +198,7 @@ func (s *Server) GroupUM(ctx context.Context, r *pb.KVRequest) (*pb.ListOfString
 	}
 
 	if r.GetAction() != pb.Action_READ {
-		err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META)
+		ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META)
 		g := types.Group{Name: proto.String(r.GetTarget())}
 		if err != nil && !s.manageByMembership(ctx, getTokenClaims(ctx).EntityID, &g) {
 			return &pb.ListOfStrings{}, err
@@ -144,6 +208,9 @@ func (s *Server) GroupUM(ctx context.Context, r *pb.KVRequest) (*pb.ListOfString
 	// At this point, we're either in a read-only query, or in a
 	// write one that has been authorized.
 	meta, err := s.ManageUntypedGroupMeta(ctx, r.GetTarget(), r.GetAction().String(), r.GetKey(), r.GetValue())
+	if s.conflicted(ctx, "GroupUM", err) {
+		return &pb.ListOfStrings{}, ErrConflict
+	}
 	switch err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Group does not exist!",
@@ -217,11 +284,15 @@ func (s *Server) GroupKVGet(ctx context.Context, r *pb.KV2Request) (*pb.ListOfKV
 // GroupKVAdd takes the input KV2 data and adds it to an group if an
 // only if it does not conflict with an existing key.
 func (s *Server) GroupKVAdd(ctx context.Context, r *pb.KV2Request) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META); err != nil {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META)
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
-	err := s.Manager.GroupKVAdd(ctx, r.GetTarget(), []*types.KVData{r.GetData()})
+	err = s.Manager.GroupKVAdd(ctx, r.GetTarget(), []*types.KVData{r.GetData()})
+	if s.conflicted(ctx, "GroupKVAdd", err) {
+		return &pb.Empty{}, ErrConflict
+	}
 	switch err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Group does not exist!",
@@ -254,11 +325,15 @@ func (s *Server) GroupKVAdd(ctx context.Context, r *pb.KV2Request) (*pb.Empty, e
 // GroupKVDel removes an existing key from an group.  If the key is
 // not present an error will be returned.
 func (s *Server) GroupKVDel(ctx context.Context, r *pb.KV2Request) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META); err != nil {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META)
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
-	err := s.Manager.GroupKVDel(ctx, r.GetTarget(), []*types.KVData{r.GetData()})
+	err = s.Manager.GroupKVDel(ctx, r.GetTarget(), []*types.KVData{r.GetData()})
+	if s.conflicted(ctx, "GroupKVDel", err) {
+		return &pb.Empty{}, ErrConflict
+	}
 	switch err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Group does not exist!",
@@ -292,11 +367,15 @@ func (s *Server) GroupKVDel(ctx context.Context, r *pb.KV2Request) (*pb.Empty, e
 // The key must already exist on the group or an error will be
 // returned.
 func (s *Server) GroupKVReplace(ctx context.Context, r *pb.KV2Request) (*pb.Empty, error) {
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META); err != nil {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META)
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
-	err := s.Manager.GroupKVReplace(ctx, r.GetTarget(), []*types.KVData{r.GetData()})
+	err = s.Manager.GroupKVReplace(ctx, r.GetTarget(), []*types.KVData{r.GetData()})
+	if s.conflicted(ctx, "GroupKVReplace", err) {
+		return &pb.Empty{}, ErrConflict
+	}
 	switch err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Group does not exist!",
@@ -330,12 +409,16 @@ func (s *Server) GroupKVReplace(ctx context.Context, r *pb.KV2Request) (*pb.Empt
 func (s *Server) GroupUpdateRules(ctx context.Context, r *pb.GroupRulesRequest) (*pb.Empty, error) {
 	g := r.GetGroup()
 
-	err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META)
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_META)
 	if err != nil && !s.manageByMembership(ctx, getTokenClaims(ctx).EntityID, g) {
 		return &pb.Empty{}, err
 	}
 
-	switch err := s.ModifyGroupRule(ctx, r.GetGroup().GetName(), r.GetTarget().GetName(), r.GetRuleAction()); err {
+	err = s.ModifyGroupRule(ctx, r.GetGroup().GetName(), r.GetTarget().GetName(), r.GetRuleAction())
+	if s.conflicted(ctx, "GroupUpdateRules", err) {
+		return &pb.Empty{}, ErrConflict
+	}
+	switch err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Group does not exist!",
 			"method", "GroupUpdateRules",
@@ -369,7 +452,7 @@ func (s *Server) GroupUpdateRules(ctx context.Context, r *pb.GroupRulesRequest)
 func (s *Server) GroupAddMember(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
 	e := r.GetEntity()
 
-	preErr := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_MEMBERS)
+	ctx, preErr := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_MEMBERS)
 	for _, g := range e.GetMeta().GetGroups() {
 		grp := types.Group{Name: proto.String(g)}
 		if preErr != nil && !s.manageByMembership(ctx, getTokenClaims(ctx).EntityID, &grp) {
@@ -380,7 +463,11 @@ func (s *Server) GroupAddMember(ctx context.Context, r *pb.EntityRequest) (*pb.E
 			)
 			return &pb.Empty{}, preErr
 		}
-		if err := s.AddEntityToGroup(ctx, e.GetID(), g); err != nil {
+		err := s.AddEntityToGroup(ctx, e.GetID(), g)
+		if s.conflicted(ctx, "GroupAddMember", err) {
+			return &pb.Empty{}, ErrConflict
+		}
+		if err != nil {
 			s.log.Warn("Error adding entity to group",
 				"entity", e.GetID(),
 				"group", g,
@@ -399,7 +486,7 @@ func (s *Server) GroupAddMember(ctx context.Context, r *pb.EntityRequest) (*pb.E
 func (s *Server) GroupDelMember(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
 	e := r.GetEntity()
 
-	preErr := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_MEMBERS)
+	ctx, preErr := s.mutablePrequisitesMet(ctx, types.Capability_MODIFY_GROUP_MEMBERS)
 	for _, g := range e.GetMeta().GetGroups() {
 		grp := types.Group{Name: proto.String(g)}
 		if preErr != nil && !s.manageByMembership(ctx, getTokenClaims(ctx).EntityID, &grp) {
@@ -410,7 +497,11 @@ func (s *Server) GroupDelMember(ctx context.Context, r *pb.EntityRequest) (*pb.E
 			)
 			return &pb.Empty{}, preErr
 		}
-		if err := s.RemoveEntityFromGroup(ctx, e.GetID(), g); err != nil {
+		err := s.RemoveEntityFromGroup(ctx, e.GetID(), g)
+		if s.conflicted(ctx, "GroupDelMember", err) {
+			return &pb.Empty{}, ErrConflict
+		}
+		if err != nil {
 			s.log.Warn("Error adding entity to group",
 				"entity", e.GetID(),
 				"group", g,
@@ -431,11 +522,16 @@ func (s *Server) GroupDelMember(ctx context.Context, r *pb.EntityRequest) (*pb.E
 func (s *Server) GroupDestroy(ctx context.Context, r *pb.GroupRequest) (*pb.Empty, error) {
 	g := r.GetGroup()
 
-	if err := s.mutablePrequisitesMet(ctx, types.Capability_DESTROY_GROUP); err != nil {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_DESTROY_GROUP)
+	if err != nil {
 		return &pb.Empty{}, err
 	}
 
-	switch err := s.DestroyGroup(ctx, g.GetName()); err {
+	err = s.DestroyGroup(ctx, g.GetName())
+	if s.conflicted(ctx, "GroupDestroy", err) {
+		return &pb.Empty{}, ErrConflict
+	}
+	switch err {
 	case db.ErrUnknownGroup:
 		s.log.Warn("Group does not exist!",
 			"method", "GroupDestroy",
@@ -494,12 +590,34 @@ func (s *Server) GroupMembers(ctx context.Context, r *pb.GroupRequest) (*pb.List
 }
 
 // GroupSearch searches for groups and returns a list of all groups
-// matching the criteria specified.
+// matching the criteria specified.  Paging works the same way as for
+// EntitySearch.
 func (s *Server) GroupSearch(ctx context.Context, r *pb.SearchRequest) (*pb.ListOfGroups, error) {
 	expr := r.GetExpression()
 
-	res, err := s.SearchGroups(ctx, db.SearchRequest{Expression: expr})
+	req, err := searchRequest(ctx, expr)
 	if err != nil {
+		s.log.Warn("Malformed search paging",
+			"expr", expr,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.ListOfGroups{}, err
+	}
+
+	res, page, err := s.SearchGroupPage(ctx, req)
+	switch err {
+	case nil:
+		setSearchHeader(ctx, page)
+		return &pb.ListOfGroups{Groups: res}, nil
+	case db.ErrBadSearch:
+		s.log.Warn("Bad search request",
+			"expr", expr,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.ListOfGroups{}, ErrMalformedRequest
+	default:
 		s.log.Warn("Search Error",
 			"expr", expr,
 			"service", getServiceName(ctx),
@@ -507,7 +625,5 @@ func (s *Server) GroupSearch(ctx context.Context, r *pb.SearchRequest) (*pb.List
 			"error", err,
 		)
 		return &pb.ListOfGroups{}, ErrInternal
-
 	}
-	return &pb.ListOfGroups{Groups: res}, nil
 }
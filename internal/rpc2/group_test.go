@@ -236,6 +236,54 @@ func TestGroupInfo(t *testing.T) {
 	}
 }
 
+func TestGroupByNumber(t *testing.T) {
+	cases := []struct {
+		req     *pb.GroupRequest
+		wantErr error
+		wantLen int
+	}{
+		{
+			req: &pb.GroupRequest{
+				Group: &types.Group{
+					Number: proto.Int32(2),
+				},
+			},
+			wantErr: nil,
+			wantLen: 1,
+		},
+		{
+			req: &pb.GroupRequest{
+				Group: &types.Group{
+					Number: proto.Int32(99),
+				},
+			},
+			wantErr: ErrDoesNotExist,
+			wantLen: 0,
+		},
+		{
+			req: &pb.GroupRequest{
+				Group: &types.Group{
+					Name: proto.String("group1"),
+				},
+			},
+			wantErr: ErrMalformedRequest,
+			wantLen: 0,
+		},
+	}
+
+	for i, c := range cases {
+		s := newServer(t)
+		initTree(t, s.Manager)
+		resp, err := s.GroupByNumber(context.Background(), c.req)
+		if err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		if len(resp.GetGroups()) != c.wantLen {
+			t.Errorf("%d: Got %d; Want %d", i, len(resp.GetGroups()), c.wantLen)
+		}
+	}
+}
+
 func TestGroupUM(t *testing.T) {
 	cases := []struct {
 		ctx      context.Context
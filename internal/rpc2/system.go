@@ -3,7 +3,11 @@ package rpc2
 import (
 	"context"
 
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/netauth/netauth/internal/db"
 	"github.com/netauth/netauth/internal/health"
+	"github.com/netauth/netauth/internal/tree"
 
 	types "github.com/netauth/protocol"
 	pb "github.com/netauth/protocol/v2"
@@ -63,6 +67,9 @@ func (s *Server) SystemCapabilities(ctx context.Context, r *pb.CapabilityRequest
 			"service", getServiceName(ctx),
 			"error", err,
 		)
+		if err == db.ErrConflict {
+			return &pb.Empty{}, ErrConflict
+		}
 		return &pb.Empty{}, ErrInternal
 	}
 
@@ -89,3 +96,32 @@ func (s *Server) SystemStatus(ctx context.Context, r *pb.Empty) (*pb.ServerStatu
 	status := health.Check()
 	return status.Proto(), nil
 }
+
+// SystemChains returns the hooks in each entity and group chain in
+// the order that they run, which reflects any chains that were
+// configured as well as hooks inserted by plugins.  Like the status
+// report this requires no authorization.
+func (s *Server) SystemChains(ctx context.Context, r *pb.Empty) (*structpb.Struct, error) {
+	// The names and priorities are all strings and numbers, so
+	// this can't fail.
+	st, _ := structpb.NewStruct(map[string]interface{}{
+		"entity": chainsToMap(s.Manager.EntityChains()),
+		"group":  chainsToMap(s.Manager.GroupChains()),
+	})
+	return st, nil
+}
+
+func chainsToMap(chains map[string][]tree.ChainEntry) map[string]interface{} {
+	out := make(map[string]interface{}, len(chains))
+	for chain, hooks := range chains {
+		l := make([]interface{}, len(hooks))
+		for i, h := range hooks {
+			l[i] = map[string]interface{}{
+				"hook":     h.Hook,
+				"priority": h.Priority,
+			}
+		}
+		out[chain] = l
+	}
+	return out
+}
@@ -174,3 +174,20 @@ func TestSystemStatus(t *testing.T) {
 		t.Error("Status does not reflect green state")
 	}
 }
+
+func TestSystemChains(t *testing.T) {
+	s := newServer(t)
+
+	res, err := s.SystemChains(context.Background(), &pb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := res.GetFields()["entity"].GetStructValue().GetFields()["FETCH"].GetListValue().GetValues()
+	if len(fetch) != 1 || fetch[0].GetStructValue().GetFields()["hook"].GetStringValue() != "load-entity" {
+		t.Errorf("Bad FETCH chain: %v", fetch)
+	}
+	if len(res.GetFields()["group"].GetStructValue().GetFields()) == 0 {
+		t.Error("No group chains returned")
+	}
+}
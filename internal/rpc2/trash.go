@@ -0,0 +1,170 @@
+package rpc2
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/netauth/netauth/internal/db"
+
+	types "github.com/netauth/protocol"
+	pb "github.com/netauth/protocol/v2"
+)
+
+// EntityTrash lists the entities that have been destroyed but can
+// still be restored.  Listing the trash requires the same capability
+// as destroying entities.
+func (s *Server) EntityTrash(ctx context.Context, r *pb.Empty) (*structpb.Struct, error) {
+	ctx, err := s.checkToken(ctx)
+	if err != nil {
+		return &structpb.Struct{}, err
+	}
+	if err := s.isAuthorized(ctx, types.Capability_DESTROY_ENTITY); err != nil {
+		return &structpb.Struct{}, err
+	}
+
+	entries, err := s.Manager.EntityTrash(ctx)
+	if err != nil {
+		s.log.Warn("Error listing trash",
+			"method", "EntityTrash",
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return &structpb.Struct{}, ErrInternal
+	}
+	return trashToStruct(entries), nil
+}
+
+// EntityRestore moves an entity out of the trash.  Restoring an
+// entity requires the same capability as creating one.
+func (s *Server) EntityRestore(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_CREATE_ENTITY)
+	if err != nil {
+		return &pb.Empty{}, err
+	}
+	return s.trashResult(ctx, "EntityRestore", "entity", r.GetEntity().GetID(),
+		s.Manager.RestoreEntity(ctx, r.GetEntity().GetID()))
+}
+
+// EntityPurge permanently removes an entity from the trash.
+func (s *Server) EntityPurge(ctx context.Context, r *pb.EntityRequest) (*pb.Empty, error) {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_DESTROY_ENTITY)
+	if err != nil {
+		return &pb.Empty{}, err
+	}
+	return s.trashResult(ctx, "EntityPurge", "entity", r.GetEntity().GetID(),
+		s.Manager.PurgeEntity(ctx, r.GetEntity().GetID()))
+}
+
+// GroupTrash lists the groups that have been destroyed but can still
+// be restored.
+func (s *Server) GroupTrash(ctx context.Context, r *pb.Empty) (*structpb.Struct, error) {
+	ctx, err := s.checkToken(ctx)
+	if err != nil {
+		return &structpb.Struct{}, err
+	}
+	if err := s.isAuthorized(ctx, types.Capability_DESTROY_GROUP); err != nil {
+		return &structpb.Struct{}, err
+	}
+
+	entries, err := s.Manager.GroupTrash(ctx)
+	if err != nil {
+		s.log.Warn("Error listing trash",
+			"method", "GroupTrash",
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return &structpb.Struct{}, ErrInternal
+	}
+	return trashToStruct(entries), nil
+}
+
+// GroupRestore moves a group out of the trash.
+func (s *Server) GroupRestore(ctx context.Context, r *pb.GroupRequest) (*pb.Empty, error) {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_CREATE_GROUP)
+	if err != nil {
+		return &pb.Empty{}, err
+	}
+	return s.trashResult(ctx, "GroupRestore", "group", r.GetGroup().GetName(),
+		s.Manager.RestoreGroup(ctx, r.GetGroup().GetName()))
+}
+
+// GroupPurge permanently removes a group from the trash.
+func (s *Server) GroupPurge(ctx context.Context, r *pb.GroupRequest) (*pb.Empty, error) {
+	ctx, err := s.mutablePrequisitesMet(ctx, types.Capability_DESTROY_GROUP)
+	if err != nil {
+		return &pb.Empty{}, err
+	}
+	return s.trashResult(ctx, "GroupPurge", "group", r.GetGroup().GetName(),
+		s.Manager.PurgeGroup(ctx, r.GetGroup().GetName()))
+}
+
+// trashResult logs the outcome of a restore or purge and maps it to
+// the error that is returned to the client.
+func (s *Server) trashResult(ctx context.Context, method, kind, name string, err error) (*pb.Empty, error) {
+	if s.conflicted(ctx, method, err) {
+		return &pb.Empty{}, ErrConflict
+	}
+	switch err {
+	case nil:
+		s.log.Info("Trash Updated",
+			"method", method,
+			kind, name,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, nil
+	case db.ErrNotInTrash:
+		s.log.Warn("Object is not in the trash",
+			"method", method,
+			kind, name,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+		)
+		return &pb.Empty{}, ErrDoesNotExist
+	case db.ErrObjectExists, db.ErrDuplicateNumber:
+		s.log.Warn("Restored object would collide with an existing one",
+			"method", method,
+			kind, name,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return &pb.Empty{}, ErrExists
+	default:
+		s.log.Warn("Error Updating Trash",
+			"method", method,
+			kind, name,
+			"authority", getTokenClaims(ctx).EntityID,
+			"service", getServiceName(ctx),
+			"client", getClientName(ctx),
+			"error", err,
+		)
+		return &pb.Empty{}, ErrInternal
+	}
+}
+
+// trashToStruct converts the contents of the trash to the message
+// that is returned to clients.  Times are formatted as RFC 3339.
+func trashToStruct(entries []db.TrashEntry) *structpb.Struct {
+	l := make([]interface{}, len(entries))
+	for i, e := range entries {
+		l[i] = map[string]interface{}{
+			"kind":    e.Kind,
+			"name":    e.Name,
+			"deleted": e.Deleted.UTC().Format(time.RFC3339),
+			"actor":   e.Actor,
+		}
+	}
+	// All of the values are strings, so this can't fail.
+	st, _ := structpb.NewStruct(map[string]interface{}{"entries": l})
+	return st
+}
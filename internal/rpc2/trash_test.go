@@ -0,0 +1,152 @@
+package rpc2
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	types "github.com/netauth/protocol"
+	pb "github.com/netauth/protocol/v2"
+)
+
+func newTrashServer(t *testing.T) *Server {
+	viper.Set("trash.enabled", true)
+	defer viper.Set("trash.enabled", false)
+
+	s, _, m := newServerWithRefs(t)
+	initTree(t, m)
+	ctx := context.Background()
+	if err := m.DestroyEntity(ctx, "entity1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DestroyGroup(ctx, "group2"); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func trashNames(st *structpb.Struct) []string {
+	out := []string{}
+	for _, v := range st.GetFields()["entries"].GetListValue().GetValues() {
+		out = append(out, v.GetStructValue().GetFields()["name"].GetStringValue())
+	}
+	return out
+}
+
+func TestEntityTrash(t *testing.T) {
+	cases := []struct {
+		ctx     context.Context
+		wantErr error
+		want    []string
+	}{
+		{PrivilegedContext, nil, []string{"entity1"}},
+		{UnprivilegedContext, ErrRequestorUnqualified, nil},
+		{InvalidAuthContext, ErrUnauthenticated, nil},
+	}
+
+	for i, c := range cases {
+		s := newTrashServer(t)
+		res, err := s.EntityTrash(c.ctx, &pb.Empty{})
+		if err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		if c.want != nil && !reflect.DeepEqual(trashNames(res), c.want) {
+			t.Errorf("%d: Got %v; Want %v", i, trashNames(res), c.want)
+		}
+	}
+}
+
+func TestEntityRestorePurge(t *testing.T) {
+	cases := []struct {
+		ctx      context.Context
+		purge    bool
+		id       string
+		readonly bool
+		wantErr  error
+	}{
+		{PrivilegedContext, false, "entity1", false, nil},
+		{PrivilegedContext, true, "entity1", false, nil},
+		{PrivilegedContext, false, "entity2", false, ErrDoesNotExist},
+		{PrivilegedContext, true, "entity2", false, ErrDoesNotExist},
+		{PrivilegedContext, false, "entity1", true, ErrReadOnly},
+		{UnprivilegedContext, false, "entity1", false, ErrRequestorUnqualified},
+		{InvalidAuthContext, true, "entity1", false, ErrUnauthenticated},
+	}
+
+	for i, c := range cases {
+		s := newTrashServer(t)
+		s.readonly = c.readonly
+		req := &pb.EntityRequest{Entity: &types.Entity{ID: proto.String(c.id)}}
+		var err error
+		if c.purge {
+			_, err = s.EntityPurge(c.ctx, req)
+		} else {
+			_, err = s.EntityRestore(c.ctx, req)
+		}
+		if err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+	}
+}
+
+func TestEntityRestoreExists(t *testing.T) {
+	s := newTrashServer(t)
+	if err := s.CreateEntity(context.Background(), "entity1", -1, ""); err != nil {
+		t.Fatal(err)
+	}
+	req := &pb.EntityRequest{Entity: &types.Entity{ID: proto.String("entity1")}}
+	if _, err := s.EntityRestore(PrivilegedContext, req); err != ErrExists {
+		t.Errorf("Got %v; Want %v", err, ErrExists)
+	}
+}
+
+func TestGroupTrash(t *testing.T) {
+	s := newTrashServer(t)
+	res, err := s.GroupTrash(PrivilegedContext, &pb.Empty{})
+	if err != nil || !reflect.DeepEqual(trashNames(res), []string{"group2"}) {
+		t.Errorf("Got %v %v", trashNames(res), err)
+	}
+	if _, err := s.GroupTrash(UnprivilegedContext, &pb.Empty{}); err != ErrRequestorUnqualified {
+		t.Errorf("Got %v; Want %v", err, ErrRequestorUnqualified)
+	}
+
+	req := &pb.GroupRequest{Group: &types.Group{Name: proto.String("group2")}}
+	if _, err := s.GroupRestore(PrivilegedContext, req); err != nil {
+		t.Error(err)
+	}
+	if _, err := s.GroupPurge(PrivilegedContext, req); err != ErrDoesNotExist {
+		t.Errorf("Got %v; Want %v", err, ErrDoesNotExist)
+	}
+	if _, err := s.FetchGroup(context.Background(), "group2"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExtServiceDesc(t *testing.T) {
+	// Aggregation, lookup by number and listing chains require no
+	// authorization, so the empty request either succeeds or is
+	// rejected instead.
+	unauthenticated := map[string]error{
+		"EntityByNumber":  ErrMalformedRequest,
+		"GroupByNumber":   ErrMalformedRequest,
+		"EntityAggregate": ErrMalformedRequest,
+		"GroupAggregate":  ErrMalformedRequest,
+		"SystemChains":    nil,
+	}
+
+	s := newTrashServer(t)
+	for _, m := range ExtServiceDesc.Methods {
+		want, ok := unauthenticated[m.MethodName]
+		if !ok {
+			want = ErrRequestorUnqualified
+		}
+		dec := func(in interface{}) error { return nil }
+		if _, err := m.Handler(s, UnprivilegedContext, dec, nil); err != want {
+			t.Errorf("%s: Got %v; Want %v", m.MethodName, err, want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/hashicorp/go-hclog"
 
 	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/tree"
 	"github.com/netauth/netauth/pkg/token"
 
 	pb "github.com/netauth/protocol"
@@ -33,7 +34,8 @@ type Refs struct {
 type Manager interface {
 	CreateEntity(context.Context, string, int32, string) error
 	FetchEntity(context.Context, string) (*pb.Entity, error)
-	SearchEntities(context.Context, db.SearchRequest) ([]*pb.Entity, error)
+	FetchEntityByNumber(context.Context, int32) (*pb.Entity, error)
+	SearchEntityPage(context.Context, db.SearchRequest) ([]*pb.Entity, db.SearchResult, error)
 	ValidateSecret(context.Context, string, string) error
 	SetSecret(context.Context, string, string) error
 	LockEntity(context.Context, string) error
@@ -49,7 +51,8 @@ type Manager interface {
 
 	CreateGroup(context.Context, string, string, string, int32) error
 	FetchGroup(context.Context, string) (*pb.Group, error)
-	SearchGroups(context.Context, db.SearchRequest) ([]*pb.Group, error)
+	FetchGroupByNumber(context.Context, int32) (*pb.Group, error)
+	SearchGroupPage(context.Context, db.SearchRequest) ([]*pb.Group, db.SearchResult, error)
 	UpdateGroupMeta(context.Context, string, *pb.Group) error
 	ManageUntypedGroupMeta(context.Context, string, string, string, string) ([]string, error)
 	GroupKVGet(context.Context, string, []*pb.KVData) ([]*pb.KVData, error)
@@ -68,6 +71,19 @@ type Manager interface {
 	DropEntityCapability2(context.Context, string, *pb.Capability) error
 	SetGroupCapability2(context.Context, string, *pb.Capability) error
 	DropGroupCapability2(context.Context, string, *pb.Capability) error
+
+	EntityTrash(context.Context) ([]db.TrashEntry, error)
+	RestoreEntity(context.Context, string) error
+	PurgeEntity(context.Context, string) error
+	GroupTrash(context.Context) ([]db.TrashEntry, error)
+	RestoreGroup(context.Context, string) error
+	PurgeGroup(context.Context, string) error
+
+	AggregateEntities(context.Context, db.AggregateRequest) ([]db.Facet, error)
+	AggregateGroups(context.Context, db.AggregateRequest) ([]db.Facet, error)
+
+	EntityChains() map[string][]tree.ChainEntry
+	GroupChains() map[string][]tree.ChainEntry
 }
 
 // Options configure the server
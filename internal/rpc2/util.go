@@ -2,10 +2,13 @@ package rpc2
 
 import (
 	"context"
+	"strconv"
+	"strings"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 
+	"github.com/netauth/netauth/internal/db"
 	"github.com/netauth/netauth/pkg/token"
 
 	types "github.com/netauth/protocol"
@@ -87,6 +90,7 @@ func (s *Server) checkToken(ctx context.Context) (context.Context, error) {
 		return ctx, ErrUnauthenticated
 	}
 	ctx = context.WithValue(ctx, claimsContextKey{}, c)
+	ctx = db.WithActor(ctx, c.EntityID)
 	return ctx, nil
 }
 
@@ -127,6 +131,64 @@ func getSingleStringFromMetadata(ctx context.Context, key string) string {
 	return sl[0]
 }
 
+// withNumberRange returns a context that will allocate numbers from
+// the range named in the "number-range" field of the request
+// metadata, if there is one.
+func withNumberRange(ctx context.Context) context.Context {
+	if r := getSingleStringFromMetadata(ctx, "number-range"); r != "" {
+		return db.WithNumberRange(ctx, r)
+	}
+	return ctx
+}
+
+// searchRequest builds a search request for the expression, paged
+// and sorted according to the "page-size", "page-offset",
+// "page-cursor", and "sort-by" fields of the request metadata.
+// Fields to sort by are separated by commas.  The expression is an
+// LDAP filter if the "search-syntax" field is "ldap".
+func searchRequest(ctx context.Context, expr string) (db.SearchRequest, error) {
+	r := db.SearchRequest{
+		Expression: expr,
+		Cursor:     getSingleStringFromMetadata(ctx, "page-cursor"),
+	}
+	for k, v := range map[string]*int{"page-size": &r.PageSize, "page-offset": &r.Offset} {
+		s := getSingleStringFromMetadata(ctx, k)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return r, ErrMalformedRequest
+		}
+		*v = n
+	}
+	if s := getSingleStringFromMetadata(ctx, "sort-by"); s != "" {
+		r.SortBy = strings.Split(s, ",")
+	}
+	switch getSingleStringFromMetadata(ctx, "search-syntax") {
+	case "", "query":
+		r.Syntax = db.SyntaxQueryString
+	case "ldap":
+		r.Syntax = db.SyntaxLDAP
+	default:
+		return r, ErrMalformedRequest
+	}
+	return r, nil
+}
+
+// setSearchHeader returns the total number of results and the cursor
+// for the next page in the "total-hits" and "next-cursor" fields of
+// the response header.
+func setSearchHeader(ctx context.Context, res db.SearchResult) {
+	// This only fails if the header has already been sent, or if
+	// the method wasn't called via gRPC, and in neither case is
+	// there anyone to tell.
+	grpc.SetHeader(ctx, metadata.Pairs(
+		"total-hits", strconv.Itoa(res.Total),
+		"next-cursor", res.Next,
+	))
+}
+
 // getClientName returns the client name.  If no name was set, the
 // string "BOGUS_CLIENT" is returned.
 func getClientName(ctx context.Context) string {
@@ -192,24 +254,23 @@ func (s *Server) manageByMembership(ctx context.Context, entityID string, g *typ
 // mutablePrequisitesAreMet checks for common mutable prerequisites
 // such as the server being in a writeable mode, and the correct
 // capability being present in a valid token.
-func (s *Server) mutablePrequisitesMet(ctx context.Context, c types.Capability) error {
+func (s *Server) mutablePrequisitesMet(ctx context.Context, c types.Capability) (context.Context, error) {
 	if s.readonly {
 		s.log.Warn("Mutable request in read-only mode!",
 			"method", "EntityUM",
 			"client", getClientName(ctx),
 			"service", getServiceName(ctx),
 		)
-		return ErrReadOnly
+		return ctx, ErrReadOnly
 	}
 
 	// Token validation and authorization
-	var err error
-	ctx, err = s.checkToken(ctx)
+	ctx, err := s.checkToken(ctx)
 	if err != nil {
-		return err
+		return ctx, err
 	}
 	if err := s.isAuthorized(ctx, c); err != nil {
-		return err
+		return ctx, err
 	}
-	return nil
+	return ctx, nil
 }
@@ -8,6 +8,7 @@ import (
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/proto"
 
+	"github.com/netauth/netauth/internal/db"
 	"github.com/netauth/netauth/pkg/token"
 
 	types "github.com/netauth/protocol"
@@ -203,6 +204,15 @@ func TestMutablePrequisitesMet(t *testing.T) {
 		initTree(t, s.Manager)
 		s.readonly = c.ro
 
-		assert.Equalf(t, c.wantErr, s.mutablePrequisitesMet(c.ctx, c.cap), "Test Number %d", i)
+		_, err := s.mutablePrequisitesMet(c.ctx, c.cap)
+		assert.Equalf(t, c.wantErr, err, "Test Number %d", i)
 	}
 }
+
+func TestConflicted(t *testing.T) {
+	s := newServer(t)
+
+	assert.True(t, s.conflicted(PrivilegedContext, "EntityUpdate", db.ErrConflict))
+	assert.False(t, s.conflicted(PrivilegedContext, "EntityUpdate", db.ErrUnknownEntity))
+	assert.False(t, s.conflicted(PrivilegedContext, "EntityUpdate", nil))
+}
@@ -0,0 +1,58 @@
+package tree
+
+import (
+	"strconv"
+	"strings"
+)
+
+// DefaultHooks may be listed in a configured chain in place of the
+// hooks that the chain has by default.  This allows hooks to be added
+// to a chain without restating the defaults, and without missing any
+// hooks that are added to the defaults later.
+const DefaultHooks = "..."
+
+// mergeChains returns the default chains with any configured chains
+// replacing the default of the same name, and DefaultHooks expanded
+// to the default hooks.  Chain names in the configuration are not
+// case sensitive, since configuration keys are folded to lower case
+// when they are read.  Only chains that the tree runs may be
+// configured, so that a misspelled chain name is caught rather than
+// silently ignored.
+func mergeChains(defaults, custom ChainConfig) (ChainConfig, error) {
+	out := make(ChainConfig, len(defaults))
+	for chain, hooks := range defaults {
+		out[chain] = hooks
+	}
+	for chain, hooks := range custom {
+		name := strings.ToUpper(chain)
+		if _, ok := defaults[name]; !ok {
+			log().Error("Configured chain is not a known chain", "chain", chain)
+			return nil, ErrUnknownHookChain
+		}
+		merged := []string{}
+		for _, h := range hooks {
+			if h == DefaultHooks {
+				merged = append(merged, defaults[name]...)
+				continue
+			}
+			merged = append(merged, h)
+		}
+		out[name] = merged
+	}
+	return out, nil
+}
+
+// parseHookSpec splits a hook as given in a chain configuration into
+// its name and the priority that it should run at.  The priority is
+// only overridden if one is given.
+func parseHookSpec(spec string) (string, int, bool, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) == 1 {
+		return spec, 0, false, nil
+	}
+	p, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, false, ErrBadHookPriority
+	}
+	return parts[0], p, true, nil
+}
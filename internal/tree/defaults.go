@@ -6,6 +6,7 @@ var (
 			"fail-on-existing-entity",
 			"set-entity-id",
 			"set-entity-number",
+			"check-secret-policy",
 			"set-entity-secret",
 			"save-entity",
 		},
@@ -18,6 +19,8 @@ var (
 		},
 		"SET-SECRET": {
 			"load-entity",
+			"check-secret-policy",
+			"check-secret-history",
 			"set-entity-secret",
 			"save-entity",
 		},
@@ -117,6 +120,11 @@ var (
 			"del-direct-group",
 			"save-entity",
 		},
+		"IMPORT": {
+			"import-entity",
+			"check-entity-groups",
+			"save-entity",
+		},
 	}
 
 	defaultGroupChains = map[string][]string{
@@ -188,5 +196,10 @@ var (
 			"kv-replace",
 			"save-group",
 		},
+		"IMPORT": {
+			"import-group",
+			"set-managing-group",
+			"save-group",
+		},
 	}
 )
@@ -68,6 +68,9 @@ func (m *Manager) InitializeEntityHooks() {
 func (m *Manager) InitializeEntityChains(c ChainConfig) error {
 	for chain, hooks := range c {
 		m.log.Debug("Initializing Entity Chain", "chain", chain)
+		if _, ok := m.entityProcesses[chain]; !ok {
+			m.entityProcesses[chain] = []EntityHook{}
+		}
 		for _, h := range hooks {
 			if err := m.RegisterEntityHookToChain(h, chain); err != nil {
 				return err
@@ -77,15 +80,24 @@ func (m *Manager) InitializeEntityChains(c ChainConfig) error {
 	return nil
 }
 
-// RegisterEntityHookToChain registers a hook to a given chain.
+// RegisterEntityHookToChain registers a hook to a given chain.  The
+// hook may be given as name:priority to override its priority.
 func (m *Manager) RegisterEntityHookToChain(hook, chain string) error {
-	eph, ok := m.entityHooks[hook]
+	name, priority, override, err := parseHookSpec(hook)
+	if err != nil {
+		m.log.Warn("Bad hook during chain initialization", "chain", chain, "hook", hook, "error", err)
+		return err
+	}
+	eph, ok := m.entityHooks[name]
 	if !ok {
 		m.log.Warn("Missing hook during chain initializtion", "chain", chain, "hook", hook)
 		return ErrUnknownHook
 	}
+	if override {
+		eph = prioritizedEntityHook{eph, priority}
+	}
 	m.entityProcesses[chain] = append(m.entityProcesses[chain], eph)
-	sort.Slice(m.entityProcesses[chain], func(i, j int) bool {
+	sort.SliceStable(m.entityProcesses[chain], func(i, j int) bool {
 		return m.entityProcesses[chain][i].Priority() < m.entityProcesses[chain][j].Priority()
 	})
 	m.log.Trace("Registered hook to chain", "chain", chain, "hook", hook)
@@ -111,17 +123,48 @@ func (m *Manager) CheckRequiredEntityChains() error {
 	return nil
 }
 
+// prioritizedEntityHook runs a hook at a priority that was set in
+// the chain configuration rather than its own.
+type prioritizedEntityHook struct {
+	EntityHook
+	priority int
+}
+
+func (h prioritizedEntityHook) Priority() int { return h.priority }
+
+// EntityChains returns the hooks in each entity chain in the order
+// that they run.
+func (m *Manager) EntityChains() map[string][]ChainEntry {
+	out := make(map[string][]ChainEntry, len(m.entityProcesses))
+	for chain, hooks := range m.entityProcesses {
+		for _, h := range hooks {
+			out[chain] = append(out[chain], ChainEntry{Hook: h.Name(), Priority: h.Priority()})
+		}
+	}
+	return out
+}
+
 // RunEntityChain runs the specified chain with de specifying values
-// to be consumed by the chain.
+// to be consumed by the chain.  If the storage layer supports batches
+// then all writes made by the chain are committed together once every
+// hook has run, and the chain is run again from the start if another
+// request modified the same objects in the meantime.
 func (m *Manager) RunEntityChain(ctx context.Context, chain string, de *pb.Entity) (*pb.Entity, error) {
-	e := new(pb.Entity)
+	var e *pb.Entity
 	hookChain := m.entityProcesses[chain]
-	for _, h := range hookChain {
-		m.log.Trace("Executing entity hook", "chain", chain, "hook", h.Name())
-		if err := h.Run(ctx, e, de); err != nil {
-			m.log.Trace("Error during chain execution", "chain", chain, "hook", h.Name(), "error", err)
-			return nil, err
+	err := m.batch(ctx, func(ctx context.Context) error {
+		e = new(pb.Entity)
+		for _, h := range hookChain {
+			m.log.Trace("Executing entity hook", "chain", chain, "hook", h.Name())
+			if err := h.Run(ctx, e, de); err != nil {
+				m.log.Trace("Error during chain execution", "chain", chain, "hook", h.Name(), "error", err)
+				return err
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return e, nil
 }
@@ -122,6 +122,23 @@ func (m *Manager) FetchEntity(ctx context.Context, ID string) (*pb.Entity, error
 	return safeCopyEntity(e), nil
 }
 
+// EntityIDForNumber returns the ID of the entity that holds the given
+// number.  If no entity holds it then db.ErrUnknownEntity is
+// returned.
+func (m *Manager) EntityIDForNumber(ctx context.Context, number int32) (string, error) {
+	return m.db.EntityIDForNumber(ctx, number)
+}
+
+// FetchEntityByNumber is the same as FetchEntity, but finds the
+// entity by its number rather than its ID.
+func (m *Manager) FetchEntityByNumber(ctx context.Context, number int32) (*pb.Entity, error) {
+	id, err := m.EntityIDForNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	return m.FetchEntity(ctx, id)
+}
+
 // UpdateEntityMeta drives the internal version by obtaining the
 // entity from the database based on the ID.
 func (m *Manager) UpdateEntityMeta(ctx context.Context, ID string, newMeta *pb.EntityMeta) error {
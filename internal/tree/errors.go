@@ -1,6 +1,9 @@
 package tree
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var (
 	// ErrDuplicateEntityID is returned when the entity ID
@@ -47,6 +50,10 @@ var (
 	// happen, but its possible.
 	ErrEmptyHookChain = errors.New("the specified chain is empty")
 
+	// ErrBadHookPriority is returned when a hook in a chain is
+	// given a priority that is not a number.
+	ErrBadHookPriority = errors.New("the hook priority is not a number")
+
 	// ErrKeyExists is returned when an operation would conflict
 	// with an already existing key.
 	ErrKeyExists = errors.New("the specified key already exists")
@@ -59,4 +66,30 @@ var (
 	// certain criteria to be successfully procesed, and these
 	// criteria are not met.
 	ErrFailedPrecondition = errors.New("precondition failed")
+
+	// ErrMalformedExpansion is returned when an expansion is not
+	// of the form MODE:group.
+	ErrMalformedExpansion = errors.New("expansions must be of the form INCLUDE:group or EXCLUDE:group")
+
+	// ErrMissingName is returned when an imported object has no
+	// ID or name.
+	ErrMissingName = errors.New("an ID or name is required")
+
+	// ErrSecretPolicy is matched by a SecretPolicyError with
+	// errors.Is.
+	ErrSecretPolicy = errors.New("the secret does not satisfy the secret policy")
 )
+
+// A SecretPolicyError is returned when a secret is rejected by the
+// secret policy.  Reasons describes each way in which the secret
+// fell short, and is suitable for showing to the user.
+type SecretPolicyError struct {
+	Reasons []string
+}
+
+func (e *SecretPolicyError) Error() string {
+	return ErrSecretPolicy.Error() + ": " + strings.Join(e.Reasons, "; ")
+}
+
+// Is allows a SecretPolicyError to be compared to ErrSecretPolicy.
+func (e *SecretPolicyError) Is(target error) bool { return target == ErrSecretPolicy }
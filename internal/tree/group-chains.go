@@ -68,6 +68,9 @@ func (m *Manager) InitializeGroupHooks() {
 func (m *Manager) InitializeGroupChains(c ChainConfig) error {
 	for chain, hooks := range c {
 		m.log.Debug("Initializing Group Chain", "chain", chain)
+		if _, ok := m.groupProcesses[chain]; !ok {
+			m.groupProcesses[chain] = []GroupHook{}
+		}
 		for _, h := range hooks {
 			if err := m.RegisterGroupHookToChain(h, chain); err != nil {
 				return err
@@ -77,15 +80,24 @@ func (m *Manager) InitializeGroupChains(c ChainConfig) error {
 	return nil
 }
 
-// RegisterGroupHookToChain registers a hook to a given chain.
+// RegisterGroupHookToChain registers a hook to a given chain.  The
+// hook may be given as name:priority to override its priority.
 func (m *Manager) RegisterGroupHookToChain(hook, chain string) error {
-	eph, ok := m.groupHooks[hook]
+	name, priority, override, err := parseHookSpec(hook)
+	if err != nil {
+		m.log.Warn("Bad hook during chain initialization", "chain", chain, "hook", hook, "error", err)
+		return err
+	}
+	eph, ok := m.groupHooks[name]
 	if !ok {
 		m.log.Warn("Missing hook during chain initializtion", "chain", chain, "hook", hook)
 		return ErrUnknownHook
 	}
+	if override {
+		eph = prioritizedGroupHook{eph, priority}
+	}
 	m.groupProcesses[chain] = append(m.groupProcesses[chain], eph)
-	sort.Slice(m.groupProcesses[chain], func(i, j int) bool {
+	sort.SliceStable(m.groupProcesses[chain], func(i, j int) bool {
 		return m.groupProcesses[chain][i].Priority() < m.groupProcesses[chain][j].Priority()
 	})
 	m.log.Trace("Registered hook to chain", "chain", chain, "hook", hook)
@@ -111,17 +123,48 @@ func (m *Manager) CheckRequiredGroupChains() error {
 	return nil
 }
 
+// prioritizedGroupHook runs a hook at a priority that was set in
+// the chain configuration rather than its own.
+type prioritizedGroupHook struct {
+	GroupHook
+	priority int
+}
+
+func (h prioritizedGroupHook) Priority() int { return h.priority }
+
+// GroupChains returns the hooks in each group chain in the order
+// that they run.
+func (m *Manager) GroupChains() map[string][]ChainEntry {
+	out := make(map[string][]ChainEntry, len(m.groupProcesses))
+	for chain, hooks := range m.groupProcesses {
+		for _, h := range hooks {
+			out[chain] = append(out[chain], ChainEntry{Hook: h.Name(), Priority: h.Priority()})
+		}
+	}
+	return out
+}
+
 // RunGroupChain runs the specified chain with de specifying values
-// to be consumed by the chain.
+// to be consumed by the chain.  If the storage layer supports batches
+// then all writes made by the chain are committed together once every
+// hook has run, and the chain is run again from the start if another
+// request modified the same objects in the meantime.
 func (m *Manager) RunGroupChain(ctx context.Context, chain string, de *pb.Group) (*pb.Group, error) {
-	e := new(pb.Group)
+	var e *pb.Group
 	hookChain := m.groupProcesses[chain]
-	for _, h := range hookChain {
-		m.log.Trace("Executing group hook", "chain", chain, "hook", h.Name())
-		if err := h.Run(ctx, e, de); err != nil {
-			m.log.Trace("Error during chain execution", "chain", chain, "hook", h.Name(), "error", err)
-			return nil, err
+	err := m.batch(ctx, func(ctx context.Context) error {
+		e = new(pb.Group)
+		for _, h := range hookChain {
+			m.log.Trace("Executing group hook", "chain", chain, "hook", h.Name())
+			if err := h.Run(ctx, e, de); err != nil {
+				m.log.Trace("Error during chain execution", "chain", chain, "hook", h.Name(), "error", err)
+				return err
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return e, nil
 }
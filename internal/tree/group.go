@@ -38,6 +38,23 @@ func (m *Manager) FetchGroup(ctx context.Context, name string) (*pb.Group, error
 	return m.RunGroupChain(ctx, "FETCH", rg)
 }
 
+// GroupNameForNumber returns the name of the group that holds the
+// given number.  If no group holds it then db.ErrUnknownGroup is
+// returned.
+func (m *Manager) GroupNameForNumber(ctx context.Context, number int32) (string, error) {
+	return m.db.GroupNameForNumber(ctx, number)
+}
+
+// FetchGroupByNumber is the same as FetchGroup, but finds the group by
+// its number rather than its name.
+func (m *Manager) FetchGroupByNumber(ctx context.Context, number int32) (*pb.Group, error) {
+	name, err := m.GroupNameForNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	return m.FetchGroup(ctx, name)
+}
+
 // DestroyGroup unsurprisingly deletes a group.  There's no real logic
 // here, it just passes the delete call through to the storage layer.
 func (m *Manager) DestroyGroup(ctx context.Context, name string) error {
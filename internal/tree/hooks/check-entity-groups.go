@@ -0,0 +1,45 @@
+package hooks
+
+import (
+	"context"
+
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// CheckEntityGroups verifies that all the groups an entity is a
+// direct member of exist.
+type CheckEntityGroups struct {
+	tree.BaseHook
+}
+
+// Run attempts to load every group listed in the metadata of de, and
+// returns the error from the first one that can't be loaded.
+func (ceg *CheckEntityGroups) Run(ctx context.Context, e, de *pb.Entity) error {
+	for _, g := range de.GetMeta().GetGroups() {
+		if _, err := ceg.Storage().LoadGroup(ctx, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(checkEntityGroupsCB)
+}
+
+func checkEntityGroupsCB() {
+	tree.RegisterEntityHookConstructor("check-entity-groups", NewCheckEntityGroups)
+}
+
+// NewCheckEntityGroups returns a configured hook ready for use.
+func NewCheckEntityGroups(opts ...tree.HookOption) (tree.EntityHook, error) {
+	opts = append([]tree.HookOption{
+		tree.WithHookName("check-entity-groups"),
+		tree.WithHookPriority(40),
+	}, opts...)
+
+	return &CheckEntityGroups{tree.NewBaseHook(opts...)}, nil
+}
@@ -0,0 +1,45 @@
+package hooks
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestCheckEntityGroups(t *testing.T) {
+	startup.DoCallbacks()
+	ctx := context.Background()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mdb.SaveGroup(ctx, &pb.Group{Name: proto.String("group1")})
+
+	hook, err := NewCheckEntityGroups(tree.WithHookStorage(mdb))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	de := &pb.Entity{Meta: &pb.EntityMeta{Groups: []string{"group1"}}}
+	if err := hook.Run(ctx, &pb.Entity{}, de); err != nil {
+		t.Error(err)
+	}
+
+	de.Meta.Groups = append(de.Meta.Groups, "group2")
+	if err := hook.Run(ctx, &pb.Entity{}, de); err != db.ErrUnknownGroup {
+		t.Error(err)
+	}
+}
+
+func TestCheckEntityGroupsCB(t *testing.T) {
+	checkEntityGroupsCB()
+}
@@ -0,0 +1,96 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// CheckSecretHistory prevents an entity from reusing a recent
+// secret.  The current secret and the secrets that it replaced are
+// all counted, up to secret.history.size in total.  Secrets that were
+// replaced longer than secret.history.retention ago are forgotten,
+// unless the retention is zero.  If the size is zero then no history
+// is kept.
+type CheckSecretHistory struct {
+	tree.BaseHook
+
+	size      int
+	retention time.Duration
+}
+
+// Run compares de.Secret with the current secret in e.Secret and the
+// history of e, and if none of them match records the current secret
+// in the history.  The history is saved immediately, so this hook
+// must run inside a batch for it to be discarded if the chain later
+// fails.
+func (c *CheckSecretHistory) Run(ctx context.Context, e, de *pb.Entity) error {
+	if c.size <= 0 {
+		return nil
+	}
+
+	hist, err := c.Storage().LoadSecretHistory(ctx, e.GetID())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	recent := []db.SecretHistoryEntry{}
+	if e.GetSecret() != "" {
+		recent = append(recent, db.SecretHistoryEntry{Secret: e.GetSecret(), Replaced: now})
+	}
+	for _, h := range hist {
+		if c.retention > 0 && h.Replaced.Before(now.Add(-c.retention)) {
+			continue
+		}
+		recent = append(recent, h)
+	}
+	if len(recent) > c.size {
+		recent = recent[:c.size]
+	}
+
+	for _, h := range recent {
+		if c.Crypto().VerifySecret(de.GetSecret(), h.Secret) == nil {
+			return &tree.SecretPolicyError{
+				Reasons: []string{fmt.Sprintf("must not be the same as any of the last %d secrets", c.size)},
+			}
+		}
+	}
+
+	// Once the secret has been changed it counts towards the
+	// size, so one less secret needs to be remembered.
+	if len(recent) > c.size-1 {
+		recent = recent[:c.size-1]
+	}
+	return c.Storage().SaveSecretHistory(ctx, e.GetID(), recent)
+}
+
+func init() {
+	startup.RegisterCallback(checkSecretHistoryCB)
+}
+
+func checkSecretHistoryCB() {
+	tree.RegisterEntityHookConstructor("check-secret-history", NewCheckSecretHistory)
+}
+
+// NewCheckSecretHistory returns an initialized hook ready for use.
+func NewCheckSecretHistory(opts ...tree.HookOption) (tree.EntityHook, error) {
+	opts = append([]tree.HookOption{
+		tree.WithHookName("check-secret-history"),
+		tree.WithHookPriority(45),
+	}, opts...)
+
+	return &CheckSecretHistory{
+		BaseHook:  tree.NewBaseHook(opts...),
+		size:      viper.GetInt("secret.history.size"),
+		retention: viper.GetDuration("secret.history.retention"),
+	}, nil
+}
@@ -0,0 +1,107 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/crypto/nocrypto"
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestCheckSecretHistory(t *testing.T) {
+	startup.DoCallbacks()
+	ctx := context.Background()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crypt, err := nocrypto.New(hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("secret.history.size", 3)
+	viper.Set("secret.history.retention", time.Hour)
+	defer viper.Set("secret.history.size", nil)
+	defer viper.Set("secret.history.retention", nil)
+
+	hook, err := NewCheckSecretHistory(tree.WithHookStorage(mdb), tree.WithHookCrypto(crypt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An old secret that has passed the retention is forgotten.
+	old := []db.SecretHistoryEntry{{Secret: "ancient", Replaced: time.Now().Add(-2 * time.Hour)}}
+	if err := mdb.SaveSecretHistory(ctx, "foo", old); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &pb.Entity{ID: proto.String("foo"), Secret: proto.String("first")}
+	change := func(secret string) error {
+		if err := hook.Run(ctx, e, &pb.Entity{Secret: proto.String(secret)}); err != nil {
+			return err
+		}
+		e.Secret = proto.String(secret)
+		return nil
+	}
+
+	cases := []struct {
+		secret  string
+		wantErr bool
+	}{
+		{"first", true},
+		{"ancient", false},
+		{"second", false},
+		{"first", true},
+		{"third", false},
+		{"ancient", true},
+		{"first", false},
+	}
+	for i, c := range cases {
+		err := change(c.secret)
+		if c.wantErr && !errors.Is(err, tree.ErrSecretPolicy) {
+			t.Errorf("%d: Got %v; want a policy error", i, err)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("%d: Unexpected error: %v", i, err)
+		}
+	}
+
+	// Only the secrets that the current one replaced are stored.
+	hist, err := mdb.LoadSecretHistory(ctx, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hist) != 2 || hist[0].Secret != "third" || hist[1].Secret != "second" {
+		t.Errorf("Wrong history: %v", hist)
+	}
+}
+
+func TestCheckSecretHistoryDisabled(t *testing.T) {
+	hook, err := NewCheckSecretHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With no history there's no need for storage.
+	e := &pb.Entity{ID: proto.String("foo"), Secret: proto.String("secret")}
+	if err := hook.Run(context.Background(), e, &pb.Entity{Secret: proto.String("secret")}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckSecretHistoryCB(t *testing.T) {
+	checkSecretHistoryCB()
+}
@@ -0,0 +1,219 @@
+package hooks
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// CheckSecretPolicy rejects secrets that don't satisfy the secret
+// policy.  The policy is read from the secret.policy section of the
+// configuration when the hook is created:
+//
+//	min-length       the fewest characters a secret may have
+//	min-classes      how many of lowercase letters, uppercase
+//	                 letters, digits and symbols must appear
+//	dictionary       a file of words, one per line, which a secret
+//	                 may not be, ignoring case and any digits or
+//	                 symbols at either end
+//	breached         a file of known breached secrets, one per
+//	                 line, either in plain text or as the hex
+//	                 encoded SHA-1 of the secret optionally followed
+//	                 by :count
+//	reject-identity  rejects secrets that contain the entity ID or
+//	                 any part of the GECOS
+//
+// Relative paths are relative to core.conf.  Each part of the policy
+// is disabled when it is unset.
+type CheckSecretPolicy struct {
+	tree.BaseHook
+
+	minLength      int
+	minClasses     int
+	rejectIdentity bool
+
+	dictionary map[string]struct{}
+	breached   map[string]struct{}
+}
+
+// Run checks de.Secret against the policy.  The entity is read from
+// e when it has been loaded, which provides the GECOS.  An empty
+// secret when creating an entity leaves the secret unset and is not
+// checked.
+func (c *CheckSecretPolicy) Run(_ context.Context, e, de *pb.Entity) error {
+	secret := de.GetSecret()
+	if secret == "" && e.GetID() == "" {
+		return nil
+	}
+
+	reasons := []string{}
+	if len([]rune(secret)) < c.minLength {
+		reasons = append(reasons, fmt.Sprintf("must be at least %d characters long", c.minLength))
+	}
+	if countClasses(secret) < c.minClasses {
+		reasons = append(reasons, fmt.Sprintf("must contain at least %d of lowercase letters, uppercase letters, digits, and symbols", c.minClasses))
+	}
+	word := strings.ToLower(strings.TrimFunc(secret, func(r rune) bool { return !unicode.IsLetter(r) }))
+	if _, ok := c.dictionary[word]; ok && word != "" {
+		reasons = append(reasons, "must not be a dictionary word")
+	}
+	if c.isBreached(secret) {
+		reasons = append(reasons, "appears in a list of breached secrets")
+	}
+	if c.rejectIdentity {
+		lsecret := strings.ToLower(secret)
+		if id := strings.ToLower(de.GetID()); id != "" && strings.Contains(lsecret, id) {
+			reasons = append(reasons, "must not contain the entity ID")
+		}
+		for _, part := range gecosParts(e.GetMeta().GetGECOS()) {
+			if strings.Contains(lsecret, part) {
+				reasons = append(reasons, "must not contain the entity's name or GECOS")
+				break
+			}
+		}
+	}
+
+	if len(reasons) > 0 {
+		return &tree.SecretPolicyError{Reasons: reasons}
+	}
+	return nil
+}
+
+// isBreached checks the secret against the breached list in both of
+// the forms that it may appear in.
+func (c *CheckSecretPolicy) isBreached(secret string) bool {
+	if len(c.breached) == 0 {
+		return false
+	}
+	if _, ok := c.breached[secret]; ok {
+		return true
+	}
+	sum := sha1.Sum([]byte(secret))
+	_, ok := c.breached[hex.EncodeToString(sum[:])]
+	return ok
+}
+
+// countClasses returns how many kinds of character appear in s.
+func countClasses(s string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// gecosParts splits the GECOS into the words that a secret may not
+// contain.  Very short words would reject too many secrets so they
+// are ignored.
+func gecosParts(gecos string) []string {
+	out := []string{}
+	for _, f := range strings.FieldsFunc(strings.ToLower(gecos), func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		if len([]rune(f)) >= 3 {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// loadWordList reads a file with one entry per line.  Blank lines
+// and lines starting with # are skipped.  Entries are lowercased if
+// fold is set.  If hashes is set then entries that look like a SHA-1
+// are lowercased and have any trailing :count removed.
+func loadWordList(path string, fold, hashes bool) (map[string]struct{}, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(viper.GetString("core.conf"), path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := make(map[string]struct{})
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		if hashes {
+			if h := strings.SplitN(l, ":", 2)[0]; isSHA1(h) {
+				l = strings.ToLower(h)
+			}
+		}
+		if fold {
+			l = strings.ToLower(l)
+		}
+		out[l] = struct{}{}
+	}
+	return out, s.Err()
+}
+
+func isSHA1(s string) bool {
+	if len(s) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func init() {
+	startup.RegisterCallback(checkSecretPolicyCB)
+}
+
+func checkSecretPolicyCB() {
+	tree.RegisterEntityHookConstructor("check-secret-policy", NewCheckSecretPolicy)
+}
+
+// NewCheckSecretPolicy returns an initialized hook ready for use.
+// An error is returned if the dictionary or breached lists can't be
+// read.
+func NewCheckSecretPolicy(opts ...tree.HookOption) (tree.EntityHook, error) {
+	opts = append([]tree.HookOption{
+		tree.WithHookName("check-secret-policy"),
+		tree.WithHookPriority(40),
+	}, opts...)
+
+	c := &CheckSecretPolicy{
+		BaseHook:       tree.NewBaseHook(opts...),
+		minLength:      viper.GetInt("secret.policy.min-length"),
+		minClasses:     viper.GetInt("secret.policy.min-classes"),
+		rejectIdentity: viper.GetBool("secret.policy.reject-identity"),
+	}
+
+	var err error
+	if c.dictionary, err = loadWordList(viper.GetString("secret.policy.dictionary"), true, false); err != nil {
+		c.Log().Error("Could not load secret dictionary", "error", err)
+		return nil, err
+	}
+	if c.breached, err = loadWordList(viper.GetString("secret.policy.breached"), false, true); err != nil {
+		c.Log().Error("Could not load breached secret list", "error", err)
+		return nil, err
+	}
+	return c, nil
+}
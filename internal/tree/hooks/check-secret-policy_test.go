@@ -0,0 +1,97 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestCheckSecretPolicy(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "words"), []byte("# words\nDragon\nmonkey\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The second entry is the SHA-1 of "Tr0ub4dor&3".
+	if err := ioutil.WriteFile(filepath.Join(dir, "breached"), []byte("letmein99\n874572E7A5AE6A49466A6AC578B98ADBA78C6AA6:12\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("core.conf", dir)
+	viper.Set("secret.policy.min-length", 8)
+	viper.Set("secret.policy.min-classes", 3)
+	viper.Set("secret.policy.dictionary", "words")
+	viper.Set("secret.policy.breached", filepath.Join(dir, "breached"))
+	viper.Set("secret.policy.reject-identity", true)
+	defer func() {
+		for _, k := range []string{"core.conf", "secret.policy.min-length", "secret.policy.min-classes", "secret.policy.dictionary", "secret.policy.breached", "secret.policy.reject-identity"} {
+			viper.Set(k, nil)
+		}
+	}()
+
+	hook, err := NewCheckSecretPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &pb.Entity{
+		ID:   proto.String("jdoe"),
+		Meta: &pb.EntityMeta{GECOS: proto.String("Jane Doe,Room 12")},
+	}
+
+	cases := []struct {
+		e       *pb.Entity
+		secret  string
+		reasons int
+	}{
+		{&pb.Entity{}, "", 0},
+		{loaded, "", 2},
+		{loaded, "short1A", 1},
+		{loaded, "alllowercase", 1},
+		{loaded, "Dragon!!1", 1},
+		{loaded, "Letmein99", 0},
+		{loaded, "Tr0ub4dor&3", 1},
+		{loaded, "letmein99", 2},
+		{&pb.Entity{ID: proto.String("jdoe")}, "xJDOE-2020", 1},
+		{loaded, "Janes-2020", 1},
+		{loaded, "Correct-Horse-9", 0},
+	}
+	for i, c := range cases {
+		err := hook.Run(context.Background(), c.e, &pb.Entity{ID: proto.String("jdoe"), Secret: proto.String(c.secret)})
+		if c.reasons == 0 {
+			if err != nil {
+				t.Errorf("%d: Unexpected error: %v", i, err)
+			}
+			continue
+		}
+		var pe *tree.SecretPolicyError
+		if !errors.As(err, &pe) || !errors.Is(err, tree.ErrSecretPolicy) {
+			t.Errorf("%d: Got %v; want a policy error", i, err)
+			continue
+		}
+		if len(pe.Reasons) != c.reasons {
+			t.Errorf("%d: Got reasons %v; want %d", i, pe.Reasons, c.reasons)
+		}
+	}
+}
+
+func TestCheckSecretPolicyBadList(t *testing.T) {
+	viper.Set("secret.policy.dictionary", filepath.Join(t.TempDir(), "missing"))
+	defer viper.Set("secret.policy.dictionary", nil)
+
+	if _, err := NewCheckSecretPolicy(); err == nil {
+		t.Error("Hook created with a missing dictionary")
+	}
+}
+
+func TestCheckSecretPolicyCB(t *testing.T) {
+	checkSecretPolicyCB()
+}
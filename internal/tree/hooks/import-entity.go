@@ -0,0 +1,56 @@
+package hooks
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// ImportEntity replaces an entity wholesale with one that has been
+// imported from elsewhere.
+type ImportEntity struct {
+	tree.BaseHook
+}
+
+// Run copies all of de to e.  Imports may omit secrets, in which case
+// the secret of the existing entity, if there is one, is retained.
+func (ie *ImportEntity) Run(ctx context.Context, e, de *pb.Entity) error {
+	proto.Merge(e, de)
+	if de.Secret != nil {
+		return nil
+	}
+
+	old, err := ie.Storage().LoadEntity(ctx, de.GetID())
+	switch err {
+	case nil:
+		e.Secret = old.Secret
+	case db.ErrUnknownEntity:
+	default:
+		return err
+	}
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(importEntityCB)
+}
+
+func importEntityCB() {
+	tree.RegisterEntityHookConstructor("import-entity", NewImportEntity)
+}
+
+// NewImportEntity returns an initialized hook ready for use.
+func NewImportEntity(opts ...tree.HookOption) (tree.EntityHook, error) {
+	opts = append([]tree.HookOption{
+		tree.WithHookName("import-entity"),
+		tree.WithHookPriority(10),
+	}, opts...)
+
+	return &ImportEntity{tree.NewBaseHook(opts...)}, nil
+}
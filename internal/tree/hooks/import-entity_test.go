@@ -0,0 +1,64 @@
+package hooks
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	_ "github.com/netauth/netauth/internal/db/memory"
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestImportEntity(t *testing.T) {
+	startup.DoCallbacks()
+	ctx := context.Background()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mdb.SaveEntity(ctx, &pb.Entity{ID: proto.String("entity1"), Secret: proto.String("old-hash")})
+
+	hook, err := NewImportEntity(tree.WithHookStorage(mdb))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Secrets that are omitted are kept.
+	e := &pb.Entity{}
+	de := &pb.Entity{ID: proto.String("entity1"), Number: proto.Int32(42)}
+	if err := hook.Run(ctx, e, de); err != nil {
+		t.Fatal(err)
+	}
+	if e.GetNumber() != 42 || e.GetSecret() != "old-hash" {
+		t.Error("Spec error - please trace hook", e)
+	}
+
+	// Secrets that are provided replace the existing one.
+	e = &pb.Entity{}
+	de.Secret = proto.String("new-hash")
+	if err := hook.Run(ctx, e, de); err != nil {
+		t.Fatal(err)
+	}
+	if e.GetSecret() != "new-hash" {
+		t.Error("Spec error - please trace hook", e)
+	}
+
+	// New entities without secrets don't get one.
+	e = &pb.Entity{}
+	if err := hook.Run(ctx, e, &pb.Entity{ID: proto.String("entity2")}); err != nil {
+		t.Fatal(err)
+	}
+	if e.Secret != nil {
+		t.Error("Spec error - please trace hook", e)
+	}
+}
+
+func TestImportEntityCB(t *testing.T) {
+	importEntityCB()
+}
@@ -0,0 +1,45 @@
+package hooks
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/startup"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+// ImportGroup replaces a group wholesale with one that has been
+// imported from elsewhere.
+type ImportGroup struct {
+	tree.BaseHook
+}
+
+// Run copies all of dg to g, except for the expansions.  Expansions
+// must be added afterwards so that they can be checked once all the
+// groups they refer to are present.
+func (*ImportGroup) Run(_ context.Context, g, dg *pb.Group) error {
+	proto.Merge(g, dg)
+	g.Expansions = nil
+	return nil
+}
+
+func init() {
+	startup.RegisterCallback(importGroupCB)
+}
+
+func importGroupCB() {
+	tree.RegisterGroupHookConstructor("import-group", NewImportGroup)
+}
+
+// NewImportGroup returns an initialized hook ready for use.
+func NewImportGroup(opts ...tree.HookOption) (tree.GroupHook, error) {
+	opts = append([]tree.HookOption{
+		tree.WithHookName("import-group"),
+		tree.WithHookPriority(5),
+	}, opts...)
+
+	return &ImportGroup{tree.NewBaseHook(opts...)}, nil
+}
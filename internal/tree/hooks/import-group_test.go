@@ -0,0 +1,38 @@
+package hooks
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestImportGroup(t *testing.T) {
+	hook, err := NewImportGroup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := &pb.Group{}
+	dg := &pb.Group{
+		Name:        proto.String("group1"),
+		DisplayName: proto.String("Group One"),
+		Number:      proto.Int32(7),
+		Expansions:  []string{"INCLUDE:group2"},
+	}
+
+	if err := hook.Run(context.Background(), g, dg); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.GetName() != "group1" || g.GetNumber() != 7 || len(g.GetExpansions()) != 0 {
+		t.Log(g)
+		t.Error("Spec error - please trace hook")
+	}
+}
+
+func TestImportGroupCB(t *testing.T) {
+	importGroupCB()
+}
@@ -3,6 +3,7 @@ package hooks
 import (
 	"context"
 
+	"github.com/netauth/netauth/internal/db"
 	"github.com/netauth/netauth/internal/startup"
 	"github.com/netauth/netauth/internal/tree"
 
@@ -17,11 +18,11 @@ type SetEntityNumber struct {
 }
 
 // Run will provision a number in one of two ways.  If the number is
-// not equal to -1 then it will be used directly with no further
-// checks and will be applied to the entity.  If the number is -1 then
-// the data storage system will be queried for the next available
-// number.  These numbers are not guaranteed to be in order or have
-// any mathematical progression, only uniqueness.
+// not equal to -1 then it will be applied to the entity as long as no
+// other entity already holds it.  If the number is -1 then the data
+// storage system will be queried for the next available number in
+// the requested range.  These numbers are not guaranteed to be in
+// order or have any mathematical progression, only uniqueness.
 func (s *SetEntityNumber) Run(ctx context.Context, e, de *pb.Entity) error {
 	if de.GetNumber() == -1 {
 		n, err := s.Storage().NextEntityNumber(ctx)
@@ -31,6 +32,16 @@ func (s *SetEntityNumber) Run(ctx context.Context, e, de *pb.Entity) error {
 		e.Number = &n
 		return nil
 	}
+
+	switch id, err := s.Storage().EntityIDForNumber(ctx, de.GetNumber()); err {
+	case db.ErrUnknownEntity:
+	case nil:
+		if id != e.GetID() {
+			return tree.ErrDuplicateNumber
+		}
+	default:
+		return err
+	}
 	e.Number = de.Number
 	return nil
 }
@@ -43,6 +43,35 @@ func TestSetEntityNumber(t *testing.T) {
 	}
 }
 
+func TestSetEntityNumberDuplicate(t *testing.T) {
+	startup.DoCallbacks()
+	ctx := context.Background()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.SaveEntity(ctx, &pb.Entity{ID: proto.String("entity1"), Number: proto.Int32(27)}); err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err := NewSetEntityNumber(tree.WithHookStorage(mdb))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &pb.Entity{ID: proto.String("entity2")}
+	if err := hook.Run(ctx, e, &pb.Entity{Number: proto.Int32(27)}); err != tree.ErrDuplicateNumber {
+		t.Error(err)
+	}
+
+	// The entity that holds the number may keep it.
+	e = &pb.Entity{ID: proto.String("entity1")}
+	if err := hook.Run(ctx, e, &pb.Entity{Number: proto.Int32(27)}); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestSetEntityNumberCB(t *testing.T) {
 	setEntityNumberCB()
 }
@@ -3,6 +3,7 @@ package hooks
 import (
 	"context"
 
+	"github.com/netauth/netauth/internal/db"
 	"github.com/netauth/netauth/internal/startup"
 	"github.com/netauth/netauth/internal/tree"
 
@@ -17,11 +18,11 @@ type SetGroupNumber struct {
 }
 
 // Run will set the group number on g.  If dg.Number is provided as a
-// non-zero positive integer, it will be used directly (care should be
-// taken this number is not already allocated).  If dg.Number is -1, a
-// number will be dynamically provisioned by the database.  It is
-// recommended to use automatic provisioning unless strictly necessary
-// to do otherwise.
+// non-zero positive integer, it will be used directly unless another
+// group already holds it.  If dg.Number is -1, a number will be
+// dynamically provisioned by the database from the requested range.
+// It is recommended to use automatic provisioning unless strictly
+// necessary to do otherwise.
 func (s *SetGroupNumber) Run(ctx context.Context, g, dg *pb.Group) error {
 	if dg.GetNumber() == -1 {
 		number, err := s.Storage().NextGroupNumber(ctx)
@@ -31,6 +32,16 @@ func (s *SetGroupNumber) Run(ctx context.Context, g, dg *pb.Group) error {
 		g.Number = &number
 		return nil
 	}
+
+	switch name, err := s.Storage().GroupNameForNumber(ctx, dg.GetNumber()); err {
+	case db.ErrUnknownGroup:
+	case nil:
+		if name != g.GetName() {
+			return tree.ErrDuplicateNumber
+		}
+	default:
+		return err
+	}
 	g.Number = dg.Number
 	return nil
 }
@@ -49,6 +49,29 @@ func TestSetGroupNumber(t *testing.T) {
 	}
 }
 
+func TestSetGroupNumberDuplicate(t *testing.T) {
+	startup.DoCallbacks()
+	ctx := context.Background()
+
+	mdb, err := db.New("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.SaveGroup(ctx, &pb.Group{Name: proto.String("group1"), Number: proto.Int32(27)}); err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err := NewSetGroupNumber(tree.WithHookStorage(mdb))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := &pb.Group{Name: proto.String("group2")}
+	if err := hook.Run(ctx, g, &pb.Group{Number: proto.Int32(27)}); err != tree.ErrDuplicateNumber {
+		t.Error(err)
+	}
+}
+
 func TestSetGroupNumberCB(t *testing.T) {
 	setGroupNumberCB()
 }
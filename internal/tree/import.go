@@ -0,0 +1,102 @@
+package tree
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/netauth/protocol"
+)
+
+// Import writes the given entities and groups to the tree, replacing
+// any that already exist.  Every object is passed through the IMPORT
+// chains, and expansions are added through the MODIFY-EXPANSIONS
+// chain, so data that has been edited by hand is subject to the same
+// checks as changes made through the API.  This happens in stages so
+// that objects may refer to one another in any order:
+//
+//   - groups are imported without their managing groups or expansions
+//   - groups with managing groups are imported again, now that the
+//     managing groups exist
+//   - entities are imported, now that their groups exist
+//   - expansions are added to the groups
+//
+// If the storage layer supports batches then the import is all or
+// nothing.  Objects that exist in the tree but not in the import are
+// left alone.
+func (m *Manager) Import(ctx context.Context, entities []*pb.Entity, groups []*pb.Group) error {
+	if err := checkImport(entities, groups); err != nil {
+		return err
+	}
+
+	return m.batch(ctx, func(ctx context.Context) error {
+		for _, g := range groups {
+			dg := proto.Clone(g).(*pb.Group)
+			dg.ManagedBy = nil
+			if _, err := m.RunGroupChain(ctx, "IMPORT", dg); err != nil {
+				return fmt.Errorf("group %s: %w", g.GetName(), err)
+			}
+		}
+
+		for _, g := range groups {
+			if g.GetManagedBy() == "" {
+				continue
+			}
+			if _, err := m.RunGroupChain(ctx, "IMPORT", g); err != nil {
+				return fmt.Errorf("group %s: %w", g.GetName(), err)
+			}
+		}
+
+		for _, e := range entities {
+			if _, err := m.RunEntityChain(ctx, "IMPORT", e); err != nil {
+				return fmt.Errorf("entity %s: %w", e.GetID(), err)
+			}
+		}
+
+		for _, g := range groups {
+			for _, exp := range g.GetExpansions() {
+				dg := &pb.Group{Name: g.Name, Expansions: []string{exp}}
+				if _, err := m.RunGroupChain(ctx, "MODIFY-EXPANSIONS", dg); err != nil {
+					return fmt.Errorf("group %s: expansion %s: %w", g.GetName(), exp, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// checkImport looks for problems that the hooks are not expected to
+// handle, since the API does not allow them to be expressed.
+func checkImport(entities []*pb.Entity, groups []*pb.Group) error {
+	seen := make(map[string]bool, len(entities))
+	for _, e := range entities {
+		switch {
+		case e.GetID() == "":
+			return fmt.Errorf("entity: %w", ErrMissingName)
+		case seen[e.GetID()]:
+			return fmt.Errorf("entity %s: %w", e.GetID(), ErrDuplicateEntityID)
+		}
+		seen[e.GetID()] = true
+	}
+
+	seen = make(map[string]bool, len(groups))
+	for _, g := range groups {
+		switch {
+		case g.GetName() == "":
+			return fmt.Errorf("group: %w", ErrMissingName)
+		case seen[g.GetName()]:
+			return fmt.Errorf("group %s: %w", g.GetName(), ErrDuplicateGroupName)
+		}
+		seen[g.GetName()] = true
+
+		for _, exp := range g.GetExpansions() {
+			parts := strings.SplitN(exp, ":", 2)
+			if len(parts) != 2 || parts[1] == "" || (parts[0] != "INCLUDE" && parts[0] != "EXCLUDE") {
+				return fmt.Errorf("group %s: %w", g.GetName(), ErrMalformedExpansion)
+			}
+		}
+	}
+	return nil
+}
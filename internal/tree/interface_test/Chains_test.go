@@ -0,0 +1,101 @@
+package interface_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/netauth/netauth/internal/tree"
+)
+
+func TestConfiguredChains(t *testing.T) {
+	ctx := context.Background()
+
+	// The entity must be unlocked to change its secret, and the
+	// names of configured chains are not case sensitive.
+	m, _ := newTreeManager(t, tree.WithEntityChains(tree.ChainConfig{
+		"set-secret": {"load-entity", "validate-entity-unlocked", "set-entity-secret", "save-entity"},
+	}))
+
+	want := []tree.ChainEntry{
+		{Hook: "load-entity", Priority: 0},
+		{Hook: "validate-entity-unlocked", Priority: 20},
+		{Hook: "set-entity-secret", Priority: 50},
+		{Hook: "save-entity", Priority: 99},
+	}
+	got := m.EntityChains()["SET-SECRET"]
+	if len(got) != len(want) {
+		t.Fatalf("Got %v; Want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Got %v; Want %v", got[i], want[i])
+		}
+	}
+
+	if err := m.CreateEntity(ctx, "entity1", -1, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.LockEntity(ctx, "entity1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetSecret(ctx, "entity1", "secret2"); err != tree.ErrEntityLocked {
+		t.Errorf("Got %v; Want %v", err, tree.ErrEntityLocked)
+	}
+
+	// Chains that aren't configured keep their defaults.
+	if len(m.EntityChains()["FETCH"]) != 1 || len(m.GroupChains()["FETCH"]) != 1 {
+		t.Error("Default chain was modified")
+	}
+}
+
+func TestConfiguredChainPriority(t *testing.T) {
+	m, _ := newTreeManager(t, tree.WithGroupChains(tree.ChainConfig{
+		"FETCH": {"load-group:50", "merge-group-meta:10"},
+	}))
+
+	got := m.GroupChains()["FETCH"]
+	if len(got) != 2 || got[0].Hook != "merge-group-meta" || got[1] != (tree.ChainEntry{Hook: "load-group", Priority: 50}) {
+		t.Errorf("Chain was not reordered: %v", got)
+	}
+}
+
+func TestConfiguredChainDefaults(t *testing.T) {
+	d, _ := newTreeManager(t)
+	m, _ := newTreeManager(t, tree.WithEntityChains(tree.ChainConfig{
+		"SET-SECRET": {tree.DefaultHooks, "validate-entity-unlocked"},
+	}))
+
+	// The configured hook is added to every default hook.
+	want := d.EntityChains()["SET-SECRET"]
+	got := m.EntityChains()["SET-SECRET"]
+	if len(got) != len(want)+1 {
+		t.Fatalf("Got %v; Want %v and validate-entity-unlocked", got, want)
+	}
+	found := false
+	for _, e := range got {
+		found = found || e.Hook == "validate-entity-unlocked"
+	}
+	if !found {
+		t.Errorf("Configured hook is missing: %v", got)
+	}
+}
+
+func TestConfiguredChainsInvalid(t *testing.T) {
+	cases := []struct {
+		opt     tree.Option
+		wantErr error
+	}{
+		{tree.WithEntityChains(tree.ChainConfig{"NOT-A-CHAIN": {"load-entity"}}), tree.ErrUnknownHookChain},
+		{tree.WithEntityChains(tree.ChainConfig{"FETCH": {"not-a-hook"}}), tree.ErrUnknownHook},
+		{tree.WithEntityChains(tree.ChainConfig{"FETCH": {"load-entity:first"}}), tree.ErrBadHookPriority},
+		{tree.WithEntityChains(tree.ChainConfig{"FETCH": {}}), tree.ErrEmptyHookChain},
+		{tree.WithGroupChains(tree.ChainConfig{"FETCH": {}}), tree.ErrEmptyHookChain},
+	}
+
+	_, mdb := newTreeManager(t)
+	for i, c := range cases {
+		if _, err := tree.New(tree.WithStorage(mdb), c.opt); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+	}
+}
@@ -34,6 +34,30 @@ func TestFetchEntity(t *testing.T) {
 	}
 }
 
+func TestFetchEntityByNumber(t *testing.T) {
+	ctxt := context.Background()
+	m, mdb := newTreeManager(t)
+
+	addEntity(t, mdb)
+
+	id, err := m.EntityIDForNumber(ctxt, 1)
+	if err != nil || id != "entity1" {
+		t.Fatal(id, err)
+	}
+
+	e, err := m.FetchEntityByNumber(ctxt, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetID() != "entity1" || e.GetSecret() != "<REDACTED>" {
+		t.Error("Fetched wrong or unredacted entity", e)
+	}
+
+	if _, err := m.FetchEntityByNumber(ctxt, 2); err != db.ErrUnknownEntity {
+		t.Error(err)
+	}
+}
+
 func TestFetchEntityNonExistant(t *testing.T) {
 	m, _ := newTreeManager(t)
 	if _, err := m.FetchEntity(context.Background(), "non-existent"); err != db.ErrUnknownEntity {
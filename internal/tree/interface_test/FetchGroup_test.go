@@ -5,6 +5,8 @@ import (
 	"testing"
 
 	"google.golang.org/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
 )
 
 func TestFetchGroup(t *testing.T) {
@@ -27,3 +29,22 @@ func TestFetchGroup(t *testing.T) {
 		t.Error("Group handling error")
 	}
 }
+
+func TestFetchGroupByNumber(t *testing.T) {
+	ctxt := context.Background()
+	m, mdb := newTreeManager(t)
+
+	addGroup(t, mdb)
+
+	g, err := m.FetchGroupByNumber(ctxt, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GetName() != "group1" {
+		t.Error("Fetched wrong group", g)
+	}
+
+	if _, err := m.FetchGroupByNumber(ctxt, 2); err != db.ErrUnknownGroup {
+		t.Error(err)
+	}
+}
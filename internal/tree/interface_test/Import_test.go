@@ -0,0 +1,124 @@
+package interface_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netauth/netauth/internal/db"
+	"github.com/netauth/netauth/internal/tree"
+
+	pb "github.com/netauth/protocol"
+)
+
+func TestImport(t *testing.T) {
+	ctxt := context.Background()
+	m, mdb := newTreeManager(t)
+
+	entities := []*pb.Entity{{
+		ID:     proto.String("entity1"),
+		Number: proto.Int32(1),
+		Secret: proto.String("hash"),
+		Meta:   &pb.EntityMeta{Groups: []string{"group1"}},
+	}}
+	// Groups refer to ones that come later in the list.
+	groups := []*pb.Group{
+		{
+			Name:       proto.String("group1"),
+			ManagedBy:  proto.String("group2"),
+			Expansions: []string{"INCLUDE:group2"},
+		},
+		{Name: proto.String("group2"), Number: proto.Int32(2)},
+	}
+
+	if err := m.Import(ctxt, entities, groups); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := mdb.LoadGroup(ctxt, "group1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GetManagedBy() != "group2" || len(g.GetExpansions()) != 1 {
+		t.Error("group1 was not imported correctly", g)
+	}
+	e, err := mdb.LoadEntity(ctxt, "entity1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetSecret() != "hash" || e.GetMeta().GetGroups()[0] != "group1" {
+		t.Error("entity1 was not imported correctly", e)
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	ctxt := context.Background()
+
+	cases := []struct {
+		name     string
+		entities []*pb.Entity
+		groups   []*pb.Group
+		wantErr  error
+	}{
+		{
+			name: "cycle",
+			groups: []*pb.Group{
+				{Name: proto.String("group1"), Expansions: []string{"INCLUDE:group2"}},
+				{Name: proto.String("group2"), Expansions: []string{"INCLUDE:group1"}},
+			},
+			wantErr: tree.ErrExistingExpansion,
+		},
+		{
+			name: "missing-target",
+			groups: []*pb.Group{
+				{Name: proto.String("group1"), Expansions: []string{"EXCLUDE:group2"}},
+			},
+			wantErr: db.ErrUnknownGroup,
+		},
+		{
+			name: "malformed-expansion",
+			groups: []*pb.Group{
+				{Name: proto.String("group1"), Expansions: []string{"group2"}},
+			},
+			wantErr: tree.ErrMalformedExpansion,
+		},
+		{
+			name: "missing-manager",
+			groups: []*pb.Group{
+				{Name: proto.String("group1"), ManagedBy: proto.String("group2")},
+			},
+			wantErr: db.ErrUnknownGroup,
+		},
+		{
+			name: "missing-group",
+			entities: []*pb.Entity{
+				{ID: proto.String("entity1"), Meta: &pb.EntityMeta{Groups: []string{"group1"}}},
+			},
+			wantErr: db.ErrUnknownGroup,
+		},
+		{
+			name:     "duplicate",
+			entities: []*pb.Entity{{ID: proto.String("entity1")}, {ID: proto.String("entity1")}},
+			wantErr:  tree.ErrDuplicateEntityID,
+		},
+		{
+			name:    "unnamed",
+			groups:  []*pb.Group{{DisplayName: proto.String("No Name")}},
+			wantErr: tree.ErrMissingName,
+		},
+	}
+
+	for _, c := range cases {
+		m, mdb := newTreeManager(t)
+		if err := m.Import(ctxt, c.entities, c.groups); !errors.Is(err, c.wantErr) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.wantErr)
+		}
+
+		// Nothing is written when the import fails.
+		if ids, _ := mdb.DiscoverGroupNames(ctxt); len(ids) != 0 {
+			t.Errorf("%s: partial import was written: %v", c.name, ids)
+		}
+	}
+}
@@ -2,7 +2,12 @@ package interface_test
 
 import (
 	"context"
+	"errors"
 	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/tree"
 )
 
 func TestSetSecret(t *testing.T) {
@@ -22,3 +27,29 @@ func TestSetSecret(t *testing.T) {
 		t.Error("Secret not set correctly")
 	}
 }
+
+func TestSetSecretHistory(t *testing.T) {
+	viper.Set("secret.history.size", 2)
+	defer viper.Set("secret.history.size", nil)
+
+	ctxt := context.Background()
+	em, mdb := newTreeManager(t)
+
+	addEntity(t, mdb)
+
+	if err := em.SetSecret(ctxt, "entity1", "entity1"); !errors.Is(err, tree.ErrSecretPolicy) {
+		t.Errorf("Reused current secret: %v", err)
+	}
+	if err := em.SetSecret(ctxt, "entity1", "secret1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.SetSecret(ctxt, "entity1", "entity1"); !errors.Is(err, tree.ErrSecretPolicy) {
+		t.Errorf("Reused previous secret: %v", err)
+	}
+	if err := em.SetSecret(ctxt, "entity1", "secret2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.SetSecret(ctxt, "entity1", "entity1"); err != nil {
+		t.Errorf("Secret older than the history was rejected: %v", err)
+	}
+}
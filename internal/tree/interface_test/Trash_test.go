@@ -0,0 +1,90 @@
+package interface_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+func TestEntityTrash(t *testing.T) {
+	viper.Set("trash.enabled", true)
+	defer viper.Set("trash.enabled", false)
+	m, mdb := newTreeManager(t)
+	ctx := context.Background()
+
+	addEntity(t, mdb)
+	addGroup(t, mdb)
+	if err := m.AddEntityToGroup(ctx, "entity1", "group1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.DestroyEntity(ctx, "entity1"); err != nil {
+		t.Fatal(err)
+	}
+	if members, _ := m.ListMembers(ctx, "group1"); len(members) != 0 {
+		t.Error("Trashed entity is still a member")
+	}
+
+	trash, err := m.EntityTrash(ctx)
+	if err != nil || len(trash) != 1 || trash[0].Name != "entity1" {
+		t.Fatalf("Bad trash: %v %v", trash, err)
+	}
+
+	if err := m.RestoreEntity(ctx, "entity1"); err != nil {
+		t.Fatal(err)
+	}
+	if members, _ := m.ListMembers(ctx, "group1"); len(members) != 1 {
+		t.Error("Restored entity is not a member")
+	}
+
+	if err := m.DestroyEntity(ctx, "entity1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PurgeEntity(ctx, "entity1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RestoreEntity(ctx, "entity1"); err != db.ErrNotInTrash {
+		t.Errorf("Purged entity was restored: %v", err)
+	}
+}
+
+func TestGroupTrash(t *testing.T) {
+	viper.Set("trash.enabled", true)
+	defer viper.Set("trash.enabled", false)
+	m, mdb := newTreeManager(t)
+	ctx := context.Background()
+
+	addGroup(t, mdb)
+
+	if err := m.DestroyGroup(ctx, "group1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.FetchGroup(ctx, "group1"); err != db.ErrUnknownGroup {
+		t.Errorf("Trashed group is still visible: %v", err)
+	}
+
+	trash, err := m.GroupTrash(ctx)
+	if err != nil || len(trash) != 1 || trash[0].Name != "group1" {
+		t.Fatalf("Bad trash: %v %v", trash, err)
+	}
+
+	if err := m.RestoreGroup(ctx, "group1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.FetchGroup(ctx, "group1"); err != nil {
+		t.Error(err)
+	}
+
+	if err := m.DestroyGroup(ctx, "group1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PurgeGroup(ctx, "group1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PurgeGroup(ctx, "group1"); err != db.ErrNotInTrash {
+		t.Errorf("Group purged twice: %v", err)
+	}
+}
@@ -17,7 +17,7 @@ import (
 	pb "github.com/netauth/protocol"
 )
 
-func newTreeManager(t *testing.T) (*tree.Manager, tree.DB) {
+func newTreeManager(t *testing.T, opts ...tree.Option) (*tree.Manager, tree.DB) {
 	startup.DoCallbacks()
 
 	mdb, err := db.New("memory")
@@ -30,7 +30,7 @@ func newTreeManager(t *testing.T) (*tree.Manager, tree.DB) {
 		t.Fatal(err)
 	}
 
-	em, err := tree.New(tree.WithStorage(mdb), tree.WithCrypto(crypto))
+	em, err := tree.New(append([]tree.Option{tree.WithStorage(mdb), tree.WithCrypto(crypto)}, opts...)...)
 	if err != nil {
 		t.Fatal(err)
 	}
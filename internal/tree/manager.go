@@ -1,8 +1,11 @@
 package tree
 
 import (
+	"context"
+
 	"github.com/hashicorp/go-hclog"
 
+	"github.com/netauth/netauth/internal/db"
 	"github.com/netauth/netauth/internal/mresolver"
 )
 
@@ -33,9 +36,17 @@ func New(opts ...Option) (*Manager, error) {
 	x.entityHooks = make(map[string]EntityHook)
 	x.InitializeEntityHooks()
 
-	// Construct entity chains out of the bound plugins.
+	// Construct entity chains out of the bound plugins, with any
+	// configured chains replacing the defaults.
+	eChains, err := mergeChains(defaultEntityChains, x.entityChainConfig)
+	if err != nil {
+		x.log.Error("Invalid entity chain configuration", "error", err)
+		return nil, err
+	}
 	x.entityProcesses = make(map[string][]EntityHook)
-	x.InitializeEntityChains(defaultEntityChains)
+	if err := x.InitializeEntityChains(eChains); err != nil {
+		return nil, err
+	}
 
 	// Check that required chains are loaded, bailing out if they
 	// aren't.
@@ -48,8 +59,15 @@ func New(opts ...Option) (*Manager, error) {
 	x.InitializeGroupHooks()
 
 	// Construct group chains out of the bound plugins.
+	gChains, err := mergeChains(defaultGroupChains, x.groupChainConfig)
+	if err != nil {
+		x.log.Error("Invalid group chain configuration", "error", err)
+		return nil, err
+	}
 	x.groupProcesses = make(map[string][]GroupHook)
-	x.InitializeGroupChains(defaultGroupChains)
+	if err := x.InitializeGroupChains(gChains); err != nil {
+		return nil, err
+	}
 
 	// Check that required chains are loaded, bailing out if they aren't.
 	if err := x.CheckRequiredGroupChains(); err != nil {
@@ -61,6 +79,38 @@ func New(opts ...Option) (*Manager, error) {
 	return &x, nil
 }
 
+// maxChainAttempts is the number of times that a chain will be run
+// before a conflict is returned to the caller.
+const maxChainAttempts = 3
+
+// batch runs f within a storage batch if the DB supports them, and
+// directly otherwise.  If the batch fails to commit because an object
+// was concurrently modified then f is run again, up to
+// maxChainAttempts times.
+func (m *Manager) batch(ctx context.Context, f func(context.Context) error) error {
+	b, ok := m.db.(Batcher)
+	if !ok {
+		return f(ctx)
+	}
+
+	var err error
+	for i := 0; i < maxChainAttempts; i++ {
+		// A duplicate number at commit means another chain
+		// took the number after it was checked.  Running the
+		// chain again will either choose another number or
+		// report the duplicate from the hook that checks it.
+		if err = b.Batch(ctx, f); err != db.ErrConflict && err != db.ErrDuplicateNumber {
+			return err
+		}
+		m.log.Debug("Conflicting write, retrying", "attempt", i+1, "error", err)
+	}
+	m.log.Warn("Conflicting write, giving up", "attempts", maxChainAttempts)
+	if err == db.ErrDuplicateNumber {
+		return ErrDuplicateNumber
+	}
+	return err
+}
+
 // SetParentLogger sets the parent logger for this instance.
 func SetParentLogger(l hclog.Logger) {
 	initlb = l.Named("tree.init")
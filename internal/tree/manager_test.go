@@ -1,9 +1,12 @@
 package tree
 
 import (
+	"context"
 	"testing"
 
 	"github.com/hashicorp/go-hclog"
+
+	"github.com/netauth/netauth/internal/db"
 )
 
 func TestSetParentLogger(t *testing.T) {
@@ -23,3 +26,49 @@ func TestLogParentUnset(t *testing.T) {
 		t.Error("auto log was not aquired")
 	}
 }
+
+// conflictDB is a Batcher that reports a conflict for the first few
+// commits.
+type conflictDB struct {
+	DB
+
+	conflicts int
+	commits   int
+}
+
+func (c *conflictDB) Batch(ctx context.Context, f func(context.Context) error) error {
+	if err := f(ctx); err != nil {
+		return err
+	}
+	c.commits++
+	if c.commits <= c.conflicts {
+		return db.ErrConflict
+	}
+	return nil
+}
+
+func TestBatchRetry(t *testing.T) {
+	cases := []struct {
+		conflicts int
+		wantRuns  int
+		wantErr   error
+	}{
+		{0, 1, nil},
+		{2, 3, nil},
+		{5, maxChainAttempts, db.ErrConflict},
+	}
+
+	for i, c := range cases {
+		cdb := &conflictDB{conflicts: c.conflicts}
+		m := Manager{db: cdb, log: hclog.NewNullLogger()}
+
+		runs := 0
+		err := m.batch(context.Background(), func(context.Context) error {
+			runs++
+			return nil
+		})
+		if err != c.wantErr || runs != c.wantRuns {
+			t.Errorf("%d: Got %v after %d runs; Want %v after %d runs", i, err, runs, c.wantErr, c.wantRuns)
+		}
+	}
+}
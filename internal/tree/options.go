@@ -17,3 +17,19 @@ func WithCrypto(c crypto.EMCrypto) Option {
 func WithLogger(l hclog.Logger) Option {
 	return func(m *Manager) { m.log = l.Named("tree") }
 }
+
+// WithEntityChains replaces the default entity chains with those
+// that are configured.  Chains that aren't configured keep their
+// default hooks.  A configured chain replaces the whole default
+// chain, so to add hooks to a chain either list DefaultHooks along
+// with them, or restate every default hook as shown by netauth
+// system chains.
+func WithEntityChains(c ChainConfig) Option {
+	return func(m *Manager) { m.entityChainConfig = c }
+}
+
+// WithGroupChains replaces the default group chains in the same way
+// as WithEntityChains.
+func WithGroupChains(c ChainConfig) Option {
+	return func(m *Manager) { m.groupChainConfig = c }
+}
@@ -11,20 +11,56 @@ import (
 // SearchGroups returns a list of groups filtered by the search
 // criteria.
 func (m *Manager) SearchGroups(ctx context.Context, r db.SearchRequest) ([]*pb.Group, error) {
-	return m.db.SearchGroups(ctx, r)
+	groups, _, err := m.SearchGroupPage(ctx, r)
+	return groups, err
+}
+
+// SearchGroupPage returns a single page of the groups that match the
+// search criteria, along with the total number of matches and the
+// cursor for the next page.  Membership in LDAP filters is resolved
+// by the tree's own resolver.
+func (m *Manager) SearchGroupPage(ctx context.Context, r db.SearchRequest) ([]*pb.Group, db.SearchResult, error) {
+	if r.Resolver == nil {
+		r.Resolver = m.resolver
+	}
+	return m.db.SearchGroupPage(ctx, r)
 }
 
 // SearchEntities returns a list of entities filtered by the search
 // criteria.
 func (m *Manager) SearchEntities(ctx context.Context, r db.SearchRequest) ([]*pb.Entity, error) {
-	entities, err := m.db.SearchEntities(ctx, r)
+	entities, _, err := m.SearchEntityPage(ctx, r)
+	return entities, err
+}
+
+// SearchEntityPage returns a single page of the entities that match
+// the search criteria, along with the total number of matches and the
+// cursor for the next page.  Membership in LDAP filters is resolved
+// by the tree's own resolver.
+func (m *Manager) SearchEntityPage(ctx context.Context, r db.SearchRequest) ([]*pb.Entity, db.SearchResult, error) {
+	if r.Resolver == nil {
+		r.Resolver = m.resolver
+	}
+	entities, res, err := m.db.SearchEntityPage(ctx, r)
 	if err != nil {
-		return nil, err
+		return nil, db.SearchResult{}, err
 	}
 
 	out := make([]*pb.Entity, len(entities))
 	for i := range entities {
 		out[i] = safeCopyEntity(entities[i])
 	}
-	return out, nil
+	return out, res, nil
+}
+
+// AggregateEntities counts the entities that have each value of the
+// requested fields.
+func (m *Manager) AggregateEntities(ctx context.Context, r db.AggregateRequest) ([]db.Facet, error) {
+	return m.db.AggregateEntities(ctx, r)
+}
+
+// AggregateGroups counts the groups that have each value of the
+// requested fields.
+func (m *Manager) AggregateGroups(ctx context.Context, r db.AggregateRequest) ([]db.Facet, error) {
+	return m.db.AggregateGroups(ctx, r)
 }
@@ -0,0 +1,40 @@
+package tree
+
+import (
+	"context"
+
+	"github.com/netauth/netauth/internal/db"
+)
+
+// EntityTrash returns the entities that have been destroyed but are
+// still held in the trash.
+func (m *Manager) EntityTrash(ctx context.Context) ([]db.TrashEntry, error) {
+	return m.db.Trash(ctx, db.TrashEntity)
+}
+
+// RestoreEntity brings an entity back from the trash.  The entity is
+// restored exactly as it was when it was destroyed.
+func (m *Manager) RestoreEntity(ctx context.Context, ID string) error {
+	return m.db.RestoreEntity(ctx, ID)
+}
+
+// PurgeEntity permanently removes an entity from the trash.
+func (m *Manager) PurgeEntity(ctx context.Context, ID string) error {
+	return m.db.PurgeTrash(ctx, db.TrashEntity, ID)
+}
+
+// GroupTrash returns the groups that have been destroyed but are
+// still held in the trash.
+func (m *Manager) GroupTrash(ctx context.Context) ([]db.TrashEntry, error) {
+	return m.db.Trash(ctx, db.TrashGroup)
+}
+
+// RestoreGroup brings a group back from the trash.
+func (m *Manager) RestoreGroup(ctx context.Context, name string) error {
+	return m.db.RestoreGroup(ctx, name)
+}
+
+// PurgeGroup permanently removes a group from the trash.
+func (m *Manager) PurgeGroup(ctx context.Context, name string) error {
+	return m.db.PurgeTrash(ctx, db.TrashGroup, name)
+}
@@ -32,6 +32,10 @@ type Manager struct {
 	entityProcesses map[string][]EntityHook
 	groupProcesses  map[string][]GroupHook
 
+	// Chains that are configured to replace the defaults.
+	entityChainConfig ChainConfig
+	groupChainConfig  ChainConfig
+
 	resolver *mresolver.MResolver
 
 	log hclog.Logger
@@ -45,7 +49,9 @@ type DB interface {
 	SaveEntity(context.Context, *types.Entity) error
 	DeleteEntity(context.Context, string) error
 	NextEntityNumber(context.Context) (int32, error)
+	EntityIDForNumber(context.Context, int32) (string, error)
 	SearchEntities(context.Context, db.SearchRequest) ([]*types.Entity, error)
+	SearchEntityPage(context.Context, db.SearchRequest) ([]*types.Entity, db.SearchResult, error)
 
 	// Group handling
 	DiscoverGroupNames(context.Context) ([]string, error)
@@ -53,18 +59,51 @@ type DB interface {
 	SaveGroup(context.Context, *types.Group) error
 	DeleteGroup(context.Context, string) error
 	NextGroupNumber(context.Context) (int32, error)
+	GroupNameForNumber(context.Context, int32) (string, error)
 	SearchGroups(context.Context, db.SearchRequest) ([]*types.Group, error)
+	SearchGroupPage(context.Context, db.SearchRequest) ([]*types.Group, db.SearchResult, error)
+
+	// Reporting
+	AggregateEntities(context.Context, db.AggregateRequest) ([]db.Facet, error)
+	AggregateGroups(context.Context, db.AggregateRequest) ([]db.Facet, error)
+
+	// Trash handling
+	Trash(context.Context, string) ([]db.TrashEntry, error)
+	RestoreEntity(context.Context, string) error
+	RestoreGroup(context.Context, string) error
+	PurgeTrash(context.Context, string, string) error
+
+	// Secret history handling
+	LoadSecretHistory(context.Context, string) ([]db.SecretHistoryEntry, error)
+	SaveSecretHistory(context.Context, string, []db.SecretHistoryEntry) error
 
 	// Callbacks
 	RegisterCallback(string, db.Callback)
 }
 
+// A Batcher is a DB that is able to group the writes made while
+// running a chain so that they are committed together.  The writes
+// are held until the function returns, and are discarded if it
+// returns an error.
+type Batcher interface {
+	Batch(context.Context, func(context.Context) error) error
+}
+
 // The ChainConfig type maps from chain name to a list of hooks that
 // should be in this chain.  The same type is used for entities and
 // groups, but as these each have separate chains, different configs
-// must be created and loaded for each.
+// must be created and loaded for each.  A hook may be given as
+// name:priority to run it at a different priority than its own, and
+// hooks with the same priority run in the order they are listed.
+// DefaultHooks stands for the hooks that the chain has by default.
 type ChainConfig map[string][]string
 
+// A ChainEntry describes a hook in a chain that has been loaded.
+type ChainEntry struct {
+	Hook     string
+	Priority int
+}
+
 // Option is a type used to feed in various configurables when
 // initializing a new Manager construct.  This follows the variadic
 // types pattern for option passing.
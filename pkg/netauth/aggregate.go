@@ -0,0 +1,68 @@
+package netauth
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// EntityAggregate counts the entities that have each value of the
+// named fields.  The fields that can be counted are PrimaryGroup,
+// Shell, and Locked.  If expr is not empty then only the entities
+// that match it are counted, and if size is greater than zero then
+// at most that many values are returned for each field.  The server
+// applies its own limit as well, and values beyond either limit are
+// summed into Facet.Other.  Aggregating does not require an
+// authenticated context.
+func (c *Client) EntityAggregate(ctx context.Context, expr string, fields []string, size int) ([]Facet, error) {
+	return c.aggregate(ctx, "EntityAggregate", expr, fields, size)
+}
+
+// GroupAggregate counts the groups that have each value of the named
+// fields in the same way as EntityAggregate.  The only field that can
+// be counted is ManagedBy.
+func (c *Client) GroupAggregate(ctx context.Context, expr string, fields []string, size int) ([]Facet, error) {
+	return c.aggregate(ctx, "GroupAggregate", expr, fields, size)
+}
+
+func (c *Client) aggregate(ctx context.Context, method, expr string, fields []string, size int) ([]Facet, error) {
+	ctx = c.appendMetadata(ctx)
+
+	f := make([]interface{}, len(fields))
+	for i := range fields {
+		f[i] = fields[i]
+	}
+	r, err := structpb.NewStruct(map[string]interface{}{
+		"expression": expr,
+		"fields":     f,
+		"size":       size,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &structpb.Struct{}
+	if err := c.ext.Invoke(ctx, extMethod(method), r, res); err != nil {
+		return nil, err
+	}
+
+	out := []Facet{}
+	for _, v := range res.GetFields()["facets"].GetListValue().GetValues() {
+		fv := v.GetStructValue().GetFields()
+		facet := Facet{
+			Field:   fv["field"].GetStringValue(),
+			Missing: int(fv["missing"].GetNumberValue()),
+			Other:   int(fv["other"].GetNumberValue()),
+			Terms:   []FacetTerm{},
+		}
+		for _, t := range fv["terms"].GetListValue().GetValues() {
+			tv := t.GetStructValue().GetFields()
+			facet.Terms = append(facet.Terms, FacetTerm{
+				Term:  tv["term"].GetStringValue(),
+				Count: int(tv["count"].GetNumberValue()),
+			})
+		}
+		out = append(out, facet)
+	}
+	return out, nil
+}
@@ -3,6 +3,7 @@ package netauth
 import (
 	"context"
 	"errors"
+	"math"
 	"sort"
 	"strings"
 
@@ -69,6 +70,32 @@ func (c *Client) EntityInfo(ctx context.Context, id string) (pb.Entity, error) {
 	return *res.GetEntities()[0], nil
 }
 
+// EntityInfoByNumber returns the entity that holds the given number.
+// This is a direct lookup and does not involve the search engine, so
+// it is suitable for resolving UIDs.  The number must fit in the
+// range used for entity numbers.
+func (c *Client) EntityInfoByNumber(ctx context.Context, number int) (*pb.Entity, error) {
+	if number < 0 || number > math.MaxInt32 {
+		return nil, errors.New("number must be between 0 and 2147483647")
+	}
+
+	ctx = c.appendMetadata(ctx)
+	r := rpc.EntityRequest{
+		Entity: &pb.Entity{
+			Number: proto.Int32(int32(number)),
+		},
+	}
+
+	res := &rpc.ListOfEntities{}
+	if err := c.ext.Invoke(ctx, extMethod("EntityByNumber"), &r, res); err != nil {
+		return nil, err
+	}
+	if len(res.GetEntities()) == 0 {
+		return nil, errors.New("server returned no entity")
+	}
+	return res.GetEntities()[0], nil
+}
+
 // EntitySearch performs a search of all entities.  This search will
 // return a slice of zero or more entities that matched the search
 // criteria.  Searching does not require an authenticated context.
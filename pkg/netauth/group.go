@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"sort"
 	"strings"
 
@@ -76,6 +77,32 @@ func (c *Client) GroupInfo(ctx context.Context, name string) (*pb.Group, []*pb.G
 	return res.GetGroups()[0], res2, nil
 }
 
+// GroupInfoByNumber returns the group that holds the given number.
+// This is a direct lookup and does not involve the search engine, so
+// it is suitable for resolving GIDs.  Unlike GroupInfo the groups
+// managed by the group are not returned.
+func (c *Client) GroupInfoByNumber(ctx context.Context, number int) (*pb.Group, error) {
+	if number < 0 || number > math.MaxInt32 {
+		return nil, errors.New("number must be between 0 and 2147483647")
+	}
+
+	ctx = c.appendMetadata(ctx)
+	r := rpc.GroupRequest{
+		Group: &pb.Group{
+			Number: proto.Int32(int32(number)),
+		},
+	}
+
+	res := &rpc.ListOfGroups{}
+	if err := c.ext.Invoke(ctx, extMethod("GroupByNumber"), &r, res); err != nil {
+		return nil, err
+	}
+	if len(res.GetGroups()) == 0 {
+		return nil, errors.New("server returned no group")
+	}
+	return res.GetGroups()[0], nil
+}
+
 // GroupUM handles operations concerning the untyped key-value store
 // on each group.  This data is not directly processed by NetAuth or
 // visible in search indexes, but is useful for integrating with 3rd
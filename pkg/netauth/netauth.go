@@ -56,6 +56,7 @@ func NewWithLog(l hclog.Logger) (*Client, error) {
 
 	return &Client{
 		rpc:        rpc.NewNetAuth2Client(conn),
+		ext:        conn,
 		log:        l,
 		clientName: viper.GetString("client.ID"),
 	}, nil
@@ -121,6 +122,7 @@ func (c *Client) makeWritable() error {
 		return err
 	}
 	c.rpc = rpc.NewNetAuth2Client(conn)
+	c.ext = conn
 	c.writeable = true
 	return nil
 }
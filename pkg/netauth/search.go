@@ -0,0 +1,85 @@
+package netauth
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/netauth/protocol"
+	rpc "github.com/netauth/protocol/v2"
+)
+
+// EntitySearchPage performs a search of all entities in the same way
+// as EntitySearch, but returns only the page of results selected by
+// opts along with a description of the page.
+func (c *Client) EntitySearchPage(ctx context.Context, expr string, opts SearchOptions) ([]*pb.Entity, SearchPage, error) {
+	ctx = withSearchOptions(c.appendMetadata(ctx), opts)
+	r := rpc.SearchRequest{
+		Expression: &expr,
+	}
+
+	var md metadata.MD
+	res, err := c.rpc.EntitySearch(ctx, &r, grpc.Header(&md))
+	if err != nil {
+		return nil, SearchPage{}, err
+	}
+	return res.GetEntities(), searchPage(md), nil
+}
+
+// GroupSearchPage performs a search of all groups in the same way as
+// GroupSearch, but returns only the page of results selected by opts
+// along with a description of the page.
+func (c *Client) GroupSearchPage(ctx context.Context, expr string, opts SearchOptions) ([]*pb.Group, SearchPage, error) {
+	ctx = withSearchOptions(c.appendMetadata(ctx), opts)
+	r := rpc.SearchRequest{
+		Expression: &expr,
+	}
+
+	var md metadata.MD
+	res, err := c.rpc.GroupSearch(ctx, &r, grpc.Header(&md))
+	if err != nil {
+		return nil, SearchPage{}, err
+	}
+	return res.GetGroups(), searchPage(md), nil
+}
+
+// withSearchOptions attaches the search options to the outgoing
+// metadata, since the search request has no fields for them.
+func withSearchOptions(ctx context.Context, opts SearchOptions) context.Context {
+	kv := []string{}
+	if opts.PageSize > 0 {
+		kv = append(kv, "page-size", strconv.Itoa(opts.PageSize))
+	}
+	if opts.Offset > 0 {
+		kv = append(kv, "page-offset", strconv.Itoa(opts.Offset))
+	}
+	if opts.Cursor != "" {
+		kv = append(kv, "page-cursor", opts.Cursor)
+	}
+	if len(opts.SortBy) > 0 {
+		kv = append(kv, "sort-by", strings.Join(opts.SortBy, ","))
+	}
+	if opts.Syntax != "" {
+		kv = append(kv, "search-syntax", opts.Syntax)
+	}
+	if len(kv) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// searchPage reads the description of a page from the response
+// header.
+func searchPage(md metadata.MD) SearchPage {
+	p := SearchPage{}
+	if v := md.Get("total-hits"); len(v) > 0 {
+		p.Total, _ = strconv.Atoi(v[0])
+	}
+	if v := md.Get("next-cursor"); len(v) > 0 {
+		p.Next = v[0]
+	}
+	return p
+}
@@ -0,0 +1,49 @@
+package netauth
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestWithSearchOptions(t *testing.T) {
+	ctx := withSearchOptions(context.Background(), SearchOptions{
+		PageSize: 10,
+		Cursor:   "next",
+		SortBy:   []string{"-number", "ID"},
+		Syntax:   "ldap",
+	})
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		t.Fatal("Bad metadata")
+	}
+	if res := md.Get("page-size"); len(res) != 1 || res[0] != "10" {
+		t.Errorf("Bad page size: %v", res)
+	}
+	if res := md.Get("page-offset"); len(res) != 0 {
+		t.Errorf("Unset offset was sent: %v", res)
+	}
+	if res := md.Get("page-cursor"); len(res) != 1 || res[0] != "next" {
+		t.Errorf("Bad cursor: %v", res)
+	}
+	if res := md.Get("sort-by"); len(res) != 1 || res[0] != "-number,ID" {
+		t.Errorf("Bad sort order: %v", res)
+	}
+	if res := md.Get("search-syntax"); len(res) != 1 || res[0] != "ldap" {
+		t.Errorf("Bad syntax: %v", res)
+	}
+}
+
+func TestSearchPage(t *testing.T) {
+	p := searchPage(metadata.Pairs("total-hits", "42", "next-cursor", "abc"))
+	if p.Total != 42 || p.Next != "abc" {
+		t.Errorf("Bad page: %+v", p)
+	}
+
+	p = searchPage(metadata.MD{})
+	if p.Total != 0 || p.Next != "" {
+		t.Errorf("Bad page: %+v", p)
+	}
+}
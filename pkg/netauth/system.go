@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 
+	"google.golang.org/protobuf/types/known/structpb"
+
 	pb "github.com/netauth/protocol"
 	rpc "github.com/netauth/protocol/v2"
 )
@@ -57,3 +59,30 @@ func (c *Client) SystemStatus(ctx context.Context) (*rpc.ServerStatus, error) {
 	ctx = c.appendMetadata(ctx)
 	return c.rpc.SystemStatus(ctx, &rpc.Empty{})
 }
+
+// SystemChains returns the hook chains that the server runs for
+// entities and for groups, keyed by the name of the chain.  The hooks
+// in each chain are in the order that they run.
+func (c *Client) SystemChains(ctx context.Context) (map[string][]ChainHook, map[string][]ChainHook, error) {
+	ctx = c.appendMetadata(ctx)
+	res := &structpb.Struct{}
+	if err := c.ext.Invoke(ctx, extMethod("SystemChains"), &rpc.Empty{}, res); err != nil {
+		return nil, nil, err
+	}
+	return parseChains(res.GetFields()["entity"]), parseChains(res.GetFields()["group"]), nil
+}
+
+func parseChains(v *structpb.Value) map[string][]ChainHook {
+	out := make(map[string][]ChainHook)
+	for chain, hooks := range v.GetStructValue().GetFields() {
+		out[chain] = []ChainHook{}
+		for _, h := range hooks.GetListValue().GetValues() {
+			f := h.GetStructValue().GetFields()
+			out[chain] = append(out[chain], ChainHook{
+				Name:     f["hook"].GetStringValue(),
+				Priority: int(f["priority"].GetNumberValue()),
+			})
+		}
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package netauth
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pb "github.com/netauth/protocol"
+	rpc "github.com/netauth/protocol/v2"
+)
+
+// extMethod returns the full name of a method on the supplementary
+// service.
+func extMethod(name string) string {
+	return "/netauth.v2.NetAuth2Ext/" + name
+}
+
+// EntityTrash returns the entities that have been destroyed but can
+// still be restored.  The server must have the trash enabled for any
+// entities to be returned.
+func (c *Client) EntityTrash(ctx context.Context) ([]TrashEntry, error) {
+	return c.trash(ctx, "EntityTrash")
+}
+
+// EntityRestore brings a destroyed entity back from the trash.
+func (c *Client) EntityRestore(ctx context.Context, id string) error {
+	return c.entityTrashOp(ctx, "EntityRestore", id)
+}
+
+// EntityPurge permanently removes an entity from the trash.
+func (c *Client) EntityPurge(ctx context.Context, id string) error {
+	return c.entityTrashOp(ctx, "EntityPurge", id)
+}
+
+// GroupTrash returns the groups that have been destroyed but can
+// still be restored.
+func (c *Client) GroupTrash(ctx context.Context) ([]TrashEntry, error) {
+	return c.trash(ctx, "GroupTrash")
+}
+
+// GroupRestore brings a destroyed group back from the trash.
+func (c *Client) GroupRestore(ctx context.Context, name string) error {
+	return c.groupTrashOp(ctx, "GroupRestore", name)
+}
+
+// GroupPurge permanently removes a group from the trash.
+func (c *Client) GroupPurge(ctx context.Context, name string) error {
+	return c.groupTrashOp(ctx, "GroupPurge", name)
+}
+
+func (c *Client) trash(ctx context.Context, method string) ([]TrashEntry, error) {
+	ctx = c.appendMetadata(ctx)
+	res := &structpb.Struct{}
+	if err := c.ext.Invoke(ctx, extMethod(method), &rpc.Empty{}, res); err != nil {
+		return nil, err
+	}
+
+	out := []TrashEntry{}
+	for _, v := range res.GetFields()["entries"].GetListValue().GetValues() {
+		f := v.GetStructValue().GetFields()
+		deleted, _ := time.Parse(time.RFC3339, f["deleted"].GetStringValue())
+		out = append(out, TrashEntry{
+			Kind:    f["kind"].GetStringValue(),
+			Name:    f["name"].GetStringValue(),
+			Deleted: deleted,
+			Actor:   f["actor"].GetStringValue(),
+		})
+	}
+	return out, nil
+}
+
+func (c *Client) entityTrashOp(ctx context.Context, method, id string) error {
+	if err := c.makeWritable(); err != nil {
+		return err
+	}
+
+	ctx = c.appendMetadata(ctx)
+	r := rpc.EntityRequest{
+		Entity: &pb.Entity{
+			ID: &id,
+		},
+	}
+	return c.ext.Invoke(ctx, extMethod(method), &r, &rpc.Empty{})
+}
+
+func (c *Client) groupTrashOp(ctx context.Context, method, name string) error {
+	if err := c.makeWritable(); err != nil {
+		return err
+	}
+
+	ctx = c.appendMetadata(ctx)
+	r := rpc.GroupRequest{
+		Group: &pb.Group{
+			Name: &name,
+		},
+	}
+	return c.ext.Invoke(ctx, extMethod(method), &r, &rpc.Empty{})
+}
@@ -1,7 +1,10 @@
 package netauth
 
 import (
+	"time"
+
 	"github.com/hashicorp/go-hclog"
+	"google.golang.org/grpc"
 
 	rpc "github.com/netauth/protocol/v2"
 )
@@ -14,8 +17,78 @@ type Client struct {
 	rpc rpc.NetAuth2Client
 	log hclog.Logger
 
+	// ext is used to call methods on the supplementary service
+	// that serves features the protocol has no messages for.
+	ext grpc.ClientConnInterface
+
 	clientName  string
 	serviceName string
 
 	writeable bool
 }
+
+// A TrashEntry describes an entity or group that has been destroyed
+// but can still be restored.
+type TrashEntry struct {
+	Kind    string
+	Name    string
+	Deleted time.Time
+	Actor   string
+}
+
+// SearchOptions control which page of results a search returns, and
+// the order in which they are returned.
+type SearchOptions struct {
+	// PageSize is the most results to return.  If it is zero the
+	// server returns every result, up to a fixed limit.
+	PageSize int
+
+	// Offset is the number of results to skip.  Cursor may be
+	// set to the Next value of a previous page instead, in which
+	// case Offset is ignored.
+	Offset int
+	Cursor string
+
+	// SortBy lists the fields to order results by.  A field
+	// prefixed with "-" is sorted in descending order.
+	SortBy []string
+
+	// Syntax is the language the expression is written in.  It
+	// may be "ldap" for an RFC 4515 filter, and otherwise the
+	// query string syntax is used.
+	Syntax string
+}
+
+// A SearchPage describes the page of results returned by a search.
+type SearchPage struct {
+	// Total is the number of results that matched the search.
+	Total int
+
+	// Next is the cursor for the following page, and is empty
+	// once there are no more results.
+	Next string
+}
+
+// A Facet holds the number of entities or groups that have each value
+// of a field.  Missing is the number that have no value at all, and
+// Other is the number whose value was left out of Terms.
+type Facet struct {
+	Field   string      `json:"field"`
+	Missing int         `json:"missing"`
+	Other   int         `json:"other"`
+	Terms   []FacetTerm `json:"terms"`
+}
+
+// A FacetTerm is a single value of a field and the number of entities
+// or groups that have it.
+type FacetTerm struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// A ChainHook is a single hook in one of the chains that the server
+// runs to carry out a request.
+type ChainHook struct {
+	Name     string
+	Priority int
+}
@@ -20,6 +20,14 @@ func Authorize(ctx context.Context, token string) context.Context {
 	return metadata.AppendToOutgoingContext(ctx, "authorization", token)
 }
 
+// WithNumberRange returns a context that will cause EntityCreate and
+// GroupCreate to allocate numbers from the named range on the server,
+// rather than from the default range.  This only has an effect when
+// the number passed to the create call is -1.
+func WithNumberRange(ctx context.Context, name string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "number-range", name)
+}
+
 // parseKV turns an unsorted list of strings into a map of key to
 // sorted values.
 func parseKV(in []string) map[string][]string {